@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// account is an AWS account or role a batch spreads its items over, from an -accounts file.
+// Credentials and region left out are those of the environment.
+type account struct {
+	// Name identifies the account in logs, outputs and the batch summary
+	Name            string `json:"name"`
+	Region          string `json:"region,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+	// RoleARN is assumed with the account's credentials, e.g. a role in another account
+	RoleARN string `json:"role_arn,omitempty"`
+	// RPS and TPM are the account's own rate limits; -rps and -tpm when zero
+	RPS float64 `json:"rps,omitempty"`
+	TPM int     `json:"tpm,omitempty"`
+}
+
+// loadAccounts reads an -accounts file: a JSON array of accounts, e.g.
+// [{"name": "team-a"}, {"name": "team-b", "role_arn": "arn:aws:iam::222222222222:role/bedrock"}]
+func loadAccounts(path string) ([]account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts: %v", err)
+	}
+	var accounts []account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("accounts %s: invalid JSON: %v", path, err)
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("accounts %s lists no accounts", path)
+	}
+	seen := map[string]bool{}
+	for i, a := range accounts {
+		switch {
+		case a.Name == "":
+			return nil, fmt.Errorf("accounts %s: account %d has no name", path, i+1)
+		case seen[a.Name]:
+			return nil, fmt.Errorf("accounts %s: account %s is listed twice", path, a.Name)
+		case (a.AccessKeyID == "") != (a.SecretAccessKey == ""):
+			return nil, fmt.Errorf("accounts %s: account %s needs both access_key_id and secret_access_key", path, a.Name)
+		case a.RPS < 0 || a.TPM < 0:
+			return nil, fmt.Errorf("accounts %s: account %s has a negative rate limit", path, a.Name)
+		}
+		seen[a.Name] = true
+	}
+	return accounts, nil
+}
+
+// withAccount returns a copy of the extractor, with its fallbacks, that invokes the models with
+// the account's credentials, in its region and under its own rate limits. Provisioned
+// throughput belongs to the environment's account and isn't carried over to another; the hedge
+// and Llama Guard keep the environment's credentials.
+func (e *extractor) withAccount(ctx context.Context, a account, rps float64, tpm int) (*extractor, error) {
+	cfg := e.awsConfig
+	if a.Region != "" {
+		cfg.Region = a.Region
+	}
+	if a.AccessKeyID != "" {
+		cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken = a.AccessKeyID, a.SecretAccessKey, a.SessionToken
+	}
+	cfg.RoleARN = a.RoleARN
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %v", a.Name, err)
+	}
+
+	other := *e
+	other.account = a.Name
+	other.awsConfig, other.client = cfg, client
+	if cfg.Region != e.awsConfig.Region || a.AccessKeyID != "" || a.RoleARN != "" {
+		other.opts.Provisioned = nil
+	}
+	if a.RPS > 0 {
+		rps = a.RPS
+	}
+	if a.TPM > 0 {
+		tpm = a.TPM
+	}
+	other.limiter = nil
+	if rps > 0 || tpm > 0 {
+		other.limiter = bedrock.NewLimiter(rps, tpm)
+	}
+	other.model = other.newModel(other.modelInfo, other.opts)
+	if len(e.fallbacks) > 0 {
+		chain := make([]models.Info, len(e.fallbacks))
+		for i, fallback := range e.fallbacks {
+			chain[i] = fallback.modelInfo
+		}
+		if other.fallbacks, err = other.newFallbacks(chain); err != nil {
+			return nil, err
+		}
+	}
+	via := "the environment's credentials"
+	if a.RoleARN != "" {
+		via = "role " + a.RoleARN
+	} else if a.AccessKeyID != "" {
+		via = "its own access key"
+	}
+	log.Printf("Account %s: %s in %s", a.Name, via, cfg.Region)
+	return &other, nil
+}
+
+// accountRunners creates the run function of every account of an -accounts file, for
+// processItems to spread the items over
+func (e *extractor) accountRunners(ctx context.Context, path string, rps float64, tpm int) ([]runFunc, error) {
+	accounts, err := loadAccounts(path)
+	if err != nil {
+		return nil, err
+	}
+	runners := make([]runFunc, len(accounts))
+	for i, a := range accounts {
+		other, err := e.withAccount(ctx, a, rps, tpm)
+		if err != nil {
+			return nil, err
+		}
+		runners[i] = other.runItem
+	}
+	return runners, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/tools"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+)
+
+// bedrockAgentFlags are the agent flags that apply to Bedrock Agents; the others only apply
+// to local tool use
+var bedrockAgentFlags = []string{
+	"agent-id", "alias-id", "session-id", "end-session", "trace", "region", "fips", "max-attempts", "timeout",
+	"sdk-retry-mode", "sdk-max-attempts", "sdk-max-backoff", "record-fixtures", "replay-fixtures", "quiet", "verbose",
+}
+
+// runAgent implements the agent subcommand, which answers a prompt with a model that can call
+// local tools, such as looking a title up in the user's catalog, or with an agent built with
+// Bedrock Agents
+func runAgent(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	f := registerFlags(fs)
+	catalogFlag := fs.String("catalog", "", "File of titles, one per line, for the catalog_search tool")
+	dirFlag := fs.String("dir", "", "Folder the list_directory, read_filename and rename_file tools work in")
+	applyFlag := fs.Bool("apply", false, "Let rename_file rename the files in -dir; without it, renames are only proposed")
+	toolsFlag := fs.String("tools", "", "Comma-separated tools the model may call; defaults to all available")
+	maxRoundsFlag := fs.Int("max-rounds", bedrock.DefaultMaxToolRounds, "Maximum tool-use round trips before giving up")
+	agentIDFlag := fs.String("agent-id", "", "ID of a Bedrock Agents agent to invoke instead of a model with local tools")
+	aliasIDFlag := fs.String("alias-id", "TSTALIASID", "Alias of the agent version to invoke; TSTALIASID is the working draft")
+	sessionIDFlag := fs.String("session-id", "", "Agent session to continue, as logged by an earlier run; a new session when empty")
+	endSessionFlag := fs.Bool("end-session", false, "End the agent session after this prompt")
+	traceFlag := fs.Bool("trace", false, "Log the agent's reasoning steps as they happen")
+	f.region = fs.String("region", "", "Region to run in; defaults to $AWS_REGION")
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	prompt := strings.Join(fs.Args(), " ")
+	if strings.TrimSpace(prompt) == "" {
+		fatalf("Usage: %s agent [flags] PROMPT, or %s agent -agent-id ID [flags] PROMPT", os.Args[0], os.Args[0])
+	}
+	if *agentIDFlag != "" {
+		fs.Visit(func(fl *flag.Flag) {
+			if !slices.Contains(bedrockAgentFlags, fl.Name) {
+				fatalf("-%s doesn't apply to Bedrock Agents, which run in their own configuration", fl.Name)
+			}
+		})
+		cfg, err := clientConfig(f)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		runBedrockAgent(cfg, bedrock.AgentRequest{
+			AgentID:    *agentIDFlag,
+			AliasID:    *aliasIDFlag,
+			SessionID:  *sessionIDFlag,
+			Input:      prompt,
+			EndSession: *endSessionFlag,
+			Trace:      *traceFlag,
+			Retry:      bedrock.RetryPolicy{MaxAttempts: *f.maxAttempts},
+			Timeout:    *f.timeout,
+		})
+		return
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "alias-id", "session-id", "end-session", "trace":
+			fatalf("-%s only applies to Bedrock Agents, with -agent-id", fl.Name)
+		}
+	})
+
+	if strings.Contains(*f.model, ",") {
+		fatalf("-model takes a single model for tool use: nova or claude")
+	}
+	if *maxRoundsFlag < 1 {
+		fatalf("-max-rounds must be at least 1")
+	}
+
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.CurrentDate()); err != nil {
+		fatalf("%v", err)
+	}
+	if *catalogFlag != "" {
+		file, err := os.Open(*catalogFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		titles, err := tools.ReadCatalog(file)
+		file.Close()
+		if err != nil {
+			fatalf("Error reading %s: %v", *catalogFlag, err)
+		}
+		if err := registry.Register(tools.CatalogSearch(titles)); err != nil {
+			fatalf("%v", err)
+		}
+		log.Printf("Loaded %d title(s) from %s", len(titles), *catalogFlag)
+	}
+	var folder *tools.Folder
+	if *dirFlag != "" {
+		var err error
+		if folder, err = tools.NewFolder(*dirFlag, !*applyFlag); err != nil {
+			fatalf("Error: %v", err)
+		}
+		for _, tool := range folder.Tools() {
+			if err := registry.Register(tool); err != nil {
+				fatalf("%v", err)
+			}
+		}
+	} else if *applyFlag {
+		fatalf("-apply requires -dir")
+	}
+	var names []string
+	for _, name := range strings.Split(*toolsFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	available, err := registry.Tools(names...)
+	if err != nil {
+		fatalf("Error: %v. Available tools: %s", err, strings.Join(registry.Names(), ", "))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	runWithExtractor(ctx, f, func(e *extractor) error {
+		info := e.modelInfo
+		// The models that take a tool for structured output are the ones that can call tools
+		if !info.SupportsStructuredOutput {
+			return fmt.Errorf("%s can't call tools. Use nova or claude", info.DisplayName)
+		}
+		// The model goes through the guardrail, budget, rate limits, metrics and audit log of
+		// extraction runs, with the response cap of conversations
+		opts := e.chatOptions(info)
+		if *f.maxOutput > 0 {
+			opts.MaxTokens = *f.maxOutput
+		}
+		opts.MaxToolRounds = *maxRoundsFlag
+		model := e.newModel(info, opts).(bedrock.ToolUser)
+
+		names = names[:0]
+		for _, tool := range available {
+			names = append(names, tool.Name)
+		}
+		log.Printf("Asking %s with tools: %s", info.DisplayName, strings.Join(names, ", "))
+		result, err := model.UseTools(ctx, bedrock.UserTurn(prompt), available, func(call bedrock.ToolCall) {
+			if call.Err != nil {
+				warnf("Tool %s(%s) failed: %v", call.Name, call.Input, call.Err)
+				return
+			}
+			log.Printf("Tool %s(%s) returned %s", call.Name, call.Input, call.Output)
+		})
+		if folder != nil {
+			logRenames(folder.Renames())
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.TrimSpace(result.Text))
+		log.Printf("Tokens: %d input, %d output", result.InputTokens, result.OutputTokens)
+		return nil
+	})
+}
+
+// logRenames logs the renames the model asked rename_file for, telling how to apply the
+// ones that were only proposed
+func logRenames(renames []tools.Rename) {
+	proposed := 0
+	for _, rename := range renames {
+		if rename.Applied {
+			log.Printf("Renamed %s to %s", rename.From, rename.To)
+		} else {
+			log.Printf("Proposed rename: %s -> %s", rename.From, rename.To)
+			proposed++
+		}
+	}
+	if proposed > 0 {
+		log.Printf("Nothing was renamed; run again with -apply to rename the files")
+	}
+}
+
+// runBedrockAgent sends a prompt to an agent built with Bedrock Agents, printing its response as it arrives
+func runBedrockAgent(cfg bedrock.Config, req bedrock.AgentRequest) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := bedrock.NewAgentClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if req.SessionID == "" {
+		req.SessionID = bedrock.NewSessionID()
+	}
+
+	log.Printf("Invoking agent %s (alias %s) in session %s", req.AgentID, req.AliasID, req.SessionID)
+	printed := false
+	response, err := bedrock.InvokeAgent(ctx, client, req, func(text string) {
+		fmt.Print(text)
+		printed = true
+	}, func(trace bedrock.AgentTrace) {
+		if trace.Step != "" {
+			log.Printf("Trace: %s: %s", trace.Step, trace.Summary)
+		}
+	})
+	if printed {
+		fmt.Println()
+	}
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	for _, source := range response.Sources {
+		log.Printf("Source: %s", source)
+	}
+	if response.InputTokens > 0 || response.OutputTokens > 0 {
+		log.Printf("Tokens: %d input, %d output", response.InputTokens, response.OutputTokens)
+	}
+	if !req.EndSession {
+		log.Printf("Continue the conversation with -session-id %s", response.SessionID)
+	}
+}
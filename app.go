@@ -0,0 +1,846 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"bedrock-llama/models"
+	"bedrock-llama/prompts"
+	"bedrock-llama/render"
+	"bedrock-llama/schema"
+	"bedrock-llama/tasks"
+	"bedrock-llama/vcr"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/joho/godotenv"
+)
+
+// flags holds the command-line flags shared by the single-input and batch commands
+type flags struct {
+	model    *string
+	task     *string
+	endpoint *string
+	fips     *bool
+	// region overrides $AWS_REGION; only defined by the subcommands with a -region flag
+	region *string
+	// modelVersion pins the version of the models that have several
+	modelVersion *string
+	// sdkRetryMode, sdkMaxAttempts and sdkMaxBackoff configure the SDK's own retries
+	sdkRetryMode   *string
+	sdkMaxAttempts *int
+	sdkMaxBackoff  *time.Duration
+	// recordDir and replayDir record the Bedrock exchanges as fixtures, or replay them
+	recordDir      *string
+	replayDir      *string
+	structured     *bool
+	provisioned    *string
+	guardrailID    *string
+	guardrailVer   *string
+	llamaGuard     *string
+	moderate       *string
+	moderationAct  *string
+	thinkingBudget *int
+	showThinking   *bool
+	anthropicBeta  *string
+	system         *string
+	topK           *int
+	stopSequences  *string
+	temperature    floatFlag
+	topP           floatFlag
+	latency        *string
+	prompt         *string
+	promptsDir     *string
+	listPrompts    *bool
+	promptFile     *string
+	vars           varsFlag
+	examples       *string
+	schema         *string
+	output         *string
+	maxRepairs     *int
+	maxContinue    *int
+	rps            *float64
+	tpm            *int
+	quiet          *bool
+	verbose        *bool
+	emf            *bool
+	emfNS          *string
+	audit          *string
+	auditRedact    *string
+	auditMask      patternsFlag
+	auditOutput    *int
+	usageFile      *string
+	history        *string
+	usage          *bool
+	maxAttempts    *int
+	timeout        *time.Duration
+	hedge          *string
+	hedgeDelay     *time.Duration
+	cache          *string
+	cacheTTL       *time.Duration
+	noCache        *bool
+	maxCost        *float64
+	maxTokens      *int
+	maxOutput      *int
+	image          *string
+	document       *string
+	video          *string
+	videoUpload    *string
+}
+
+// registerFlags defines the shared flags on a flag set
+func registerFlags(fs *flag.FlagSet) *flags {
+	f := &flags{vars: varsFlag{}}
+	f.model = fs.String("model", "nova", "The LLM model to use: "+models.Usage()+"; a comma-separated list tries each model in turn until one succeeds")
+	f.modelVersion = fs.String("model-version", "", "Claude version to invoke: "+strings.Join(models.Versions("claude"), ", ")+"; defaults to 3.5-sonnet-v2, or 3.7-sonnet with -thinking-budget")
+	f.task = fs.String("task", "series", "The extraction task to run: "+strings.Join(tasks.Names(), ", "))
+	f.endpoint = fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL (e.g. a VPC interface endpoint); defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	f.fips = fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	registerSDKRetryFlags(fs, f)
+	registerFixtureFlags(fs, f)
+	f.structured = fs.Bool("structured", false, "Force schema-constrained JSON output through tool use (claude and nova only)")
+	f.provisioned = fs.String("provisioned-model", "", "ARN of a provisioned throughput model to route to first, spilling over to on-demand when it is at capacity")
+	f.guardrailID = fs.String("guardrail-id", "", "ID or ARN of a Bedrock Guardrail that screens every prompt and response")
+	f.guardrailVer = fs.String("guardrail-version", "", "Version of the -guardrail-id guardrail; the working draft (DRAFT) when empty")
+	f.llamaGuard = fs.String("llama-guard", "", "ARN of a Llama Guard model, imported or on a Marketplace endpoint, that classifies prompts and responses as safe or unsafe")
+	f.moderate = fs.String("moderate", moderateBoth, "What -llama-guard classifies: input (the prompts), output (the responses) or both")
+	f.moderationAct = fs.String("moderation-action", moderationBlock, "What happens to content -llama-guard classifies as unsafe: block (fail the request) or flag (report its categories in the output)")
+	f.thinkingBudget = fs.Int("thinking-budget", 0, "Tokens Claude may spend reasoning before it answers, from 1024 (0 disables extended thinking; claude only)")
+	f.showThinking = fs.Bool("show-thinking", false, "Print the model's reasoning, from -thinking-budget or a model that always reasons like deepseek, to stderr and add it to JSON output, instead of discarding it")
+	f.anthropicBeta = fs.String("anthropic-beta", "", "Comma-separated anthropic_beta flags sent with Claude requests to turn on Anthropic features in beta, e.g. token-efficient-tools-2025-02-19")
+	f.system = fs.String("system", "", "System prompt sent to the model before the task prompt, and before conversations without one (none when empty)")
+	f.topK = fs.Int("top-k", 0, "Sample only from the K most likely tokens (0 leaves the model's default; nova only)")
+	f.stopSequences = fs.String("stop-sequences", "", "Comma-separated sequences that end the response when the model generates one; Llama requests with them go through the Converse API")
+	fs.Var(&f.temperature, "temperature", "Sampling temperature, from 0 to 1; the model's default when unset")
+	fs.Var(&f.topP, "top-p", "Nucleus sampling probability mass, from 0 to 1; the model's default when unset")
+	f.latency = fs.String("latency", bedrock.LatencyStandard, "Inference latency mode: 'standard' or 'optimized' (only honored by models that support it)")
+	f.prompt = fs.String("prompt", "", "Versioned prompt from the registry, as name or name@version (latest version when unpinned)")
+	f.promptsDir = fs.String("prompts-dir", "prompts", "Directory of the prompt registry")
+	f.listPrompts = fs.Bool("list-prompts", false, "List the prompt versions in the registry and exit")
+	f.promptFile = fs.String("prompt-file", "", "Go text/template file used as the prompt instead of the task's built-in template")
+	fs.Var(f.vars, "var", "Template parameter as key=value, available as {{.Vars.key}} in -prompt-file (repeatable)")
+	f.image = fs.String("image", "", "PNG, JPEG, GIF, WebP, BMP or TIFF image shown to the model with the prompt, e.g. poster art or a video frame (claude and nova only)")
+	f.document = fs.String("document", "", "PDF, CSV, DOC, DOCX, XLS, XLSX, HTML, TXT or MD document shown to the model with the prompt, e.g. an episode guide")
+	f.video = fs.String("video", "", "Video clip, as a local file or an s3://bucket/key, shown to the model with the prompt, e.g. a series intro (nova only)")
+	f.videoUpload = fs.String("video-upload", "", "S3 prefix, as s3://bucket/prefix/, that local -video clips over 25 MB are uploaded to for the model to read")
+	f.examples = fs.String("examples", "", "JSONL file of few-shot {\"input\": ..., \"output\": ...} examples shown to the model before the input")
+	f.schema = fs.String("schema", "", `Custom extraction schema, as a field map ('{"series":"string","year":"int"}'), a JSON Schema, or a field list ('series,year:int')`)
+	f.output = fs.String("output", render.JSON, "Output format: "+strings.Join(render.Formats(), ", "))
+	f.maxOutput = fs.Int("max-output-tokens", 0, "Maximum tokens of the model's answer per request (0 for the task's default, e.g. 64 for series); deepseek gets 2048 tokens for its reasoning on top")
+	f.maxRepairs = fs.Int("max-repairs", 2, "Maximum number of corrective re-prompts when the model returns invalid JSON (0 disables)")
+	f.maxContinue = fs.Int("max-continuations", 2, "Maximum follow-up requests that continue a Claude response cut off at the output cap, joining the parts (0 disables)")
+	f.maxAttempts = fs.Int("max-attempts", 5, "Maximum attempts per model request when Bedrock throttles it or the model is unavailable, with exponential backoff between them (1 disables retries)")
+	f.timeout = fs.Duration("timeout", 0, "Time limit for each model request, or each streamed response, e.g. 30s (0 for no limit)")
+	f.hedge = fs.String("hedge", "", "Second target raced against -model when it has no valid output within -hedge-delay: a model, model@region, or @region for the same model")
+	f.hedgeDelay = fs.Duration("hedge-delay", 2*time.Second, "How long -model gets before the -hedge target is also sent the prompt")
+	f.cache = fs.String("cache", os.Getenv(cacheFileEnv), "Cache of validated results by model, prompt and schema, so repeated inputs skip the model: a BoltDB file, redis://host:port/db, s3://bucket/prefix/ or dynamodb://table; defaults to $"+cacheFileEnv)
+	f.cacheTTL = fs.Duration("cache-ttl", 7*24*time.Hour, "How long a cached result is reused (0 keeps results forever)")
+	f.noCache = fs.Bool("no-cache", false, "Invoke the model even when -cache holds a result, and store the fresh one")
+	f.maxCost = fs.Float64("max-cost", 0, "Stop invoking models once the run's estimated spend reaches this many USD (0 for no limit)")
+	f.maxTokens = fs.Int("max-tokens", 0, "Stop invoking models once the run has used this many input plus output tokens (0 for no limit)")
+	f.rps = fs.Float64("rps", 0, "Maximum model requests per second, including corrective re-prompts (0 for no limit)")
+	f.tpm = fs.Int("tpm", 0, "Maximum input plus output tokens per minute (0 for no limit)")
+	f.emf = fs.Bool("emf", false, "Write a CloudWatch Embedded Metric Format record to stderr for every model invocation")
+	f.emfNS = fs.String("emf-namespace", "BedrockLlama", "CloudWatch namespace of the -emf metrics")
+	f.audit = fs.String("audit", "", "Append a JSON audit record of every model invocation to a file or an s3://bucket/prefix/")
+	f.auditRedact = fs.String("audit-redact", redactFull, "Prompt content kept in the audit log: 'full' redaction keeps only a hash, 'patterns' masks sensitive data, 'none' keeps the prompt")
+	fs.Var(&f.auditMask, "audit-redact-pattern", "Regular expression masked in audited prompts and outputs with -audit-redact=patterns (repeatable)")
+	f.auditOutput = fs.Int("audit-max-output", 1000, "Maximum bytes of model output kept in each audit record")
+	f.usage = fs.Bool("usage", false, "Include token counts, latency, model ID and stop reason in the JSON output")
+	f.usageFile = fs.String("usage-file", defaultUsageFile(), "Usage store the run's token usage is appended to, for 'usage report' (empty disables); defaults to $"+usageFileEnv)
+	f.history = fs.String("history", defaultHistoryFile(), "SQLite database to record every extraction in, verbatim, for 'history' (off when empty); defaults to $"+historyFileEnv)
+	registerLogFlags(fs, f)
+	return f
+}
+
+// registerSDKRetryFlags defines the flags configuring the AWS SDK's own retries
+func registerSDKRetryFlags(fs *flag.FlagSet, f *flags) {
+	f.sdkRetryMode = fs.String("sdk-retry-mode", "", "AWS SDK retry mode: 'standard', or 'adaptive' to also rate limit requests on the client side after throttling; defaults to $AWS_RETRY_MODE, then standard")
+	f.sdkMaxAttempts = fs.Int("sdk-max-attempts", 0, fmt.Sprintf("Attempts the AWS SDK makes per request, including the first, before -max-attempts backs off; defaults to $AWS_MAX_ATTEMPTS, then %d", retry.DefaultMaxAttempts))
+	f.sdkMaxBackoff = fs.Duration("sdk-max-backoff", retry.DefaultMaxBackoff, "Maximum backoff between the AWS SDK's attempts")
+}
+
+// registerFixtureFlags defines the flags that record the Bedrock exchanges as fixtures or replay them
+func registerFixtureFlags(fs *flag.FlagSet, f *flags) {
+	f.recordDir = fs.String("record-fixtures", "", "Directory to save every Bedrock HTTP exchange to as a sanitized JSON fixture")
+	f.replayDir = fs.String("replay-fixtures", "", "Directory of fixtures saved by -record-fixtures to answer the Bedrock requests from, without calling AWS")
+}
+
+// registerLogFlags defines the flags controlling diagnostic output on stderr
+func registerLogFlags(fs *flag.FlagSet, f *flags) {
+	f.quiet = fs.Bool("quiet", false, "Only log warnings and errors to stderr")
+	f.verbose = fs.Bool("verbose", false, "Also log prompts, request payloads and raw model responses to stderr")
+}
+
+// applyLogLevel sets the diagnostic level from -quiet and -verbose
+func (f *flags) applyLogLevel() {
+	switch {
+	case *f.quiet && *f.verbose:
+		fatalf("Error: -quiet and -verbose can't be combined")
+	case *f.quiet:
+		logging.SetLevel(logging.Quiet)
+	case *f.verbose:
+		logging.SetLevel(logging.Verbose)
+	}
+}
+
+// extractor runs the selected task against the selected model
+type extractor struct {
+	task      *tasks.Task
+	modelInfo models.Info
+	model     bedrock.Model
+	examples  []tasks.Example
+	// images are attached to the input of every extraction, from -image
+	images []bedrock.Image
+	// documents are attached to the input of every extraction, from -document
+	documents []bedrock.Document
+	// videos are attached to the input of every extraction, from -video
+	videos       []bedrock.Video
+	outputFormat string
+	// showThinking prints the model's reasoning before the output, and adds it to the JSON
+	// output, from -show-thinking
+	showThinking bool
+	// reportUsage adds a usage object to the JSON output
+	reportUsage bool
+	maxRepairs  int
+	opts        bedrock.Options
+	// awsConfig holds the credentials, so other AWS clients can be created alongside Bedrock
+	awsConfig bedrock.Config
+	client    *bedrockruntime.Client
+	limiter   *bedrock.Limiter
+	// metrics record every model invocation; serve exposes them on /metrics
+	metrics *metrics
+	// shutdownTracing flushes the spans still buffered for export
+	shutdownTracing func(context.Context) error
+	// audit records every model invocation when -audit is set
+	audit *auditLog
+	// cache answers inputs seen before when -cache is set
+	cache *responseCache
+	// history records every extraction when -history is set
+	history *historyRecorder
+	// budget caps the run's spend and tokens when -max-cost or -max-tokens is set
+	budget *budget
+	// moderator classifies prompts and responses with Llama Guard when -llama-guard is set
+	moderator *moderator
+	// hedge is raced against the model when -hedge is set
+	hedge *hedge
+	// fallbacks are tried in order when the model fails, from -model a,b,c
+	fallbacks []*extractor
+	// pinned holds the models of -model pinned to -model-version, by name
+	pinned map[string]models.Info
+	// account names the -accounts account the models are invoked with; empty for the
+	// environment's credentials
+	account string
+}
+
+// resolveTask selects the task and prompt template described by the flags
+func resolveTask(f *flags) *tasks.Task {
+	// A registry prompt selects its own task unless the metadata leaves it unset
+	taskName := strings.ToLower(*f.task)
+	var promptVersion prompts.Version
+	if *f.prompt != "" {
+		registry, err := prompts.Open(*f.promptsDir)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		promptVersion, err = registry.Resolve(*f.prompt)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		if promptVersion.Metadata.Task != "" {
+			taskName = promptVersion.Metadata.Task
+		}
+	}
+
+	// Select the task, or build a custom one when an extraction schema is supplied
+	task, ok := tasks.Lookup(taskName)
+	if !ok {
+		fatalf("Invalid task specified. Use one of: %s", strings.Join(tasks.Names(), ", "))
+	}
+	if *f.schema != "" {
+		outputSchema, err := schema.Parse(*f.schema)
+		if err != nil {
+			fatalf("Invalid schema: %v", err)
+		}
+		task = tasks.Custom(outputSchema)
+	}
+	if *f.promptFile != "" && *f.prompt != "" {
+		fatalf("Use either -prompt or -prompt-file, not both")
+	}
+	if *f.promptFile != "" {
+		var err error
+		task, err = task.WithTemplateFile(*f.promptFile, f.vars)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+	if *f.prompt != "" {
+		var err error
+		task, err = task.WithTemplateFile(promptVersion.Path, f.vars)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		task.Ref = promptVersion.Ref()
+		if promptVersion.Metadata.MaxTokens > 0 {
+			task.MaxTokens = promptVersion.Metadata.MaxTokens
+		}
+		log.Printf("Using prompt %s from %s", task.Ref, promptVersion.Path)
+	}
+	return task
+}
+
+// newExtractor validates the flags, loads the AWS credentials and creates the Bedrock client
+func newExtractor(ctx context.Context, f *flags) *extractor {
+	f.applyLogLevel()
+	e := &extractor{
+		task:       resolveTask(f),
+		maxRepairs: *f.maxRepairs,
+	}
+
+	if *f.examples != "" {
+		var err error
+		e.examples, err = tasks.LoadExamples(*f.examples)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		log.Printf("Loaded %d few-shot examples", len(e.examples))
+	}
+
+	e.outputFormat = strings.ToLower(*f.output)
+	if _, err := render.Records(e.outputFormat, e.task.Schema, nil); err != nil {
+		fatalf("%v", err)
+	}
+	e.reportUsage = *f.usage
+	if e.reportUsage && e.outputFormat != render.JSON {
+		fatalf("-usage is only supported with -output=%s", render.JSON)
+	}
+
+	// Validate model selection; models after the first are fallbacks
+	var chain []models.Info
+	pinned := false
+	for _, name := range strings.Split(strings.ToLower(*f.model), ",") {
+		info, ok := models.Lookup(strings.TrimSpace(name))
+		if !ok {
+			fatalf("Invalid model specified. Use %s", models.Usage())
+		}
+		if *f.modelVersion != "" && models.Versions(info.Name) != nil {
+			var err error
+			if info, err = models.PinVersion(info.Name, *f.modelVersion); err != nil {
+				fatalf("%v", err)
+			}
+			log.Printf("Pinning %s to %s (%s)", info.Name, info.DisplayName, info.ModelID)
+			if e.pinned == nil {
+				e.pinned = map[string]models.Info{}
+			}
+			e.pinned[info.Name] = info
+			pinned = true
+		}
+		for _, earlier := range chain {
+			if earlier.Name == info.Name {
+				fatalf("Model %s is listed twice in -model", info.Name)
+			}
+		}
+		chain = append(chain, info)
+	}
+	if *f.modelVersion != "" && !pinned {
+		fatalf("-model-version needs a model with versions in -model")
+	}
+	e.modelInfo = chain[0]
+	modelName := e.modelInfo.Name
+
+	latency := strings.ToLower(*f.latency)
+	if err := bedrock.ValidateLatency(latency); err != nil {
+		fatalf("%v", err)
+	}
+	if *f.maxAttempts < 1 {
+		fatalf("-max-attempts must be at least 1")
+	}
+	if *f.timeout < 0 {
+		fatalf("-timeout can't be negative")
+	}
+	if *f.maxOutput < 0 {
+		fatalf("-max-output-tokens can't be negative")
+	}
+	if *f.maxContinue < 0 {
+		fatalf("-max-continuations can't be negative")
+	}
+	e.opts = bedrock.Options{
+		Latency:          latency,
+		Retry:            bedrock.RetryPolicy{MaxAttempts: *f.maxAttempts},
+		Timeout:          *f.timeout,
+		MaxTokens:        e.task.MaxTokens,
+		MaxContinuations: *f.maxContinue,
+	}
+	if *f.maxOutput > 0 {
+		e.opts.MaxTokens = *f.maxOutput
+	}
+
+	if *f.provisioned != "" {
+		e.opts.Provisioned = bedrock.NewProvisioned(*f.provisioned)
+	}
+	if *f.guardrailID != "" {
+		e.opts.Guardrail = bedrock.NewGuardrail(*f.guardrailID, *f.guardrailVer)
+		log.Printf("Screening prompts and responses with guardrail %s", e.opts.Guardrail)
+	} else if *f.guardrailVer != "" {
+		fatalf("-guardrail-version requires -guardrail-id")
+	}
+
+	for _, beta := range strings.Split(*f.anthropicBeta, ",") {
+		if beta = strings.TrimSpace(beta); beta != "" {
+			e.opts.AnthropicBeta = append(e.opts.AnthropicBeta, beta)
+		}
+	}
+	if len(e.opts.AnthropicBeta) > 0 {
+		log.Printf("Sending the anthropic_beta flags %s with Claude requests", strings.Join(e.opts.AnthropicBeta, ", "))
+	}
+
+	if *f.topK < 0 {
+		fatalf("-top-k can't be negative")
+	}
+	e.opts.TopK = *f.topK
+	e.opts.System = *f.system
+	for _, stop := range strings.Split(*f.stopSequences, ",") {
+		if stop != "" {
+			e.opts.StopSequences = append(e.opts.StopSequences, stop)
+		}
+	}
+	if t := f.temperature.value; t != nil && (*t < 0 || *t > 1) {
+		fatalf("-temperature must be between 0 and 1")
+	}
+	if p := f.topP.value; p != nil && (*p < 0 || *p > 1) {
+		fatalf("-top-p must be between 0 and 1")
+	}
+	e.opts.Temperature, e.opts.TopP = f.temperature.value, f.topP.value
+
+	if *f.thinkingBudget < 0 {
+		fatalf("-thinking-budget can't be negative")
+	} else if *f.thinkingBudget > 0 {
+		if !e.modelInfo.SupportsThinking {
+			if *f.modelVersion != "" {
+				fatalf("Extended thinking is not supported by %s. Pin a later -model-version", e.modelInfo.DisplayName)
+			}
+			fatalf("Extended thinking is not supported by the %s model. Use 'claude'", modelName)
+		}
+		if *f.thinkingBudget < bedrock.MinThinkingBudget {
+			fatalf("-thinking-budget must be at least %d tokens", bedrock.MinThinkingBudget)
+		}
+		e.opts.Thinking = &bedrock.Thinking{BudgetTokens: *f.thinkingBudget}
+		log.Printf("Extended thinking with a budget of %d tokens", *f.thinkingBudget)
+	} else if *f.showThinking && !e.modelInfo.AlwaysReasons {
+		fatalf("-show-thinking requires -thinking-budget, or a model that always reasons, such as 'deepseek'")
+	}
+	e.showThinking = *f.showThinking
+
+	if *f.structured {
+		// Claude can't be forced to call a tool while it thinks
+		if e.opts.Thinking != nil {
+			fatalf("-structured can't be combined with -thinking-budget")
+		}
+		if !e.modelInfo.SupportsStructuredOutput {
+			fatalf("Structured output is not supported by the %s model. Use 'claude' or 'nova'", modelName)
+		}
+		e.opts.Structured = &bedrock.StructuredOutput{
+			Name:        "record_extraction",
+			Description: "Record the fields extracted from the input",
+			Schema:      e.task.Schema.JSONSchema(),
+		}
+	}
+
+	if *f.image != "" {
+		for _, info := range chain {
+			if !info.SupportsImages {
+				fatalf("Images are not supported by the %s model. Use 'claude' or 'nova'", info.Name)
+			}
+		}
+		image, err := bedrock.LoadImage(*f.image)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		e.images = []bedrock.Image{image}
+		log.Printf("Attaching %s (%s, %dx%d, %d bytes)", *f.image, image.MediaType(), image.Width, image.Height, len(image.Data))
+	}
+
+	if *f.video != "" {
+		for _, info := range chain {
+			if !info.SupportsVideo {
+				fatalf("Videos are not supported by the %s model. Use 'nova'", info.Name)
+			}
+		}
+	}
+
+	if *f.document != "" {
+		// Documents are sent through the Converse API, which isn't given the tool for structured output
+		if e.opts.Structured != nil {
+			fatalf("-structured can't be combined with -document")
+		}
+		document, err := bedrock.LoadDocument(*f.document)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		e.documents = []bedrock.Document{document}
+		log.Printf("Attaching %s (%s, %d bytes)", *f.document, document.Format, len(document.Data))
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	e.metrics = newMetrics()
+	if *f.emf {
+		// Written past the logger so -quiet doesn't drop the metrics
+		e.metrics.emf = newEMFWriter(os.Stderr, *f.emfNS, e.task.Name)
+	}
+	if *f.usageFile != "" {
+		e.metrics.usage = newUsageRecorder(*f.usageFile, e.task.Name)
+	}
+	if e.shutdownTracing, err = setupTracing(ctx); err != nil {
+		fatalf("Error: %v", err)
+	}
+	cfg.APIOptions = append(cfg.APIOptions, e.metrics.apiOption)
+	e.awsConfig = cfg
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	e.client = client
+	if *f.video != "" {
+		video, err := e.loadVideo(ctx, *f.video, *f.videoUpload)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		e.videos = []bedrock.Video{video}
+		if video.S3URI != "" {
+			log.Printf("Attaching %s (%s)", video.S3URI, video.Format)
+		} else {
+			log.Printf("Attaching %s (%s, %d bytes)", *f.video, video.Format, len(video.Data))
+		}
+	}
+	if *f.audit != "" {
+		if e.audit, err = e.newAuditLog(ctx, *f.audit, strings.ToLower(*f.auditRedact), f.auditMask, *f.auditOutput); err != nil {
+			fatalf("Error: %v", err)
+		}
+		log.Printf("Auditing model invocations to %s (redaction: %s)", *f.audit, e.audit.redact)
+	}
+	if *f.history != "" {
+		// Like the usage store, a missing history must not fail the run
+		if e.history, err = newHistoryRecorder(*f.history); err != nil {
+			warnf("not recording history: %v", err)
+		}
+	}
+	if *f.cacheTTL < 0 {
+		fatalf("-cache-ttl can't be negative")
+	}
+	if *f.cache != "" {
+		if e.cache, err = e.openResponseCache(ctx, *f.cache, *f.cacheTTL, *f.noCache); err != nil {
+			fatalf("Error: %v", err)
+		}
+		log.Printf("Caching results in %s", *f.cache)
+	}
+	if *f.maxCost < 0 || *f.maxTokens < 0 {
+		fatalf("-max-cost and -max-tokens can't be negative")
+	}
+	e.budget = newBudget(*f.maxCost, *f.maxTokens)
+	if *f.rps < 0 || *f.tpm < 0 {
+		fatalf("-rps and -tpm can't be negative")
+	}
+	if *f.rps > 0 || *f.tpm > 0 {
+		e.limiter = bedrock.NewLimiter(*f.rps, *f.tpm)
+		log.Printf("Rate limit: %g requests/s, %d tokens/min (0 means unlimited)", *f.rps, *f.tpm)
+	}
+	if e.moderator, err = newModerator(client, *f.llamaGuard, *f.moderate, *f.moderationAct, e.opts.Retry, e.opts.Timeout); err != nil {
+		fatalf("%v", err)
+	}
+	if e.moderator != nil {
+		log.Printf("Moderating %s with Llama Guard %s (%s unsafe content)", e.moderator, *f.llamaGuard, strings.ToLower(*f.moderationAct))
+	}
+	e.model = e.newModel(e.modelInfo, e.opts)
+	if len(chain) > 1 {
+		if e.fallbacks, err = e.newFallbacks(chain[1:]); err != nil {
+			fatalf("Error: %v", err)
+		}
+		log.Printf("Falling back to %s when %s fails", strings.Join(modelNames(chain[1:]), ", then "), modelName)
+	}
+	if *f.hedge != "" {
+		if *f.hedgeDelay < 0 {
+			fatalf("-hedge-delay can't be negative")
+		}
+		if e.hedge, err = e.newHedge(ctx, *f.hedge, *f.hedgeDelay); err != nil {
+			fatalf("Error: %v", err)
+		}
+		log.Printf("Hedging with %s after %s", e.hedge.label, e.hedge.delay)
+	}
+	return e
+}
+
+// newModel creates a model on the shared client, behind the shared rate limiter, the moderator
+// and the budget. Time spent waiting for the limiter isn't counted in the metrics.
+func (e *extractor) newModel(info models.Info, opts bedrock.Options) bedrock.Model {
+	model := e.metrics.wrap(e.audit.wrap(traceModel(info.New(e.client, opts), info, e.awsConfig.Region)))
+	if e.limiter != nil {
+		model = e.limiter.Wrap(model)
+	}
+	return e.budget.wrap(e.moderator.wrap(model))
+}
+
+// forModel returns the extractor for a model named in a request, or e itself when no model is named
+func (e *extractor) forModel(name string) (*extractor, error) {
+	if name == "" {
+		return e, nil
+	}
+	info, ok := e.lookupModel(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown model %q: use %s", name, models.Usage())
+	}
+	return e.withModel(info)
+}
+
+// lookupModel returns the model registered under name, pinned to -model-version when -model
+// pins it
+func (e *extractor) lookupModel(name string) (models.Info, bool) {
+	name = strings.ToLower(name)
+	if info, ok := e.pinned[name]; ok {
+		return info, true
+	}
+	return models.Lookup(name)
+}
+
+// withAnthropicBeta returns a copy of the extractor that sends other anthropic_beta flags
+// than -anthropic-beta, or e itself when beta is empty
+func (e *extractor) withAnthropicBeta(beta []string) *extractor {
+	if len(beta) == 0 {
+		return e
+	}
+	other := *e
+	other.opts.AnthropicBeta = beta
+	other.model = e.newModel(other.modelInfo, other.opts)
+	return &other
+}
+
+// modelNames returns the names of the models
+func modelNames(infos []models.Info) []string {
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name
+	}
+	return names
+}
+
+// withModel returns a copy of the extractor that invokes another model. Provisioned throughput
+// routing belongs to the configured model and isn't carried over, nor is extended thinking to
+// a model that can't think.
+func (e *extractor) withModel(info models.Info) (*extractor, error) {
+	if info.Name == e.modelInfo.Name {
+		return e, nil
+	}
+	if e.opts.Structured != nil && !info.SupportsStructuredOutput {
+		return nil, fmt.Errorf("structured output is not supported by the %s model", info.Name)
+	}
+	if len(e.images) > 0 && !info.SupportsImages {
+		return nil, fmt.Errorf("images are not supported by the %s model", info.Name)
+	}
+	if len(e.videos) > 0 && !info.SupportsVideo {
+		return nil, fmt.Errorf("videos are not supported by the %s model", info.Name)
+	}
+	other := *e
+	other.modelInfo = info
+	other.opts.Provisioned = nil
+	if !info.SupportsThinking {
+		other.opts.Thinking = nil
+	}
+	other.model = e.newModel(info, other.opts)
+	return &other, nil
+}
+
+// clientConfig loads the AWS credentials from the environment (and .env) and resolves the endpoint
+func clientConfig(f *flags) (bedrock.Config, error) {
+	log.Println("Loading environment variables...")
+	if err := godotenv.Load(); err != nil {
+		warnf("Error loading .env file: %v", err)
+	}
+
+	accessKeyId := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	sessionToken := os.Getenv("AWS_SESSION_TOKEN")
+	awsRegion := os.Getenv("AWS_REGION")
+	if f.region != nil && *f.region != "" {
+		awsRegion = *f.region
+	}
+
+	// Additional diagnostic information
+	log.Printf("Using AWS region: %s", awsRegion)
+	log.Printf("AWS access key ID present: %v", accessKeyId != "")
+	log.Printf("AWS secret access key present: %v", secretAccessKey != "")
+
+	if accessKeyId == "" || secretAccessKey == "" || awsRegion == "" {
+		return bedrock.Config{}, errors.New("Missing required environment variables: AWS_ACCESS_KEY_ID and/or AWS_SECRET_ACCESS_KEY and/or AWS_REGION")
+	}
+
+	endpoint := *f.endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	}
+	useFIPS := *f.fips || strings.EqualFold(os.Getenv("AWS_USE_FIPS_ENDPOINT"), "true")
+
+	retryMode := *f.sdkRetryMode
+	if retryMode == "" {
+		retryMode = os.Getenv("AWS_RETRY_MODE")
+	}
+	mode := aws.RetryModeStandard
+	if retryMode != "" {
+		var err error
+		if mode, err = aws.ParseRetryMode(retryMode); err != nil {
+			return bedrock.Config{}, fmt.Errorf("invalid -sdk-retry-mode %q: use standard or adaptive", retryMode)
+		}
+	}
+	maxAttempts := *f.sdkMaxAttempts
+	if maxAttempts == 0 {
+		if env := os.Getenv("AWS_MAX_ATTEMPTS"); env != "" {
+			var err error
+			if maxAttempts, err = strconv.Atoi(env); err != nil {
+				return bedrock.Config{}, fmt.Errorf("invalid AWS_MAX_ATTEMPTS %q: %v", env, err)
+			}
+		}
+	}
+	if maxAttempts == 0 {
+		maxAttempts = retry.DefaultMaxAttempts
+	}
+	if maxAttempts < 1 {
+		return bedrock.Config{}, errors.New("-sdk-max-attempts must be at least 1")
+	}
+	if *f.sdkMaxBackoff <= 0 {
+		return bedrock.Config{}, errors.New("-sdk-max-backoff must be positive")
+	}
+	if mode != aws.RetryModeStandard || maxAttempts != retry.DefaultMaxAttempts || *f.sdkMaxBackoff != retry.DefaultMaxBackoff {
+		log.Printf("AWS SDK retries: %s mode, %d attempts, backoff up to %s", mode, maxAttempts, *f.sdkMaxBackoff)
+	}
+
+	recorder, err := fixtureRecorder(f)
+	if err != nil {
+		return bedrock.Config{}, err
+	}
+	var wrapHTTPClient func(aws.HTTPClient) aws.HTTPClient
+	if recorder != nil {
+		wrapHTTPClient = recorder.Wrap
+	}
+
+	return bedrock.Config{
+		AccessKeyID:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+		Region:          awsRegion,
+		Endpoint:        endpoint,
+		UseFIPS:         useFIPS,
+
+		RetryMode:        mode,
+		RetryMaxAttempts: maxAttempts,
+		RetryMaxBackoff:  *f.sdkMaxBackoff,
+
+		WrapHTTPClient: wrapHTTPClient,
+	}, nil
+}
+
+// fixtureRecorder creates the recorder of -record-fixtures or -replay-fixtures; nil when
+// neither is set, or when the command doesn't define them
+func fixtureRecorder(f *flags) (*vcr.Recorder, error) {
+	record, replay := "", ""
+	if f.recordDir != nil {
+		record, replay = *f.recordDir, *f.replayDir
+	}
+	switch {
+	case record != "" && replay != "":
+		return nil, errors.New("-record-fixtures and -replay-fixtures can't be combined")
+	case record != "":
+		log.Printf("Recording Bedrock exchanges to %s", record)
+		return vcr.New(vcr.ModeRecord, record)
+	case replay != "":
+		log.Printf("Replaying Bedrock exchanges from %s", replay)
+		return vcr.New(vcr.ModeReplay, replay)
+	}
+	return nil, nil
+}
+
+// conversation formats the prompt for an input, preceded by any few-shot examples and with
+// the -image, -document and -video attached
+func (e *extractor) conversation(input string) ([]bedrock.Turn, error) {
+	turns, err := e.task.Conversation(input, e.examples)
+	if err == nil {
+		turns[len(turns)-1].Images = e.images
+		turns[len(turns)-1].Documents = e.documents
+		turns[len(turns)-1].Videos = e.videos
+	}
+	return turns, err
+}
+
+// extract invokes the model for one input, re-prompting until the output passes the task's
+// checks. A *bedrock.ParseError is returned together with the last result when it never does.
+// With a hedge, the input is raced against the hedge target, and with fallbacks each is
+// tried in turn after a failure. The outcome is recorded in the history.
+func (e *extractor) extract(ctx context.Context, input string) (*bedrock.Result, error) {
+	start := time.Now()
+	var result *bedrock.Result
+	var err error
+	switch {
+	case len(e.fallbacks) > 0:
+		result, err = e.extractWithFallback(ctx, input)
+	case e.hedge != nil:
+		result, err = e.extractHedged(ctx, input)
+	default:
+		result, err = e.extractOnce(ctx, input)
+	}
+	e.history.record(ctx, e, input, start, result, err)
+	return result, err
+}
+
+// extractOnce runs the extraction on the extractor's own model
+func (e *extractor) extractOnce(ctx context.Context, input string) (*bedrock.Result, error) {
+	turns, err := e.conversation(input)
+	if err != nil {
+		return nil, err
+	}
+	key := e.cacheKey(turns)
+	if result, ok := e.cache.get(ctx, key); ok {
+		return result, nil
+	}
+	e.checkContextWindow(ctx, turns)
+	validate := func(text string) error {
+		_, _, err := e.task.Check(text)
+		return err
+	}
+	result, err := bedrock.InvokeWithRepair(ctx, e.model, turns, e.maxRepairs, validate)
+	if result != nil {
+		result.Prompt = e.task.PromptRef()
+		result.PromptVersion = e.task.Version()
+		result.Revision = tasks.BuildRevision()
+		e.metrics.observeRepairs(result.Model, result.Repairs)
+	}
+	if err == nil {
+		e.cache.put(ctx, key, result)
+	}
+	return result, err
+}
+
+// close logs end-of-run statistics, flushes the buffered traces, audit records and usage, and
+// closes the history
+func (e *extractor) close() {
+	if e.opts.Provisioned != nil {
+		e.opts.Provisioned.LogUtilization()
+	}
+	e.audit.close()
+	e.cache.close()
+	e.history.close()
+	e.budget.logSpend()
+	e.metrics.usage.flush()
+	if err := e.shutdownTracing(context.Background()); err != nil {
+		log.Printf("Error exporting traces: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/s3io"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Redaction modes of the audit log
+const (
+	// redactFull leaves the prompt out of the audit log, keeping only its hash
+	redactFull = "full"
+	// redactPatterns keeps the prompt and output with sensitive matches masked
+	redactPatterns = "patterns"
+	// redactNone keeps the prompt verbatim
+	redactNone = "none"
+)
+
+// redactedText replaces the text matched by a redaction pattern
+const redactedText = "[REDACTED]"
+
+// auditRotateInterval is how long an S3 audit object collects records before it is uploaded
+const auditRotateInterval = 5 * time.Minute
+
+// defaultRedactPatterns mask common personal and secret data: e-mail addresses, card
+// numbers, phone numbers, US social security numbers and AWS access key IDs
+var defaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+	regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4}\b`),
+	regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`),
+}
+
+// patternsFlag collects the regular expressions of a repeatable flag
+type patternsFlag []*regexp.Regexp
+
+func (p *patternsFlag) String() string {
+	sources := make([]string, len(*p))
+	for i, pattern := range *p {
+		sources[i] = pattern.String()
+	}
+	return strings.Join(sources, " ")
+}
+
+func (p *patternsFlag) Set(source string) error {
+	pattern, err := regexp.Compile(source)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, pattern)
+	return nil
+}
+
+// auditRecord is one line of the audit log: a model invocation and its response
+type auditRecord struct {
+	Time         time.Time `json:"time"`
+	Model        string    `json:"model"`
+	Task         string    `json:"task"`
+	APIKey       string    `json:"api_key,omitempty"`
+	PromptSHA256 string    `json:"prompt_sha256"`
+	Prompt       string    `json:"prompt,omitempty"`
+	Turns        int       `json:"turns"`
+	DurationMs   int64     `json:"duration_ms"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	StopReason   string    `json:"stop_reason,omitempty"`
+	Output       string    `json:"output,omitempty"`
+	Truncated    bool      `json:"output_truncated,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	// Guardrail tells which policies of the -guardrail-id guardrail fired when it intervened
+	Guardrail *bedrock.GuardrailTrace `json:"guardrail,omitempty"`
+}
+
+// auditLog appends a JSON line per model invocation to a local file, or to objects under an
+// S3 prefix that are rolled every few minutes
+type auditLog struct {
+	task      string
+	redact    string
+	patterns  []*regexp.Regexp
+	maxOutput int
+
+	mu sync.Mutex
+	w  io.WriteCloser
+	// open starts the next S3 object; nil for a local file, which is never rotated
+	open     func() (io.WriteCloser, error)
+	openedAt time.Time
+}
+
+// newAuditLog opens the audit log at a path or s3://bucket/prefix/
+func (e *extractor) newAuditLog(ctx context.Context, target, redact string, patterns []*regexp.Regexp, maxOutput int) (*auditLog, error) {
+	switch redact {
+	case redactFull, redactPatterns, redactNone:
+	default:
+		return nil, fmt.Errorf("invalid -audit-redact %q: use %s, %s or %s", redact, redactFull, redactPatterns, redactNone)
+	}
+	if maxOutput < 0 {
+		return nil, fmt.Errorf("-audit-max-output can't be negative")
+	}
+	a := &auditLog{
+		task:      e.task.Name,
+		redact:    redact,
+		patterns:  append(append([]*regexp.Regexp{}, defaultRedactPatterns...), patterns...),
+		maxOutput: maxOutput,
+	}
+
+	location, isS3, err := s3io.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if !isS3 {
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the audit log: %v", err)
+		}
+		a.w = file
+		return a, nil
+	}
+
+	if !location.IsPrefix() {
+		location.Key += "/"
+	}
+	client, err := e.s3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	sequence := 0
+	a.open = func() (io.WriteCloser, error) {
+		sequence++
+		now := time.Now().UTC()
+		object := location
+		object.Key = path.Join(location.Key, now.Format("2006/01/02"),
+			fmt.Sprintf("%s-%s-%d-%d.jsonl", now.Format("20060102T150405Z"), host, os.Getpid(), sequence))
+		return s3io.Create(context.WithoutCancel(ctx), client, object, "application/x-ndjson"), nil
+	}
+	return a, nil
+}
+
+// write appends a record, starting a new S3 object when the current one is due
+func (a *auditLog) write(record auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.open != nil && a.w != nil && time.Since(a.openedAt) >= auditRotateInterval {
+		a.closeObject()
+	}
+	if a.w == nil {
+		if a.open == nil {
+			return
+		}
+		w, err := a.open()
+		if err != nil {
+			log.Printf("Error opening the audit log: %v", err)
+			return
+		}
+		a.w, a.openedAt = w, time.Now()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error encoding audit record: %v", err)
+		return
+	}
+	if _, err := a.w.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing the audit log: %v", err)
+	}
+}
+
+// closeObject uploads the current S3 object; the caller holds a.mu
+func (a *auditLog) closeObject() {
+	if err := a.w.Close(); err != nil {
+		log.Printf("Error writing the audit log: %v", err)
+	}
+	a.w = nil
+}
+
+// flush uploads the records written to S3 so far, e.g. before a Lambda invocation is frozen.
+// Writes to a local file aren't buffered, so there is nothing to flush.
+func (a *auditLog) flush() {
+	if a == nil || a.open == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.w != nil {
+		a.closeObject()
+	}
+}
+
+// close flushes and closes the audit log
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.w != nil {
+		a.closeObject()
+	}
+}
+
+// mask replaces the matches of the redaction patterns
+func (a *auditLog) mask(text string) string {
+	for _, pattern := range a.patterns {
+		text = pattern.ReplaceAllString(text, redactedText)
+	}
+	return text
+}
+
+// guardrailTrace redacts a guardrail trace like the prompt and the output it was found in. The
+// matches found in the prompt are left out with full redaction, which leaves out the prompt.
+func (a *auditLog) guardrailTrace(trace *bedrock.GuardrailTrace) *bedrock.GuardrailTrace {
+	if trace == nil {
+		return nil
+	}
+	redacted := &bedrock.GuardrailTrace{Reason: trace.Reason}
+	for _, finding := range trace.Input {
+		switch a.redact {
+		case redactFull:
+			finding.Match = ""
+		case redactPatterns:
+			finding.Match = a.mask(finding.Match)
+		}
+		redacted.Input = append(redacted.Input, finding)
+	}
+	for _, finding := range trace.Output {
+		if a.redact == redactPatterns {
+			finding.Match = a.mask(finding.Match)
+		}
+		redacted.Output = append(redacted.Output, finding)
+	}
+	for _, output := range trace.ModelOutput {
+		if a.redact == redactPatterns {
+			output = a.mask(output)
+		}
+		output, _ = truncate(output, a.maxOutput)
+		redacted.ModelOutput = append(redacted.ModelOutput, output)
+	}
+	return redacted
+}
+
+// record builds the audit record of an invocation
+func (a *auditLog) record(ctx context.Context, model string, turns []bedrock.Turn, start time.Time, result *bedrock.Result, err error) auditRecord {
+	prompt := bedrock.FormatTranscript(turns)
+	hash := sha256.Sum256([]byte(prompt))
+	record := auditRecord{
+		Time:         start.UTC(),
+		Model:        model,
+		Task:         a.task,
+		PromptSHA256: hex.EncodeToString(hash[:]),
+		Turns:        len(turns),
+		DurationMs:   time.Since(start).Milliseconds(),
+	}
+	if key := requestKeyState(ctx); key != nil {
+		record.APIKey = key.name
+	}
+	switch a.redact {
+	case redactNone:
+		record.Prompt = prompt
+	case redactPatterns:
+		record.Prompt = a.mask(prompt)
+	}
+	if result != nil {
+		record.InputTokens, record.OutputTokens = result.InputTokens, result.OutputTokens
+		record.StopReason = result.StopReason
+		output := result.Text
+		if a.redact == redactPatterns {
+			output = a.mask(output)
+		}
+		record.Output, record.Truncated = truncate(output, a.maxOutput)
+		record.Guardrail = a.guardrailTrace(result.Guardrail)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// truncate shortens text to at most max bytes without splitting a character
+func truncate(text string, max int) (string, bool) {
+	if len(text) <= max {
+		return text, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut], true
+}
+
+// wrap returns a model whose invocations are audited; the model itself when auditing is off
+func (a *auditLog) wrap(model bedrock.Model) bedrock.Model {
+	if a == nil {
+		return model
+	}
+	return &auditedModel{Model: model, audit: a}
+}
+
+// auditedModel is a Model whose invocations are written to the audit log
+type auditedModel struct {
+	bedrock.Model
+	audit *auditLog
+}
+
+func (m *auditedModel) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *auditedModel) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	start := time.Now()
+	result, err := m.Model.Chat(ctx, turns)
+	m.audit.write(m.audit.record(ctx, m.Name(), turns, start, result, err))
+	return result, err
+}
+
+func (m *auditedModel) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	streamer, ok := m.Model.(bedrock.Streamer)
+	if !ok {
+		return nil, fmt.Errorf("the %s model doesn't support streaming", m.Name())
+	}
+	start := time.Now()
+	result, err := streamer.Stream(ctx, turns, onText)
+	m.audit.write(m.audit.record(ctx, m.Name(), turns, start, result, err))
+	return result, err
+}
+
+func (m *auditedModel) UseTools(ctx context.Context, turns []bedrock.Turn, tools []bedrock.Tool, onCall func(call bedrock.ToolCall)) (*bedrock.Result, error) {
+	toolUser, ok := m.Model.(bedrock.ToolUser)
+	if !ok {
+		return nil, fmt.Errorf("the %s model can't call tools", m.Name())
+	}
+	start := time.Now()
+	result, err := toolUser.UseTools(ctx, turns, tools, onCall)
+	m.audit.write(m.audit.record(ctx, m.Name(), turns, start, result, err))
+	return result, err
+}
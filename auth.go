@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// apiKey is an entry of the -api-keys file
+type apiKey struct {
+	// Name identifies the key in logs and usage reports
+	Name string `json:"name"`
+	// Key is the secret clients send
+	Key string `json:"key"`
+	// RPS and TPM limit the key's requests per second and tokens per minute; zero is unlimited
+	RPS float64 `json:"rps,omitempty"`
+	TPM int     `json:"tpm,omitempty"`
+}
+
+// keyUsage is the accounting of one API key
+type keyUsage struct {
+	Requests     int64 `json:"requests"`
+	Rejected     int64 `json:"rejected"`
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// keyState is the limiter and usage of one API key
+type keyState struct {
+	name    string
+	limiter *bedrock.Limiter
+
+	mu    sync.Mutex
+	usage keyUsage
+}
+
+// authenticator checks API keys and enforces their limits. Keys are looked up by their hash so
+// the lookup time doesn't depend on how much of a guess matches.
+type authenticator struct {
+	keys map[[sha256.Size]byte]*keyState
+}
+
+// keyContext is the context key under which an authenticated request carries its key
+type keyContext struct{}
+
+// loadAPIKeys reads the -api-keys file; an empty path disables authentication
+func loadAPIKeys(path string) (*authenticator, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %v", err)
+	}
+	var keys []apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %v", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("API keys file %s has no keys", path)
+	}
+
+	a := &authenticator{keys: map[[sha256.Size]byte]*keyState{}}
+	names := map[string]bool{}
+	for _, key := range keys {
+		switch {
+		case key.Name == "":
+			return nil, errors.New("every API key needs a name")
+		case names[key.Name]:
+			return nil, fmt.Errorf("duplicate API key name %q", key.Name)
+		case len(key.Key) < 16:
+			return nil, fmt.Errorf("API key %q is shorter than 16 characters", key.Name)
+		case key.RPS < 0 || key.TPM < 0:
+			return nil, fmt.Errorf("API key %q has a negative limit", key.Name)
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := a.keys[hash]; ok {
+			return nil, fmt.Errorf("API key %q reuses the secret of another key", key.Name)
+		}
+		names[key.Name] = true
+		a.keys[hash] = &keyState{name: key.Name, limiter: bedrock.NewLimiter(key.RPS, key.TPM)}
+	}
+	return a, nil
+}
+
+// requestKey returns the secret sent with a request: a bearer token, an X-API-Key header, or an
+// api_key query parameter for WebSockets, which browsers can't send headers with
+func requestKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if r.URL.Path == "/chat" {
+		return r.URL.Query().Get("api_key")
+	}
+	return ""
+}
+
+// middleware rejects requests without a valid key (401) or over the key's limits (429).
+// Health checks and metrics stay open so probes and scrapers don't need a key, and usage
+// lookups don't count against the limits.
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fail := writeError
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			fail = writeOpenAIError
+		}
+
+		key, ok := a.keys[sha256.Sum256([]byte(requestKey(r)))]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bedrock-llama"`)
+			fail(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		if r.URL.Path != "/usage" && !key.allow() {
+			log.Printf("Rate limit exceeded for API key %s: %s %s", key.name, r.Method, r.URL.Path)
+			fail(w, http.StatusTooManyRequests, "rate limit exceeded for this API key")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keyContext{}, key)))
+	})
+}
+
+// allow takes one request from the key's limits, counting it as requested or rejected
+func (k *keyState) allow() bool {
+	allowed := k.limiter.Allow()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if allowed {
+		k.usage.Requests++
+	} else {
+		k.usage.Rejected++
+	}
+	return allowed
+}
+
+// charge records the tokens a request used against its key
+func (k *keyState) charge(inputTokens, outputTokens int) {
+	k.limiter.Charge(inputTokens + outputTokens)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.usage.InputTokens += int64(inputTokens)
+	k.usage.OutputTokens += int64(outputTokens)
+}
+
+// snapshot returns a copy of the key's usage
+func (k *keyState) snapshot() keyUsage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.usage
+}
+
+// requestKeyState returns the key of an authenticated request, or nil without authentication
+func requestKeyState(ctx context.Context) *keyState {
+	key, _ := ctx.Value(keyContext{}).(*keyState)
+	return key
+}
+
+// charge records token usage against the key of the request, if any
+func charge(ctx context.Context, inputTokens, outputTokens int) {
+	if key := requestKeyState(ctx); key != nil {
+		key.charge(inputTokens, outputTokens)
+	}
+}
+
+// handleUsage returns the usage of the calling API key
+func (s *server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	key := requestKeyState(r.Context())
+	if key == nil {
+		writeError(w, http.StatusNotFound, "usage accounting requires -api-keys")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": key.name, "usage": key.snapshot()})
+}
+
+// logUsage logs the usage of every key, e.g. on shutdown
+func (a *authenticator) logUsage() {
+	keys := make([]*keyState, 0, len(a.keys))
+	for _, key := range a.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+	for _, key := range keys {
+		usage := key.snapshot()
+		log.Printf("API key %s: %d requests (%d rejected), %d input / %d output tokens",
+			key.name, usage.Requests, usage.Rejected, usage.InputTokens, usage.OutputTokens)
+	}
+}
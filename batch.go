@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"bedrock-llama/pricing"
+	"bedrock-llama/render"
+	"bedrock-llama/s3io"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxLineSize is the longest input or checkpoint line accepted
+const maxLineSize = 1024 * 1024
+
+// exitInterrupted is the exit status of a batch stopped by SIGINT or SIGTERM, the status a shell
+// reports for a process killed by SIGINT
+const exitInterrupted = 130
+
+// batchItem is one input of a batch run. In JSONL mode each input line is decoded into it.
+type batchItem struct {
+	// ID identifies the item in the output; it defaults to the input line number
+	ID string `json:"id"`
+	// Input is the text to extract from
+	Input string `json:"input"`
+	// Metadata is passed through to the output unchanged
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+
+	// invalid tells why the input line isn't a valid item, which fails without being run
+	invalid string
+}
+
+// batchOutput is the result of one batch item, written as a JSONL record in JSONL mode
+type batchOutput struct {
+	ID       string          `json:"id"`
+	Input    string          `json:"input"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Records  json.RawMessage `json:"records,omitempty"`
+	Model    string          `json:"model"`
+	// FallbackFrom lists the models that failed before Model produced the result
+	FallbackFrom []string `json:"fallback_from,omitempty"`
+	InputTokens  int      `json:"input_tokens"`
+	OutputTokens int      `json:"output_tokens"`
+	// CostUSD is the estimated on-demand cost of the tokens
+	CostUSD   float64 `json:"estimated_cost_usd"`
+	LatencyMs int64   `json:"latency_ms"`
+	// StopReason is why the model stopped, as it reported it; Status says whether that left the
+	// output complete, truncated or filtered
+	StopReason string   `json:"stop_reason,omitempty"`
+	Status     string   `json:"status,omitempty"`
+	Repairs    int      `json:"repairs,omitempty"`
+	Issues     []string `json:"issues,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	// AWSError holds the request ID and error code when a Bedrock request failed
+	AWSError *bedrock.APIError `json:"aws_error,omitempty"`
+	// GuardrailIntervened is set when the -guardrail-id guardrail blocked the input or the response
+	GuardrailIntervened bool `json:"guardrail_intervened,omitempty"`
+	// GuardrailTrace tells which of the guardrail's policies fired
+	GuardrailTrace *bedrock.GuardrailTrace `json:"guardrail_trace,omitempty"`
+	// Moderation holds the -llama-guard verdicts, with the categories of unsafe content
+	Moderation *bedrock.Moderation `json:"moderation,omitempty"`
+	// Thinking is the model's reasoning, with -show-thinking
+	Thinking string `json:"thinking,omitempty"`
+	// Usage details the consumption of the item with -usage
+	Usage *callUsage `json:"usage,omitempty"`
+	// Cached is set when the result came from -cache without invoking the model
+	Cached bool `json:"cached,omitempty"`
+	// DuplicateOf is the id of the earlier item with the same input whose result was reused
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Account is the -accounts account that processed the item
+	Account string `json:"account,omitempty"`
+
+	// resumed is set for outputs restored from a checkpoint
+	resumed bool
+	// invalid is set when the output never passed the task's checks
+	invalid bool
+	// err is the error behind Error, for classifying failures
+	err error
+}
+
+// runFunc processes one batch item
+type runFunc func(context.Context, batchItem) batchOutput
+
+// callUsage is the consumption of one extraction, reported in the JSON output with -usage.
+// Tokens and latency cover the corrective re-prompts as well.
+type callUsage struct {
+	Model        string `json:"model"`
+	ModelID      string `json:"model_id"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	// CostUSD is the estimated on-demand cost of the tokens
+	CostUSD    float64 `json:"estimated_cost_usd"`
+	LatencyMs  int64   `json:"latency_ms"`
+	StopReason string  `json:"stop_reason,omitempty"`
+	Status     string  `json:"status,omitempty"`
+	Repairs    int     `json:"repairs"`
+	// FallbackFrom lists the models that failed before Model produced the result
+	FallbackFrom []string `json:"fallback_from,omitempty"`
+}
+
+// newCallUsage describes the consumption of an extraction by the model that produced the result
+func newCallUsage(result *bedrock.Result, latency time.Duration) *callUsage {
+	info, _ := models.Lookup(result.Model)
+	return &callUsage{
+		Model:        result.Model,
+		ModelID:      info.ModelID,
+		InputTokens:  result.InputTokens,
+		OutputTokens: result.OutputTokens,
+		CostUSD:      pricing.Cost(result.VersionedModel(), result.InputTokens, result.OutputTokens),
+		LatencyMs:    latency.Milliseconds(),
+		StopReason:   result.StopReason,
+		Status:       result.Status(),
+		Repairs:      result.Repairs,
+		FallbackFrom: result.FallbackFrom,
+	}
+}
+
+// runBatch extracts every input listed in a file, writing one output line per input
+func runBatch(args []string) {
+	runBatchWith(args, nil)
+}
+
+// runBatchWith runs a batch. original is the manifest of the run that rerun repeats, whose flag
+// values and results the batch is held to; nil otherwise.
+func runBatchWith(args []string, original *runManifest) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	f := registerFlags(fs)
+	inputFileFlag := fs.String("input-file", "", "File with one input per line, an s3://bucket/key object, or - for stdin")
+	jsonlFlag := fs.Bool("jsonl", false, "Read JSONL input records and write JSONL output records (default for .jsonl input files)")
+	concurrencyFlag := fs.Int("concurrency", 1, "Number of inputs processed in parallel")
+	orderedFlag := fs.Bool("ordered", true, "Write outputs in input order; with -ordered=false they are written as they complete")
+	dedupeFlag := fs.Bool("dedupe", true, "Invoke the model once per distinct input and reuse the result for duplicates")
+	checkpointFlag := fs.String("checkpoint", "", "JSONL file recording completed inputs; rerunning with the same file skips them")
+	accountsFlag := fs.String("accounts", "", `JSON file of AWS accounts or roles to spread the inputs over, each with its own quota, e.g. [{"name": "a"}, {"name": "b", "role_arn": "arn:aws:iam::222222222222:role/bedrock"}]`)
+	manifestFlag := fs.String("manifest", "", "File or s3://bucket/key to write the run manifest to: the configuration, prompt and model versions and the input and result checksums, for reproducing the run with 'rerun'")
+	fs.Parse(args)
+	if original != nil {
+		original.applyFlags(fs)
+	}
+
+	if *inputFileFlag == "" {
+		fatalf("Batch mode needs an input file. Provide one using the -input-file flag.")
+	}
+	// -output is either the json format or an S3 destination for the JSON lines
+	destination, toS3, err := s3io.Parse(*f.output)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if toS3 {
+		*f.output = render.JSON
+	}
+	if format := strings.ToLower(*f.output); format != render.JSON {
+		fatalf("Batch mode writes one JSON line per input; -output=%s is not supported", format)
+	}
+	if *concurrencyFlag < 1 {
+		fatalf("-concurrency must be at least 1")
+	}
+	jsonl := *jsonlFlag || strings.HasSuffix(*inputFileFlag, ".jsonl")
+	if *f.usage && !jsonl {
+		fatalf("-usage needs JSONL output; add -jsonl")
+	}
+
+	// A signal cancels the in-flight calls and stops new ones; the run then winds down normally so
+	// that completed results, the checkpoint and the usage are still written. After the first
+	// signal the default handling is restored, so a second one kills the process.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		warnf("received %s, cancelling in-flight requests (send it again to exit immediately)", sig)
+		cancel()
+	}()
+
+	runWithExtractor(ctx, f, func(e *extractor) error {
+		var manifest *runManifest
+		if *manifestFlag != "" {
+			if manifest, err = e.newRunManifest(fs, args, *f.examples); err != nil {
+				return err
+			}
+			if original != nil {
+				original.compare(manifest)
+			}
+		}
+
+		input, total, err := openBatchInput(ctx, e.awsConfig, *inputFileFlag)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		var output io.Writer = os.Stdout
+		outputName := "stdout"
+		var upload *s3io.Writer
+		if toS3 {
+			if destination.IsPrefix() {
+				destination.Key += resultsName(*inputFileFlag)
+			}
+			client, err := e.s3Client(ctx)
+			if err != nil {
+				return err
+			}
+			log.Printf("Writing results to %s", destination)
+			// The results completed before an interruption are still uploaded
+			upload = s3io.Create(context.WithoutCancel(ctx), client, destination, "application/x-ndjson")
+			output = upload
+			outputName = destination.String()
+		}
+		// The checksums of the input read and the output written go into the manifest
+		inputSum, outputSum := newChecksum(), newChecksum()
+		output = io.MultiWriter(output, outputSum)
+
+		runners := []runFunc{e.runItem}
+		if *accountsFlag != "" {
+			if runners, err = e.accountRunners(ctx, *accountsFlag, *f.rps, *f.tpm); err != nil {
+				return err
+			}
+			log.Printf("Spreading the inputs over %d accounts", len(runners))
+		}
+		// The deduper and the checkpoint are shared by the accounts
+		var dedupe *deduper
+		if *dedupeFlag {
+			dedupe = newDeduper()
+			for i := range runners {
+				runners[i] = dedupe.wrap(runners[i])
+			}
+		}
+		var cp *checkpoint
+		if *checkpointFlag != "" {
+			cp, err = openCheckpoint(*checkpointFlag)
+			if err != nil {
+				return err
+			}
+			log.Printf("Checkpoint %s holds %d completed inputs", *checkpointFlag, len(cp.done))
+			for i, next := range runners {
+				runners[i] = func(ctx context.Context, item batchItem) batchOutput {
+					if out, ok := cp.completed(item); ok {
+						out.resumed = true
+						return out
+					}
+					return next(ctx, item)
+				}
+			}
+		}
+
+		// Inputs are streamed to the workers as they are read
+		items := make(chan batchItem)
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- readItems(io.TeeReader(input, inputSum), jsonl, items)
+			close(items)
+		}()
+
+		summary := newBatchSummary(total)
+		var budgetErr, recordErr error
+		processItems(ctx, items, *concurrencyFlag, *orderedFlag, runners, func(out batchOutput) {
+			if errors.Is(out.err, errBudgetExceeded) {
+				// Once the budget is spent no further input can be processed; the run stops as
+				// if interrupted, leaving the remaining inputs for a resumed run
+				if budgetErr == nil {
+					budgetErr = out.err
+					cancel()
+				}
+				summary.interrupted++
+				return
+			}
+			if ctx.Err() != nil && (out.Error != "" || recordErr != nil) {
+				// Cancelled by the interruption; left out of the output and checkpoint so that
+				// resuming retries it
+				summary.interrupted++
+				return
+			}
+			summary.add(out)
+			if manifest != nil {
+				manifest.Results[out.ID] = resultHash(out)
+			}
+			if cp != nil && !out.resumed {
+				if err := cp.record(out); err != nil && recordErr == nil {
+					// The run stops, since the checkpoint can no longer tell what was completed
+					recordErr = err
+					cancel()
+				}
+			}
+			writeOutput(output, out, jsonl)
+		})
+
+		if upload != nil {
+			if err := upload.Close(); err != nil {
+				return err
+			}
+		}
+		summary.print()
+		if dedupe != nil {
+			dedupe.logSavings()
+		}
+		if cp != nil {
+			cp.close()
+		}
+		// The reader may be blocked on inputs that will never be processed after an interruption
+		var inputErr error
+		complete := budgetErr == nil && ctx.Err() == nil
+		if complete {
+			inputErr = <-readErr
+			complete = inputErr == nil
+		}
+		if manifest != nil {
+			manifest.Input, manifest.Output = inputSum.of(*inputFileFlag), outputSum.of(outputName)
+			manifest.Items, manifest.Failed, manifest.Interrupted = summary.done, summary.failed, summary.interrupted
+			manifest.Complete = complete
+			if err := e.writeManifest(context.WithoutCancel(ctx), *manifestFlag, manifest); err != nil {
+				return err
+			}
+			log.Printf("Wrote the run manifest to %s", *manifestFlag)
+			if original != nil {
+				original.verify(manifest)
+			}
+		}
+		switch {
+		case budgetErr != nil:
+			return budgetErr
+		case recordErr != nil:
+			return recordErr
+		case ctx.Err() != nil:
+			return exitStatus(exitInterrupted)
+		case inputErr != nil:
+			return inputErr
+		case summary.failed > 0:
+			return exitStatus(1)
+		}
+		return nil
+	})
+}
+
+// openBatchInput opens a local file, an S3 object or stdin. total is the number of inputs
+// when it can be counted up front, or 0 for streams.
+func openBatchInput(ctx context.Context, cfg bedrock.Config, path string) (r io.ReadCloser, total int, err error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), 0, nil
+	}
+	location, isS3, err := s3io.Parse(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if isS3 {
+		client, err := newS3Client(ctx, cfg)
+		if err != nil {
+			return nil, 0, err
+		}
+		body, err := s3io.Open(ctx, client, location)
+		return body, 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open input file: %v", err)
+	}
+	total, err = countLines(file)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, total, nil
+}
+
+// s3Client creates an S3 client with the same credentials as the Bedrock client
+func (e *extractor) s3Client(ctx context.Context) (*s3.Client, error) {
+	return newS3Client(ctx, e.awsConfig)
+}
+
+// newS3Client creates an S3 client with the credentials of a Bedrock client configuration
+func newS3Client(ctx context.Context, cfg bedrock.Config) (*s3.Client, error) {
+	awsCfg, err := bedrock.LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(awsCfg), nil
+}
+
+// resultsName names the results object written under an S3 prefix after the input file
+func resultsName(inputPath string) string {
+	name := path.Base(inputPath)
+	if inputPath == "-" || name == "." || name == "/" {
+		return "results.jsonl"
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + ".results.jsonl"
+}
+
+// processItems runs the items on a pool of workers. Each item goes to the runner with the fewest
+// items in flight, taking turns on ties, so that a runner slowed down by throttling, such as an
+// account at its quota, is given less work. emit is called from a single goroutine, in input
+// order when ordered is set and in completion order otherwise.
+func processItems(ctx context.Context, items <-chan batchItem, workers int, ordered bool, runners []runFunc, emit func(batchOutput)) {
+	type job struct {
+		index int
+		item  batchItem
+	}
+	type indexed struct {
+		index int
+		out   batchOutput
+	}
+
+	var mu sync.Mutex
+	inFlight := make([]int, len(runners))
+	turn := 0
+	acquire := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		best := turn % len(runners)
+		for i := range runners {
+			if candidate := (turn + i) % len(runners); inFlight[candidate] < inFlight[best] {
+				best = candidate
+			}
+		}
+		inFlight[best]++
+		turn = best + 1
+		return best
+	}
+	release := func(runner int) {
+		mu.Lock()
+		inFlight[runner]--
+		mu.Unlock()
+	}
+
+	jobs := make(chan job)
+	results := make(chan indexed)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if j.item.invalid != "" {
+					warnf("%s", j.item.invalid)
+					results <- indexed{j.index, batchOutput{ID: j.item.ID, Input: j.item.Input, Metadata: j.item.Metadata, Error: j.item.invalid}}
+					continue
+				}
+				log.Printf("[%d] %q", j.index+1, j.item.Input)
+				runner := acquire()
+				out := runners[runner](ctx, j.item)
+				release(runner)
+				results <- indexed{j.index, out}
+			}
+		}()
+	}
+	go func() {
+		index := 0
+	dispatch:
+		for item := range items {
+			select {
+			case jobs <- job{index, item}:
+				index++
+			case <-ctx.Done():
+				// Inputs not yet started are left for a resumed run
+				break dispatch
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Outputs that finish ahead of an earlier item wait here in ordered mode
+	pending := map[int]batchOutput{}
+	next := 0
+	for result := range results {
+		if !ordered {
+			emit(result.out)
+			continue
+		}
+		pending[result.index] = result.out
+		for out, ok := pending[next]; ok; out, ok = pending[next] {
+			delete(pending, next)
+			emit(out)
+			next++
+		}
+	}
+}
+
+// runItem extracts the records of one batch item. Failures, including panics, are recorded in
+// the output's Error so that one bad item doesn't stop the run.
+func (e *extractor) runItem(ctx context.Context, item batchItem) (out batchOutput) {
+	defer func() {
+		if r := recover(); r != nil {
+			out.Error = fmt.Sprintf("panic: %v", r)
+			warnf("%s: %s", item.ID, out.Error)
+		}
+	}()
+
+	out = batchOutput{ID: item.ID, Input: item.Input, Metadata: item.Metadata, Model: e.modelInfo.Name, Account: e.account}
+
+	start := time.Now()
+	result, err := e.extract(ctx, item.Input)
+	latency := time.Since(start)
+	out.LatencyMs = latency.Milliseconds()
+	if result != nil {
+		out.Model = result.Model
+		out.FallbackFrom = result.FallbackFrom
+		out.Cached = result.Cached
+		out.InputTokens = result.InputTokens
+		out.OutputTokens = result.OutputTokens
+		out.CostUSD = pricing.Cost(result.VersionedModel(), result.InputTokens, result.OutputTokens)
+		out.StopReason = result.StopReason
+		out.Status = result.Status()
+		out.Repairs = result.Repairs
+		out.Moderation = result.Moderation
+		if e.showThinking {
+			out.Thinking = result.Thinking
+		}
+		if e.reportUsage {
+			out.Usage = newCallUsage(result, latency)
+		}
+	}
+
+	var parseErr *bedrock.ParseError
+	if errors.As(err, &parseErr) {
+		out.invalid = true
+		out.Error = fmt.Sprintf("output is still invalid after %d correction attempts: %v", result.Repairs, parseErr.Err)
+	} else if err != nil {
+		out.Error = err.Error()
+		out.err = err
+		errors.As(err, &out.AWSError)
+		if errors.Is(err, bedrock.ErrContentFiltered) {
+			out.Status = bedrock.StatusFiltered
+		}
+		var guardrailErr *bedrock.GuardrailError
+		if errors.As(err, &guardrailErr) {
+			out.GuardrailIntervened = true
+			out.GuardrailTrace = guardrailErr.Trace
+		}
+		var moderationErr *bedrock.ModerationError
+		if errors.As(err, &moderationErr) {
+			out.Moderation = moderationErr.Moderation()
+		}
+	}
+	if out.Error != "" {
+		warnf("%s: %s", item.ID, out.Error)
+		return out
+	}
+
+	if out.Status == bedrock.StatusTruncated {
+		warnf("%s: the response was cut off (%s), the records may be incomplete", item.ID, result.StopReason)
+	}
+	records, issues, _ := e.task.Check(result.Text)
+	out.Records = json.RawMessage(e.task.Schema.Format(records))
+	out.Issues = issues
+	for _, issue := range out.Issues {
+		warnf("%s: low quality output: %s", item.ID, issue)
+	}
+	return out
+}
+
+// writeOutput writes one batch output line. In text mode this is the JSON records, or an empty
+// line for a failed input, so that unless -ordered=false the output has a line for every
+// non-blank input line, in the same order.
+func writeOutput(w io.Writer, out batchOutput, jsonl bool) {
+	var line []byte
+	switch {
+	case jsonl:
+		var err error
+		line, err = json.Marshal(out)
+		if err != nil {
+			fatalf("Error encoding output for %s: %v", out.ID, err)
+		}
+	case out.Error == "":
+		line = out.Records
+	}
+	if _, err := fmt.Fprintln(w, string(line)); err != nil {
+		fatalf("Error writing output: %v", err)
+	}
+}
+
+// readItems sends the batch items read from r to items. Plain text holds one input per line;
+// blank lines are skipped. A JSONL line that isn't a valid item is sent as an invalid item, which
+// fails on its own without stopping the run.
+func readItems(r io.Reader, jsonl bool, items chan<- batchItem) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		item := batchItem{Input: line}
+		if jsonl {
+			item = batchItem{}
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				item = batchItem{Input: line, invalid: fmt.Sprintf("input line %d: invalid JSON: %v", lineNumber, err)}
+			} else if item.Input == "" {
+				item.invalid = fmt.Sprintf("input line %d: missing \"input\"", lineNumber)
+			}
+		}
+		if item.ID == "" {
+			item.ID = strconv.Itoa(lineNumber)
+		}
+		items <- item
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read input: %v", err)
+	}
+	return nil
+}
+
+// countLines counts the non-blank lines of r
+func countLines(r io.Reader) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			count++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read input file: %v", err)
+	}
+	return count, nil
+}
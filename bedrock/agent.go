@@ -0,0 +1,302 @@
+package bedrock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockagentruntime"
+	agenttypes "github.com/aws/aws-sdk-go-v2/service/bedrockagentruntime/types"
+)
+
+// AgentRequest is a prompt for an agent built with Bedrock Agents
+type AgentRequest struct {
+	// AgentID and AliasID identify the agent and the alias of the version to invoke
+	AgentID string
+	AliasID string
+	// SessionID continues an earlier conversation with the agent; a new session when empty
+	SessionID string
+	Input     string
+	// EndSession ends the session after this prompt
+	EndSession bool
+	// Trace asks the agent for the trace of its reasoning, passed to the onTrace callback
+	Trace bool
+	// Retry resends the request when it is throttled before the response starts
+	Retry RetryPolicy
+	// Timeout limits the invocation from the request to the end of the response; no limit when zero
+	Timeout time.Duration
+}
+
+// AgentResponse is the completed response of an agent
+type AgentResponse struct {
+	// SessionID continues the conversation in a later request
+	SessionID string
+	Text      string
+	// Sources lists the locations of the knowledge base documents the response cites
+	Sources []string
+	// InputTokens and OutputTokens add up the model invocations of the agent; they are only
+	// reported in traces, so they are zero unless the request asked for them
+	InputTokens  int
+	OutputTokens int
+}
+
+// AgentTrace is a step of an agent's reasoning
+type AgentTrace struct {
+	// Step names the kind of the step, e.g. "rationale" or "action"; empty for the steps the
+	// summary doesn't cover, such as the model input
+	Step string
+	// Summary is a one-line description of the step
+	Summary string
+	// Part is the complete trace received from the service
+	Part agenttypes.TracePart
+}
+
+// NewAgentClient creates a Bedrock Agents runtime client. Its endpoint is the region's, or
+// $AWS_ENDPOINT_URL_BEDROCK_AGENT_RUNTIME; the Bedrock runtime endpoint isn't used.
+func NewAgentClient(ctx context.Context, cfg Config) (*bedrockagentruntime.Client, error) {
+	awsCfg, err := LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return bedrockagentruntime.NewFromConfig(awsCfg), nil
+}
+
+// NewSessionID returns a random agent session ID
+func NewSessionID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// InvokeAgent sends a prompt to an agent, calling onText with each chunk of the response as it
+// arrives and onTrace with each step of the trace when req.Trace is set
+func InvokeAgent(ctx context.Context, client *bedrockagentruntime.Client, req AgentRequest, onText func(text string), onTrace func(trace AgentTrace)) (*AgentResponse, error) {
+	var response *AgentResponse
+	err := withTimeout(ctx, "InvokeAgent", req.Timeout, func(ctx context.Context) error {
+		var err error
+		response, err = invokeAgent(ctx, client, req, onText, onTrace)
+		return err
+	})
+	return response, err
+}
+
+// invokeAgent runs the stream of InvokeAgent
+func invokeAgent(ctx context.Context, client *bedrockagentruntime.Client, req AgentRequest, onText func(text string), onTrace func(trace AgentTrace)) (*AgentResponse, error) {
+	if req.SessionID == "" {
+		req.SessionID = NewSessionID()
+	}
+	agent := req.AgentID + "/" + req.AliasID
+	input := &bedrockagentruntime.InvokeAgentInput{
+		AgentId:      aws.String(req.AgentID),
+		AgentAliasId: aws.String(req.AliasID),
+		SessionId:    aws.String(req.SessionID),
+		InputText:    aws.String(req.Input),
+		EnableTrace:  aws.Bool(req.Trace),
+		EndSession:   aws.Bool(req.EndSession),
+	}
+
+	var output *bedrockagentruntime.InvokeAgentOutput
+	err := req.Retry.do(ctx, "InvokeAgent", func() error {
+		var err error
+		output, err = client.InvokeAgent(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to invoke agent %s: %w", agent, describeError("InvokeAgent", agent, err))
+	}
+	stream := output.GetStream()
+	defer stream.Close()
+
+	response := &AgentResponse{SessionID: aws.ToString(output.SessionId)}
+	if response.SessionID == "" {
+		response.SessionID = req.SessionID
+	}
+	var text strings.Builder
+	seen := map[string]bool{}
+	for event := range stream.Events() {
+		switch e := event.(type) {
+		case *agenttypes.ResponseStreamMemberChunk:
+			chunk := string(e.Value.Bytes)
+			text.WriteString(chunk)
+			if onText != nil {
+				onText(chunk)
+			}
+			if attribution := e.Value.Attribution; attribution != nil {
+				for _, citation := range attribution.Citations {
+					for _, reference := range citation.RetrievedReferences {
+						if source := referenceSource(reference); source != "" && !seen[source] {
+							seen[source] = true
+							response.Sources = append(response.Sources, source)
+						}
+					}
+				}
+			}
+		case *agenttypes.ResponseStreamMemberTrace:
+			trace := summarizeTrace(e.Value)
+			if usage := traceUsage(e.Value); usage != nil {
+				response.InputTokens += int(aws.ToInt32(usage.InputTokens))
+				response.OutputTokens += int(aws.ToInt32(usage.OutputTokens))
+			}
+			if onTrace != nil {
+				onTrace(trace)
+			}
+		case *agenttypes.ResponseStreamMemberReturnControl:
+			return nil, fmt.Errorf("agent %s returned control to run an action group, which needs the action to run in the application", agent)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("stream from agent %s failed: %w", agent, describeStreamError("InvokeAgent", agent, output.ResultMetadata, err))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	response.Text = text.String()
+	return response, nil
+}
+
+// referenceSource returns the location of a cited document, such as its S3 URI or URL
+func referenceSource(reference agenttypes.RetrievedReference) string {
+	return locationSource(reference.Location)
+}
+
+// locationSource returns a knowledge base document location as a URI, URL or document ID
+func locationSource(location *agenttypes.RetrievalResultLocation) string {
+	switch {
+	case location == nil:
+		return ""
+	case location.S3Location != nil:
+		return aws.ToString(location.S3Location.Uri)
+	case location.WebLocation != nil:
+		return aws.ToString(location.WebLocation.Url)
+	case location.ConfluenceLocation != nil:
+		return aws.ToString(location.ConfluenceLocation.Url)
+	case location.SharePointLocation != nil:
+		return aws.ToString(location.SharePointLocation.Url)
+	case location.SalesforceLocation != nil:
+		return aws.ToString(location.SalesforceLocation.Url)
+	case location.KendraDocumentLocation != nil:
+		return aws.ToString(location.KendraDocumentLocation.Uri)
+	case location.CustomDocumentLocation != nil:
+		return aws.ToString(location.CustomDocumentLocation.Id)
+	}
+	return ""
+}
+
+// summarizeTrace describes a trace part in one line
+func summarizeTrace(part agenttypes.TracePart) AgentTrace {
+	trace := AgentTrace{Part: part}
+	switch t := part.Trace.(type) {
+	case *agenttypes.TraceMemberOrchestrationTrace:
+		switch o := t.Value.(type) {
+		case *agenttypes.OrchestrationTraceMemberRationale:
+			trace.Step, trace.Summary = "rationale", aws.ToString(o.Value.Text)
+		case *agenttypes.OrchestrationTraceMemberInvocationInput:
+			trace.Step, trace.Summary = invocationSummary(o.Value)
+		case *agenttypes.OrchestrationTraceMemberObservation:
+			trace.Step, trace.Summary = observationSummary(o.Value)
+		}
+	case *agenttypes.TraceMemberPreProcessingTrace:
+		if o, ok := t.Value.(*agenttypes.PreProcessingTraceMemberModelInvocationOutput); ok && o.Value.ParsedResponse != nil {
+			valid := "valid"
+			if !aws.ToBool(o.Value.ParsedResponse.IsValid) {
+				valid = "rejected"
+			}
+			trace.Step, trace.Summary = "pre-processing", valid+": "+aws.ToString(o.Value.ParsedResponse.Rationale)
+		}
+	case *agenttypes.TraceMemberPostProcessingTrace:
+		if o, ok := t.Value.(*agenttypes.PostProcessingTraceMemberModelInvocationOutput); ok && o.Value.ParsedResponse != nil {
+			trace.Step, trace.Summary = "post-processing", aws.ToString(o.Value.ParsedResponse.Text)
+		}
+	case *agenttypes.TraceMemberGuardrailTrace:
+		trace.Step, trace.Summary = "guardrail", string(t.Value.Action)
+	case *agenttypes.TraceMemberFailureTrace:
+		trace.Step, trace.Summary = "failure", aws.ToString(t.Value.FailureReason)
+	}
+	trace.Summary = strings.Join(strings.Fields(trace.Summary), " ")
+	return trace
+}
+
+// invocationSummary describes what an agent is about to invoke
+func invocationSummary(input agenttypes.InvocationInput) (string, string) {
+	switch {
+	case input.ActionGroupInvocationInput != nil:
+		action := input.ActionGroupInvocationInput
+		name := aws.ToString(action.Function)
+		if name == "" {
+			name = aws.ToString(action.Verb) + " " + aws.ToString(action.ApiPath)
+		}
+		params := make([]string, len(action.Parameters))
+		for i, param := range action.Parameters {
+			params[i] = aws.ToString(param.Name) + "=" + aws.ToString(param.Value)
+		}
+		return "action", fmt.Sprintf("%s: %s(%s)", aws.ToString(action.ActionGroupName), name, strings.Join(params, ", "))
+	case input.KnowledgeBaseLookupInput != nil:
+		lookup := input.KnowledgeBaseLookupInput
+		return "knowledge base", fmt.Sprintf("%s: %s", aws.ToString(lookup.KnowledgeBaseId), aws.ToString(lookup.Text))
+	case input.AgentCollaboratorInvocationInput != nil:
+		collaborator := input.AgentCollaboratorInvocationInput
+		text := ""
+		if collaborator.Input != nil {
+			text = aws.ToString(collaborator.Input.Text)
+		}
+		return "collaborator", fmt.Sprintf("%s: %s", aws.ToString(collaborator.AgentCollaboratorName), text)
+	case input.CodeInterpreterInvocationInput != nil:
+		return "code", aws.ToString(input.CodeInterpreterInvocationInput.Code)
+	}
+	return "", ""
+}
+
+// observationSummary describes the outcome of an agent's step
+func observationSummary(observation agenttypes.Observation) (string, string) {
+	switch {
+	case observation.ActionGroupInvocationOutput != nil:
+		return "action result", aws.ToString(observation.ActionGroupInvocationOutput.Text)
+	case observation.KnowledgeBaseLookupOutput != nil:
+		return "knowledge base result", fmt.Sprintf("%d reference(s)", len(observation.KnowledgeBaseLookupOutput.RetrievedReferences))
+	case observation.AgentCollaboratorInvocationOutput != nil:
+		collaborator := observation.AgentCollaboratorInvocationOutput
+		text := ""
+		if collaborator.Output != nil {
+			text = aws.ToString(collaborator.Output.Text)
+		}
+		return "collaborator result", fmt.Sprintf("%s: %s", aws.ToString(collaborator.AgentCollaboratorName), text)
+	case observation.CodeInterpreterInvocationOutput != nil:
+		output := observation.CodeInterpreterInvocationOutput
+		if failure := aws.ToString(output.ExecutionError); failure != "" {
+			return "code result", failure
+		}
+		return "code result", aws.ToString(output.ExecutionOutput)
+	case observation.RepromptResponse != nil:
+		return "reprompt", aws.ToString(observation.RepromptResponse.Text)
+	case observation.FinalResponse != nil:
+		return "final response", aws.ToString(observation.FinalResponse.Text)
+	}
+	return "", ""
+}
+
+// traceUsage returns the tokens of the model invocation a trace part reports, if any
+func traceUsage(part agenttypes.TracePart) *agenttypes.Usage {
+	var metadata *agenttypes.Metadata
+	switch t := part.Trace.(type) {
+	case *agenttypes.TraceMemberOrchestrationTrace:
+		if o, ok := t.Value.(*agenttypes.OrchestrationTraceMemberModelInvocationOutput); ok {
+			metadata = o.Value.Metadata
+		}
+	case *agenttypes.TraceMemberPreProcessingTrace:
+		if o, ok := t.Value.(*agenttypes.PreProcessingTraceMemberModelInvocationOutput); ok {
+			metadata = o.Value.Metadata
+		}
+	case *agenttypes.TraceMemberPostProcessingTrace:
+		if o, ok := t.Value.(*agenttypes.PostProcessingTraceMemberModelInvocationOutput); ok {
+			metadata = o.Value.Metadata
+		}
+	}
+	if metadata == nil {
+		return nil
+	}
+	return metadata.Usage
+}
@@ -0,0 +1,114 @@
+package bedrock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// Statuses of an asynchronous invocation
+const (
+	AsyncInProgress = string(types.AsyncInvokeStatusInProgress)
+	AsyncCompleted  = string(types.AsyncInvokeStatusCompleted)
+	AsyncFailed     = string(types.AsyncInvokeStatusFailed)
+)
+
+// AsyncInvocation is the state of an asynchronous invocation, such as a video generation job
+type AsyncInvocation struct {
+	// ARN identifies the invocation
+	ARN    string
+	Status string
+	// OutputURI is the s3:// prefix the model writes its output under
+	OutputURI string
+	// Failure explains why a failed invocation failed
+	Failure string
+}
+
+// StartAsyncInvoke starts an asynchronous invocation of a model that writes its output under an
+// S3 prefix, and returns the invocation ARN. The model input is marshaled with its JSON tags, like
+// InvokeModel bodies. The request is retried like Invoke.
+func StartAsyncInvoke(ctx context.Context, client *bedrockruntime.Client, modelID string, modelInput any, outputURI string, opts Options) (string, error) {
+	// Smithy documents ignore JSON tags and encode []byte as arrays, so go through JSON first
+	data, err := json.Marshal(modelInput)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal model input: %v", err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", fmt.Errorf("failed to marshal model input: %v", err)
+	}
+	input := &bedrockruntime.StartAsyncInvokeInput{
+		ModelId:    aws.String(modelID),
+		ModelInput: document.NewLazyDocument(value),
+		OutputDataConfig: &types.AsyncInvokeOutputDataConfigMemberS3OutputDataConfig{
+			Value: types.AsyncInvokeS3OutputDataConfig{S3Uri: aws.String(outputURI)},
+		},
+	}
+	var output *bedrockruntime.StartAsyncInvokeOutput
+	err = opts.Retry.do(ctx, "StartAsyncInvoke", func() error {
+		return withTimeout(ctx, "StartAsyncInvoke", opts.Timeout, func(ctx context.Context) error {
+			var err error
+			output, err = client.StartAsyncInvoke(ctx, input)
+			return err
+		})
+	})
+	if err != nil {
+		return "", describeError("StartAsyncInvoke", modelID, err)
+	}
+	return aws.ToString(output.InvocationArn), nil
+}
+
+// GetAsyncInvoke returns the state of an asynchronous invocation
+func GetAsyncInvoke(ctx context.Context, client *bedrockruntime.Client, arn string, opts Options) (*AsyncInvocation, error) {
+	var output *bedrockruntime.GetAsyncInvokeOutput
+	err := opts.Retry.do(ctx, "GetAsyncInvoke", func() error {
+		return withTimeout(ctx, "GetAsyncInvoke", opts.Timeout, func(ctx context.Context) error {
+			var err error
+			output, err = client.GetAsyncInvoke(ctx, &bedrockruntime.GetAsyncInvokeInput{InvocationArn: aws.String(arn)})
+			return err
+		})
+	})
+	if err != nil {
+		return nil, describeError("GetAsyncInvoke", arn, err)
+	}
+	invocation := &AsyncInvocation{
+		ARN:     aws.ToString(output.InvocationArn),
+		Status:  string(output.Status),
+		Failure: aws.ToString(output.FailureMessage),
+	}
+	if config, ok := output.OutputDataConfig.(*types.AsyncInvokeOutputDataConfigMemberS3OutputDataConfig); ok {
+		invocation.OutputURI = aws.ToString(config.Value.S3Uri)
+	}
+	return invocation, nil
+}
+
+// WaitAsyncInvoke polls an asynchronous invocation every interval until it completes, calling
+// onPoll with each state. A failed invocation is returned with an error.
+func WaitAsyncInvoke(ctx context.Context, client *bedrockruntime.Client, arn string, interval time.Duration, opts Options, onPoll func(*AsyncInvocation)) (*AsyncInvocation, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		invocation, err := GetAsyncInvoke(ctx, client, arn, opts)
+		if err != nil {
+			return nil, err
+		}
+		onPoll(invocation)
+		switch invocation.Status {
+		case AsyncCompleted:
+			return invocation, nil
+		case AsyncFailed:
+			return invocation, fmt.Errorf("invocation %s failed: %s", arn, invocation.Failure)
+		}
+		select {
+		case <-ctx.Done():
+			return invocation, fmt.Errorf("stopped waiting for invocation %s: %w", arn, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
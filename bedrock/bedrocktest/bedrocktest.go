@@ -0,0 +1,99 @@
+// Package bedrocktest provides a fake bedrock.Invoker for the tests of the model packages, so
+// that their payloads and response parsing are exercised without calling AWS. It is also a
+// bedrock.Runtime, whose Converse API fails.
+package bedrocktest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// Invoker answers every InvokeModel request with a canned response body and records the
+// requests it received
+type Invoker struct {
+	// Body is the response body; Err fails the requests instead when set
+	Body string
+	Err  error
+	// Inputs are the requests received, in order
+	Inputs []*bedrockruntime.InvokeModelInput
+}
+
+func (f *Invoker) InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	f.Inputs = append(f.Inputs, params)
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return &bedrockruntime.InvokeModelOutput{Body: []byte(f.Body), ContentType: aws.String("application/json")}, nil
+}
+
+// errConverse fails the Converse API requests, which the fake doesn't answer
+var errConverse = errors.New("bedrocktest: the fake only answers InvokeModel requests")
+
+// Converse fails with Err, or errConverse. With ConverseStream it makes the fake a
+// bedrock.Runtime, so that the registered models can be created with it.
+func (f *Invoker) Converse(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return nil, errConverse
+}
+
+// ConverseStream fails with Err, or errConverse
+func (f *Invoker) ConverseStream(ctx context.Context, params *bedrockruntime.ConverseStreamInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseStreamOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return nil, errConverse
+}
+
+// Payload decodes the body of the last request into a generic JSON value, for comparing the
+// marshalled payload field by field
+func (f *Invoker) Payload(t testing.TB) map[string]any {
+	t.Helper()
+	if len(f.Inputs) == 0 {
+		t.Fatal("no request was sent")
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(f.Inputs[len(f.Inputs)-1].Body, &payload); err != nil {
+		t.Fatalf("the request body isn't JSON: %v", err)
+	}
+	return payload
+}
+
+// ModelID returns the model ID of the last request
+func (f *Invoker) ModelID(t testing.TB) string {
+	t.Helper()
+	if len(f.Inputs) == 0 {
+		t.Fatal("no request was sent")
+	}
+	return aws.ToString(f.Inputs[len(f.Inputs)-1].ModelId)
+}
+
+// Field returns the value at a path of object keys and array indexes in a decoded payload,
+// e.g. Field(payload, "messages", 0, "role"); nil when the path doesn't exist
+func Field(value any, path ...any) any {
+	for _, step := range path {
+		switch key := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil
+			}
+			value = object[key]
+		case int:
+			array, ok := value.([]any)
+			if !ok || key >= len(array) {
+				return nil
+			}
+			value = array[key]
+		default:
+			return nil
+		}
+	}
+	return value
+}
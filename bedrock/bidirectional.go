@@ -0,0 +1,90 @@
+package bedrock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// BidirectionalStream is an open InvokeModelWithBidirectionalStream session, such as a Nova
+// Sonic conversation, that exchanges JSON events with the model in both directions
+type BidirectionalStream struct {
+	modelID string
+	output  *bedrockruntime.InvokeModelWithBidirectionalStreamOutput
+
+	replyOnce sync.Once
+	// metadata describes the response to the request that opened the stream
+	metadata middleware.Metadata
+}
+
+// OpenBidirectionalStream opens a bidirectional stream to a model. The stream needs HTTP/2,
+// which the SDK only negotiates over TLS. It isn't retried: a session can't be replayed once
+// live input has been sent.
+func OpenBidirectionalStream(ctx context.Context, client *bedrockruntime.Client, modelID string) (*BidirectionalStream, error) {
+	output, err := client.InvokeModelWithBidirectionalStream(ctx, &bedrockruntime.InvokeModelWithBidirectionalStreamInput{
+		ModelId: aws.String(modelID),
+	})
+	if err != nil {
+		return nil, describeError("InvokeModelWithBidirectionalStream", modelID, err)
+	}
+	return &BidirectionalStream{modelID: modelID, output: output}, nil
+}
+
+// Send marshals an event to JSON and sends it to the model
+func (s *BidirectionalStream) Send(ctx context.Context, event any) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	err = s.output.GetStream().Send(ctx, &types.InvokeModelWithBidirectionalStreamInputMemberChunk{
+		Value: types.BidirectionalInputPayloadPart{Bytes: data},
+	})
+	if err != nil {
+		return s.err(err)
+	}
+	return nil
+}
+
+// Recv returns the next JSON event from the model, or io.EOF once the model closed the stream
+func (s *BidirectionalStream) Recv() ([]byte, error) {
+	stream := s.output.GetStream()
+	for event := range stream.Events() {
+		if chunk, ok := event.(*types.InvokeModelWithBidirectionalStreamOutputMemberChunk); ok {
+			return chunk.Value.Bytes, nil
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, s.err(err)
+	}
+	return nil, io.EOF
+}
+
+// Close ends the stream in both directions
+func (s *BidirectionalStream) Close() error {
+	return s.output.GetStream().Close()
+}
+
+// err describes a failure of the stream. The request that opened it completes in the
+// background, so its outcome is awaited first: a rejected request fails the stream with the
+// service's error, which is more telling than the closed pipe a send then runs into.
+func (s *BidirectionalStream) err(err error) error {
+	s.replyOnce.Do(func() {
+		select {
+		case reply := <-s.output.GetInitialReply():
+			s.metadata = reply.ResultMetadata
+		case <-time.After(5 * time.Second):
+		}
+	})
+	if streamErr := s.output.GetStream().Err(); streamErr != nil {
+		err = streamErr
+	}
+	return describeStreamError("InvokeModelWithBidirectionalStream", s.modelID, s.metadata, err)
+}
@@ -0,0 +1,132 @@
+package bedrock
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Config holds the AWS settings used to build a Bedrock Runtime client
+type Config struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken accompanies temporary credentials, e.g. those of a Lambda execution role
+	SessionToken string
+	Region       string
+	// RoleARN is an IAM role assumed with the credentials above, e.g. to invoke the models in
+	// another account
+	RoleARN string
+
+	// Endpoint overrides the Bedrock runtime endpoint URL, e.g. a VPC interface endpoint
+	Endpoint string
+	// UseFIPS resolves the FIPS endpoint for the region instead of the standard one
+	UseFIPS bool
+	// APIOptions add middleware to every Bedrock Runtime operation, e.g. for metrics
+	APIOptions []func(*middleware.Stack) error
+
+	// RetryMode is the SDK's retry strategy for every client built from the configuration:
+	// aws.RetryModeStandard, or aws.RetryModeAdaptive to also slow down requests on the client
+	// side once the service throttles; standard when empty
+	RetryMode aws.RetryMode
+	// RetryMaxAttempts is the number of times the SDK sends a request, including the first;
+	// retry.DefaultMaxAttempts when zero
+	RetryMaxAttempts int
+	// RetryMaxBackoff caps the SDK's backoff between attempts; retry.DefaultMaxBackoff when zero
+	RetryMaxBackoff time.Duration
+
+	// WrapHTTPClient wraps the HTTP client that sends the Bedrock runtime requests, e.g. in a
+	// vcr.Recorder that records or replays them
+	WrapHTTPClient func(next aws.HTTPClient) aws.HTTPClient
+}
+
+// retryer creates the SDK retryer described by the configuration
+func (cfg Config) retryer() aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		if cfg.RetryMaxAttempts > 0 {
+			o.MaxAttempts = cfg.RetryMaxAttempts
+		}
+		if cfg.RetryMaxBackoff > 0 {
+			o.MaxBackoff = cfg.RetryMaxBackoff
+		}
+	}
+	if cfg.RetryMode == aws.RetryModeAdaptive {
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standard)
+		})
+	}
+	return retry.NewStandard(standard)
+}
+
+// LoadAWSConfig builds the shared AWS configuration (credentials, region, FIPS setting and
+// SDK retries) used for Bedrock and for the other AWS services the tool talks to
+func LoadAWSConfig(ctx context.Context, cfg Config) (aws.Config, error) {
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.Region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			cfg.AccessKeyID,
+			cfg.SecretAccessKey,
+			cfg.SessionToken, // Empty for regular access keys
+		)),
+		config.WithRetryer(cfg.retryer),
+	}
+	if cfg.UseFIPS {
+		loadOptions = append(loadOptions, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+	if cfg.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "bedrock-llama"
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return awsCfg, nil
+}
+
+// NewClient creates a Bedrock Runtime client from the given configuration
+func NewClient(ctx context.Context, cfg Config) (*bedrockruntime.Client, error) {
+	awsCfg, err := LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientOptions []func(*bedrockruntime.Options)
+	if cfg.Endpoint != "" {
+		if _, err := url.ParseRequestURI(cfg.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid Bedrock endpoint %q: %v", cfg.Endpoint, err)
+		}
+		log.Printf("Using custom Bedrock runtime endpoint: %s", cfg.Endpoint)
+		clientOptions = append(clientOptions, func(o *bedrockruntime.Options) {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		})
+	}
+	if cfg.UseFIPS {
+		log.Printf("Using FIPS endpoint for region %s", cfg.Region)
+	}
+	if len(cfg.APIOptions) > 0 {
+		clientOptions = append(clientOptions, func(o *bedrockruntime.Options) {
+			o.APIOptions = append(o.APIOptions, cfg.APIOptions...)
+		})
+	}
+	if cfg.WrapHTTPClient != nil {
+		clientOptions = append(clientOptions, func(o *bedrockruntime.Options) {
+			o.HTTPClient = cfg.WrapHTTPClient(o.HTTPClient)
+		})
+	}
+
+	return bedrockruntime.NewFromConfig(awsCfg, clientOptions...), nil
+}
@@ -0,0 +1,97 @@
+package bedrock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxDocumentBytes is the largest document Bedrock accepts in a message
+const MaxDocumentBytes = 4_500_000
+
+// documentFormats are the formats of the Converse API's document blocks, by file extension
+var documentFormats = map[string]string{
+	".pdf":      "pdf",
+	".csv":      "csv",
+	".doc":      "doc",
+	".docx":     "docx",
+	".xls":      "xls",
+	".xlsx":     "xlsx",
+	".html":     "html",
+	".htm":      "html",
+	".txt":      "txt",
+	".md":       "md",
+	".markdown": "md",
+}
+
+// binaryBytesPerToken roughly relates the size of a PDF or Office file to the tokens of its text
+const binaryBytesPerToken = 16
+
+// Document is a file attached to a conversation turn, such as a PDF episode guide. Documents
+// are only accepted by the Converse API.
+type Document struct {
+	// Name identifies the document to the model
+	Name string `json:"name"`
+	// Format is the document format: pdf, csv, doc, docx, xls, xlsx, html, txt or md
+	Format string `json:"format"`
+	Data   []byte `json:"data"`
+}
+
+// LoadDocument reads a document file, taking its format from the extension and its name from
+// the file name
+func LoadDocument(path string) (Document, error) {
+	format, ok := documentFormats[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return Document{}, fmt.Errorf("unsupported document %s: use PDF, CSV, DOC, DOCX, XLS, XLSX, HTML, TXT or MD", filepath.Base(path))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to read document: %v", err)
+	}
+	if len(data) > MaxDocumentBytes {
+		return Document{}, fmt.Errorf("the document is %d bytes; Bedrock accepts at most %d", len(data), MaxDocumentBytes)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return Document{Name: documentName(name), Format: format, Data: data}, nil
+}
+
+// documentName reduces a file name to the characters Bedrock allows in document names:
+// letters, digits, single spaces, hyphens, parentheses and square brackets
+func documentName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("-()[]", r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	name = strings.Join(strings.Fields(b.String()), " ")
+	if name == "" {
+		return "document"
+	}
+	return name
+}
+
+// HasDocuments reports whether any turn of a conversation carries documents
+func HasDocuments(turns []Turn) bool {
+	for _, turn := range turns {
+		if len(turn.Documents) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tokens approximates the input tokens of the document: text formats at four characters per
+// token, and PDF and Office files from their size
+func (d Document) tokens() int {
+	switch d.Format {
+	case "csv", "html", "txt", "md":
+		return utf8.RuneCount(d.Data) / 4
+	}
+	return len(d.Data) / binaryBytesPerToken
+}
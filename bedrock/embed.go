@@ -0,0 +1,34 @@
+package bedrock
+
+import "context"
+
+// EmbeddingModel is implemented by each embedding model package so callers can embed text with
+// any of them uniformly
+type EmbeddingModel interface {
+	// Name returns the short model name used on the command line (e.g. "titan-embed")
+	Name() string
+	// Embed returns one vector per text, in order. Models that take one text per request make
+	// one request per text.
+	Embed(ctx context.Context, req EmbeddingRequest) (*EmbeddingResult, error)
+}
+
+// EmbeddingRequest describes the texts to embed. Zero fields use the model's defaults.
+type EmbeddingRequest struct {
+	Texts []string
+	// Dimensions is the length of the vectors, for the models that offer several
+	Dimensions int
+	// Normalize scales the vectors to unit length, for the models that make it optional
+	Normalize bool
+	// InputType tells the models that take it what the texts are for, such as "search_query"
+	InputType string
+}
+
+// EmbeddingResult holds the vectors of the embedded texts
+type EmbeddingResult struct {
+	// Model is the short name of the model that embedded the texts
+	Model string
+	// Embeddings holds one vector per text, in the order of the request
+	Embeddings [][]float64
+	// InputTokens is the number of tokens embedded, when the model reports it
+	InputTokens int
+}
@@ -0,0 +1,167 @@
+package bedrock
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+)
+
+// Classes of failed invocations, matched with errors.Is against the errors returned by the
+// model packages
+var (
+	// ErrThrottled means Bedrock rejected the request to protect the account's quotas
+	ErrThrottled = errors.New("request throttled")
+	// ErrAccessDenied means the credentials aren't valid or lack permission to invoke the model
+	ErrAccessDenied = errors.New("access denied")
+	// ErrModelNotFound means the model ID, inference profile ARN or provisioned model doesn't
+	// exist in the region, or access to it hasn't been granted
+	ErrModelNotFound = errors.New("model not found")
+	// ErrValidation means Bedrock rejected the request as malformed, e.g. an invalid payload
+	ErrValidation = errors.New("invalid request")
+	// ErrContentFiltered means the prompt or response was blocked by content filters or a guardrail
+	ErrContentFiltered = errors.New("content filtered")
+	// ErrTimeout means a model call didn't finish within Options.Timeout. It is distinct from
+	// the caller's own context being cancelled or reaching its deadline.
+	ErrTimeout = errors.New("timed out")
+)
+
+// errorClasses maps the Bedrock error codes to the error classes
+var errorClasses = map[string]error{
+	"ThrottlingException":           ErrThrottled,
+	"ServiceQuotaExceededException": ErrThrottled,
+	"AccessDeniedException":         ErrAccessDenied,
+	"UnrecognizedClientException":   ErrAccessDenied,
+	"ExpiredTokenException":         ErrAccessDenied,
+	"InvalidSignatureException":     ErrAccessDenied,
+	"ResourceNotFoundException":     ErrModelNotFound,
+	"ValidationException":           ErrValidation,
+}
+
+// filteredStopReasons are the stop reasons of responses blocked by content filters or a guardrail
+var filteredStopReasons = map[string]bool{
+	"content_filtered":      true,
+	StopGuardrailIntervened: true,
+	// Claude 4 and later stop with refusal when they decline to answer for safety reasons
+	"refusal": true,
+}
+
+// APIError describes a failed Bedrock API request with the details AWS support asks for
+// when a case is opened: the request ID, the HTTP status and the service's error code
+type APIError struct {
+	// Operation is the API operation, e.g. InvokeModel
+	Operation string `json:"operation"`
+	// ModelID is the model or inference profile the request was sent to
+	ModelID string `json:"model_id,omitempty"`
+	// StatusCode is the HTTP status of the response; 200 for exceptions sent inside a stream
+	StatusCode int `json:"status_code,omitempty"`
+	// RequestID is the x-amzn-RequestId of the response
+	RequestID string `json:"request_id,omitempty"`
+	// Code is the error code, e.g. ThrottlingException
+	Code string `json:"code,omitempty"`
+	// Message is the error message returned by the service
+	Message string `json:"message,omitempty"`
+
+	// Err is the original SDK error
+	Err error `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	var details []string
+	if e.StatusCode != 0 {
+		details = append(details, fmt.Sprintf("HTTP %d", e.StatusCode))
+	}
+	if e.RequestID != "" {
+		details = append(details, "request ID "+e.RequestID)
+	}
+
+	text := e.Operation
+	if e.Code != "" {
+		text += " " + e.Code
+	}
+	if len(details) > 0 {
+		text += " (" + strings.Join(details, ", ") + ")"
+	}
+	if e.Message != "" {
+		return text + ": " + e.Message
+	}
+	return text + ": " + e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the error class of the error code. Some rejections only show in the message: an
+// unknown model ID and a prompt blocked by content filters are both reported as validation errors.
+func (e *APIError) Is(target error) bool {
+	class, ok := errorClasses[e.Code]
+	if ok && class == target {
+		return true
+	}
+	message := strings.ToLower(e.Message)
+	switch target {
+	case ErrModelNotFound:
+		return e.Code == "ValidationException" && strings.Contains(message, "model identifier is invalid")
+	case ErrContentFiltered:
+		return strings.Contains(message, "content filter") || strings.Contains(message, "blocked by")
+	case ErrThrottled:
+		return e.StatusCode == 429
+	case ErrAccessDenied:
+		return e.StatusCode == 403
+	}
+	return false
+}
+
+// CheckStopReason returns an error wrapping ErrContentFiltered when a response was blocked by
+// content filters or a guardrail, which Bedrock reports as a successful call with a stop reason.
+// A guardrail's intervention is returned as a *GuardrailError.
+func CheckStopReason(result *Result) error {
+	if result.StopReason == StopGuardrailIntervened {
+		return &GuardrailError{Model: result.Model, Text: result.Text, Trace: result.Guardrail}
+	}
+	if filteredStopReasons[result.StopReason] {
+		return fmt.Errorf("%w: %s stopped with %s", ErrContentFiltered, result.Model, result.StopReason)
+	}
+	return nil
+}
+
+// describeError attaches the request metadata to an error returned by the Bedrock client. Errors
+// that never reached the service, such as a cancelled context, are returned unchanged.
+func describeError(operation, modelID string, err error) error {
+	if err == nil {
+		return nil
+	}
+	apiErr := &APIError{Operation: operation, ModelID: modelID, Err: err}
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		apiErr.StatusCode = responseErr.HTTPStatusCode()
+		apiErr.RequestID = responseErr.ServiceRequestID()
+	}
+	var smithyErr smithy.APIError
+	if errors.As(err, &smithyErr) {
+		apiErr.Code = smithyErr.ErrorCode()
+		apiErr.Message = smithyErr.ErrorMessage()
+	}
+	if apiErr.StatusCode == 0 && apiErr.Code == "" {
+		return err
+	}
+	return apiErr
+}
+
+// describeStreamError attaches the request ID of an opened stream to an exception sent inside it
+func describeStreamError(operation, modelID string, metadata smithymiddleware.Metadata, err error) error {
+	described := describeError(operation, modelID, err)
+	if apiErr, ok := described.(*APIError); ok && apiErr.RequestID == "" {
+		// A stream can also fail with the error response of the request that opened it
+		if apiErr.StatusCode == 0 {
+			apiErr.StatusCode = 200
+		}
+		apiErr.RequestID, _ = awsmiddleware.GetRequestIDMetadata(metadata)
+	}
+	return described
+}
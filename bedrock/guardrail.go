@@ -0,0 +1,334 @@
+package bedrock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// GuardrailDraft is the version of a guardrail's working draft
+const GuardrailDraft = "DRAFT"
+
+// StopGuardrailIntervened is the stop reason of a response a guardrail blocked or masked
+const StopGuardrailIntervened = "guardrail_intervened"
+
+// Directions of the content screened by ApplyGuardrail
+const (
+	GuardrailSourceInput  = "input"
+	GuardrailSourceOutput = "output"
+)
+
+// ErrGuardrailIntervened means a guardrail blocked the prompt or the response. Errors matching
+// it also match ErrContentFiltered.
+var ErrGuardrailIntervened = errors.New("guardrail intervened")
+
+// Guardrail screens the prompts and responses of every invocation with an Amazon Bedrock Guardrail
+type Guardrail struct {
+	// ID is the guardrail ID or ARN
+	ID string
+	// Version is the guardrail version, e.g. "1", or "DRAFT" for the working draft
+	Version string
+}
+
+// NewGuardrail creates the configuration of the given guardrail; version defaults to DRAFT
+func NewGuardrail(id, version string) *Guardrail {
+	if version == "" {
+		version = GuardrailDraft
+	}
+	return &Guardrail{ID: id, Version: version}
+}
+
+func (g *Guardrail) String() string {
+	return g.ID + " version " + g.Version
+}
+
+// GuardrailTrace is a guardrail's account of an invocation it intervened in, for tuning the
+// guardrail's configuration from the traffic it screens
+type GuardrailTrace struct {
+	// Reason is the service's explanation of the intervention, when it gives one
+	Reason string `json:"reason,omitempty"`
+	// Input holds what the guardrail's policies found in the prompt
+	Input []GuardrailFinding `json:"input,omitempty"`
+	// Output holds what they found in the model's response
+	Output []GuardrailFinding `json:"output,omitempty"`
+	// ModelOutput is the model's response before the guardrail blocked or masked it
+	ModelOutput []string `json:"model_output,omitempty"`
+}
+
+// GuardrailError is returned when a guardrail intervened in an invocation. The result is
+// returned with it, so its tokens are still accounted for.
+type GuardrailError struct {
+	// Model is the short model name
+	Model string
+	// Text is what the guardrail answered in place of the model, its blocked messaging
+	Text string
+	// Trace tells which policies fired; nil when the response didn't include the trace
+	Trace *GuardrailTrace
+}
+
+func (e *GuardrailError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("%v: %s response blocked", ErrGuardrailIntervened, e.Model)
+	}
+	return fmt.Sprintf("%v: %s response blocked: %s", ErrGuardrailIntervened, e.Model, e.Text)
+}
+
+// Is matches ErrGuardrailIntervened, and ErrContentFiltered, which covers every blocked response
+func (e *GuardrailError) Is(target error) bool {
+	return target == ErrGuardrailIntervened || target == ErrContentFiltered
+}
+
+// GuardrailIntervened reports whether the body of an InvokeModel response says a guardrail
+// intervened. Bedrock adds the verdict to the native response of each model family, whose own
+// stop reason doesn't tell.
+func GuardrailIntervened(body []byte) bool {
+	var verdict struct {
+		Action string `json:"amazon-bedrock-guardrailAction"`
+	}
+	return json.Unmarshal(body, &verdict) == nil && verdict.Action == "INTERVENED"
+}
+
+// ParseGuardrailTrace returns the guardrail trace Bedrock adds to the body of an InvokeModel
+// response; nil when the body has none
+func ParseGuardrailTrace(body []byte) *GuardrailTrace {
+	var response struct {
+		Trace struct {
+			Guardrail *struct {
+				ModelOutput []string                      `json:"modelOutput"`
+				Input       map[string]nativeAssessment   `json:"input"`
+				Outputs     []map[string]nativeAssessment `json:"outputs"`
+			} `json:"guardrail"`
+		} `json:"amazon-bedrock-trace"`
+	}
+	if json.Unmarshal(body, &response) != nil || response.Trace.Guardrail == nil {
+		return nil
+	}
+	native := response.Trace.Guardrail
+	trace := &GuardrailTrace{ModelOutput: native.ModelOutput}
+	for _, a := range native.Input {
+		trace.Input = append(trace.Input, guardrailFindings(a.assessment())...)
+	}
+	for _, output := range native.Outputs {
+		for _, a := range output {
+			trace.Output = append(trace.Output, guardrailFindings(a.assessment())...)
+		}
+	}
+	return trace
+}
+
+// nativeAssessment is a guardrail assessment in the trace of an InvokeModel response body,
+// limited to the policies guardrailFindings reports
+type nativeAssessment struct {
+	TopicPolicy                *types.GuardrailTopicPolicyAssessment                `json:"topicPolicy"`
+	ContentPolicy              *types.GuardrailContentPolicyAssessment              `json:"contentPolicy"`
+	WordPolicy                 *types.GuardrailWordPolicyAssessment                 `json:"wordPolicy"`
+	SensitiveInformationPolicy *types.GuardrailSensitiveInformationPolicyAssessment `json:"sensitiveInformationPolicy"`
+	ContextualGroundingPolicy  *types.GuardrailContextualGroundingPolicyAssessment  `json:"contextualGroundingPolicy"`
+}
+
+func (a nativeAssessment) assessment() types.GuardrailAssessment {
+	return types.GuardrailAssessment{
+		TopicPolicy:                a.TopicPolicy,
+		ContentPolicy:              a.ContentPolicy,
+		WordPolicy:                 a.WordPolicy,
+		SensitiveInformationPolicy: a.SensitiveInformationPolicy,
+		ContextualGroundingPolicy:  a.ContextualGroundingPolicy,
+	}
+}
+
+// converseGuardrailTrace converts the guardrail trace of a Converse response; nil without one
+func converseGuardrailTrace(t *types.GuardrailTraceAssessment) *GuardrailTrace {
+	if t == nil {
+		return nil
+	}
+	trace := &GuardrailTrace{Reason: aws.ToString(t.ActionReason), ModelOutput: t.ModelOutput}
+	for _, a := range t.InputAssessment {
+		trace.Input = append(trace.Input, guardrailFindings(a)...)
+	}
+	for _, assessments := range t.OutputAssessments {
+		for _, a := range assessments {
+			trace.Output = append(trace.Output, guardrailFindings(a)...)
+		}
+	}
+	return trace
+}
+
+// apply attaches the guardrail, with its trace, to an InvokeModel request that doesn't name its own
+func (g *Guardrail) apply(input *bedrockruntime.InvokeModelInput) {
+	if g == nil || input.GuardrailIdentifier != nil {
+		return
+	}
+	input.GuardrailIdentifier = aws.String(g.ID)
+	input.GuardrailVersion = aws.String(g.Version)
+	input.Trace = types.TraceEnabled
+}
+
+// converseConfig returns the guardrail configuration of a Converse request, with its trace; nil
+// without a guardrail
+func (g *Guardrail) converseConfig() *types.GuardrailConfiguration {
+	if g == nil {
+		return nil
+	}
+	return &types.GuardrailConfiguration{
+		GuardrailIdentifier: aws.String(g.ID),
+		GuardrailVersion:    aws.String(g.Version),
+		Trace:               types.GuardrailTraceEnabled,
+	}
+}
+
+// streamConfig returns the guardrail configuration of a ConverseStream request, with its trace;
+// nil without a guardrail. Chunks are screened before they are sent, so no blocked text is ever printed.
+func (g *Guardrail) streamConfig() *types.GuardrailStreamConfiguration {
+	if g == nil {
+		return nil
+	}
+	return &types.GuardrailStreamConfiguration{
+		GuardrailIdentifier:  aws.String(g.ID),
+		GuardrailVersion:     aws.String(g.Version),
+		StreamProcessingMode: types.GuardrailStreamProcessingModeSync,
+		Trace:                types.GuardrailTraceEnabled,
+	}
+}
+
+// GuardrailFinding is what a guardrail policy found in the screened content
+type GuardrailFinding struct {
+	// Policy is the policy that found it: topic, content, word, sensitive_information or
+	// contextual_grounding
+	Policy string `json:"policy"`
+	// Type is the kind of finding, e.g. DENY for a denied topic, HATE, PROFANITY or EMAIL
+	Type string `json:"type,omitempty"`
+	// Name is the denied topic or the regex that matched
+	Name string `json:"name,omitempty"`
+	// Match is the text that matched a word or sensitive information policy
+	Match string `json:"match,omitempty"`
+	// Detail is how sure the policy is, e.g. "confidence HIGH" or "score 0.12, threshold 0.5"
+	Detail string `json:"detail,omitempty"`
+	// Action is what the guardrail did about it: BLOCKED, ANONYMIZED, or NONE in detect mode
+	Action string `json:"action"`
+}
+
+func (f GuardrailFinding) String() string {
+	text := f.Policy
+	for _, part := range []string{f.Type, f.Name} {
+		if part != "" {
+			text += " " + part
+		}
+	}
+	if f.Match != "" {
+		text += " " + strconv.Quote(f.Match)
+	}
+	if f.Detail != "" {
+		text += " (" + f.Detail + ")"
+	}
+	return text + ": " + f.Action
+}
+
+// GuardrailAssessment is a guardrail's verdict on some content
+type GuardrailAssessment struct {
+	// Intervened is set when the guardrail blocked or masked the content
+	Intervened bool `json:"intervened"`
+	// Output is the guardrail's blocked message, or the content with its sensitive
+	// information masked; empty when the guardrail didn't intervene
+	Output string `json:"output,omitempty"`
+	// Reason is the service's explanation of the action, when it gives one
+	Reason string `json:"reason,omitempty"`
+	// Findings are what the guardrail's policies found
+	Findings []GuardrailFinding `json:"findings"`
+}
+
+// ApplyGuardrail screens text with a guardrail without invoking a model. source is
+// GuardrailSourceInput to screen it as a prompt, or GuardrailSourceOutput as a response.
+func ApplyGuardrail(ctx context.Context, client *bedrockruntime.Client, guardrail *Guardrail, source, text string, retry RetryPolicy, timeout time.Duration) (*GuardrailAssessment, error) {
+	input := &bedrockruntime.ApplyGuardrailInput{
+		GuardrailIdentifier: aws.String(guardrail.ID),
+		GuardrailVersion:    aws.String(guardrail.Version),
+		Content:             []types.GuardrailContentBlock{&types.GuardrailContentBlockMemberText{Value: types.GuardrailTextBlock{Text: aws.String(text)}}},
+	}
+	switch source {
+	case GuardrailSourceInput:
+		input.Source = types.GuardrailContentSourceInput
+	case GuardrailSourceOutput:
+		input.Source = types.GuardrailContentSourceOutput
+	default:
+		return nil, fmt.Errorf("invalid guardrail source %q: use %s or %s", source, GuardrailSourceInput, GuardrailSourceOutput)
+	}
+
+	var output *bedrockruntime.ApplyGuardrailOutput
+	err := retry.do(ctx, "ApplyGuardrail", func() error {
+		return withTimeout(ctx, "ApplyGuardrail", timeout, func(ctx context.Context) error {
+			var err error
+			output, err = client.ApplyGuardrail(ctx, input)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply guardrail %s: %w", guardrail, describeError("ApplyGuardrail", guardrail.ID, err))
+	}
+
+	assessment := &GuardrailAssessment{
+		Intervened: output.Action == types.GuardrailActionGuardrailIntervened,
+		Reason:     aws.ToString(output.ActionReason),
+		Findings:   []GuardrailFinding{},
+	}
+	if assessment.Intervened {
+		var texts []string
+		for _, content := range output.Outputs {
+			texts = append(texts, aws.ToString(content.Text))
+		}
+		assessment.Output = strings.Join(texts, "\n")
+	}
+	for _, a := range output.Assessments {
+		assessment.Findings = append(assessment.Findings, guardrailFindings(a)...)
+	}
+	return assessment, nil
+}
+
+// guardrailFindings lists the findings of each policy of a guardrail assessment
+func guardrailFindings(a types.GuardrailAssessment) []GuardrailFinding {
+	var findings []GuardrailFinding
+	if policy := a.TopicPolicy; policy != nil {
+		for _, topic := range policy.Topics {
+			findings = append(findings, GuardrailFinding{Policy: "topic", Type: string(topic.Type), Name: aws.ToString(topic.Name), Action: string(topic.Action)})
+		}
+	}
+	if policy := a.ContentPolicy; policy != nil {
+		for _, filter := range policy.Filters {
+			detail := "confidence " + string(filter.Confidence)
+			if filter.FilterStrength != "" {
+				detail += ", strength " + string(filter.FilterStrength)
+			}
+			findings = append(findings, GuardrailFinding{Policy: "content", Type: string(filter.Type), Detail: detail, Action: string(filter.Action)})
+		}
+	}
+	if policy := a.WordPolicy; policy != nil {
+		for _, word := range policy.CustomWords {
+			findings = append(findings, GuardrailFinding{Policy: "word", Type: "CUSTOM", Match: aws.ToString(word.Match), Action: string(word.Action)})
+		}
+		for _, word := range policy.ManagedWordLists {
+			findings = append(findings, GuardrailFinding{Policy: "word", Type: string(word.Type), Match: aws.ToString(word.Match), Action: string(word.Action)})
+		}
+	}
+	if policy := a.SensitiveInformationPolicy; policy != nil {
+		for _, entity := range policy.PiiEntities {
+			findings = append(findings, GuardrailFinding{Policy: "sensitive_information", Type: string(entity.Type), Match: aws.ToString(entity.Match), Action: string(entity.Action)})
+		}
+		for _, regex := range policy.Regexes {
+			findings = append(findings, GuardrailFinding{Policy: "sensitive_information", Type: "REGEX", Name: aws.ToString(regex.Name), Match: aws.ToString(regex.Match), Action: string(regex.Action)})
+		}
+	}
+	if policy := a.ContextualGroundingPolicy; policy != nil {
+		for _, filter := range policy.Filters {
+			detail := "score " + strconv.FormatFloat(aws.ToFloat64(filter.Score), 'g', -1, 64) + ", threshold " + strconv.FormatFloat(aws.ToFloat64(filter.Threshold), 'g', -1, 64)
+			findings = append(findings, GuardrailFinding{Policy: "contextual_grounding", Type: string(filter.Type), Detail: detail, Action: string(filter.Action)})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,128 @@
+package bedrock
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxImageBytes is the largest image Bedrock accepts in a message
+const MaxImageBytes = 3_750_000
+
+// MaxImageDimension is the longest edge in pixels Bedrock accepts for an image
+const MaxImageDimension = 8000
+
+// imageTokens is the most tokens an image costs; models downscale larger images
+const imageTokens = 1600
+
+// jpegQuality is the quality of images re-encoded as JPEG
+const jpegQuality = 85
+
+// Image is a picture attached to a conversation turn
+type Image struct {
+	// Format is the image format: png, jpeg, gif or webp
+	Format string `json:"format"`
+	Data   []byte `json:"data"`
+	// Width and Height are the dimensions in pixels, zero when the format isn't decoded
+	Width  int `json:"-"`
+	Height int `json:"-"`
+}
+
+// LoadImage reads an image file, detecting its format from the content
+func LoadImage(path string) (Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to read image: %v", err)
+	}
+	return NewImage(data)
+}
+
+// NewImage wraps encoded image data, detecting its format from the content. PNG, JPEG, GIF and
+// WebP images within Bedrock's limits are sent as they are; BMP and TIFF images are converted to
+// PNG, and images over the limits are scaled down and re-encoded.
+func NewImage(data []byte) (Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		mediaType := http.DetectContentType(data)
+		switch {
+		case mediaType == "image/webp" && len(data) <= MaxImageBytes:
+			// Animated WebP has no decoder, but Bedrock accepts it
+			return Image{Format: "webp", Data: data}, nil
+		case !strings.HasPrefix(mediaType, "image/"):
+			return Image{}, fmt.Errorf("unsupported image type %s: use PNG, JPEG, GIF, WebP, BMP or TIFF", mediaType)
+		}
+		return Image{}, fmt.Errorf("failed to decode image: %v", err)
+	}
+	native := format == "png" || format == "jpeg" || format == "gif" || format == "webp"
+	if native && len(data) <= MaxImageBytes && max(config.Width, config.Height) <= MaxImageDimension {
+		return Image{Format: format, Data: data, Width: config.Width, Height: config.Height}, nil
+	}
+	return convertImage(data, format)
+}
+
+// convertImage re-encodes an image Bedrock can't take as it is. Lossless formats stay PNG while
+// they fit, photos become JPEG, and the image is scaled down until it is within the limits.
+func convertImage(data []byte, format string) (Image, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to decode %s image: %v", format, err)
+	}
+	bounds := src.Bounds()
+	scale := min(1, float64(MaxImageDimension)/float64(max(bounds.Dx(), bounds.Dy())))
+	lossless := format != "jpeg" && format != "webp"
+	for {
+		width, height := max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale))
+		img := src
+		if width != bounds.Dx() || height != bounds.Dy() {
+			scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+			draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, bounds, draw.Src, nil)
+			img = scaled
+		}
+		if lossless {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				return Image{}, fmt.Errorf("failed to encode image: %v", err)
+			}
+			if buf.Len() <= MaxImageBytes {
+				return Image{Format: "png", Data: buf.Bytes(), Width: width, Height: height}, nil
+			}
+		}
+		// JPEG has no transparency, so transparent areas become white
+		opaque := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return Image{}, fmt.Errorf("failed to encode image: %v", err)
+		}
+		if buf.Len() <= MaxImageBytes {
+			return Image{Format: "jpeg", Data: buf.Bytes(), Width: width, Height: height}, nil
+		}
+		scale *= 0.75
+	}
+}
+
+// MediaType returns the MIME type of the image, e.g. "image/png"
+func (i Image) MediaType() string {
+	return "image/" + i.Format
+}
+
+// tokens approximates the input tokens of the image at one per 750 pixels
+func (i Image) tokens() int {
+	if i.Width == 0 || i.Height == 0 {
+		return imageTokens
+	}
+	return min(i.Width*i.Height/750, imageTokens)
+}
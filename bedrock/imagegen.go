@@ -0,0 +1,42 @@
+package bedrock
+
+import "context"
+
+// ImageModel is implemented by each image generation model package so callers can generate
+// images with any of them uniformly
+type ImageModel interface {
+	// Name returns the short model name used on the command line (e.g. "canvas")
+	Name() string
+	// Generate creates images from a text prompt
+	Generate(ctx context.Context, req ImageRequest) (*ImageResult, error)
+}
+
+// ImageRequest describes the images to generate from a text prompt. Zero fields use the
+// model's defaults.
+type ImageRequest struct {
+	Prompt string
+	// NegativePrompt describes what the images must not show
+	NegativePrompt string
+	Width          int
+	Height         int
+	// Count is the number of images to generate
+	Count int
+	// Seed makes the generation reproducible; a random seed is used when it is negative
+	Seed int
+	// CFGScale is how closely the images follow the prompt
+	CFGScale float64
+	// Steps is the number of diffusion steps, for the models that take it
+	Steps int
+	// Quality is "standard" or "premium", for the models that take it
+	Quality string
+}
+
+// ImageResult holds the generated images
+type ImageResult struct {
+	// Model is the short name of the model that generated the images
+	Model string
+	// Images are the generated images, PNG-encoded
+	Images [][]byte
+	// Seed is the seed the images were generated with, to reproduce them
+	Seed int
+}
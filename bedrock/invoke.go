@@ -0,0 +1,89 @@
+package bedrock
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// anthropicBetaHeader is the request header that carries anthropic_beta flags
+const anthropicBetaHeader = "anthropic-beta"
+
+// Response headers with the token counts of an InvokeModel call
+const (
+	inputTokenCountHeader  = "X-Amzn-Bedrock-Input-Token-Count"
+	outputTokenCountHeader = "X-Amzn-Bedrock-Output-Token-Count"
+)
+
+// Invoker sends InvokeModel requests. A *bedrockruntime.Client is one; a fake that answers
+// without calling AWS can stand in for it to exercise payload construction and response parsing.
+type Invoker interface {
+	InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
+}
+
+// Converser sends Converse API requests, which the text models use to stream their responses
+// and to call tools
+type Converser interface {
+	Converse(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error)
+	ConverseStream(ctx context.Context, params *bedrockruntime.ConverseStreamInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseStreamOutput, error)
+}
+
+// Runtime is the part of the Bedrock runtime API a text model needs: InvokeModel and the Converse
+// API. A *bedrockruntime.Client is one; a fake can stand in for it to run a registered model
+// without AWS.
+type Runtime interface {
+	Invoker
+	Converser
+}
+
+// Invoke sends an InvokeModel request on behalf of a model package, applying the shared
+// invocation options such as the guardrail, provisioned throughput routing, throttling retries
+// and the request timeout. Failed requests are returned as an *APIError carrying the request ID and error code.
+func Invoke(ctx context.Context, client Invoker, input *bedrockruntime.InvokeModelInput, opts Options) (*bedrockruntime.InvokeModelOutput, error) {
+	opts.Guardrail.apply(input)
+	optFns := betaHeader(opts.AnthropicBeta)
+	var output *bedrockruntime.InvokeModelOutput
+	err := opts.Retry.do(ctx, "InvokeModel", func() error {
+		return withTimeout(ctx, "InvokeModel", opts.Timeout, func(ctx context.Context) error {
+			var err error
+			if opts.Provisioned != nil {
+				output, err = opts.Provisioned.invoke(ctx, client, input, optFns...)
+			} else {
+				output, err = client.InvokeModel(ctx, input, optFns...)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, describeError("InvokeModel", aws.ToString(input.ModelId), err)
+	}
+	return output, nil
+}
+
+// TokenCounts returns the tokens of an InvokeModel call from the response headers, for the
+// models whose response body doesn't report them; zero when the headers are missing
+func TokenCounts(output *bedrockruntime.InvokeModelOutput) (inputTokens, outputTokens int) {
+	response, ok := awsmiddleware.GetRawResponse(output.ResultMetadata).(*smithyhttp.Response)
+	if !ok {
+		return 0, 0
+	}
+	inputTokens, _ = strconv.Atoi(response.Header.Get(inputTokenCountHeader))
+	outputTokens, _ = strconv.Atoi(response.Header.Get(outputTokenCountHeader))
+	return inputTokens, outputTokens
+}
+
+// betaHeader returns the client option that also sends anthropic_beta flags as the
+// anthropic-beta header, next to the request body; none when there are no flags
+func betaHeader(beta []string) []func(*bedrockruntime.Options) {
+	if len(beta) == 0 {
+		return nil
+	}
+	return []func(*bedrockruntime.Options){func(o *bedrockruntime.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue(anthropicBetaHeader, strings.Join(beta, ",")))
+	}}
+}
@@ -0,0 +1,41 @@
+package bedrock
+
+import (
+	"bedrock-llama/extract"
+	"context"
+	"fmt"
+)
+
+// jsonInstruction is appended to InvokeJSON prompts so the model answers with JSON only
+const jsonInstruction = "\n\nRespond ONLY with valid JSON. Do not include any explanation or text outside the JSON."
+
+// ParseError is returned when a model response can't be parsed into the requested type.
+// It carries the raw model output so callers can log or inspect what went wrong.
+type ParseError struct {
+	Raw string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse model output as JSON: %v (raw output: %q)", e.Err, e.Raw)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// InvokeJSON prompts the model for JSON, extracts the first JSON value from its
+// response and unmarshals it into T
+func InvokeJSON[T any](ctx context.Context, model Model, prompt string) (T, error) {
+	var value T
+
+	result, err := model.Invoke(ctx, prompt+jsonInstruction)
+	if err != nil {
+		return value, err
+	}
+
+	if err := extract.Unmarshal(result.Text, &value); err != nil {
+		return value, &ParseError{Raw: result.Text, Err: err}
+	}
+	return value, nil
+}
@@ -0,0 +1,207 @@
+package bedrock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockagentruntime"
+	agenttypes "github.com/aws/aws-sdk-go-v2/service/bedrockagentruntime/types"
+	"github.com/aws/smithy-go/document"
+)
+
+// Search types of a knowledge base query
+const (
+	SearchHybrid   = "hybrid"
+	SearchSemantic = "semantic"
+)
+
+// KnowledgeBaseQuery is a query of a Bedrock Knowledge Base
+type KnowledgeBaseQuery struct {
+	KnowledgeBaseID string
+	Text            string
+	// Results is the number of chunks to retrieve; the service's default (5) when zero
+	Results int
+	// SearchType is SearchHybrid or SearchSemantic; the knowledge base decides when empty
+	SearchType string
+	// Retry resends the request when it is throttled
+	Retry RetryPolicy
+	// Timeout limits the request; no limit when zero
+	Timeout time.Duration
+}
+
+// RetrievedChunk is a chunk of a knowledge base document that matched a query
+type RetrievedChunk struct {
+	Text string `json:"text"`
+	// Score is the relevance of the chunk to the query; higher is more relevant
+	Score float64 `json:"score"`
+	// Source is the location of the document, such as its S3 URI
+	Source   string         `json:"source,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// GenerateRequest asks a model to answer a query from the chunks a knowledge base retrieves
+type GenerateRequest struct {
+	KnowledgeBaseQuery
+	// ModelARN is the ARN of the foundation model or inference profile that generates the answer
+	ModelARN string
+	// SessionID continues an earlier conversation; a new session when empty
+	SessionID string
+}
+
+// Citation is a part of a generated answer and the chunks it is based on
+type Citation struct {
+	// Text is the part of the answer
+	Text string `json:"text"`
+	// Sources are the locations of the documents the part is based on
+	Sources []string `json:"sources"`
+}
+
+// GeneratedAnswer is the answer of RetrieveAndGenerate
+type GeneratedAnswer struct {
+	Text      string     `json:"text"`
+	SessionID string     `json:"session_id"`
+	Citations []Citation `json:"citations"`
+}
+
+// ValidateSearchType checks that the given search type is one knowledge bases understand
+func ValidateSearchType(searchType string) error {
+	switch strings.ToLower(searchType) {
+	case "", SearchHybrid, SearchSemantic:
+		return nil
+	default:
+		return fmt.Errorf("invalid search type %q: use '%s' or '%s'", searchType, SearchHybrid, SearchSemantic)
+	}
+}
+
+// retrievalConfiguration returns the vector search settings of a query, or nil for the defaults
+func (q KnowledgeBaseQuery) retrievalConfiguration() *agenttypes.KnowledgeBaseRetrievalConfiguration {
+	if q.Results <= 0 && q.SearchType == "" {
+		return nil
+	}
+	search := &agenttypes.KnowledgeBaseVectorSearchConfiguration{
+		OverrideSearchType: agenttypes.SearchType(strings.ToUpper(q.SearchType)),
+	}
+	if q.Results > 0 {
+		search.NumberOfResults = aws.Int32(int32(q.Results))
+	}
+	return &agenttypes.KnowledgeBaseRetrievalConfiguration{VectorSearchConfiguration: search}
+}
+
+// Retrieve returns the knowledge base chunks that best match a query, the most relevant first
+func Retrieve(ctx context.Context, client *bedrockagentruntime.Client, query KnowledgeBaseQuery) ([]RetrievedChunk, error) {
+	input := &bedrockagentruntime.RetrieveInput{
+		KnowledgeBaseId:        aws.String(query.KnowledgeBaseID),
+		RetrievalQuery:         &agenttypes.KnowledgeBaseQuery{Text: aws.String(query.Text)},
+		RetrievalConfiguration: query.retrievalConfiguration(),
+	}
+	var output *bedrockagentruntime.RetrieveOutput
+	err := withTimeout(ctx, "Retrieve", query.Timeout, func(ctx context.Context) error {
+		return query.Retry.do(ctx, "Retrieve", func() error {
+			var err error
+			output, err = client.Retrieve(ctx, input)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query knowledge base %s: %w", query.KnowledgeBaseID, describeError("Retrieve", query.KnowledgeBaseID, err))
+	}
+
+	chunks := make([]RetrievedChunk, 0, len(output.RetrievalResults))
+	for _, result := range output.RetrievalResults {
+		chunk := RetrievedChunk{Score: aws.ToFloat64(result.Score), Source: locationSource(result.Location)}
+		if result.Content != nil {
+			chunk.Text = aws.ToString(result.Content.Text)
+		}
+		for key, value := range result.Metadata {
+			var v any
+			if value != nil && value.UnmarshalSmithyDocument(&v) == nil {
+				if chunk.Metadata == nil {
+					chunk.Metadata = map[string]any{}
+				}
+				chunk.Metadata[key] = jsonValue(v)
+			}
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// jsonValue replaces the numbers of a decoded document, which would otherwise be encoded as
+// strings, with JSON numbers
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case document.Number:
+		return json.Number(v)
+	case map[string]any:
+		for key, value := range v {
+			v[key] = jsonValue(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+	}
+	return v
+}
+
+// RetrieveAndGenerate answers a query with a model grounded in the chunks a knowledge base
+// retrieves for it, citing the documents each part of the answer is based on
+func RetrieveAndGenerate(ctx context.Context, client *bedrockagentruntime.Client, req GenerateRequest) (*GeneratedAnswer, error) {
+	input := &bedrockagentruntime.RetrieveAndGenerateInput{
+		Input: &agenttypes.RetrieveAndGenerateInput{Text: aws.String(req.Text)},
+		RetrieveAndGenerateConfiguration: &agenttypes.RetrieveAndGenerateConfiguration{
+			Type: agenttypes.RetrieveAndGenerateTypeKnowledgeBase,
+			KnowledgeBaseConfiguration: &agenttypes.KnowledgeBaseRetrieveAndGenerateConfiguration{
+				KnowledgeBaseId:        aws.String(req.KnowledgeBaseID),
+				ModelArn:               aws.String(req.ModelARN),
+				RetrievalConfiguration: req.retrievalConfiguration(),
+			},
+		},
+	}
+	if req.SessionID != "" {
+		input.SessionId = aws.String(req.SessionID)
+	}
+	var output *bedrockagentruntime.RetrieveAndGenerateOutput
+	err := withTimeout(ctx, "RetrieveAndGenerate", req.Timeout, func(ctx context.Context) error {
+		return req.Retry.do(ctx, "RetrieveAndGenerate", func() error {
+			var err error
+			output, err = client.RetrieveAndGenerate(ctx, input)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query knowledge base %s: %w", req.KnowledgeBaseID, describeError("RetrieveAndGenerate", req.KnowledgeBaseID, err))
+	}
+
+	answer := &GeneratedAnswer{SessionID: aws.ToString(output.SessionId), Citations: []Citation{}}
+	if output.Output != nil {
+		answer.Text = aws.ToString(output.Output.Text)
+	}
+	for _, citation := range output.Citations {
+		c := Citation{Sources: []string{}}
+		if part := citation.GeneratedResponsePart; part != nil && part.TextResponsePart != nil {
+			c.Text = aws.ToString(part.TextResponsePart.Text)
+		}
+		for _, reference := range citation.RetrievedReferences {
+			if source := referenceSource(reference); source != "" && !slices.Contains(c.Sources, source) {
+				c.Sources = append(c.Sources, source)
+			}
+		}
+		answer.Citations = append(answer.Citations, c)
+	}
+	return answer, nil
+}
+
+// FoundationModelARN returns the ARN RetrieveAndGenerate takes for a model: model and
+// inference profile ARNs unchanged, and the foundation model ARN in the region for a model ID
+func FoundationModelARN(region, modelID string) string {
+	if strings.HasPrefix(modelID, "arn:") {
+		return modelID
+	}
+	return fmt.Sprintf("arn:aws:bedrock:%s::foundation-model/%s", region, modelID)
+}
@@ -0,0 +1,174 @@
+package bedrock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Model is implemented by each model package so callers can invoke any model uniformly
+type Model interface {
+	// Name returns the short model name used on the command line (e.g. "nova")
+	Name() string
+	// Invoke sends the prompt to the model and returns its model-agnostic result
+	Invoke(ctx context.Context, prompt string) (*Result, error)
+	// Chat sends a multi-turn conversation to the model; the last turn must be from the user.
+	// A response blocked by content filters is returned together with an error wrapping
+	// ErrContentFiltered.
+	Chat(ctx context.Context, turns []Turn) (*Result, error)
+}
+
+// Conversation roles
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Turn is a single message in a conversation
+type Turn struct {
+	Role string `json:"role"`
+	Text string `json:"text"`
+	// Images are shown to the model before the text, by the models that support them
+	Images []Image `json:"images,omitempty"`
+	// Documents come before the images and the text; they are sent through the Converse API
+	Documents []Document `json:"documents,omitempty"`
+	// Videos come before the images, for the models that support them
+	Videos []Video `json:"videos,omitempty"`
+}
+
+// UserTurn returns a conversation consisting of a single user prompt
+func UserTurn(prompt string) []Turn {
+	return []Turn{{Role: RoleUser, Text: prompt}}
+}
+
+// Result is the model-agnostic view of a model response
+type Result struct {
+	// Model is the short name of the model that produced the result
+	Model string `json:"model"`
+	// ModelVersion is the version of the model family that answered, for the models with a
+	// version table (Claude); prices and usage are kept by it
+	ModelVersion string `json:"model_version,omitempty"`
+	// Text is the generated text
+	Text         string `json:"text"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	// Thinking is the model's reasoning before the text, when Options.Thinking is set
+	Thinking string `json:"thinking,omitempty"`
+	// Latency is the performanceConfig latency Bedrock served
+	Latency string `json:"latency,omitempty"`
+	// StopReason is why the model stopped generating, as reported by the model (e.g. "end_turn")
+	StopReason string `json:"stop_reason,omitempty"`
+	// Guardrail is the trace of the Options.Guardrail guardrail when it intervened
+	Guardrail *GuardrailTrace `json:"guardrail,omitempty"`
+	// Moderation holds the Llama Guard verdicts when the invocation was moderated
+	Moderation *Moderation `json:"moderation,omitempty"`
+	// Continuations is the number of follow-up requests that continued a response cut off at
+	// the output cap
+	Continuations int `json:"continuations,omitempty"`
+	// Repairs is the number of corrective re-prompts needed to get valid output
+	Repairs int `json:"repairs,omitempty"`
+	// Prompt is the registry reference (name@version) or task name of the prompt used
+	Prompt string `json:"prompt,omitempty"`
+	// PromptVersion is the content hash of the prompt template that produced the result
+	PromptVersion string `json:"prompt_version,omitempty"`
+	// Revision is the git commit of the binary that produced the result
+	Revision string `json:"revision,omitempty"`
+	// Issues lists the quality rules the output failed without being rejected
+	Issues []string `json:"issues,omitempty"`
+	// FallbackFrom lists the models that failed, in order, before Model produced the result
+	FallbackFrom []string `json:"fallback_from,omitempty"`
+	// Cached is set when the result was served from the response cache without invoking the model
+	Cached bool `json:"cached,omitempty"`
+}
+
+// VersionedModel returns the model name qualified by its version, e.g. "claude@opus-4", or the
+// bare name when there is no version
+func (r *Result) VersionedModel() string {
+	if r.ModelVersion == "" {
+		return r.Model
+	}
+	return r.Model + "@" + r.ModelVersion
+}
+
+// Statuses of a result, from the stop reason of the response
+const (
+	// StatusComplete means the model finished its response
+	StatusComplete = "complete"
+	// StatusTruncated means the response was cut off at the output cap or the context window
+	StatusTruncated = "truncated"
+	// StatusFiltered means content filters or a guardrail blocked the prompt or the response
+	StatusFiltered = "filtered"
+)
+
+// truncatedStopReasons are the stop reasons of responses cut off before the model finished.
+// Claude, Nova and the Converse API report max_tokens, Llama and DeepSeek length.
+var truncatedStopReasons = map[string]bool{
+	"max_tokens":                    true,
+	"length":                        true,
+	"model_context_window_exceeded": true,
+}
+
+// Status tells from the stop reason whether the response is complete, truncated or filtered,
+// so that partial output isn't mistaken for a full answer. It is empty when the model reported
+// no stop reason.
+func (r *Result) Status() string {
+	switch {
+	case r.StopReason == "":
+		return ""
+	case truncatedStopReasons[r.StopReason]:
+		return StatusTruncated
+	case filteredStopReasons[r.StopReason]:
+		return StatusFiltered
+	}
+	return StatusComplete
+}
+
+// FormatTranscript renders a conversation as a single text prompt for models that take raw
+// text. A single user turn is sent as-is; longer conversations are labelled by role.
+func FormatTranscript(turns []Turn) string {
+	if len(turns) == 1 {
+		return turns[0].Text
+	}
+
+	var transcript strings.Builder
+	for _, turn := range turns {
+		role := "User"
+		if turn.Role == RoleAssistant {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", role, turn.Text)
+	}
+	transcript.WriteString("Assistant:")
+	return transcript.String()
+}
+
+// llama3Begin is the special token that starts a Llama 3 prompt
+const llama3Begin = "<|begin_of_text|>"
+
+// FormatLlama3 renders a conversation in the chat template of the Llama 3 instruct models,
+// which were trained to follow instructions in that shape: each turn sits between header
+// tokens naming its role and ends with <|eot_id|>, after an optional system turn. The prompt
+// ends with an open assistant header, for the model to write the next turn. A single prompt
+// that is already in the template is left as it is.
+func FormatLlama3(system string, turns []Turn) string {
+	if len(turns) == 1 && system == "" && strings.HasPrefix(turns[0].Text, llama3Begin) {
+		return turns[0].Text
+	}
+	var prompt strings.Builder
+	prompt.WriteString(llama3Begin)
+	writeTurn := func(role, text string) {
+		fmt.Fprintf(&prompt, "<|start_header_id|>%s<|end_header_id|>\n\n%s<|eot_id|>", role, strings.TrimSpace(text))
+	}
+	if system != "" {
+		writeTurn("system", system)
+	}
+	for _, turn := range turns {
+		role := RoleUser
+		if turn.Role == RoleAssistant {
+			role = RoleAssistant
+		}
+		writeTurn(role, turn.Text)
+	}
+	prompt.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	return prompt.String()
+}
@@ -0,0 +1,199 @@
+package bedrock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// LlamaGuardCategories are the hazard categories of Llama Guard 3, by code
+var LlamaGuardCategories = map[string]string{
+	"S1":  "Violent Crimes",
+	"S2":  "Non-Violent Crimes",
+	"S3":  "Sex-Related Crimes",
+	"S4":  "Child Sexual Exploitation",
+	"S5":  "Defamation",
+	"S6":  "Specialized Advice",
+	"S7":  "Privacy",
+	"S8":  "Intellectual Property",
+	"S9":  "Indiscriminate Weapons",
+	"S10": "Hate",
+	"S11": "Suicide & Self-Harm",
+	"S12": "Sexual Content",
+	"S13": "Elections",
+	"S14": "Code Interpreter Abuse",
+}
+
+// llamaGuardMaxGenLen is the generation limit of a classification, which is a verdict line and a
+// line of category codes
+const llamaGuardMaxGenLen = 32
+
+// Moderation holds the Llama Guard verdicts on an invocation's prompt and response
+type Moderation struct {
+	// Input is the verdict on the last user turn; nil when the prompt wasn't moderated
+	Input *ModerationVerdict `json:"input,omitempty"`
+	// Output is the verdict on the model's response; nil when the response wasn't moderated
+	Output *ModerationVerdict `json:"output,omitempty"`
+}
+
+// Safe reports whether every moderated message was classified as safe
+func (m *Moderation) Safe() bool {
+	return m == nil || ((m.Input == nil || m.Input.Safe) && (m.Output == nil || m.Output.Safe))
+}
+
+// ModerationVerdict is Llama Guard's classification of a message
+type ModerationVerdict struct {
+	Safe bool `json:"safe"`
+	// Categories are the hazard categories an unsafe message falls in
+	Categories []ModerationCategory `json:"categories,omitempty"`
+}
+
+// ModerationCategory is a hazard category of Llama Guard, e.g. S1 Violent Crimes
+type ModerationCategory struct {
+	Code string `json:"code"`
+	// Name is empty for a code outside LlamaGuardCategories
+	Name string `json:"name,omitempty"`
+}
+
+func (c ModerationCategory) String() string {
+	if c.Name == "" {
+		return c.Code
+	}
+	return c.Code + " " + c.Name
+}
+
+// Labels returns the categories of the verdict as "S1 Violent Crimes", joined by commas
+func (v *ModerationVerdict) Labels() string {
+	labels := make([]string, len(v.Categories))
+	for i, category := range v.Categories {
+		labels[i] = category.String()
+	}
+	return strings.Join(labels, ", ")
+}
+
+// ModerationError is returned when Llama Guard classified the prompt or the response as unsafe
+// and moderation blocks unsafe content. The result, if the model was invoked, is returned with it.
+type ModerationError struct {
+	// Model is the short name of the moderated model
+	Model string
+	// Source is GuardrailSourceInput for the prompt or GuardrailSourceOutput for the response
+	Source  string
+	Verdict *ModerationVerdict
+}
+
+func (e *ModerationError) Error() string {
+	what := "response"
+	if e.Source == GuardrailSourceInput {
+		what = "prompt"
+	}
+	if len(e.Verdict.Categories) == 0 {
+		return fmt.Sprintf("%v: Llama Guard classified the %s %s as unsafe", ErrContentFiltered, e.Model, what)
+	}
+	return fmt.Sprintf("%v: Llama Guard classified the %s %s as unsafe: %s", ErrContentFiltered, e.Model, what, e.Verdict.Labels())
+}
+
+// Moderation returns the verdict as the moderation of the invocation it blocked
+func (e *ModerationError) Moderation() *Moderation {
+	if e.Source == GuardrailSourceInput {
+		return &Moderation{Input: e.Verdict}
+	}
+	return &Moderation{Output: e.Verdict}
+}
+
+// Is matches ErrContentFiltered, which covers every blocked response
+func (e *ModerationError) Is(target error) bool {
+	return target == ErrContentFiltered
+}
+
+// ClassifyLlamaGuard asks the Llama Guard model modelID whether the last turn of a conversation is
+// safe: the last user turn for GuardrailSourceInput, or the last assistant turn for
+// GuardrailSourceOutput. Llama Guard isn't served on demand, so modelID is the ARN of an imported
+// model or a Marketplace endpoint; either takes the native Llama request body.
+func ClassifyLlamaGuard(ctx context.Context, client Invoker, modelID, source string, turns []Turn, retry RetryPolicy, timeout time.Duration) (*ModerationVerdict, error) {
+	role := "User"
+	switch source {
+	case GuardrailSourceInput:
+	case GuardrailSourceOutput:
+		role = "Agent"
+	default:
+		return nil, fmt.Errorf("invalid moderation source %q: use %s or %s", source, GuardrailSourceInput, GuardrailSourceOutput)
+	}
+
+	body, err := json.Marshal(struct {
+		Prompt    string `json:"prompt"`
+		MaxGenLen int    `json:"max_gen_len"`
+	}{llamaGuardPrompt(role, turns), llamaGuardMaxGenLen})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Llama Guard payload: %v", err)
+	}
+	output, err := Invoke(ctx, client, &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(modelID),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+	}, Options{Retry: retry, Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to classify the %s with Llama Guard: %w", source, err)
+	}
+
+	var response struct {
+		Generation string `json:"generation"`
+	}
+	if err := json.Unmarshal(output.Body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Llama Guard response: %v", err)
+	}
+	return parseLlamaGuard(response.Generation)
+}
+
+// llamaGuardPrompt renders the Llama Guard 3 prompt that asks for the safety of the last message
+// of role, "User" or "Agent", in the conversation
+func llamaGuardPrompt(role string, turns []Turn) string {
+	var b strings.Builder
+	b.WriteString(llama3Begin + "<|start_header_id|>user<|end_header_id|>\n\n")
+	fmt.Fprintf(&b, "Task: Check if there is unsafe content in '%s' messages in conversations according our safety policy with the below categories.\n\n", role)
+	b.WriteString("<BEGIN UNSAFE CONTENT CATEGORIES>\n")
+	for i := 1; i <= len(LlamaGuardCategories); i++ {
+		code := fmt.Sprintf("S%d", i)
+		fmt.Fprintf(&b, "%s: %s.\n", code, LlamaGuardCategories[code])
+	}
+	b.WriteString("<END UNSAFE CONTENT CATEGORIES>\n\n<BEGIN CONVERSATION>\n\n")
+	for _, turn := range turns {
+		speaker := "User"
+		if turn.Role == RoleAssistant {
+			speaker = "Agent"
+		}
+		fmt.Fprintf(&b, "%s: %s\n\n", speaker, strings.TrimSpace(turn.Text))
+	}
+	b.WriteString("<END CONVERSATION>\n\n")
+	fmt.Fprintf(&b, "Provide your safety assessment for ONLY THE LAST %s message in the above conversation:\n", role)
+	b.WriteString(" - First line must read 'safe' or 'unsafe'.\n")
+	b.WriteString(" - If unsafe, a second line must include a comma-separated list of violated categories.")
+	b.WriteString("<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n")
+	return b.String()
+}
+
+// parseLlamaGuard parses Llama Guard's answer: "safe", or "unsafe" followed by a line of
+// comma-separated category codes
+func parseLlamaGuard(answer string) (*ModerationVerdict, error) {
+	lines := strings.Split(strings.TrimSpace(answer), "\n")
+	switch strings.ToLower(strings.TrimSpace(lines[0])) {
+	case "safe":
+		return &ModerationVerdict{Safe: true}, nil
+	case "unsafe":
+	default:
+		return nil, fmt.Errorf("unexpected Llama Guard answer %q", answer)
+	}
+	verdict := &ModerationVerdict{}
+	if len(lines) > 1 {
+		for _, code := range strings.Split(lines[1], ",") {
+			if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+				verdict.Categories = append(verdict.Categories, ModerationCategory{Code: code, Name: LlamaGuardCategories[code]})
+			}
+		}
+	}
+	return verdict, nil
+}
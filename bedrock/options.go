@@ -0,0 +1,156 @@
+package bedrock
+
+import (
+	"bedrock-llama/logging"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// Latency modes accepted by the -latency flag
+const (
+	LatencyStandard  = "standard"
+	LatencyOptimized = "optimized"
+)
+
+// Options holds the per-invocation settings shared by all model packages
+type Options struct {
+	// Latency is the requested performanceConfig latency mode ("standard" or "optimized")
+	Latency string
+
+	// System is the system prompt: the Llama and DeepSeek models render it in their chat
+	// templates, Nova, Claude and the Converse API send it as the system field
+	System string
+
+	// ModelVersion pins the version of the model family, by its name in the model package's
+	// version table (only Claude has one); the package's default version when empty
+	ModelVersion string
+
+	// TopK limits sampling to the K most likely tokens, on Nova; the model's default when zero
+	TopK int
+
+	// StopSequences end the response when the model generates one of them. The Llama models'
+	// native request body can't carry them, so their requests go through the Converse API.
+	StopSequences []string
+
+	// Temperature and TopP override the model's sampling; the model's defaults when nil
+	Temperature *float64
+	TopP        *float64
+
+	// Structured requests schema-constrained output. Models that support tool use
+	// define a single tool with this schema and force the model to call it.
+	Structured *StructuredOutput
+
+	// Provisioned routes invocations through provisioned throughput with on-demand spillover
+	Provisioned *Provisioned
+
+	// Guardrail screens every prompt and response; none when nil
+	Guardrail *Guardrail
+
+	// Thinking enables extended thinking on models that support it; off when nil
+	Thinking *Thinking
+
+	// AnthropicBeta lists the anthropic_beta flags sent with Claude requests, which turn on
+	// Anthropic features in beta (e.g. "token-efficient-tools-2025-02-19"). Other models ignore them.
+	AnthropicBeta []string
+
+	// Retry resends requests rejected by throttling
+	Retry RetryPolicy
+
+	// Timeout limits each request, or each stream from start to end; no limit when zero
+	Timeout time.Duration
+
+	// MaxTokens caps the tokens the model generates; the model's own default when zero
+	MaxTokens int
+
+	// MaxContinuations caps the follow-up requests that continue a response cut off at the
+	// output cap; a cut off response is returned as it is when zero
+	MaxContinuations int
+
+	// MaxToolRounds caps the tool-use round trips of UseTools; DefaultMaxToolRounds when zero
+	MaxToolRounds int
+}
+
+// StructuredOutput describes the JSON shape a model must produce in structured output mode.
+// The tool input the model sends back is the structured result.
+type StructuredOutput struct {
+	// Name is the name of the tool the model is forced to call
+	Name string
+	// Description tells the model what the tool input represents
+	Description string
+	// Schema is the JSON schema of the tool input; it must describe an object
+	Schema json.RawMessage
+}
+
+// MinThinkingBudget is the smallest reasoning budget Claude accepts
+const MinThinkingBudget = 1024
+
+// Thinking lets the model reason step by step before it answers. The reasoning comes back
+// apart from the answer, in Result.Thinking.
+type Thinking struct {
+	// BudgetTokens caps the tokens spent reasoning; at least MinThinkingBudget
+	BudgetTokens int
+}
+
+// ValidateLatency checks that the given latency mode is one Bedrock understands
+func ValidateLatency(latency string) error {
+	switch latency {
+	case "", LatencyStandard, LatencyOptimized:
+		return nil
+	default:
+		return fmt.Errorf("invalid latency mode %q: use '%s' or '%s'", latency, LatencyStandard, LatencyOptimized)
+	}
+}
+
+// MaxTokensOr returns the output token cap to send, or the model's default when none is set
+func (o Options) MaxTokensOr(defaultMax int) int {
+	if o.MaxTokens > 0 {
+		return o.MaxTokens
+	}
+	return defaultMax
+}
+
+// TemperatureOr returns the sampling temperature to send, or the model's default when none is set
+func (o Options) TemperatureOr(defaultTemperature float64) float64 {
+	if o.Temperature != nil {
+		return *o.Temperature
+	}
+	return defaultTemperature
+}
+
+// TopPOr returns the nucleus sampling top_p to send, or the model's default when none is set
+func (o Options) TopPOr(defaultTopP float64) float64 {
+	if o.TopP != nil {
+		return *o.TopP
+	}
+	return defaultTopP
+}
+
+// PerformanceLatency returns the performanceConfig latency to send for a model.
+// Optimized latency is only requested when the model supports it, otherwise the
+// request falls back to standard so Bedrock doesn't reject the call.
+func (o Options) PerformanceLatency(modelName string, supported bool) types.PerformanceConfigLatency {
+	if o.Latency != LatencyOptimized {
+		return types.PerformanceConfigLatencyStandard
+	}
+	if !supported {
+		logging.Warn(fmt.Sprintf("Warning: %s does not support latency-optimized inference, using standard latency", modelName))
+		return types.PerformanceConfigLatencyStandard
+	}
+	return types.PerformanceConfigLatencyOptimized
+}
+
+// LogLatency reports the latency mode Bedrock served against the one requested
+func (o Options) LogLatency(served string) {
+	requested := o.Latency
+	if requested == "" {
+		requested = LatencyStandard
+	}
+	if served == "" {
+		served = LatencyStandard
+	}
+	log.Printf("Latency mode: requested %s, served %s (honored: %v)", requested, served, requested == served)
+}
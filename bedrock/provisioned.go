@@ -0,0 +1,70 @@
+package bedrock
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// Provisioned routes invocations to a provisioned throughput model first and spills
+// over to the on-demand model ID when the committed capacity is exhausted
+type Provisioned struct {
+	// ModelARN is the ARN of the provisioned model
+	ModelARN string
+
+	served  atomic.Int64
+	spilled atomic.Int64
+}
+
+// NewProvisioned creates a provisioned throughput router for the given provisioned model ARN
+func NewProvisioned(modelARN string) *Provisioned {
+	return &Provisioned{ModelARN: modelARN}
+}
+
+// Utilization returns how many invocations were served by the provisioned model
+// and how many spilled over to on-demand
+func (p *Provisioned) Utilization() (served, spilled int64) {
+	return p.served.Load(), p.spilled.Load()
+}
+
+// LogUtilization logs how much of the traffic the committed capacity absorbed
+func (p *Provisioned) LogUtilization() {
+	served, spilled := p.Utilization()
+	total := served + spilled
+	if total == 0 {
+		return
+	}
+	log.Printf("Provisioned throughput: %d served, %d spilled to on-demand (%.1f%% on committed capacity)",
+		served, spilled, float64(served)*100/float64(total))
+}
+
+func (p *Provisioned) invoke(ctx context.Context, client Invoker, input *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	provisionedInput := *input
+	provisionedInput.ModelId = aws.String(p.ModelARN)
+
+	output, err := client.InvokeModel(ctx, &provisionedInput, optFns...)
+	if err == nil {
+		p.served.Add(1)
+		return output, nil
+	}
+	if !capacityExhausted(err) {
+		return nil, err
+	}
+
+	log.Printf("Provisioned model %s is at capacity, spilling over to on-demand model %s", p.ModelARN, aws.ToString(input.ModelId))
+	p.spilled.Add(1)
+	return client.InvokeModel(ctx, input, optFns...)
+}
+
+// capacityExhausted reports whether the error means the provisioned capacity can't take the request
+func capacityExhausted(err error) bool {
+	var throttling *types.ThrottlingException
+	var quota *types.ServiceQuotaExceededException
+	var notReady *types.ModelNotReadyException
+	return errors.As(err, &throttling) || errors.As(err, &quota) || errors.As(err, &notReady)
+}
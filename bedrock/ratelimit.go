@@ -0,0 +1,199 @@
+package bedrock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limiter keeps invocations under a requests-per-second and a tokens-per-minute budget using
+// token buckets, so large batches stay within the account quotas instead of being throttled
+type Limiter struct {
+	requests *bucket
+	tokens   *bucket
+}
+
+// NewLimiter creates a limiter; a zero rps or tpm leaves that dimension unlimited
+func NewLimiter(rps float64, tpm int) *Limiter {
+	l := &Limiter{}
+	if rps > 0 {
+		l.requests = newBucket(rps, max(1, rps))
+	}
+	if tpm > 0 {
+		l.tokens = newBucket(float64(tpm)/60, float64(tpm))
+	}
+	return l
+}
+
+// Wrap returns a model whose invocations wait for the limiter
+func (l *Limiter) Wrap(model Model) Model {
+	return &limitedModel{Model: model, limiter: l}
+}
+
+// acquire waits for one request and the estimated tokens of the prompt
+func (l *Limiter) acquire(ctx context.Context, estimate int) error {
+	if l.requests != nil {
+		if err := l.requests.take(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if l.tokens != nil {
+		return l.tokens.take(ctx, float64(estimate))
+	}
+	return nil
+}
+
+// Allow reports whether a request fits the limits right now without waiting. One request is
+// taken when it does; tokens aren't known yet, so the token budget only has to be non-negative
+// and Charge records the real usage afterwards.
+func (l *Limiter) Allow() bool {
+	if l.tokens != nil && l.tokens.available() < 0 {
+		return false
+	}
+	if l.requests != nil {
+		return l.requests.tryTake(1)
+	}
+	return true
+}
+
+// Charge removes the tokens a request used from the token budget
+func (l *Limiter) Charge(tokens int) {
+	if l.tokens != nil {
+		l.tokens.adjust(float64(tokens))
+	}
+}
+
+// settle corrects the token bucket once the real usage of a request is known
+func (l *Limiter) settle(estimate int, result *Result) {
+	if l.tokens == nil {
+		return
+	}
+	used := 0
+	if result != nil {
+		used = result.InputTokens + result.OutputTokens
+	}
+	l.tokens.adjust(float64(used - estimate))
+}
+
+// limitedModel is a Model whose invocations are rate limited
+type limitedModel struct {
+	Model
+	limiter *Limiter
+}
+
+func (m *limitedModel) Invoke(ctx context.Context, prompt string) (*Result, error) {
+	return m.Chat(ctx, UserTurn(prompt))
+}
+
+func (m *limitedModel) Chat(ctx context.Context, turns []Turn) (*Result, error) {
+	estimate := EstimateTokens(turns)
+	if err := m.limiter.acquire(ctx, estimate); err != nil {
+		return nil, err
+	}
+	result, err := m.Model.Chat(ctx, turns)
+	m.limiter.settle(estimate, result)
+	return result, err
+}
+
+func (m *limitedModel) Stream(ctx context.Context, turns []Turn, onText func(text string)) (*Result, error) {
+	streamer, ok := m.Model.(Streamer)
+	if !ok {
+		return nil, fmt.Errorf("the %s model doesn't support streaming", m.Name())
+	}
+	estimate := EstimateTokens(turns)
+	if err := m.limiter.acquire(ctx, estimate); err != nil {
+		return nil, err
+	}
+	result, err := streamer.Stream(ctx, turns, onText)
+	m.limiter.settle(estimate, result)
+	return result, err
+}
+
+func (m *limitedModel) UseTools(ctx context.Context, turns []Turn, tools []Tool, onCall func(call ToolCall)) (*Result, error) {
+	toolUser, ok := m.Model.(ToolUser)
+	if !ok {
+		return nil, fmt.Errorf("the %s model can't call tools", m.Name())
+	}
+	estimate := EstimateTokens(turns)
+	if err := m.limiter.acquire(ctx, estimate); err != nil {
+		return nil, err
+	}
+	result, err := toolUser.UseTools(ctx, turns, tools, onCall)
+	m.limiter.settle(estimate, result)
+	return result, err
+}
+
+// bucket is a token bucket refilled continuously at a fixed rate
+type bucket struct {
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// newBucket creates a full bucket refilled at rate tokens per second
+func newBucket(rate, capacity float64) *bucket {
+	return &bucket{rate: rate, capacity: capacity, tokens: capacity, last: time.Now()}
+}
+
+// take waits until n tokens are available and removes them. Requests larger than the
+// capacity wait for a full bucket and leave it in debt.
+func (b *bucket) take(ctx context.Context, n float64) error {
+	for {
+		b.mu.Lock()
+		b.refill()
+		need := min(n, b.capacity)
+		if b.tokens >= need {
+			b.tokens -= n
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tryTake removes n tokens if they are available and reports whether it did
+func (b *bucket) tryTake(n float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// available returns the tokens in the bucket, negative while it is in debt
+func (b *bucket) available() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens
+}
+
+// adjust removes n more tokens from the bucket, or returns them when n is negative
+func (b *bucket) adjust(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens = min(b.tokens-n, b.capacity)
+}
+
+// refill adds the tokens accumulated since the last refill; callers hold the lock
+func (b *bucket) refill() {
+	now := time.Now()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
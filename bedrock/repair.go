@@ -0,0 +1,48 @@
+package bedrock
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// repairPrompt asks the model to correct output that failed validation
+const repairPrompt = `Your previous response was not valid: %v
+
+Reply again with ONLY the corrected JSON, without any explanation or additional text.`
+
+// InvokeWithRepair sends the conversation to the model and validates its output. When
+// validation fails, the invalid output and the validation error are sent back to the model
+// asking for corrected JSON, up to maxRepairs times. If the output is still invalid, the last
+// result is returned together with a ParseError. Token usage is summed across all attempts.
+func InvokeWithRepair(ctx context.Context, model Model, turns []Turn, maxRepairs int, validate func(text string) error) (*Result, error) {
+	turns = append([]Turn(nil), turns...)
+	var inputTokens, outputTokens int
+
+	for attempt := 0; ; attempt++ {
+		result, err := model.Chat(ctx, turns)
+		if err != nil {
+			return nil, err
+		}
+
+		inputTokens += result.InputTokens
+		outputTokens += result.OutputTokens
+		result.InputTokens = inputTokens
+		result.OutputTokens = outputTokens
+		result.Repairs = attempt
+
+		validationErr := validate(result.Text)
+		if validationErr == nil {
+			return result, nil
+		}
+		if attempt >= maxRepairs {
+			return result, &ParseError{Raw: result.Text, Err: validationErr}
+		}
+
+		log.Printf("%s output failed validation (%v), requesting a correction (%d/%d)", model.Name(), validationErr, attempt+1, maxRepairs)
+		turns = append(turns,
+			Turn{Role: RoleAssistant, Text: result.Text},
+			Turn{Role: RoleUser, Text: fmt.Sprintf(repairPrompt, validationErr)},
+		)
+	}
+}
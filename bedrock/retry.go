@@ -0,0 +1,128 @@
+package bedrock
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// Default backoff of RetryPolicy
+const (
+	DefaultRetryBaseDelay = time.Second
+	DefaultRetryMaxDelay  = 20 * time.Second
+)
+
+// retryableCodes are the error codes of requests that can succeed when sent again later
+var retryableCodes = map[string]bool{
+	"ThrottlingException":         true,
+	"ServiceUnavailableException": true,
+	"ModelNotReadyException":      true,
+}
+
+// RetryPolicy retries requests rejected by throttling or a temporarily unavailable model with
+// exponential backoff and full jitter. It applies on top of the SDK's own quick retries, so
+// a run can ride out sustained throttling instead of failing. The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent, including the first
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubled for each further retry;
+	// DefaultRetryBaseDelay when zero
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff and any Retry-After the service asks for; DefaultRetryMaxDelay when zero
+	MaxDelay time.Duration
+}
+
+// do runs a request until it succeeds, fails with an error that isn't retryable, or runs out of attempts
+func (p RetryPolicy) do(ctx context.Context, operation string, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := p.delay(attempt, err)
+		log.Printf("%s was rejected (%v), retrying in %s (attempt %d/%d)", operation, errorCode(err), delay.Round(time.Millisecond), attempt+1, p.MaxAttempts)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the wait before the retry following an attempt: the Retry-After of the
+// response when there is one, otherwise a random backoff of up to BaseDelay * 2^(attempt-1)
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+	if after, ok := retryAfter(err); ok {
+		return min(after, maxDelay)
+	}
+	base := p.BaseDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	backoff := min(base<<(attempt-1), maxDelay)
+	if backoff <= 0 {
+		// The shift overflowed
+		backoff = maxDelay
+	}
+	return rand.N(backoff) + time.Millisecond
+}
+
+// retryable reports whether a failed request may succeed when sent again
+func retryable(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return retryableCodes[apiErr.ErrorCode()]
+	}
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		status := responseErr.HTTPStatusCode()
+		return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// errorCode names the error of a rejected request for the retry log line
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		return "HTTP " + strconv.Itoa(responseErr.HTTPStatusCode())
+	}
+	return err.Error()
+}
+
+// retryAfter returns the wait requested by a Retry-After header, given in seconds or as an HTTP date
+func retryAfter(err error) (time.Duration, bool) {
+	var responseErr *awshttp.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Response == nil {
+		return 0, false
+	}
+	header := responseErr.Response.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,222 @@
+package bedrock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// Streamer is implemented by models that can stream their response while it is generated
+type Streamer interface {
+	// Stream sends a conversation to the model, calling onText with each chunk of generated
+	// text, and returns the complete result once the response has finished
+	Stream(ctx context.Context, turns []Turn, onText func(text string)) (*Result, error)
+}
+
+// StreamRequest identifies the model a conversation is streamed from
+type StreamRequest struct {
+	// Model is the short model name reported in the result
+	Model string
+	// ModelID is the Bedrock model or inference profile ID
+	ModelID string
+	// Latency is the performance configuration to request
+	Latency types.PerformanceConfigLatency
+	// Retry resends the request when opening the stream is throttled
+	Retry RetryPolicy
+	// Timeout limits the stream from the request to the last event; no limit when zero
+	Timeout time.Duration
+	// MaxTokens caps the generated tokens; the model's default when zero
+	MaxTokens int
+	// System is the system prompt; none when empty
+	System string
+	// StopSequences end the response when the model generates one of them
+	StopSequences []string
+	// Temperature and TopP override the model's sampling; the model's defaults when nil
+	Temperature *float64
+	TopP        *float64
+	// TopK limits the sampling of a Nova request to the K most likely tokens
+	TopK int
+	// Guardrail screens the conversation and the response; none when nil
+	Guardrail *Guardrail
+	// Thinking enables extended thinking; MaxTokens must leave room for the budget
+	Thinking *Thinking
+	// AnthropicBeta lists the anthropic_beta flags of a Claude request
+	AnthropicBeta []string
+}
+
+// additionalFields returns the model-specific fields of a Converse request: the ones that
+// enable thinking and Anthropic's beta features, and Nova's top-k sampling. It returns nil
+// when there are none.
+func (req StreamRequest) additionalFields() document.Interface {
+	fields := map[string]any{}
+	if req.Thinking != nil {
+		fields["thinking"] = map[string]any{"type": "enabled", "budget_tokens": req.Thinking.BudgetTokens}
+	}
+	if len(req.AnthropicBeta) > 0 {
+		fields["anthropic_beta"] = req.AnthropicBeta
+	}
+	if req.TopK > 0 {
+		fields["inferenceConfig"] = map[string]any{"topK": req.TopK}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return document.NewLazyDocument(fields)
+}
+
+// system returns the system prompt of a Converse request; nil when there is none
+func (req StreamRequest) system() []types.SystemContentBlock {
+	if req.System == "" {
+		return nil
+	}
+	return []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: req.System}}
+}
+
+// inferenceConfig returns the inference configuration of a Converse request; nil when it
+// leaves everything to the model's defaults
+func (req StreamRequest) inferenceConfig() *types.InferenceConfiguration {
+	if req.MaxTokens <= 0 && len(req.StopSequences) == 0 && req.Temperature == nil && req.TopP == nil {
+		return nil
+	}
+	config := &types.InferenceConfiguration{StopSequences: req.StopSequences}
+	if req.MaxTokens > 0 {
+		config.MaxTokens = aws.Int32(int32(req.MaxTokens))
+	}
+	if req.Temperature != nil {
+		config.Temperature = aws.Float32(float32(*req.Temperature))
+	}
+	if req.TopP != nil {
+		config.TopP = aws.Float32(float32(*req.TopP))
+	}
+	return config
+}
+
+// ConverseStream streams a conversation through the model-agnostic Converse API, which accepts
+// the same messages for every model family
+func ConverseStream(ctx context.Context, client Converser, req StreamRequest, turns []Turn, onText func(text string)) (*Result, error) {
+	var result *Result
+	err := withTimeout(ctx, "ConverseStream", req.Timeout, func(ctx context.Context) error {
+		var err error
+		result, err = converseStream(ctx, client, req, turns, onText)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrContentFiltered) {
+		return nil, err
+	}
+	return result, err
+}
+
+// converseMessages converts a conversation to Converse API messages
+func converseMessages(turns []Turn) []types.Message {
+	messages := make([]types.Message, len(turns))
+	for i, turn := range turns {
+		var content []types.ContentBlock
+		for _, document := range turn.Documents {
+			content = append(content, &types.ContentBlockMemberDocument{Value: types.DocumentBlock{
+				Name:   aws.String(document.Name),
+				Format: types.DocumentFormat(document.Format),
+				Source: &types.DocumentSourceMemberBytes{Value: document.Data},
+			}})
+		}
+		for _, video := range turn.Videos {
+			block := types.VideoBlock{Format: types.VideoFormat(video.Format)}
+			if video.S3URI != "" {
+				block.Source = &types.VideoSourceMemberS3Location{Value: types.S3Location{Uri: aws.String(video.S3URI)}}
+			} else {
+				block.Source = &types.VideoSourceMemberBytes{Value: video.Data}
+			}
+			content = append(content, &types.ContentBlockMemberVideo{Value: block})
+		}
+		for _, image := range turn.Images {
+			content = append(content, &types.ContentBlockMemberImage{Value: types.ImageBlock{
+				Format: types.ImageFormat(image.Format),
+				Source: &types.ImageSourceMemberBytes{Value: image.Data},
+			}})
+		}
+		messages[i] = types.Message{
+			Role:    types.ConversationRole(turn.Role),
+			Content: append(content, &types.ContentBlockMemberText{Value: turn.Text}),
+		}
+	}
+	return messages
+}
+
+// converseStream runs the stream of ConverseStream
+func converseStream(ctx context.Context, client Converser, req StreamRequest, turns []Turn, onText func(text string)) (*Result, error) {
+	input := &bedrockruntime.ConverseStreamInput{
+		ModelId:         aws.String(req.ModelID),
+		Messages:        converseMessages(turns),
+		System:          req.system(),
+		InferenceConfig: req.inferenceConfig(),
+		GuardrailConfig: req.Guardrail.streamConfig(),
+
+		AdditionalModelRequestFields: req.additionalFields(),
+	}
+	if req.Latency != "" {
+		input.PerformanceConfig = &types.PerformanceConfiguration{Latency: req.Latency}
+	}
+
+	var output *bedrockruntime.ConverseStreamOutput
+	err := req.Retry.do(ctx, "ConverseStream", func() error {
+		var err error
+		output, err = client.ConverseStream(ctx, input, betaHeader(req.AnthropicBeta)...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream from %s: %w", req.Model, describeError("ConverseStream", req.ModelID, err))
+	}
+	stream := output.GetStream()
+	defer stream.Close()
+
+	result := &Result{Model: req.Model}
+	var text, thinking strings.Builder
+	var trace *GuardrailTrace
+	for event := range stream.Events() {
+		switch e := event.(type) {
+		case *types.ConverseStreamOutputMemberContentBlockDelta:
+			switch delta := e.Value.Delta.(type) {
+			case *types.ContentBlockDeltaMemberText:
+				text.WriteString(delta.Value)
+				onText(delta.Value)
+			case *types.ContentBlockDeltaMemberReasoningContent:
+				// The reasoning isn't passed to onText, it isn't part of the answer
+				if reasoning, ok := delta.Value.(*types.ReasoningContentBlockDeltaMemberText); ok {
+					thinking.WriteString(reasoning.Value)
+				}
+			}
+		case *types.ConverseStreamOutputMemberMessageStop:
+			result.StopReason = string(e.Value.StopReason)
+		case *types.ConverseStreamOutputMemberMetadata:
+			if usage := e.Value.Usage; usage != nil {
+				result.InputTokens = int(aws.ToInt32(usage.InputTokens))
+				result.OutputTokens = int(aws.ToInt32(usage.OutputTokens))
+			}
+			if performance := e.Value.PerformanceConfig; performance != nil {
+				result.Latency = string(performance.Latency)
+			}
+			if e.Value.Trace != nil {
+				trace = converseGuardrailTrace(e.Value.Trace.Guardrail)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("stream from %s failed: %w", req.Model, describeStreamError("ConverseStream", req.ModelID, output.ResultMetadata, err))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Text = text.String()
+	result.Thinking = thinking.String()
+	if result.StopReason == StopGuardrailIntervened {
+		result.Guardrail = trace
+	}
+	return result, CheckStopReason(result)
+}
@@ -0,0 +1,23 @@
+package bedrock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// withTimeout runs a call under a deadline of timeout, or without one when timeout is zero,
+// turning the expiry of that deadline into an ErrTimeout
+func withTimeout(ctx context.Context, operation string, timeout time.Duration, call func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return call(ctx)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := call(callCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s %w after %s", operation, ErrTimeout, timeout)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package bedrock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// EstimateTokens approximates the input tokens of a conversation at four characters per token,
+// an image at one token per 750 pixels, and PDF and Office documents from their size. Videos
+// aren't counted, since their tokens depend on the duration. Real tokenizers vary by model and
+// language, so treat it as a rough guide.
+func EstimateTokens(turns []Turn) int {
+	chars, attachments := 0, 0
+	for _, turn := range turns {
+		chars += utf8.RuneCountInString(turn.Text)
+		for _, image := range turn.Images {
+			attachments += image.tokens()
+		}
+		for _, document := range turn.Documents {
+			attachments += document.tokens()
+		}
+	}
+	return chars/4 + 1 + attachments
+}
+
+// CountTokens asks Bedrock how many input tokens the conversation is for the model, without
+// invoking it. Only some models support counting; the others fail with a validation error.
+func CountTokens(ctx context.Context, client *bedrockruntime.Client, modelID string, turns []Turn) (int, error) {
+	modelID = FoundationModelID(modelID)
+	output, err := client.CountTokens(ctx, &bedrockruntime.CountTokensInput{
+		ModelId: aws.String(modelID),
+		Input: &types.CountTokensInputMemberConverse{Value: types.ConverseTokensRequest{
+			Messages: converseMessages(turns),
+		}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error counting tokens: %w", describeError("CountTokens", modelID, err))
+	}
+	return int(aws.ToInt32(output.InputTokens)), nil
+}
+
+// geographyPrefixes start the IDs of cross-region inference profiles
+var geographyPrefixes = []string{"us.", "us-gov.", "eu.", "apac.", "ca.", "jp.", "au.", "global."}
+
+// FoundationModelID returns the foundation model behind a cross-region inference profile ID or
+// ARN, e.g. anthropic.claude-3-5-sonnet-20241022-v2:0 for us.anthropic.claude-3-5-sonnet-20241022-v2:0.
+// CountTokens only accepts foundation models. Other IDs are returned unchanged.
+func FoundationModelID(modelID string) string {
+	_, profile, ok := strings.Cut(modelID, ":inference-profile/")
+	if !ok {
+		profile = modelID
+	}
+	for _, prefix := range geographyPrefixes {
+		if strings.HasPrefix(profile, prefix) {
+			return strings.TrimPrefix(profile, prefix)
+		}
+	}
+	return modelID
+}
@@ -0,0 +1,229 @@
+package bedrock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// DefaultMaxToolRounds is the number of tool-use round trips UseTools allows when
+// Options.MaxToolRounds is zero
+const DefaultMaxToolRounds = 8
+
+// ErrToolRounds is returned when a model keeps calling tools beyond the allowed rounds
+var ErrToolRounds = errors.New("too many tool-use rounds")
+
+// Tool is a Go function the model can call while it answers
+type Tool struct {
+	// Name identifies the tool to the model; letters, digits, underscores and hyphens
+	Name string
+	// Description tells the model what the tool does and when to call it
+	Description string
+	// Schema is the JSON schema of the tool input; it must describe an object
+	Schema json.RawMessage
+	// Run executes a call with the input the model sent. Its result, or the text of its
+	// error, is returned to the model.
+	Run func(ctx context.Context, input json.RawMessage) (string, error)
+}
+
+// ToolCall is a tool call the model requested, with its outcome
+type ToolCall struct {
+	Name   string
+	Input  json.RawMessage
+	Output string
+	// Err is set when the tool failed; the model is told so and can try again
+	Err error
+}
+
+// ToolUser is implemented by models that can call tools while they answer
+type ToolUser interface {
+	// UseTools sends a conversation with the given tools, runs the tool calls the model
+	// requests and returns their results to it until the model answers in text. onCall is
+	// called after each tool call.
+	UseTools(ctx context.Context, turns []Turn, tools []Tool, onCall func(call ToolCall)) (*Result, error)
+}
+
+// ConverseTools runs a tool-use conversation through the Converse API. Each round sends the
+// conversation so far; when the model stops to use tools, the calls are run locally and their
+// results appended as a user message for the next round. The result adds up the tokens of
+// all rounds. maxRounds limits the rounds that end in tool use; DefaultMaxToolRounds when zero.
+func ConverseTools(ctx context.Context, client Converser, req StreamRequest, turns []Turn, tools []Tool, maxRounds int, onCall func(call ToolCall)) (*Result, error) {
+	if maxRounds <= 0 {
+		maxRounds = DefaultMaxToolRounds
+	}
+	config, err := toolConfiguration(tools)
+	if err != nil {
+		return nil, err
+	}
+	input := &bedrockruntime.ConverseInput{
+		ModelId:         aws.String(req.ModelID),
+		Messages:        converseMessages(turns),
+		System:          req.system(),
+		InferenceConfig: req.inferenceConfig(),
+		ToolConfig:      config,
+		GuardrailConfig: req.Guardrail.converseConfig(),
+
+		AdditionalModelRequestFields: req.additionalFields(),
+	}
+	if req.Latency != "" {
+		input.PerformanceConfig = &types.PerformanceConfiguration{Latency: req.Latency}
+	}
+
+	result := &Result{Model: req.Model}
+	for round := 0; ; round++ {
+		var output *bedrockruntime.ConverseOutput
+		err := withTimeout(ctx, "Converse", req.Timeout, func(ctx context.Context) error {
+			return req.Retry.do(ctx, "Converse", func() error {
+				var err error
+				output, err = client.Converse(ctx, input, betaHeader(req.AnthropicBeta)...)
+				return err
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to invoke %s: %w", req.Model, describeError("Converse", req.ModelID, err))
+		}
+		if usage := output.Usage; usage != nil {
+			result.InputTokens += int(aws.ToInt32(usage.InputTokens))
+			result.OutputTokens += int(aws.ToInt32(usage.OutputTokens))
+		}
+		if performance := output.PerformanceConfig; performance != nil {
+			result.Latency = string(performance.Latency)
+		}
+		result.StopReason = string(output.StopReason)
+		message, ok := output.Output.(*types.ConverseOutputMemberMessage)
+		if !ok {
+			return nil, fmt.Errorf("%s returned no message", req.Model)
+		}
+		if thinking := messageThinking(message.Value); thinking != "" {
+			if result.Thinking != "" {
+				result.Thinking += "\n\n"
+			}
+			result.Thinking += thinking
+		}
+		if output.StopReason != types.StopReasonToolUse {
+			result.Text = messageText(message.Value)
+			if output.StopReason == types.StopReasonGuardrailIntervened && output.Trace != nil {
+				result.Guardrail = converseGuardrailTrace(output.Trace.Guardrail)
+			}
+			return result, CheckStopReason(result)
+		}
+		if round >= maxRounds {
+			return nil, fmt.Errorf("%w: %s still calling tools after %d rounds", ErrToolRounds, req.Model, maxRounds)
+		}
+
+		var results []types.ContentBlock
+		for _, block := range message.Value.Content {
+			use, ok := block.(*types.ContentBlockMemberToolUse)
+			if !ok {
+				continue
+			}
+			input := json.RawMessage("{}")
+			if use.Value.Input != nil {
+				if data, err := use.Value.Input.MarshalSmithyDocument(); err == nil {
+					input = data
+				}
+			}
+			call := CallTool(ctx, tools, aws.ToString(use.Value.Name), input)
+			if onCall != nil {
+				onCall(call)
+			}
+			toolResult := types.ToolResultBlock{
+				ToolUseId: use.Value.ToolUseId,
+				Content:   []types.ToolResultContentBlock{&types.ToolResultContentBlockMemberText{Value: call.Output}},
+			}
+			if call.Err != nil {
+				toolResult.Content = []types.ToolResultContentBlock{&types.ToolResultContentBlockMemberText{Value: call.Err.Error()}}
+				toolResult.Status = types.ToolResultStatusError
+			}
+			results = append(results, &types.ContentBlockMemberToolResult{Value: toolResult})
+		}
+		if len(results) == 0 {
+			return nil, fmt.Errorf("%s stopped to use tools without calling any", req.Model)
+		}
+		input.Messages = append(input.Messages, message.Value, types.Message{Role: types.ConversationRoleUser, Content: results})
+	}
+}
+
+// CheckTools checks that there are tools to use, that their names are unique and that their
+// schemas are JSON objects
+func CheckTools(tools []Tool) error {
+	if len(tools) == 0 {
+		return errors.New("no tools to use")
+	}
+	seen := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		if seen[tool.Name] {
+			return fmt.Errorf("tool %q is defined twice", tool.Name)
+		}
+		seen[tool.Name] = true
+		var schema map[string]any
+		if err := json.Unmarshal(tool.Schema, &schema); err != nil {
+			return fmt.Errorf("invalid schema of tool %q: %v", tool.Name, err)
+		}
+	}
+	return nil
+}
+
+// CallTool runs a tool call the model requested. A call of a tool that isn't defined fails
+// like a failing tool, so the model learns of its mistake.
+func CallTool(ctx context.Context, tools []Tool, name string, input json.RawMessage) ToolCall {
+	call := ToolCall{Name: name, Input: input}
+	for _, tool := range tools {
+		if tool.Name == name {
+			call.Output, call.Err = tool.Run(ctx, input)
+			return call
+		}
+	}
+	call.Err = fmt.Errorf("there is no tool named %q", name)
+	return call
+}
+
+// toolConfiguration converts tools to the Converse API's tool configuration
+func toolConfiguration(tools []Tool) (*types.ToolConfiguration, error) {
+	if err := CheckTools(tools); err != nil {
+		return nil, err
+	}
+	config := &types.ToolConfiguration{}
+	for _, tool := range tools {
+		var schema map[string]any
+		json.Unmarshal(tool.Schema, &schema)
+		config.Tools = append(config.Tools, &types.ToolMemberToolSpec{Value: types.ToolSpecification{
+			Name:        aws.String(tool.Name),
+			Description: aws.String(tool.Description),
+			InputSchema: &types.ToolInputSchemaMemberJson{Value: document.NewLazyDocument(schema)},
+		}})
+	}
+	return config, nil
+}
+
+// messageText joins the text blocks of a Converse API message
+func messageText(message types.Message) string {
+	var text strings.Builder
+	for _, block := range message.Content {
+		if t, ok := block.(*types.ContentBlockMemberText); ok {
+			text.WriteString(t.Value)
+		}
+	}
+	return text.String()
+}
+
+// messageThinking returns the reasoning in a Converse message. Redacted reasoning is left out,
+// it can only be sent back to the model.
+func messageThinking(message types.Message) string {
+	var thinking strings.Builder
+	for _, block := range message.Content {
+		if r, ok := block.(*types.ContentBlockMemberReasoningContent); ok {
+			if t, ok := r.Value.(*types.ReasoningContentBlockMemberReasoningText); ok {
+				thinking.WriteString(aws.ToString(t.Value.Text))
+			}
+		}
+	}
+	return thinking.String()
+}
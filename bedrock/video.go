@@ -0,0 +1,69 @@
+package bedrock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxVideoBytes is the largest video that can be sent in a request; larger clips must be read
+// from S3
+const MaxVideoBytes = 25_000_000
+
+// videoFormats are the video formats Bedrock accepts, by file extension
+var videoFormats = map[string]string{
+	".mp4":  "mp4",
+	".mov":  "mov",
+	".mkv":  "mkv",
+	".webm": "webm",
+	".flv":  "flv",
+	".mpeg": "mpeg",
+	".mpg":  "mpg",
+	".wmv":  "wmv",
+	".3gp":  "three_gp",
+}
+
+// Video is a clip attached to a conversation turn, sent in the request or read by Bedrock from S3
+type Video struct {
+	// Format is the video format, e.g. mp4 or mov
+	Format string `json:"format"`
+	// Data is the clip itself, when it isn't read from S3
+	Data []byte `json:"data,omitempty"`
+	// S3URI is the s3://bucket/key of the clip, which must be in the account's region
+	S3URI string `json:"s3_uri,omitempty"`
+}
+
+// VideoFormat returns the Bedrock format of a video file from its extension
+func VideoFormat(path string) (string, error) {
+	format, ok := videoFormats[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return "", fmt.Errorf("unsupported video %s: use MP4, MOV, MKV, WebM, FLV, MPEG, WMV or 3GP", filepath.Base(path))
+	}
+	return format, nil
+}
+
+// LoadVideo reads a video file to send in the request
+func LoadVideo(path string) (Video, error) {
+	format, err := VideoFormat(path)
+	if err != nil {
+		return Video{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Video{}, fmt.Errorf("failed to read video: %v", err)
+	}
+	if len(data) > MaxVideoBytes {
+		return Video{}, fmt.Errorf("the video is %d bytes; at most %d can be sent in a request, larger clips must be read from S3", len(data), MaxVideoBytes)
+	}
+	return Video{Format: format, Data: data}, nil
+}
+
+// S3Video references a clip Bedrock reads from S3
+func S3Video(uri string) (Video, error) {
+	format, err := VideoFormat(uri)
+	if err != nil {
+		return Video{}, err
+	}
+	return Video{Format: format, S3URI: uri}, nil
+}
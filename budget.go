@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/pricing"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// errBudgetExceeded is returned instead of invoking a model once the run's budget is spent
+var errBudgetExceeded = errors.New("budget exceeded")
+
+// budget caps the estimated spend and the tokens of a run, from -max-cost and -max-tokens.
+// Calls already in flight when the limit is crossed finish, so the total can overshoot by
+// their usage.
+type budget struct {
+	maxCost   float64
+	maxTokens int
+
+	mu     sync.Mutex
+	cost   float64
+	tokens int
+}
+
+// newBudget creates a budget; nil when neither limit is set
+func newBudget(maxCost float64, maxTokens int) *budget {
+	if maxCost <= 0 && maxTokens <= 0 {
+		return nil
+	}
+	return &budget{maxCost: maxCost, maxTokens: maxTokens}
+}
+
+// check returns an error wrapping errBudgetExceeded once a limit has been reached
+func (b *budget) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.maxCost > 0 && b.cost >= b.maxCost {
+		return fmt.Errorf("%w: estimated spend $%.4f reached -max-cost $%.4f", errBudgetExceeded, b.cost, b.maxCost)
+	}
+	if b.maxTokens > 0 && b.tokens >= b.maxTokens {
+		return fmt.Errorf("%w: %d tokens reached -max-tokens %d", errBudgetExceeded, b.tokens, b.maxTokens)
+	}
+	return nil
+}
+
+// charge records the usage of a model call
+func (b *budget) charge(model string, inputTokens, outputTokens int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cost += pricing.Cost(model, inputTokens, outputTokens)
+	b.tokens += inputTokens + outputTokens
+}
+
+// logSpend logs how much of the budget the run used
+func (b *budget) logSpend() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log.Printf("Budget: estimated spend $%.4f, %d tokens", b.cost, b.tokens)
+}
+
+// wrap returns a model that stops invoking once the budget is spent; the model itself without a budget
+func (b *budget) wrap(model bedrock.Model) bedrock.Model {
+	if b == nil {
+		return model
+	}
+	return &budgetedModel{Model: model, budget: b}
+}
+
+// budgetedModel is a Model whose invocations are charged to a budget
+type budgetedModel struct {
+	bedrock.Model
+	budget *budget
+}
+
+func (m *budgetedModel) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *budgetedModel) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	if err := m.budget.check(); err != nil {
+		return nil, err
+	}
+	result, err := m.Model.Chat(ctx, turns)
+	if result != nil {
+		m.budget.charge(result.VersionedModel(), result.InputTokens, result.OutputTokens)
+	}
+	return result, err
+}
+
+func (m *budgetedModel) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	streamer, ok := m.Model.(bedrock.Streamer)
+	if !ok {
+		return nil, fmt.Errorf("the %s model doesn't support streaming", m.Name())
+	}
+	if err := m.budget.check(); err != nil {
+		return nil, err
+	}
+	result, err := streamer.Stream(ctx, turns, onText)
+	if result != nil {
+		m.budget.charge(result.VersionedModel(), result.InputTokens, result.OutputTokens)
+	}
+	return result, err
+}
+
+func (m *budgetedModel) UseTools(ctx context.Context, turns []bedrock.Turn, tools []bedrock.Tool, onCall func(call bedrock.ToolCall)) (*bedrock.Result, error) {
+	toolUser, ok := m.Model.(bedrock.ToolUser)
+	if !ok {
+		return nil, fmt.Errorf("the %s model can't call tools", m.Name())
+	}
+	if err := m.budget.check(); err != nil {
+		return nil, err
+	}
+	result, err := toolUser.UseTools(ctx, turns, tools, onCall)
+	if result != nil {
+		m.budget.charge(result.VersionedModel(), result.InputTokens, result.OutputTokens)
+	}
+	return result, err
+}
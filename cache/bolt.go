@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucket holds the cached values
+var bucket = []byte("responses")
+
+// openTimeout is how long OpenBolt waits for another process holding the database
+const openTimeout = time.Second
+
+// Bolt is a cache in a local BoltDB file. Each value is stored with its expiry. Only one
+// process can have the file open.
+type Bolt struct {
+	db  *bolt.DB
+	ttl time.Duration
+}
+
+// OpenBolt opens or creates the cache database at path. Values expire ttl after they are
+// stored, or never when ttl is zero.
+func OpenBolt(path string, ttl time.Duration) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		if err == bolt.ErrTimeout {
+			return nil, fmt.Errorf("cache %s is in use by another process", path)
+		}
+		return nil, fmt.Errorf("failed to open cache: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open cache: %v", err)
+	}
+	return &Bolt{db: db, ttl: ttl}, nil
+}
+
+// Get returns the value stored under key. Expired values are deleted and reported as missing.
+func (s *Bolt) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	stale := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(bucket).Get([]byte(key))
+		if len(stored) < 8 {
+			return nil
+		}
+		if expires := int64(binary.BigEndian.Uint64(stored)); expires != 0 && expired(time.Unix(0, expires)) {
+			stale = true
+			return nil
+		}
+		// The stored bytes are only valid within the transaction
+		value = append([]byte{}, stored[8:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %v", err)
+	}
+	if stale {
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucket).Delete([]byte(key))
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to expire cache entry: %v", err)
+		}
+	}
+	return value, value != nil, nil
+}
+
+// Put stores a value under key, replacing any earlier one
+func (s *Bolt) Put(ctx context.Context, key string, value []byte) error {
+	stored := make([]byte, 8, 8+len(value))
+	if expires := expiry(s.ttl); !expires.IsZero() {
+		binary.BigEndian.PutUint64(stored, uint64(expires.UnixNano()))
+	}
+	stored = append(stored, value...)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), stored)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	return nil
+}
+
+// Close closes the cache database
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache stores response values by key. Implementations are safe for concurrent use, and values
+// expire after the time to live the cache was created with.
+type Cache interface {
+	// Get returns the value stored under key; ok is false when there is none or it expired
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Put stores a value under key, replacing any earlier one
+	Put(ctx context.Context, key string, value []byte) error
+	// Close releases the cache's connections or files
+	Close() error
+}
+
+// expiry returns when a value stored now expires, or the zero time when ttl is zero
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// expired reports whether a value with the given expiry has expired
+func expired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attributes of the DynamoDB cache items
+const (
+	// dynamoKey is the table's partition key, a string
+	dynamoKey = "key"
+	// dynamoValue is the cached value, binary
+	dynamoValue = "value"
+	// dynamoExpires is the expiry in Unix seconds, suited to be the table's TTL attribute
+	dynamoExpires = "expires_at"
+)
+
+// DynamoDB is a cache in a DynamoDB table with a string partition key named "key". Expiry is
+// checked on read; DynamoDB's time to live on the expires_at attribute deletes expired items.
+type DynamoDB struct {
+	client *dynamodb.Client
+	table  string
+	ttl    time.Duration
+}
+
+// NewDynamoDB creates a cache in the table
+func NewDynamoDB(client *dynamodb.Client, table string, ttl time.Duration) *DynamoDB {
+	return &DynamoDB{client: client, table: table, ttl: ttl}
+}
+
+// Get returns the value stored under key
+func (c *DynamoDB) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	out, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(c.table),
+		Key:       map[string]types.AttributeValue{dynamoKey: &types.AttributeValueMemberS{Value: key}},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %v", err)
+	}
+	value, ok := out.Item[dynamoValue].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, false, nil
+	}
+	if attr, ok := out.Item[dynamoExpires].(*types.AttributeValueMemberN); ok {
+		// DynamoDB deletes expired items in the background, possibly days later
+		if seconds, err := strconv.ParseInt(attr.Value, 10, 64); err == nil && expired(time.Unix(seconds, 0)) {
+			return nil, false, nil
+		}
+	}
+	return value.Value, true, nil
+}
+
+// Put stores a value under key, replacing any earlier one
+func (c *DynamoDB) Put(ctx context.Context, key string, value []byte) error {
+	item := map[string]types.AttributeValue{
+		dynamoKey:   &types.AttributeValueMemberS{Value: key},
+		dynamoValue: &types.AttributeValueMemberB{Value: value},
+	}
+	if expires := expiry(c.ttl); !expires.IsZero() {
+		item[dynamoExpires] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expires.Unix(), 10)}
+	}
+	_, err := c.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(c.table), Item: item})
+	if err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	return nil
+}
+
+// Close does nothing; the DynamoDB client holds no resources of its own
+func (c *DynamoDB) Close() error {
+	return nil
+}
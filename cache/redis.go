@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a cache in a Redis server, shared by every process that connects to it. Expiry is
+// left to Redis.
+type Redis struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// OpenRedis connects to the Redis server at a redis:// or rediss:// URL and checks that it answers.
+// Keys are stored with the given prefix.
+func OpenRedis(ctx context.Context, url, prefix string, ttl time.Duration) (*Redis, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	client := redis.NewClient(options)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis at %s: %v", options.Addr, err)
+	}
+	return &Redis{client: client, prefix: prefix, ttl: ttl}, nil
+}
+
+// Get returns the value stored under key
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %v", err)
+	}
+	return value, true, nil
+}
+
+// Put stores a value under key, replacing any earlier one
+func (r *Redis) Put(ctx context.Context, key string, value []byte) error {
+	if err := r.client.Set(ctx, r.prefix+key, value, r.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	return nil
+}
+
+// Close closes the connections to the server
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
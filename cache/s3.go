@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3ExpiresMetadata is the object metadata holding a value's expiry
+const s3ExpiresMetadata = "expires-at"
+
+// S3 is a cache of one object per key under an S3 prefix. The expiry is kept in the object
+// metadata and checked on read; a lifecycle rule on the prefix can delete expired objects.
+type S3 struct {
+	client *s3.Client
+	bucket string
+	prefix string
+	ttl    time.Duration
+}
+
+// NewS3 creates a cache under the prefix of the bucket
+func NewS3(client *s3.Client, bucket, prefix string, ttl time.Duration) *S3 {
+	return &S3{client: client, bucket: bucket, prefix: prefix, ttl: ttl}
+}
+
+// Get returns the value stored under key
+func (c *S3) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	out, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.prefix + key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %v", err)
+	}
+	defer out.Body.Close()
+	if expires, err := time.Parse(time.RFC3339, out.Metadata[s3ExpiresMetadata]); err == nil && expired(expires) {
+		return nil, false, nil
+	}
+	value, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %v", err)
+	}
+	return value, true, nil
+}
+
+// Put stores a value under key, replacing any earlier one
+func (c *S3) Put(ctx context.Context, key string, value []byte) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(c.prefix + key),
+		Body:        bytes.NewReader(value),
+		ContentType: aws.String("application/json"),
+	}
+	if expires := expiry(c.ttl); !expires.IsZero() {
+		input.Metadata = map[string]string{s3ExpiresMetadata: expires.UTC().Format(time.RFC3339)}
+	}
+	if _, err := c.client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	return nil
+}
+
+// Close does nothing; the S3 client holds no resources of its own
+func (c *S3) Close() error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package canvas
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// Amazon Nova Canvas
+const (
+	// Name is the short model name used on the command line
+	Name = "canvas"
+	// ModelID is the Bedrock model ID; Nova Canvas runs in us-east-1, eu-west-1 and ap-northeast-1
+	ModelID = "amazon.nova-canvas-v1:0"
+	// maxSeed is the largest seed Nova Canvas accepts
+	maxSeed = 858993459
+)
+
+// Amazon Titan Image Generator v2, which takes the same requests as Nova Canvas
+const (
+	// TitanName is the short model name used on the command line
+	TitanName = "titan-image"
+	// TitanModelID is the Bedrock model ID; Titan Image Generator runs in us-east-1 and us-west-2
+	TitanModelID = "amazon.titan-image-generator-v2:0"
+	// titanMaxSeed is the largest seed Titan Image Generator accepts
+	titanMaxSeed = 2147483646
+)
+
+// TextToImageParams represents the prompt of a text-to-image task
+type TextToImageParams struct {
+	Text         string `json:"text"`
+	NegativeText string `json:"negativeText,omitempty"`
+}
+
+// ImageGenerationConfig represents the size, number and sampling of the generated images
+type ImageGenerationConfig struct {
+	NumberOfImages int     `json:"numberOfImages,omitempty"`
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	CFGScale       float64 `json:"cfgScale,omitempty"`
+	Seed           int     `json:"seed"`
+	Quality        string  `json:"quality,omitempty"`
+}
+
+// Payload represents the request payload for a text-to-image task
+type Payload struct {
+	TaskType              string                `json:"taskType"`
+	TextToImageParams     TextToImageParams     `json:"textToImageParams"`
+	ImageGenerationConfig ImageGenerationConfig `json:"imageGenerationConfig"`
+}
+
+// Response represents the response of a text-to-image task
+type Response struct {
+	// Images are the base64-encoded PNG images
+	Images []string `json:"images"`
+	// Error is set when the request was rejected, e.g. by content moderation
+	Error *string `json:"error"`
+}
+
+// InvokeModel generates images with Nova Canvas or Titan Image Generator
+func InvokeModel(ctx context.Context, client bedrock.Invoker, modelID string, payload Payload, opts bedrock.Options) (*Response, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %v", err)
+	}
+	logging.Debugf("Payload: %s", string(payloadBytes))
+
+	output, err := bedrock.Invoke(ctx, client, &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(modelID),
+		ContentType: aws.String("application/json"),
+		Accept:      aws.String("application/json"),
+		Body:        payloadBytes,
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error invoking Bedrock image model %s: %w", modelID, err)
+	}
+
+	var response Response
+	if err := json.Unmarshal(output.Body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	if response.Error != nil && *response.Error != "" {
+		return nil, fmt.Errorf("image generation failed: %s", *response.Error)
+	}
+	return &response, nil
+}
+
+type model struct {
+	client  bedrock.Invoker
+	opts    bedrock.Options
+	name    string
+	modelID string
+	maxSeed int
+}
+
+// New returns a bedrock.ImageModel that generates images with Nova Canvas
+func New(client bedrock.Invoker, opts bedrock.Options) bedrock.ImageModel {
+	return &model{client: client, opts: opts, name: Name, modelID: ModelID, maxSeed: maxSeed}
+}
+
+// NewTitan returns a bedrock.ImageModel that generates images with Titan Image Generator v2
+func NewTitan(client bedrock.Invoker, opts bedrock.Options) bedrock.ImageModel {
+	return &model{client: client, opts: opts, name: TitanName, modelID: TitanModelID, maxSeed: titanMaxSeed}
+}
+
+func (m *model) Name() string {
+	return m.name
+}
+
+func (m *model) Generate(ctx context.Context, req bedrock.ImageRequest) (*bedrock.ImageResult, error) {
+	seed := req.Seed
+	if seed < 0 {
+		seed = rand.IntN(m.maxSeed + 1)
+	} else if seed > m.maxSeed {
+		return nil, fmt.Errorf("the %s seed must be at most %d", m.name, m.maxSeed)
+	}
+	if req.Steps > 0 {
+		return nil, fmt.Errorf("the %s model doesn't take a number of steps", m.name)
+	}
+
+	response, err := InvokeModel(ctx, m.client, m.modelID, Payload{
+		TaskType: "TEXT_IMAGE",
+		TextToImageParams: TextToImageParams{
+			Text:         req.Prompt,
+			NegativeText: req.NegativePrompt,
+		},
+		ImageGenerationConfig: ImageGenerationConfig{
+			NumberOfImages: req.Count,
+			Width:          req.Width,
+			Height:         req.Height,
+			CFGScale:       req.CFGScale,
+			Seed:           seed,
+			Quality:        req.Quality,
+		},
+	}, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Images) == 0 {
+		return nil, errors.New("the model returned no images")
+	}
+
+	result := &bedrock.ImageResult{Model: m.name, Seed: seed}
+	for _, encoded := range response.Images {
+		image, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image in the response: %v", err)
+		}
+		result.Images = append(result.Images, image)
+	}
+	return result, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// Chat WebSocket event types. Clients send message, cancel and reset; the server answers with
+// delta events while generating followed by done, cancelled or error.
+const (
+	chatEventMessage   = "message"
+	chatEventCancel    = "cancel"
+	chatEventReset     = "reset"
+	chatEventDelta     = "delta"
+	chatEventDone      = "done"
+	chatEventCancelled = "cancelled"
+	chatEventError     = "error"
+)
+
+// chatEvent is a message exchanged over the chat WebSocket
+type chatEvent struct {
+	Type         string `json:"type"`
+	Text         string `json:"text,omitempty"`
+	InputTokens  int    `json:"input_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// chatMaxTokens caps the responses of free-form conversations, which run longer than the
+// short JSON answers of extraction tasks
+const chatMaxTokens = 2048
+
+// chatOptions returns the options of a free-form conversation with a model: the extraction's,
+// capped at chatMaxTokens instead of the task's output limit and without its structured output.
+// As in withModel, another model than -model drops the provisioned throughput, and the
+// thinking budget when it can't think.
+func (e *extractor) chatOptions(info models.Info) bedrock.Options {
+	opts := e.opts
+	opts.MaxTokens = chatMaxTokens
+	opts.Structured = nil
+	if info.Name != e.modelInfo.Name {
+		opts.Provisioned = nil
+		if !info.SupportsThinking {
+			opts.Thinking = nil
+		}
+	}
+	return opts
+}
+
+// chatSession is the conversation of one WebSocket connection
+type chatSession struct {
+	conn     *websocket.Conn
+	streamer bedrock.Streamer
+	// log records the session's messages, deltas and final events when -chat-log is set
+	log *chatLog
+	// id and model identify the session in the chat log
+	id    string
+	model string
+
+	mu    sync.Mutex
+	turns []bedrock.Turn
+	// cancel stops the response being generated; nil when idle
+	cancel context.CancelFunc
+}
+
+// handleChat upgrades GET /chat to a WebSocket that holds a multi-turn conversation with
+// streamed responses. The model is chosen with ?model= and defaults to -model.
+func (s *server) handleChat(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.resolveModel(r.URL.Query().Get("model"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown model %q: use %s", r.URL.Query().Get("model"), models.Usage()))
+		return
+	}
+	opts := s.extractor.chatOptions(info)
+	streamer, ok := s.extractor.newModel(info, opts).(bedrock.Streamer)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("the %s model doesn't support streaming", info.Name))
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.CloseNow()
+	s.sessions.Add(1)
+	defer s.sessions.Done()
+
+	// End the session once the server has drained its HTTP requests
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.closing:
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+		case <-ctx.Done():
+		}
+	}()
+
+	log.Printf("Chat session started with %s from %s", info.Name, r.RemoteAddr)
+	id := make([]byte, 8)
+	rand.Read(id)
+	session := &chatSession{conn: conn, streamer: streamer, log: s.chatLog, id: hex.EncodeToString(id), model: info.Name}
+	session.run(ctx)
+	log.Printf("Chat session with %s from %s ended", info.Name, r.RemoteAddr)
+}
+
+// run reads client events until the connection closes
+func (c *chatSession) run(ctx context.Context) {
+	defer c.stop()
+	for {
+		var event chatEvent
+		if err := wsjson.Read(ctx, c.conn, &event); err != nil {
+			return
+		}
+		switch event.Type {
+		case chatEventMessage:
+			if strings.TrimSpace(event.Text) == "" {
+				c.send(ctx, chatEvent{Type: chatEventError, Error: "message text is empty"})
+				continue
+			}
+			if key := requestKeyState(ctx); key != nil && !key.allow() {
+				c.send(ctx, chatEvent{Type: chatEventError, Error: "rate limit exceeded for this API key"})
+				continue
+			}
+			c.mu.Lock()
+			busy := c.cancel != nil
+			var generateCtx context.Context
+			if !busy {
+				generateCtx, c.cancel = context.WithCancel(ctx)
+			}
+			c.mu.Unlock()
+			if busy {
+				c.send(ctx, chatEvent{Type: chatEventError, Error: "a response is already being generated; send cancel first"})
+				continue
+			}
+			go c.generate(ctx, generateCtx, event.Text)
+		case chatEventCancel:
+			c.stop()
+		case chatEventReset:
+			c.stop()
+			c.mu.Lock()
+			c.turns = nil
+			c.mu.Unlock()
+			c.send(ctx, chatEvent{Type: chatEventReset})
+		default:
+			c.send(ctx, chatEvent{Type: chatEventError, Error: fmt.Sprintf("unknown event type %q", event.Type)})
+		}
+	}
+}
+
+// generate streams the model's answer to a user message. The exchange is added to the
+// conversation only when the answer completes.
+func (c *chatSession) generate(ctx, generateCtx context.Context, text string) {
+	c.mu.Lock()
+	turns := append(slices.Clone(c.turns), bedrock.Turn{Role: bedrock.RoleUser, Text: text})
+	c.mu.Unlock()
+
+	c.log.write(ctx, c, chatEvent{Type: chatEventMessage, Text: text})
+	result, err := c.streamer.Stream(generateCtx, turns, func(delta string) {
+		event := chatEvent{Type: chatEventDelta, Text: delta}
+		c.log.write(ctx, c, event)
+		c.send(ctx, event)
+	})
+	if result != nil {
+		charge(ctx, result.InputTokens, result.OutputTokens)
+	}
+
+	c.mu.Lock()
+	cancelled := generateCtx.Err() != nil
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	if err == nil && !cancelled {
+		c.turns = append(turns, bedrock.Turn{Role: bedrock.RoleAssistant, Text: result.Text})
+	}
+	c.mu.Unlock()
+
+	var event chatEvent
+	switch {
+	case cancelled:
+		event = chatEvent{Type: chatEventCancelled}
+	case err != nil:
+		event = chatEvent{Type: chatEventError, Error: err.Error()}
+	default:
+		event = chatEvent{Type: chatEventDone, Text: result.Text, InputTokens: result.InputTokens, OutputTokens: result.OutputTokens}
+	}
+	c.log.write(ctx, c, event)
+	c.send(ctx, event)
+}
+
+// stop cancels the response being generated, if any
+func (c *chatSession) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// send writes an event to the client; errors mean the connection is gone and end the session
+func (c *chatSession) send(ctx context.Context, event chatEvent) {
+	if err := wsjson.Write(ctx, c.conn, event); err != nil {
+		c.conn.CloseNow()
+	}
+}
+
+// chatLog tees the chat sessions to a JSONL file as they stream, so that interactive sessions
+// stay auditable: each user message, every raw delta sent to the client and the final done,
+// error or cancelled event, with the full text and token counts
+type chatLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// chatLogRecord is a line of the chat log: a chat event with the session it belongs to
+type chatLogRecord struct {
+	Time    string `json:"time"`
+	Session string `json:"session"`
+	Model   string `json:"model"`
+	// APIKey is the name of the -api-keys key the session authenticated with
+	APIKey string `json:"api_key,omitempty"`
+	chatEvent
+}
+
+// openChatLog opens the chat log for appending, creating it when missing
+func openChatLog(path string) (*chatLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat log: %v", err)
+	}
+	return &chatLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// write appends an event of a session; a nil log records nothing. Write failures are logged
+// rather than ending the session.
+func (l *chatLog) write(ctx context.Context, c *chatSession, event chatEvent) {
+	if l == nil {
+		return
+	}
+	record := chatLogRecord{Time: time.Now().UTC().Format(time.RFC3339Nano), Session: c.id, Model: c.model, chatEvent: event}
+	if key := requestKeyState(ctx); key != nil {
+		record.APIKey = key.name
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(record); err != nil {
+		warnf("writing the chat log: %v", err)
+	}
+}
+
+// close closes the chat log file
+func (l *chatLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Close(); err != nil {
+		warnf("closing the chat log: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestChatOptions checks that conversations keep every extraction option but the ones that
+// only make sense for the task or for -model
+func TestChatOptions(t *testing.T) {
+	temperature, topP := 0.2, 0.9
+	opts := bedrock.Options{
+		Latency:          "optimized",
+		System:           "Answer briefly.",
+		TopK:             40,
+		StopSequences:    []string{"END"},
+		Temperature:      &temperature,
+		TopP:             &topP,
+		Structured:       &bedrock.StructuredOutput{Name: "record_extraction"},
+		Provisioned:      bedrock.NewProvisioned("arn:aws:bedrock:us-east-2:123456789012:provisioned-model/abc"),
+		Guardrail:        bedrock.NewGuardrail("gr-1", "2"),
+		Thinking:         &bedrock.Thinking{BudgetTokens: 1024},
+		AnthropicBeta:    []string{"token-efficient-tools-2025-02-19"},
+		Retry:            bedrock.RetryPolicy{MaxAttempts: 5},
+		Timeout:          30 * time.Second,
+		MaxTokens:        64,
+		MaxContinuations: 2,
+	}
+	claude, _ := models.Lookup("claude")
+	nova, _ := models.Lookup("nova")
+	e := &extractor{modelInfo: claude, opts: opts}
+
+	tests := []struct {
+		name string
+		info models.Info
+		want func(opts bedrock.Options) bedrock.Options
+	}{
+		{"the configured model", claude, func(opts bedrock.Options) bedrock.Options {
+			return opts
+		}},
+		{"a model without thinking", nova, func(opts bedrock.Options) bedrock.Options {
+			opts.Provisioned, opts.Thinking = nil, nil
+			return opts
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want(opts)
+			want.MaxTokens = chatMaxTokens
+			want.Structured = nil
+			if got := e.chatOptions(tt.info); !reflect.DeepEqual(got, want) {
+				t.Errorf("chatOptions(%s) = %+v, want %+v", tt.info.Name, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// checkpoint records completed batch items in a JSONL file so that an interrupted run can be
+// resumed without invoking the model again for them
+type checkpoint struct {
+	file *os.File
+	done map[string]batchOutput
+}
+
+// openCheckpoint loads the items completed by previous runs and opens the file for appending
+func openCheckpoint(path string) (*checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %v", err)
+	}
+
+	c := &checkpoint{file: file, done: map[string]batchOutput{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var out batchOutput
+		if err := json.Unmarshal(scanner.Bytes(), &out); err != nil {
+			// A run killed mid-write leaves a truncated last line; that item is simply redone
+			warnf("Ignoring unreadable checkpoint line %d: %v", lineNumber, err)
+			continue
+		}
+		c.done[out.ID] = out
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	// Terminate a truncated last line, so that the next record doesn't get appended to it
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+		}
+		if last[0] != '\n' {
+			if _, err := file.Write([]byte{'\n'}); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("failed to write checkpoint: %v", err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// completed returns the recorded output of an item, if a previous run finished it with the same input
+func (c *checkpoint) completed(item batchItem) (batchOutput, bool) {
+	out, ok := c.done[item.ID]
+	return out, ok && out.Input == item.Input
+}
+
+// record appends a successful output to the checkpoint. Failed items aren't recorded so that
+// they are retried on the next run.
+func (c *checkpoint) record(out batchOutput) error {
+	if out.Error != "" {
+		return nil
+	}
+	line, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// close closes the checkpoint file
+func (c *checkpoint) close() error {
+	return c.file.Close()
+}
@@ -1,26 +1,77 @@
 package claude
 
 import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/extract"
+	"bedrock-llama/logging"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 )
 
-// ModelID is the AWS Bedrock Claude 3 Sonnet model ID
+// ModelID is the AWS Bedrock Claude 3.5 Sonnet v2 inference profile ARN, of DefaultVersion
 const ModelID = "arn:aws:bedrock:us-east-2:913524932967:inference-profile/us.anthropic.claude-3-5-sonnet-20241022-v2:0"
 
-// ContentItem represents a content item in the message
+// ThinkingModelID is the Claude 3.7 Sonnet inference profile that requests with extended thinking
+// are sent to, since Claude 3.5 Sonnet can't reason before it answers
+const ThinkingModelID = "arn:aws:bedrock:us-east-2:913524932967:inference-profile/us.anthropic.claude-3-7-sonnet-20250219-v1:0"
+
+// Name is the short model name used on the command line
+const Name = "claude"
+
+// SupportsLatencyOptimized reports whether the model accepts performanceConfig latency=optimized
+// (Claude 3.5 Sonnet v2 does not support latency-optimized inference)
+const SupportsLatencyOptimized = false
+
+// SupportsStructuredOutput reports whether the model can be forced to call a tool for structured output
+const SupportsStructuredOutput = true
+
+// ContextWindow is the maximum number of tokens of a prompt and its response (Claude 3.5 Sonnet)
+const ContextWindow = 200000
+
+// SupportsCountTokens reports whether Bedrock's CountTokens API counts the model's prompts
+const SupportsCountTokens = true
+
+// SupportsImages reports whether the model accepts images in its messages
+const SupportsImages = true
+
+// SupportsVideo reports whether the model accepts videos in its messages
+const SupportsVideo = false
+
+// SupportsThinking reports whether the model can reason before it answers (extended thinking)
+const SupportsThinking = true
+
+// AlwaysReasons reports whether the model reasons before every answer, without a thinking budget
+const AlwaysReasons = false
+
+// streamAnswerTokens is the cap on the answer of a stream or a tool-use conversation with
+// extended thinking and no -max-output-tokens, since max_tokens must then be set above the budget
+const streamAnswerTokens = 4096
+
+// stopMaxTokens is the stop reason of a response cut off at max_tokens
+const stopMaxTokens = "max_tokens"
+
+// continuePrompt asks Claude to carry on with a response cut off at max_tokens
+const continuePrompt = "Your response was cut off. Continue it exactly where it stopped, without repeating anything or adding any introduction."
+
+// ContentItem represents a content item in the message: text, or an image
 type ContentItem struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type   string       `json:"type"`
+	Text   string       `json:"text,omitempty"`
+	Source *ImageSource `json:"source,omitempty"`
+}
+
+// ImageSource holds the base64-encoded data of an image content item
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
 }
 
 // Message represents a message in the conversation
@@ -29,15 +80,39 @@ type Message struct {
 	Content []ContentItem `json:"content"`
 }
 
+// Tool describes a tool the model can call
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// ToolChoice controls how the model uses the provided tools
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// ThinkingConfig enables extended thinking
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
 // Payload represents the request payload for the Claude model
 type Payload struct {
-	AnthropicVersion string    `json:"anthropic_version"`
-	MaxTokens        int       `json:"max_tokens"`
-	TopK             int       `json:"top_k"`
-	StopSequences    []string  `json:"stop_sequences"`
-	Temperature      float64   `json:"temperature"`
-	TopP             float64   `json:"top_p"`
-	Messages         []Message `json:"messages"`
+	AnthropicVersion string          `json:"anthropic_version"`
+	MaxTokens        int             `json:"max_tokens"`
+	TopK             int             `json:"top_k,omitempty"`
+	StopSequences    []string        `json:"stop_sequences"`
+	Temperature      float64         `json:"temperature"`
+	TopP             float64         `json:"top_p,omitempty"`
+	System           string          `json:"system,omitempty"`
+	Messages         []Message       `json:"messages"`
+	Tools            []Tool          `json:"tools,omitempty"`
+	ToolChoice       *ToolChoice     `json:"tool_choice,omitempty"`
+	Thinking         *ThinkingConfig `json:"thinking,omitempty"`
+	AnthropicBeta    []string        `json:"anthropic_beta,omitempty"`
 }
 
 // Response represents the response from the Claude model
@@ -46,8 +121,15 @@ type Response struct {
 	Type    string `json:"type"`
 	Role    string `json:"role"`
 	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		ID    string          `json:"id,omitempty"`
+		Name  string          `json:"name,omitempty"`
+		Input json.RawMessage `json:"input,omitempty"`
+		// Thinking and Signature are set on "thinking" items, Data on "redacted_thinking" ones
+		Thinking  string `json:"thinking,omitempty"`
+		Signature string `json:"signature,omitempty"`
+		Data      string `json:"data,omitempty"`
 	} `json:"content"`
 	Model        string      `json:"model"`
 	StopReason   string      `json:"stop_reason"`
@@ -56,47 +138,59 @@ type Response struct {
 		InputTokens  int `json:"input_tokens"`
 		OutputTokens int `json:"output_tokens"`
 	} `json:"usage"`
+	// Latency is the performanceConfig latency Bedrock served, taken from the response metadata
+	Latency string `json:"-"`
+	// Guardrail is the trace of the guardrail when it intervened
+	Guardrail *bedrock.GuardrailTrace `json:"-"`
 }
 
 // InvokeModel calls the Claude model with the given prompt
-func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKey, awsRegion string) (*Response, error) {
-	// Debug output to verify prompt
-	log.Printf("Sending prompt to Claude model: %s", prompt)
-
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(awsRegion),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			accessKeyId,
-			secretAccessKey,
-			"", // Session token (empty for regular access keys)
-		)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
-	}
+func InvokeModel(ctx context.Context, client bedrock.Invoker, prompt string, opts bedrock.Options) (*Response, error) {
+	return InvokeConversation(ctx, client, bedrock.UserTurn(prompt), opts)
+}
 
-	// Create a Bedrock Runtime client
-	client := bedrockruntime.NewFromConfig(cfg)
+// InvokeConversation calls the Claude model with a multi-turn conversation
+func InvokeConversation(ctx context.Context, client bedrock.Invoker, turns []bedrock.Turn, opts bedrock.Options) (*Response, error) {
+	prompt := turns[len(turns)-1].Text
+	v := version(opts)
+
+	// Debug output to verify prompt
+	logging.Debugf("Sending prompt to Claude model: %s", prompt)
 
 	// Prepare payload according to Claude requirements
 	payload := Payload{
 		AnthropicVersion: "bedrock-2023-05-31",
-		MaxTokens:        200,
+		MaxTokens:        opts.MaxTokensOr(200),
 		TopK:             250,
-		StopSequences:    []string{},
-		Temperature:      1.0,
-		TopP:             0.999,
-		Messages: []Message{
+		StopSequences:    append([]string{}, opts.StopSequences...),
+		Temperature:      opts.TemperatureOr(1.0),
+		TopP:             opts.TopPOr(0.999),
+		System:           opts.System,
+		Messages:         messages(turns),
+		AnthropicBeta:    opts.AnthropicBeta,
+	}
+
+	// The reasoning counts against max_tokens, so the budget comes on top of the answer's
+	// tokens. Claude doesn't take top_k while it thinks.
+	if opts.Thinking != nil {
+		payload.Thinking = &ThinkingConfig{Type: "enabled", BudgetTokens: opts.Thinking.BudgetTokens}
+		payload.MaxTokens += opts.Thinking.BudgetTokens
+		payload.TopK = 0
+	}
+	if v.ExclusiveSampling {
+		payload.TopP = 0
+	}
+
+	// Force a single tool call whose input schema is the requested output shape
+	if opts.Structured != nil {
+		payload.Tools = []Tool{
 			{
-				Role: "user",
-				Content: []ContentItem{
-					{
-						Type: "text",
-						Text: prompt,
-					},
-				},
+				Name:        opts.Structured.Name,
+				Description: opts.Structured.Description,
+				InputSchema: opts.Structured.Schema,
 			},
-		},
+		}
+		payload.ToolChoice = &ToolChoice{Type: "tool", Name: opts.Structured.Name}
 	}
 
 	payloadBytes, err := json.Marshal(payload)
@@ -105,24 +199,25 @@ func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKe
 	}
 
 	// Debug: Log the payload being sent to the model
-	log.Printf("Claude payload: %s", string(payloadBytes))
+	logging.Debugf("Claude payload: %s", string(payloadBytes))
 
 	// Create the input for the InvokeModel operation
 	input := &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(ModelID),
-		ContentType: aws.String("application/json"),
-		Accept:      aws.String("application/json"),
-		Body:        payloadBytes,
+		ModelId:                  aws.String(v.ModelID),
+		ContentType:              aws.String("application/json"),
+		Accept:                   aws.String("application/json"),
+		Body:                     payloadBytes,
+		PerformanceConfigLatency: opts.PerformanceLatency("Claude", SupportsLatencyOptimized),
 	}
 
 	// Invoke the model
-	output, err := client.InvokeModel(ctx, input)
+	output, err := bedrock.Invoke(ctx, client, input, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error invoking Bedrock Claude model: %v", err)
+		return nil, fmt.Errorf("error invoking Bedrock Claude model: %w", err)
 	}
 
 	// Debug: Log the raw response
-	log.Printf("Raw Claude response: %s", string(output.Body))
+	logging.Debugf("Raw Claude response: %s", string(output.Body))
 
 	var response Response
 	if err := json.Unmarshal(output.Body, &response); err != nil {
@@ -131,34 +226,222 @@ func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKe
 
 	// Debug: Log the parsed response structure
 	responseBytes, _ := json.MarshalIndent(response, "", "  ")
-	log.Printf("Parsed Claude response: %s", string(responseBytes))
+	logging.Debugf("Parsed Claude response: %s", string(responseBytes))
+
+	if bedrock.GuardrailIntervened(output.Body) {
+		response.StopReason = bedrock.StopGuardrailIntervened
+		response.Guardrail = bedrock.ParseGuardrailTrace(output.Body)
+	}
+	response.Latency = string(output.PerformanceConfigLatency)
+	opts.LogLatency(response.Latency)
 
 	return &response, nil
 }
 
+// messages converts conversation turns into Claude messages. Images come before the text,
+// which is where Claude reads them best.
+func messages(turns []bedrock.Turn) []Message {
+	messages := make([]Message, 0, len(turns))
+	for _, turn := range turns {
+		var content []ContentItem
+		for _, image := range turn.Images {
+			content = append(content, ContentItem{Type: "image", Source: &ImageSource{
+				Type:      "base64",
+				MediaType: image.MediaType(),
+				Data:      base64.StdEncoding.EncodeToString(image.Data),
+			}})
+		}
+		messages = append(messages, Message{
+			Role:    turn.Role,
+			Content: append(content, ContentItem{Type: "text", Text: turn.Text}),
+		})
+	}
+	return messages
+}
+
+// maxTokens returns the output token cap of a Converse request: the configured cap, with
+// room for the reasoning when thinking is on
+func maxTokens(opts bedrock.Options) int {
+	if opts.Thinking == nil {
+		return opts.MaxTokens
+	}
+	return opts.MaxTokensOr(streamAnswerTokens) + opts.Thinking.BudgetTokens
+}
+
+// Text returns the generated text from the Claude response. With extended thinking the
+// answer comes after the thinking items, and may be split around redacted ones.
+func (r *Response) Text() string {
+	var text strings.Builder
+	for _, item := range r.Content {
+		if item.Type == "text" {
+			text.WriteString(item.Text)
+		}
+	}
+	return text.String()
+}
+
+// Thinking returns the reasoning Claude did before answering. Redacted reasoning is
+// encrypted and left out.
+func (r *Response) Thinking() string {
+	var parts []string
+	for _, item := range r.Content {
+		if item.Type == "thinking" {
+			parts = append(parts, item.Thinking)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// ToolInput returns the input of the first tool call in the Claude response, or nil if there is none
+func (r *Response) ToolInput() json.RawMessage {
+	for _, item := range r.Content {
+		if item.Type == "tool_use" {
+			return item.Input
+		}
+	}
+	return nil
+}
+
+type model struct {
+	client bedrock.Runtime
+	opts   bedrock.Options
+}
+
+// New returns a bedrock.Model that invokes Claude through the given client
+func New(client bedrock.Runtime, opts bedrock.Options) bedrock.Model {
+	return &model{client: client, opts: opts}
+}
+
+func (m *model) Name() string {
+	return Name
+}
+
+func (m *model) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+// Chat sends the conversation to Claude. A response cut off at the output cap is continued
+// in follow-up requests, up to Options.MaxContinuations of them, and the parts are joined.
+// Structured output isn't continued, since a half-written tool call can't be resumed.
+func (m *model) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	result, err := m.chat(ctx, turns)
+	for err == nil && result.StopReason == stopMaxTokens && m.opts.Structured == nil && result.Text != "" {
+		if result.Continuations >= m.opts.MaxContinuations {
+			if m.opts.MaxContinuations > 0 {
+				logging.Warn(fmt.Sprintf("Warning: Claude's response is still cut off at the output cap after continuing it %d times", result.Continuations))
+			}
+			break
+		}
+		log.Printf("Claude's response was cut off at the output cap, asking it to continue (%d/%d)", result.Continuations+1, m.opts.MaxContinuations)
+		continued := append(turns[:len(turns):len(turns)],
+			bedrock.Turn{Role: bedrock.RoleAssistant, Text: result.Text},
+			bedrock.Turn{Role: bedrock.RoleUser, Text: continuePrompt},
+		)
+		var next *bedrock.Result
+		next, err = m.chat(ctx, continued)
+		if next == nil {
+			return nil, err
+		}
+		next.Text = result.Text + next.Text
+		next.Thinking = strings.TrimSpace(result.Thinking + "\n\n" + next.Thinking)
+		next.InputTokens += result.InputTokens
+		next.OutputTokens += result.OutputTokens
+		next.Continuations = result.Continuations + 1
+		result = next
+	}
+	return result, err
+}
+
+// chat sends a single request with the conversation
+func (m *model) chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	// The native request body can't carry documents, the Converse API can
+	if bedrock.HasDocuments(turns) {
+		return m.Stream(ctx, turns, func(string) {})
+	}
+	response, err := InvokeConversation(ctx, m.client, turns, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	text := response.Text()
+	if input := response.ToolInput(); input != nil {
+		text = string(input)
+	}
+	result := &bedrock.Result{
+		Model:        Name,
+		ModelVersion: version(m.opts).Name,
+		Text:         text,
+		InputTokens:  response.Usage.InputTokens,
+		OutputTokens: response.Usage.OutputTokens,
+		Thinking:     response.Thinking(),
+		Latency:      response.Latency,
+		Guardrail:    response.Guardrail,
+		StopReason:   response.StopReason,
+	}
+	// A filtered response is returned with the error, so its tokens are still accounted for
+	return result, bedrock.CheckStopReason(result)
+}
+
+// Stream streams the conversation through the Converse API
+func (m *model) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	return m.versioned(bedrock.ConverseStream(ctx, m.client, m.streamRequest(), turns, onText))
+}
+
+// UseTools runs a tool-use conversation through the Converse API
+func (m *model) UseTools(ctx context.Context, turns []bedrock.Turn, tools []bedrock.Tool, onCall func(call bedrock.ToolCall)) (*bedrock.Result, error) {
+	return m.versioned(bedrock.ConverseTools(ctx, m.client, m.streamRequest(), turns, tools, m.opts.MaxToolRounds, onCall))
+}
+
+// streamRequest returns the Converse request of the model's options. The versions that reject
+// temperature and top_p together only get the temperature, as with the native API.
+func (m *model) streamRequest() bedrock.StreamRequest {
+	v := version(m.opts)
+	req := bedrock.StreamRequest{
+		Model:         Name,
+		ModelID:       v.ModelID,
+		Latency:       m.opts.PerformanceLatency(Name, SupportsLatencyOptimized),
+		Retry:         m.opts.Retry,
+		Timeout:       m.opts.Timeout,
+		MaxTokens:     maxTokens(m.opts),
+		System:        m.opts.System,
+		StopSequences: m.opts.StopSequences,
+		Temperature:   m.opts.Temperature,
+		TopP:          m.opts.TopP,
+		Guardrail:     m.opts.Guardrail,
+		Thinking:      m.opts.Thinking,
+		AnthropicBeta: m.opts.AnthropicBeta,
+	}
+	if v.ExclusiveSampling && req.Temperature != nil {
+		req.TopP = nil
+	}
+	return req
+}
+
+// versioned records the version that answered in a Converse API result
+func (m *model) versioned(result *bedrock.Result, err error) (*bedrock.Result, error) {
+	if result != nil {
+		result.ModelVersion = version(m.opts).Name
+	}
+	return result, err
+}
+
 // PrintResponse formats and prints the Claude model response
 func PrintResponse(response *Response) {
 	var output string
-	if len(response.Content) > 0 {
-		output = response.Content[0].Text
+	if input := response.ToolInput(); input != nil {
+		// Structured output arrives as the tool input rather than text
+		output = string(input)
+	} else if len(response.Content) > 0 {
+		output = response.Text()
 	} else {
 		log.Println("No response content received from Claude model")
 		return
 	}
 
-	// Try to find the JSON array pattern and extract it
-	jsonPattern := regexp.MustCompile(`\[\s*{\s*"series"\s*:\s*"([^"]*)"\s*}\s*\]`)
-	if match := jsonPattern.FindStringSubmatch(output); len(match) > 1 {
-		fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
+	if series, err := extract.Series(output); err == nil {
+		fmt.Println(extract.FormatSeries(series))
 	} else {
-		// Try a fallback approach to extract just the series name
-		seriesPattern := regexp.MustCompile(`"series"\s*:\s*"([^"]*)"`)
-		if match := seriesPattern.FindStringSubmatch(output); len(match) > 1 {
-			fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
-		} else {
-			// Last resort: try to clean any extra text and print the response
-			fmt.Println(strings.TrimSpace(output))
-		}
+		// Last resort: print the cleaned response
+		fmt.Println(strings.TrimSpace(output))
 	}
 
 	// Print token usage information if available (as a log message to not interfere with JSON output)
@@ -0,0 +1,234 @@
+package claude
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/bedrock/bedrocktest"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// answer is a canned response body of a plain text answer
+const answer = `{"id": "msg_1", "type": "message", "role": "assistant",
+	"content": [{"type": "text", "text": "[{\"series\": \"Friends\"}]"}],
+	"model": "claude-3-5-sonnet", "stop_reason": "end_turn",
+	"usage": {"input_tokens": 118, "output_tokens": 11}}`
+
+// field is the expected value at a path of the marshalled payload; nil when it must be absent
+type field struct {
+	path []any
+	want any
+}
+
+func TestInvokeConversationPayload(t *testing.T) {
+	turns := []bedrock.Turn{{Role: bedrock.RoleUser, Text: "Friends Season 1 Episode 3"}}
+	tests := []struct {
+		name    string
+		turns   []bedrock.Turn
+		opts    bedrock.Options
+		modelID string
+		fields  []field
+	}{
+		{
+			name:    "defaults",
+			turns:   turns,
+			modelID: ModelID,
+			fields: []field{
+				{[]any{"anthropic_version"}, "bedrock-2023-05-31"},
+				{[]any{"max_tokens"}, 200.0},
+				{[]any{"top_k"}, 250.0},
+				{[]any{"temperature"}, 1.0},
+				{[]any{"top_p"}, 0.999},
+				{[]any{"system"}, nil},
+				{[]any{"messages", 0, "role"}, "user"},
+				{[]any{"messages", 0, "content", 0, "type"}, "text"},
+				{[]any{"messages", 0, "content", 0, "text"}, "Friends Season 1 Episode 3"},
+				{[]any{"thinking"}, nil},
+				{[]any{"tools"}, nil},
+			},
+		},
+		{
+			name:    "output cap and system prompt",
+			turns:   turns,
+			opts:    bedrock.Options{MaxTokens: 64, System: "Answer in JSON.", AnthropicBeta: []string{"token-efficient-tools-2025-02-19"}},
+			modelID: ModelID,
+			fields: []field{
+				{[]any{"max_tokens"}, 64.0},
+				{[]any{"system"}, "Answer in JSON."},
+				{[]any{"anthropic_beta", 0}, "token-efficient-tools-2025-02-19"},
+			},
+		},
+		{
+			name:    "thinking budget on top of the cap",
+			turns:   turns,
+			opts:    bedrock.Options{MaxTokens: 64, Thinking: &bedrock.Thinking{BudgetTokens: 2048}},
+			modelID: ThinkingModelID,
+			fields: []field{
+				{[]any{"max_tokens"}, 2112.0},
+				{[]any{"thinking", "type"}, "enabled"},
+				{[]any{"thinking", "budget_tokens"}, 2048.0},
+				{[]any{"top_k"}, nil},
+			},
+		},
+		{
+			name:    "version without top_p next to the temperature",
+			turns:   turns,
+			opts:    bedrock.Options{ModelVersion: "opus-4.1"},
+			modelID: profileARN + "us.anthropic.claude-opus-4-1-20250805-v1:0",
+			fields: []field{
+				{[]any{"temperature"}, 1.0},
+				{[]any{"top_p"}, nil},
+			},
+		},
+		{
+			name:  "structured output forces the tool",
+			turns: turns,
+			opts: bedrock.Options{Structured: &bedrock.StructuredOutput{
+				Name:   "record_series",
+				Schema: json.RawMessage(`{"type": "object"}`),
+			}},
+			modelID: ModelID,
+			fields: []field{
+				{[]any{"tools", 0, "name"}, "record_series"},
+				{[]any{"tools", 0, "input_schema", "type"}, "object"},
+				{[]any{"tool_choice", "type"}, "tool"},
+				{[]any{"tool_choice", "name"}, "record_series"},
+			},
+		},
+		{
+			name: "conversation with an image",
+			turns: []bedrock.Turn{
+				{Role: bedrock.RoleUser, Text: "Which series?", Images: []bedrock.Image{{Format: "png", Data: []byte("png")}}},
+				{Role: bedrock.RoleAssistant, Text: "Friends"},
+				{Role: bedrock.RoleUser, Text: "As JSON"},
+			},
+			modelID: ModelID,
+			fields: []field{
+				{[]any{"messages", 0, "content", 0, "type"}, "image"},
+				{[]any{"messages", 0, "content", 0, "source", "media_type"}, "image/png"},
+				{[]any{"messages", 0, "content", 0, "source", "data"}, "cG5n"},
+				{[]any{"messages", 0, "content", 1, "text"}, "Which series?"},
+				{[]any{"messages", 1, "role"}, "assistant"},
+				{[]any{"messages", 2, "content", 0, "text"}, "As JSON"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: answer}
+			if _, err := InvokeConversation(context.Background(), client, tt.turns, tt.opts); err != nil {
+				t.Fatalf("InvokeConversation: %v", err)
+			}
+			if got := client.ModelID(t); got != tt.modelID {
+				t.Errorf("model ID = %q, want %q", got, tt.modelID)
+			}
+			payload := client.Payload(t)
+			for _, f := range tt.fields {
+				if got := bedrocktest.Field(payload, f.path...); !reflect.DeepEqual(got, f.want) {
+					t.Errorf("%v = %#v, want %#v", f.path, got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvokeConversationResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		text         string
+		thinking     string
+		toolInput    string
+		stopReason   string
+		inputTokens  int
+		outputTokens int
+	}{
+		{
+			name:         "text",
+			body:         answer,
+			text:         `[{"series": "Friends"}]`,
+			stopReason:   "end_turn",
+			inputTokens:  118,
+			outputTokens: 11,
+		},
+		{
+			name: "thinking before the answer",
+			body: `{"content": [
+				{"type": "thinking", "thinking": "The title is Friends.", "signature": "sig"},
+				{"type": "redacted_thinking", "data": "secret"},
+				{"type": "text", "text": "[{\"series\": "},
+				{"type": "text", "text": "\"Friends\"}]"}],
+				"stop_reason": "end_turn", "usage": {"input_tokens": 120, "output_tokens": 40}}`,
+			text:         `[{"series": "Friends"}]`,
+			thinking:     "The title is Friends.",
+			stopReason:   "end_turn",
+			inputTokens:  120,
+			outputTokens: 40,
+		},
+		{
+			name: "tool call",
+			body: `{"content": [{"type": "tool_use", "id": "tool_1", "name": "record_series", "input": {"series": "Friends"}}],
+				"stop_reason": "tool_use", "usage": {"input_tokens": 300, "output_tokens": 20}}`,
+			toolInput:    `{"series": "Friends"}`,
+			stopReason:   "tool_use",
+			inputTokens:  300,
+			outputTokens: 20,
+		},
+		{
+			name: "guardrail intervention",
+			body: `{"content": [{"type": "text", "text": "Sorry, I can't help with that."}],
+				"stop_reason": "end_turn", "usage": {"input_tokens": 10, "output_tokens": 8},
+				"amazon-bedrock-guardrailAction": "INTERVENED"}`,
+			text:         "Sorry, I can't help with that.",
+			stopReason:   bedrock.StopGuardrailIntervened,
+			inputTokens:  10,
+			outputTokens: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: tt.body}
+			response, err := InvokeModel(context.Background(), client, "Friends Season 1 Episode 3", bedrock.Options{})
+			if err != nil {
+				t.Fatalf("InvokeModel: %v", err)
+			}
+			if got := response.Text(); got != tt.text {
+				t.Errorf("Text() = %q, want %q", got, tt.text)
+			}
+			if got := response.Thinking(); got != tt.thinking {
+				t.Errorf("Thinking() = %q, want %q", got, tt.thinking)
+			}
+			if got := string(response.ToolInput()); got != tt.toolInput {
+				t.Errorf("ToolInput() = %s, want %s", got, tt.toolInput)
+			}
+			if response.StopReason != tt.stopReason {
+				t.Errorf("StopReason = %q, want %q", response.StopReason, tt.stopReason)
+			}
+			if response.Usage.InputTokens != tt.inputTokens || response.Usage.OutputTokens != tt.outputTokens {
+				t.Errorf("usage = %d/%d tokens, want %d/%d", response.Usage.InputTokens, response.Usage.OutputTokens, tt.inputTokens, tt.outputTokens)
+			}
+		})
+	}
+}
+
+func TestInvokeConversationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *bedrocktest.Invoker
+		want   string
+	}{
+		{"request fails", &bedrocktest.Invoker{Err: errors.New("connection refused")}, "error invoking Bedrock Claude model"},
+		{"invalid body", &bedrocktest.Invoker{Body: "not json"}, "failed to unmarshal Claude response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := InvokeModel(context.Background(), tt.client, "Friends", bedrock.Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package claude
+
+import (
+	"bedrock-llama/bedrock"
+	"strings"
+)
+
+// profileARN is the prefix of the cross-region inference profiles the Claude versions are invoked through
+const profileARN = "arn:aws:bedrock:us-east-2:913524932967:inference-profile/"
+
+// DefaultVersion is the version invoked when -model-version doesn't pin one
+const DefaultVersion = "3.5-sonnet-v2"
+
+// thinkingVersion is the version requests with extended thinking go to when no version is pinned
+const thinkingVersion = "3.7-sonnet"
+
+// Version is a Claude model version that can be pinned with -model-version. The versions
+// differ in what they accept, so the payload is adjusted to the version it goes to.
+type Version struct {
+	// Name is the version's name on the command line, e.g. "sonnet-4"
+	Name string
+	// DisplayName is the human-readable version name
+	DisplayName string
+	// ModelID is the inference profile ARN of the version
+	ModelID string
+	// SupportsThinking reports whether the version can reason before it answers
+	SupportsThinking bool
+	// ExclusiveSampling is set for the versions that reject temperature and top_p in the same
+	// request; only the temperature is sent to them
+	ExclusiveSampling bool
+}
+
+// versions lists the Claude versions that can be pinned, oldest first
+var versions = []Version{
+	{DefaultVersion, "Claude 3.5 Sonnet v2", ModelID, false, false},
+	{thinkingVersion, "Claude 3.7 Sonnet", ThinkingModelID, true, false},
+	{"sonnet-4", "Claude Sonnet 4", profileARN + "us.anthropic.claude-sonnet-4-20250514-v1:0", true, false},
+	{"opus-4", "Claude Opus 4", profileARN + "us.anthropic.claude-opus-4-20250514-v1:0", true, false},
+	{"opus-4.1", "Claude Opus 4.1", profileARN + "us.anthropic.claude-opus-4-1-20250805-v1:0", true, true},
+	{"sonnet-4.5", "Claude Sonnet 4.5", profileARN + "us.anthropic.claude-sonnet-4-5-20250929-v1:0", true, true},
+}
+
+// LookupVersion returns the version registered under the given name
+func LookupVersion(name string) (Version, bool) {
+	for _, version := range versions {
+		if version.Name == strings.ToLower(name) {
+			return version, true
+		}
+	}
+	return Version{}, false
+}
+
+// VersionNames returns the names of the versions that can be pinned, oldest first
+func VersionNames() []string {
+	names := make([]string, len(versions))
+	for i, version := range versions {
+		names[i] = version.Name
+	}
+	return names
+}
+
+// version returns the version that handles a request: the pinned one, or Claude 3.7 Sonnet
+// when thinking is on and 3.5 Sonnet v2 otherwise
+func version(opts bedrock.Options) Version {
+	name := opts.ModelVersion
+	if name == "" {
+		name = DefaultVersion
+		if opts.Thinking != nil {
+			name = thinkingVersion
+		}
+	}
+	if v, ok := LookupVersion(name); ok {
+		return v
+	}
+	return versions[0]
+}
@@ -0,0 +1,168 @@
+package cohere
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// Cohere Embed v3
+const (
+	// Name is the short name of Cohere Embed English v3 used on the command line
+	Name = "cohere"
+	// ModelID is the Bedrock model ID of Cohere Embed English v3
+	ModelID = "cohere.embed-english-v3"
+	// MultilingualName is the short name of Cohere Embed Multilingual v3 used on the command line
+	MultilingualName = "cohere-multilingual"
+	// MultilingualModelID is the Bedrock model ID of Cohere Embed Multilingual v3
+	MultilingualModelID = "cohere.embed-multilingual-v3"
+	// maxTexts is the largest number of texts Cohere Embed takes in one request
+	maxTexts = 96
+)
+
+// InputTypes are the kinds of text Cohere Embed is told it embeds. Documents and the queries
+// searching them are embedded differently.
+var InputTypes = []string{"search_document", "search_query", "classification", "clustering"}
+
+// EmbedPayload represents the request payload for Cohere Embed
+type EmbedPayload struct {
+	Texts     []string `json:"texts"`
+	InputType string   `json:"input_type"`
+}
+
+// EmbedResponse represents the response from Cohere Embed
+type EmbedResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+// invoke sends a payload to a Cohere model and unmarshals its response
+func invoke(ctx context.Context, client bedrock.Invoker, modelID string, payload, response any, opts bedrock.Options) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %v", err)
+	}
+	logging.Debugf("Payload: %s", string(payloadBytes))
+
+	output, err := bedrock.Invoke(ctx, client, &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(modelID),
+		ContentType: aws.String("application/json"),
+		Accept:      aws.String("application/json"),
+		Body:        payloadBytes,
+	}, opts)
+	if err != nil {
+		return fmt.Errorf("error invoking Bedrock model %s: %w", modelID, err)
+	}
+	if err := json.Unmarshal(output.Body, response); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return nil
+}
+
+type model struct {
+	client  bedrock.Invoker
+	opts    bedrock.Options
+	name    string
+	modelID string
+}
+
+// New returns a bedrock.EmbeddingModel that embeds text with Cohere Embed English v3
+func New(client bedrock.Invoker, opts bedrock.Options) bedrock.EmbeddingModel {
+	return &model{client: client, opts: opts, name: Name, modelID: ModelID}
+}
+
+// NewMultilingual returns a bedrock.EmbeddingModel that embeds text with Cohere Embed
+// Multilingual v3
+func NewMultilingual(client bedrock.Invoker, opts bedrock.Options) bedrock.EmbeddingModel {
+	return &model{client: client, opts: opts, name: MultilingualName, modelID: MultilingualModelID}
+}
+
+func (m *model) Name() string {
+	return m.name
+}
+
+// Embed sends the texts in requests of up to 96. Cohere Embed v3 vectors always have 1024
+// dimensions and unit length, so Normalize has no effect.
+func (m *model) Embed(ctx context.Context, req bedrock.EmbeddingRequest) (*bedrock.EmbeddingResult, error) {
+	if req.Dimensions != 0 && req.Dimensions != 1024 {
+		return nil, fmt.Errorf("the %s model only makes vectors of 1024 dimensions", m.name)
+	}
+	inputType := req.InputType
+	if inputType == "" {
+		inputType = InputTypes[0]
+	} else if !slices.Contains(InputTypes, inputType) {
+		return nil, fmt.Errorf("invalid input type %q for the %s model; use one of %v", inputType, m.name, InputTypes)
+	}
+
+	result := &bedrock.EmbeddingResult{Model: m.name}
+	for texts := range slices.Chunk(req.Texts, maxTexts) {
+		var response EmbedResponse
+		if err := invoke(ctx, m.client, m.modelID, EmbedPayload{Texts: texts, InputType: inputType}, &response, m.opts); err != nil {
+			return nil, err
+		}
+		if len(response.Embeddings) != len(texts) {
+			return nil, fmt.Errorf("the model returned %d vectors for %d texts", len(response.Embeddings), len(texts))
+		}
+		result.Embeddings = append(result.Embeddings, response.Embeddings...)
+	}
+	return result, nil
+}
+
+// Cohere Rerank 3.5
+const (
+	// RerankModelID is the Bedrock model ID; Cohere Rerank runs in us-west-2, ca-central-1,
+	// eu-central-1 and ap-northeast-1
+	RerankModelID = "cohere.rerank-v3-5:0"
+	// MaxDocuments is the largest number of documents Cohere Rerank takes in one request
+	MaxDocuments = 1000
+)
+
+// RerankPayload represents the request payload for Cohere Rerank
+type RerankPayload struct {
+	Query      string   `json:"query"`
+	Documents  []string `json:"documents"`
+	TopN       int      `json:"top_n,omitempty"`
+	APIVersion int      `json:"api_version"`
+}
+
+// Ranking is the relevance of one document to the query
+type Ranking struct {
+	// Index is the position of the document in the request
+	Index int     `json:"index"`
+	Score float64 `json:"relevance_score"`
+}
+
+// RerankResponse represents the response from Cohere Rerank
+type RerankResponse struct {
+	// Results are ordered from the most to the least relevant document
+	Results []Ranking `json:"results"`
+}
+
+// Rerank orders documents by their relevance to a query, most relevant first. topN limits the
+// number of rankings returned; 0 returns all of them.
+func Rerank(ctx context.Context, client bedrock.Invoker, query string, documents []string, topN int, opts bedrock.Options) ([]Ranking, error) {
+	if len(documents) == 0 || len(documents) > MaxDocuments {
+		return nil, fmt.Errorf("rerank takes 1 to %d documents, not %d", MaxDocuments, len(documents))
+	}
+	var response RerankResponse
+	err := invoke(ctx, client, RerankModelID, RerankPayload{
+		Query:      query,
+		Documents:  documents,
+		TopN:       topN,
+		APIVersion: 2,
+	}, &response, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, ranking := range response.Results {
+		if ranking.Index < 0 || ranking.Index >= len(documents) {
+			return nil, fmt.Errorf("the model ranked document %d of %d", ranking.Index, len(documents))
+		}
+	}
+	return response.Results, nil
+}
@@ -0,0 +1,62 @@
+package color
+
+import (
+	"os"
+)
+
+// ANSI escape sequences
+const (
+	reset  = "\033[0m"
+	bold   = "\033[1m"
+	red    = "\033[31m"
+	yellow = "\033[33m"
+	cyan   = "\033[36m"
+)
+
+// Palette colors text for a single output stream, or leaves it untouched when colors are disabled
+type Palette struct {
+	enabled bool
+}
+
+// New returns a palette for the given stream. Colors are enabled only when the stream is a
+// terminal, NO_COLOR is unset (https://no-color.org) and TERM isn't "dumb".
+func New(f *os.File) *Palette {
+	return &Palette{enabled: Enabled(f)}
+}
+
+// Enabled reports whether colored output should be written to the given stream
+func Enabled(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Red colors errors
+func (p *Palette) Red(text string) string {
+	return p.wrap(red, text)
+}
+
+// Yellow colors warnings and low-confidence results
+func (p *Palette) Yellow(text string) string {
+	return p.wrap(yellow, text)
+}
+
+// Highlight emphasizes names such as the model being invoked
+func (p *Palette) Highlight(text string) string {
+	return p.wrap(bold+cyan, text)
+}
+
+func (p *Palette) wrap(code, text string) string {
+	if !p.enabled || text == "" {
+		return text
+	}
+	return code + text + reset
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/history"
+	"bedrock-llama/s3io"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// compactedRun is the run of the usage entries merged by compact, which no longer belong to one
+const compactedRun = "compacted"
+
+// runCompact applies the retention policy to the local stores: the inputs, prompts and outputs
+// of the history and the audit log are dropped after -prompt-retention-days, their entries after
+// -retention-days, and the usage of old days is merged into one entry per day, task and model.
+// The token and cost totals of the usage store are kept forever.
+func runCompact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	historyFlag := fs.String("history", defaultHistoryFile(), "History database to compact; defaults to $"+historyFileEnv)
+	auditFlag := fs.String("audit", "", "Audit log file to compact; an S3 audit log expires by the bucket's lifecycle rules instead")
+	usageFlag := fs.String("usage-file", defaultUsageFile(), "Usage store to compact; defaults to $"+usageFileEnv)
+	promptDaysFlag := fs.Int("prompt-retention-days", 30, "Days the inputs, prompts and outputs are kept in the history and the audit log, and the usage of each run (0 keeps them forever)")
+	retentionDaysFlag := fs.Int("retention-days", 0, "Days the history entries and audit records are kept at all (0 keeps them forever)")
+	dryRunFlag := fs.Bool("dry-run", false, "Report what would be compacted without changing the stores")
+	fs.Parse(args)
+
+	if *promptDaysFlag < 0 || *retentionDaysFlag < 0 {
+		fatalf("-prompt-retention-days and -retention-days can't be negative")
+	}
+	if *historyFlag == "" && *auditFlag == "" && *usageFlag == "" {
+		fatalf("Nothing to compact: set -history, -audit or -usage-file")
+	}
+	if _, isS3, err := s3io.Parse(*auditFlag); *auditFlag != "" && (err != nil || isS3) {
+		fatalf("-audit must be a local file; an S3 audit log expires by the bucket's lifecycle rules")
+	}
+	now := time.Now()
+	var stripBefore, deleteBefore time.Time
+	if *promptDaysFlag > 0 {
+		stripBefore = now.AddDate(0, 0, -*promptDaysFlag)
+	}
+	if *retentionDaysFlag > 0 {
+		deleteBefore = now.AddDate(0, 0, -*retentionDaysFlag)
+	}
+	verb := "Compacted"
+	if *dryRunFlag {
+		verb = "Would compact"
+	}
+
+	if *historyFlag != "" {
+		if _, err := os.Stat(*historyFlag); err == nil {
+			store, err := history.Open(*historyFlag)
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+			stripped, deleted, err := store.Compact(context.Background(), stripBefore, deleteBefore, *dryRunFlag)
+			store.Close()
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+			fmt.Printf("%s the history %s: %d entries cleared of their input and output, %d deleted\n", verb, *historyFlag, stripped, deleted)
+		}
+	}
+	if *auditFlag != "" {
+		stripped, deleted, err := compactAuditLog(*auditFlag, stripBefore, deleteBefore, *dryRunFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Printf("%s the audit log %s: %d records cleared of their prompt and output, %d deleted\n", verb, *auditFlag, stripped, deleted)
+	}
+	if *usageFlag != "" && !stripBefore.IsZero() {
+		before, after, err := compactUsage(*usageFlag, stripBefore.Format(dayLayout), *dryRunFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Printf("%s the usage store %s: %d entries merged into %d\n", verb, *usageFlag, before, after)
+	}
+}
+
+// compactAuditLog rewrites a local audit log without the prompts and outputs of the records
+// before stripBefore and without the records before deleteBefore
+func compactAuditLog(path string, stripBefore, deleteBefore time.Time, dryRun bool) (stripped, deleted int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open the audit log: %v", err)
+	}
+	defer file.Close()
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record auditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return 0, 0, fmt.Errorf("%s line %d: invalid JSON: %v", path, lineNumber, err)
+		}
+		switch {
+		case !deleteBefore.IsZero() && record.Time.Before(deleteBefore):
+			deleted++
+			continue
+		case !stripBefore.IsZero() && record.Time.Before(stripBefore) && stripAuditRecord(&record):
+			stripped++
+			if line, err = json.Marshal(record); err != nil {
+				return 0, 0, fmt.Errorf("failed to marshal audit record: %v", err)
+			}
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read the audit log: %v", err)
+	}
+	if dryRun || (stripped == 0 && deleted == 0) {
+		return stripped, deleted, nil
+	}
+	return stripped, deleted, replaceFile(path, out.Bytes())
+}
+
+// stripAuditRecord drops the prompt and output of a record, and the text its guardrail trace
+// quotes from them, keeping the hash of the prompt; it reports whether there was any
+func stripAuditRecord(record *auditRecord) bool {
+	changed := record.Prompt != "" || record.Output != ""
+	record.Prompt, record.Output, record.Truncated = "", "", false
+	if trace := record.Guardrail; trace != nil {
+		changed = changed || len(trace.ModelOutput) > 0
+		trace.ModelOutput = nil
+		for _, findings := range [][]bedrock.GuardrailFinding{trace.Input, trace.Output} {
+			for i := range findings {
+				changed = changed || findings[i].Match != ""
+				findings[i].Match = ""
+			}
+		}
+	}
+	return changed
+}
+
+// compactUsage merges the usage entries of the days before a day into one entry per day, task
+// and model, keeping their totals
+func compactUsage(path, before string, dryRun bool) (entries, merged int, err error) {
+	all, err := readUsage(path)
+	if err != nil || len(all) == 0 {
+		return 0, 0, err
+	}
+	var kept []usageEntry
+	groups := map[[3]string]*usageEntry{}
+	for _, entry := range all {
+		if entry.Day >= before {
+			kept = append(kept, entry)
+			continue
+		}
+		entries++
+		key := [3]string{entry.Day, entry.Task, entry.Model}
+		group, ok := groups[key]
+		if !ok {
+			group = &usageEntry{Day: entry.Day, Run: compactedRun, Task: entry.Task, Model: entry.Model}
+			groups[key] = group
+		}
+		group.Calls += entry.Calls
+		group.Failures += entry.Failures
+		group.InputTokens += entry.InputTokens
+		group.OutputTokens += entry.OutputTokens
+		if entry.RecordedAt.After(group.RecordedAt) {
+			group.RecordedAt = entry.RecordedAt
+		}
+	}
+	if dryRun || entries == len(groups) {
+		return entries, len(groups), nil
+	}
+
+	compacted := make([]usageEntry, 0, len(groups)+len(kept))
+	for _, group := range groups {
+		compacted = append(compacted, *group)
+	}
+	sort.Slice(compacted, func(i, j int) bool {
+		a, b := compacted[i], compacted[j]
+		return strings.Join([]string{a.Day, a.Task, a.Model}, "\x00") < strings.Join([]string{b.Day, b.Task, b.Model}, "\x00")
+	})
+	var out bytes.Buffer
+	for _, entry := range append(compacted, kept...) {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to marshal usage: %v", err)
+		}
+		out.Write(append(data, '\n'))
+	}
+	return entries, len(groups), replaceFile(path, out.Bytes())
+}
+
+// replaceFile replaces a file with data through a temporary file in its directory, so that a
+// failure leaves the original intact
+func replaceFile(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to compact %s: %v", path, err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to compact %s: %v", path, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to compact %s: %v", path, err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to compact %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// deduper invokes the model once per distinct input and fans the result out to every batch
+// item with the same input
+type deduper struct {
+	mu    sync.Mutex
+	calls map[string]*dedupeCall
+
+	saved       int
+	savedInput  int
+	savedOutput int
+}
+
+// dedupeCall is the extraction of one distinct input, shared by its duplicates
+type dedupeCall struct {
+	done chan struct{}
+	out  batchOutput
+}
+
+// newDeduper creates a deduper with no recorded inputs
+func newDeduper() *deduper {
+	return &deduper{calls: map[string]*dedupeCall{}}
+}
+
+// wrap returns a run function that reuses the output of the first item with the same input
+func (d *deduper) wrap(run func(context.Context, batchItem) batchOutput) func(context.Context, batchItem) batchOutput {
+	return func(ctx context.Context, item batchItem) batchOutput {
+		d.mu.Lock()
+		call, seen := d.calls[item.Input]
+		if !seen {
+			call = &dedupeCall{done: make(chan struct{})}
+			d.calls[item.Input] = call
+		}
+		d.mu.Unlock()
+
+		if !seen {
+			call.out = run(ctx, item)
+			close(call.done)
+			return call.out
+		}
+
+		<-call.done
+		d.mu.Lock()
+		d.saved++
+		d.savedInput += call.out.InputTokens
+		d.savedOutput += call.out.OutputTokens
+		d.mu.Unlock()
+
+		out := call.out
+		out.ID = item.ID
+		out.Metadata = item.Metadata
+		out.DuplicateOf = call.out.ID
+		out.InputTokens, out.OutputTokens, out.CostUSD, out.LatencyMs = 0, 0, 0, 0
+		if out.Usage != nil {
+			usage := *out.Usage
+			usage.InputTokens, usage.OutputTokens, usage.CostUSD, usage.LatencyMs = 0, 0, 0, 0
+			out.Usage = &usage
+		}
+		out.resumed = false
+		return out
+	}
+}
+
+// logSavings reports the invocations and tokens saved by deduplication
+func (d *deduper) logSavings() {
+	if d.saved > 0 {
+		log.Printf("Deduplication saved %d invocations (%d input / %d output tokens)", d.saved, d.savedInput, d.savedOutput)
+	}
+}
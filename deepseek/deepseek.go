@@ -1,84 +1,123 @@
 package deepseek
 
 import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/extract"
+	"bedrock-llama/logging"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 )
 
 // ModelID is the AWS Bedrock DeepSeek inference profile ARN
 const ModelID = "arn:aws:bedrock:us-east-2:913524932967:inference-profile/us.deepseek.r1-v1:0"
 
-// Message represents a message in the conversation
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+// Name is the short model name used on the command line
+const Name = "deepseek"
 
-// InferenceConfig represents the configuration for the inference
-type InferenceConfig struct {
-	MaxTokens int `json:"max_tokens"`
-}
+// SupportsLatencyOptimized reports whether the model accepts performanceConfig latency=optimized
+// (DeepSeek R1 does not support latency-optimized inference)
+const SupportsLatencyOptimized = false
+
+// SupportsStructuredOutput reports whether the model can be forced to call a tool for structured output
+const SupportsStructuredOutput = false
+
+// ContextWindow is the maximum number of tokens of a prompt and its response (DeepSeek-R1)
+const ContextWindow = 128000
+
+// SupportsCountTokens reports whether Bedrock's CountTokens API counts the model's prompts
+const SupportsCountTokens = false
+
+// SupportsImages reports whether the model accepts images in its messages
+const SupportsImages = false
+
+// SupportsVideo reports whether the model accepts videos in its messages
+const SupportsVideo = false
+
+// SupportsThinking reports whether the model can reason before it answers (extended thinking)
+const SupportsThinking = false
+
+// AlwaysReasons reports whether the model reasons before every answer, without a thinking budget
+const AlwaysReasons = true
+
+// Tags around the chain of thought DeepSeek-R1 writes in its text before the answer
+const (
+	thinkStart = "<think>"
+	thinkEnd   = "</think>"
+)
+
+// reasoningBudget is added to the output cap for the chain of thought R1 writes before every
+// answer, which counts against max_tokens. The cap of a task is sized for the answer alone, and
+// the 64 tokens of the series task would otherwise end R1 inside its <think> block. Options
+// can't tell a task's cap from one the user set, so an explicit -max-output-tokens gets the
+// budget too, as it does with Claude's thinking budget.
+const reasoningBudget = 2048
+
+// Sampling defaults DeepSeek recommends for R1, which repeats itself or rambles at other
+// temperatures; Options.Temperature and Options.TopP override them
+const (
+	defaultTemperature = 0.6
+	defaultTopP        = 0.95
+)
+
+// Special tokens of the DeepSeek-R1 chat template
+const (
+	beginOfSentence = "<｜begin▁of▁sentence｜>"
+	endOfSentence   = "<｜end▁of▁sentence｜>"
+	userToken       = "<｜User｜>"
+	assistantToken  = "<｜Assistant｜>"
+)
 
 // Payload represents the request payload for the DeepSeek model
 type Payload struct {
-	InferenceConfig InferenceConfig `json:"inferenceConfig"`
-	Messages        []Message       `json:"messages"`
+	Prompt      string   `json:"prompt"`
+	Temperature float64  `json:"temperature"`
+	TopP        float64  `json:"top_p"`
+	MaxTokens   int      `json:"max_tokens"`
+	Stop        []string `json:"stop,omitempty"`
 }
 
 // Response represents the response from the DeepSeek model
 type Response struct {
 	Choices []struct {
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
 	} `json:"choices"`
+	// Usage is taken from the response headers, since the body doesn't report it
 	Usage struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
+		InputTokens  int
+		OutputTokens int
+	} `json:"-"`
+	// Latency is the performanceConfig latency Bedrock served, taken from the response metadata
+	Latency string `json:"-"`
+	// Guardrail is the trace of the guardrail when it intervened
+	Guardrail *bedrock.GuardrailTrace `json:"-"`
 }
 
 // InvokeModel calls the DeepSeek model with the given prompt
-func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKey, awsRegion string) (*Response, error) {
-	// Debug output to verify prompt
-	log.Printf("Sending prompt to DeepSeek model: %s", prompt)
-
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(awsRegion),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			accessKeyId,
-			secretAccessKey,
-			"", // Session token (empty for regular access keys)
-		)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
-	}
+func InvokeModel(ctx context.Context, client bedrock.Invoker, prompt string, opts bedrock.Options) (*Response, error) {
+	return InvokeConversation(ctx, client, bedrock.UserTurn(prompt), opts)
+}
 
-	// Create a Bedrock Runtime client
-	client := bedrockruntime.NewFromConfig(cfg)
+// InvokeConversation calls the DeepSeek model with a multi-turn conversation
+func InvokeConversation(ctx context.Context, client bedrock.Invoker, turns []bedrock.Turn, opts bedrock.Options) (*Response, error) {
+	prompt := formatPrompt(opts.System, turns)
+
+	// Debug output to verify prompt
+	logging.Debugf("Sending prompt to DeepSeek model: %s", prompt)
 
 	// Prepare payload according to DeepSeek requirements
 	payload := Payload{
-		InferenceConfig: InferenceConfig{
-			MaxTokens: 512,
-		},
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+		Prompt:      prompt,
+		Temperature: opts.TemperatureOr(defaultTemperature),
+		TopP:        opts.TopPOr(defaultTopP),
+		MaxTokens:   opts.MaxTokensOr(512) + reasoningBudget,
+		Stop:        opts.StopSequences,
 	}
 
 	payloadBytes, err := json.Marshal(payload)
@@ -87,67 +126,185 @@ func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKe
 	}
 
 	// Debug: Log the payload being sent to the model
-	log.Printf("DeepSeek payload: %s", string(payloadBytes))
+	logging.Debugf("DeepSeek payload: %s", string(payloadBytes))
 
 	// Create the input for the InvokeModel operation
 	input := &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(ModelID),
-		ContentType: aws.String("application/json"),
-		Accept:      aws.String("application/json"),
-		Body:        payloadBytes,
+		ModelId:                  aws.String(ModelID),
+		ContentType:              aws.String("application/json"),
+		Accept:                   aws.String("application/json"),
+		Body:                     payloadBytes,
+		PerformanceConfigLatency: opts.PerformanceLatency("DeepSeek", SupportsLatencyOptimized),
 	}
 
 	// Invoke the model
-	output, err := client.InvokeModel(ctx, input)
+	output, err := bedrock.Invoke(ctx, client, input, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error invoking Bedrock DeepSeek model: %v", err)
+		return nil, fmt.Errorf("error invoking Bedrock DeepSeek model: %w", err)
 	}
 
 	// Debug: Log the raw response
-	log.Printf("Raw DeepSeek response: %s", string(output.Body))
+	logging.Debugf("Raw DeepSeek response: %s", string(output.Body))
 
 	var response Response
 	if err := json.Unmarshal(output.Body, &response); err != nil {
-		// Try to unmarshal into a map to see the actual response structure
-		var rawResponse map[string]interface{}
-		if jsonErr := json.Unmarshal(output.Body, &rawResponse); jsonErr == nil {
-			rawBytes, _ := json.MarshalIndent(rawResponse, "", "  ")
-			log.Printf("Raw response structure: %s", string(rawBytes))
-		}
-
 		return nil, fmt.Errorf("failed to unmarshal DeepSeek response: %v", err)
 	}
+	response.Usage.InputTokens, response.Usage.OutputTokens = bedrock.TokenCounts(output)
 
-	// Debug: Log the parsed response structure
-	responseBytes, _ := json.MarshalIndent(response, "", "  ")
-	log.Printf("Parsed DeepSeek response: %s", string(responseBytes))
+	if bedrock.GuardrailIntervened(output.Body) {
+		for i := range response.Choices {
+			response.Choices[i].StopReason = bedrock.StopGuardrailIntervened
+		}
+		response.Guardrail = bedrock.ParseGuardrailTrace(output.Body)
+	}
+	response.Latency = string(output.PerformanceConfigLatency)
+	opts.LogLatency(response.Latency)
 
 	return &response, nil
 }
 
+// formatPrompt renders a conversation in the DeepSeek-R1 chat template: the system prompt,
+// then each turn after the token of its role, with the assistant's turns closed by the end of
+// sentence token. The prompt ends with an open assistant turn started with <think>, which is
+// how R1 is meant to be prompted, so it reasons before every answer.
+func formatPrompt(system string, turns []bedrock.Turn) string {
+	var prompt strings.Builder
+	prompt.WriteString(beginOfSentence)
+	prompt.WriteString(strings.TrimSpace(system))
+	for _, turn := range turns {
+		if turn.Role == bedrock.RoleAssistant {
+			prompt.WriteString(assistantToken + strings.TrimSpace(turn.Text) + endOfSentence)
+		} else {
+			prompt.WriteString(userToken + strings.TrimSpace(turn.Text))
+		}
+	}
+	prompt.WriteString(assistantToken + thinkStart + "\n")
+	return prompt.String()
+}
+
+// Text returns the answer from the DeepSeek response, without the reasoning before it
+func (r *Response) Text() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	_, answer := splitReasoning(r.Choices[0].Text)
+	return answer
+}
+
+// Reasoning returns the chain of thought DeepSeek-R1 went through before its answer
+func (r *Response) Reasoning() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	reasoning, _ := splitReasoning(r.Choices[0].Text)
+	return reasoning
+}
+
+// splitReasoning separates the reasoning between <think> tags from the answer after them. The
+// prompt already opens the tag, so everything up to </think> is reasoning, and a response cut
+// off before </think> is all reasoning.
+func splitReasoning(text string) (reasoning, answer string) {
+	trimmed := strings.TrimSpace(text)
+	end := strings.Index(trimmed, thinkEnd)
+	if end < 0 {
+		if strings.HasPrefix(trimmed, thinkStart) {
+			return strings.TrimSpace(strings.TrimPrefix(trimmed, thinkStart)), ""
+		}
+		return "", text
+	}
+	reasoning = strings.TrimSpace(strings.TrimPrefix(trimmed[:end], thinkStart))
+	return reasoning, strings.TrimSpace(trimmed[end+len(thinkEnd):])
+}
+
+// StopReason returns why the DeepSeek model stopped generating
+func (r *Response) StopReason() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	return r.Choices[0].StopReason
+}
+
+type model struct {
+	client bedrock.Runtime
+	opts   bedrock.Options
+}
+
+// New returns a bedrock.Model that invokes DeepSeek through the given client
+func New(client bedrock.Runtime, opts bedrock.Options) bedrock.Model {
+	return &model{client: client, opts: opts}
+}
+
+func (m *model) Name() string {
+	return Name
+}
+
+func (m *model) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *model) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	// The native request body can't carry documents, the Converse API can
+	if bedrock.HasDocuments(turns) {
+		return m.Stream(ctx, turns, func(string) {})
+	}
+	response, err := InvokeConversation(ctx, m.client, turns, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &bedrock.Result{
+		Model:        Name,
+		Text:         response.Text(),
+		InputTokens:  response.Usage.InputTokens,
+		OutputTokens: response.Usage.OutputTokens,
+		Thinking:     response.Reasoning(),
+		Latency:      response.Latency,
+		Guardrail:    response.Guardrail,
+		StopReason:   response.StopReason(),
+	}
+	// A filtered response is returned with the error, so its tokens are still accounted for
+	return result, bedrock.CheckStopReason(result)
+}
+
+// Stream streams the conversation through the Converse API, which returns the reasoning apart
+// from the text; reasoning that still comes in <think> tags is streamed with the text, and only
+// separated in the result
+func (m *model) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	temperature, topP := m.opts.TemperatureOr(defaultTemperature), m.opts.TopPOr(defaultTopP)
+	result, err := bedrock.ConverseStream(ctx, m.client, bedrock.StreamRequest{
+		Model:         Name,
+		ModelID:       ModelID,
+		Latency:       m.opts.PerformanceLatency(Name, SupportsLatencyOptimized),
+		Retry:         m.opts.Retry,
+		Timeout:       m.opts.Timeout,
+		MaxTokens:     m.opts.MaxTokens,
+		System:        m.opts.System,
+		StopSequences: m.opts.StopSequences,
+		Temperature:   &temperature,
+		TopP:          &topP,
+		Guardrail:     m.opts.Guardrail,
+	}, turns, onText)
+	if result != nil && result.Thinking == "" {
+		result.Thinking, result.Text = splitReasoning(result.Text)
+	}
+	return result, err
+}
+
 // PrintResponse formats and prints the DeepSeek model response
 func PrintResponse(response *Response) {
 	var output string
 	if len(response.Choices) > 0 {
-		output = response.Choices[0].Message.Content
+		output = response.Text()
 	} else {
 		log.Println("No response content received from DeepSeek model")
 		return
 	}
 
-	// Try to find the JSON array pattern and extract it
-	jsonPattern := regexp.MustCompile(`\[\s*{\s*"series"\s*:\s*"([^"]*)"\s*}\s*\]`)
-	if match := jsonPattern.FindStringSubmatch(output); len(match) > 1 {
-		fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
+	if series, err := extract.Series(output); err == nil {
+		fmt.Println(extract.FormatSeries(series))
 	} else {
-		// Try a fallback approach to extract just the series name
-		seriesPattern := regexp.MustCompile(`"series"\s*:\s*"([^"]*)"`)
-		if match := seriesPattern.FindStringSubmatch(output); len(match) > 1 {
-			fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
-		} else {
-			// Last resort: print the cleaned response
-			fmt.Println(strings.TrimSpace(output))
-		}
+		// Last resort: print the cleaned response
+		fmt.Println(strings.TrimSpace(output))
 	}
 
 	// Print token usage information if available (as logs to not interfere with JSON output)
@@ -0,0 +1,153 @@
+package deepseek
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/bedrock/bedrocktest"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// answer is a canned response body of an answer after R1's reasoning
+const answer = `{"choices": [{"text": "The episode is from Friends.\n</think>\n\n[{\"series\": \"Friends\"}]", "stop_reason": "stop"}]}`
+
+// field is the expected value at a path of the marshalled payload
+type field struct {
+	path []any
+	want any
+}
+
+func TestInvokeConversationPayload(t *testing.T) {
+	turns := []bedrock.Turn{{Role: bedrock.RoleUser, Text: "Friends Season 1 Episode 3"}}
+	temperature, topP := 0.2, 0.5
+	tests := []struct {
+		name   string
+		turns  []bedrock.Turn
+		opts   bedrock.Options
+		fields []field
+	}{
+		{
+			name:  "defaults",
+			turns: turns,
+			fields: []field{
+				{[]any{"prompt"}, beginOfSentence + userToken + "Friends Season 1 Episode 3" + assistantToken + thinkStart + "\n"},
+				{[]any{"max_tokens"}, float64(512 + reasoningBudget)},
+				{[]any{"temperature"}, defaultTemperature},
+				{[]any{"top_p"}, defaultTopP},
+			},
+		},
+		{
+			name:  "output cap, sampling and system prompt",
+			turns: turns,
+			opts:  bedrock.Options{MaxTokens: 64, Temperature: &temperature, TopP: &topP, System: "Answer in JSON."},
+			fields: []field{
+				{[]any{"prompt"}, beginOfSentence + "Answer in JSON." + userToken + "Friends Season 1 Episode 3" + assistantToken + thinkStart + "\n"},
+				{[]any{"max_tokens"}, float64(64 + reasoningBudget)},
+				{[]any{"temperature"}, 0.2},
+				{[]any{"top_p"}, 0.5},
+			},
+		},
+		{
+			name: "conversation",
+			turns: []bedrock.Turn{
+				{Role: bedrock.RoleUser, Text: "Which series?"},
+				{Role: bedrock.RoleAssistant, Text: "Friends"},
+				{Role: bedrock.RoleUser, Text: "As JSON"},
+			},
+			fields: []field{
+				{[]any{"prompt"}, beginOfSentence + userToken + "Which series?" + assistantToken + "Friends" + endOfSentence +
+					userToken + "As JSON" + assistantToken + thinkStart + "\n"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: answer}
+			if _, err := InvokeConversation(context.Background(), client, tt.turns, tt.opts); err != nil {
+				t.Fatalf("InvokeConversation: %v", err)
+			}
+			if got := client.ModelID(t); got != ModelID {
+				t.Errorf("model ID = %q, want %q", got, ModelID)
+			}
+			payload := client.Payload(t)
+			for _, f := range tt.fields {
+				if got := bedrocktest.Field(payload, f.path...); !reflect.DeepEqual(got, f.want) {
+					t.Errorf("%v = %#v, want %#v", f.path, got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvokeConversationResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		text       string
+		reasoning  string
+		stopReason string
+	}{
+		{
+			name:       "reasoning and answer",
+			body:       answer,
+			text:       `[{"series": "Friends"}]`,
+			reasoning:  "The episode is from Friends.",
+			stopReason: "stop",
+		},
+		{
+			name:       "cut off while reasoning",
+			body:       `{"choices": [{"text": "<think>\nThe episode is", "stop_reason": "length"}]}`,
+			reasoning:  "The episode is",
+			stopReason: "length",
+		},
+		{
+			name:       "guardrail intervention",
+			body:       `{"choices": [{"text": "Sorry.", "stop_reason": "stop"}], "amazon-bedrock-guardrailAction": "INTERVENED"}`,
+			text:       "Sorry.",
+			stopReason: bedrock.StopGuardrailIntervened,
+		},
+		{
+			name: "no choices",
+			body: `{"choices": []}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: tt.body}
+			response, err := InvokeModel(context.Background(), client, "Friends Season 1 Episode 3", bedrock.Options{})
+			if err != nil {
+				t.Fatalf("InvokeModel: %v", err)
+			}
+			if got := response.Text(); got != tt.text {
+				t.Errorf("Text() = %q, want %q", got, tt.text)
+			}
+			if got := response.Reasoning(); got != tt.reasoning {
+				t.Errorf("Reasoning() = %q, want %q", got, tt.reasoning)
+			}
+			if got := response.StopReason(); got != tt.stopReason {
+				t.Errorf("StopReason() = %q, want %q", got, tt.stopReason)
+			}
+		})
+	}
+}
+
+func TestInvokeConversationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *bedrocktest.Invoker
+		want   string
+	}{
+		{"request fails", &bedrocktest.Invoker{Err: errors.New("connection refused")}, "error invoking Bedrock DeepSeek model"},
+		{"invalid body", &bedrocktest.Invoker{Body: "not json"}, "failed to unmarshal DeepSeek response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := InvokeModel(context.Background(), tt.client, "Friends", bedrock.Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
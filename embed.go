@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embeddingOutput is the vector of one input, written as a JSONL record or a JSON array element
+type embeddingOutput struct {
+	// ID is the input line number, or 1 for a text given as arguments
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// embedBatch is a group of inputs embedded together
+type embedBatch struct {
+	index int
+	items []batchItem
+}
+
+// embedResult holds the vectors of a batch
+type embedResult struct {
+	embedBatch
+	result *bedrock.EmbeddingResult
+	err    error
+}
+
+// runEmbed implements the embed subcommand, which turns text into embedding vectors
+func runEmbed(args []string) {
+	fs := flag.NewFlagSet("embed", flag.ExitOnError)
+	f := &flags{}
+	modelFlag := fs.String("model", models.EmbeddingNames()[0], "The embedding model to use: "+strings.Join(models.EmbeddingNames(), ", "))
+	inputFileFlag := fs.String("input-file", "", "File with one text per line, an s3://bucket/key object, or - for stdin; defaults to the arguments as one text")
+	formatFlag := fs.String("format", "jsonl", "Output format: jsonl (one record per line) or json (an array)")
+	dimensionsFlag := fs.Int("dimensions", 0, "Length of the vectors, for models that offer several (0 for the model's default)")
+	normalizeFlag := fs.Bool("normalize", true, "Scale the vectors to unit length, for models that make it optional")
+	inputTypeFlag := fs.String("input-type", "", "What the texts are for, for models that take it such as cohere: search_document (the default), search_query, classification or clustering")
+	batchSizeFlag := fs.Int("batch-size", 16, "Number of texts embedded together")
+	concurrencyFlag := fs.Int("concurrency", 4, "Number of batches embedded in parallel")
+	regionFlag := fs.String("region", "", "Region to embed in, where the embedding model is available; defaults to $AWS_REGION")
+	f.endpoint = fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL; defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	f.fips = fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	maxAttemptsFlag := fs.Int("max-attempts", 5, "Maximum attempts when Bedrock throttles a request (1 disables retries)")
+	timeoutFlag := fs.Duration("timeout", time.Minute, "Time limit for each request")
+	registerSDKRetryFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	info, ok := models.LookupEmbedding(strings.ToLower(*modelFlag))
+	if !ok {
+		fatalf("Unknown embedding model %q. Use %s", *modelFlag, strings.Join(models.EmbeddingNames(), ", "))
+	}
+	if *formatFlag != "jsonl" && *formatFlag != "json" {
+		fatalf("Invalid format %q. Use jsonl or json", *formatFlag)
+	}
+	if *batchSizeFlag < 1 || *concurrencyFlag < 1 {
+		fatalf("-batch-size and -concurrency must be at least 1")
+	}
+	text := strings.Join(fs.Args(), " ")
+	if (*inputFileFlag == "") == (strings.TrimSpace(text) == "") {
+		fatalf("Usage: %s embed [flags] TEXT, or %s embed -input-file FILE [flags]", os.Args[0], os.Args[0])
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *regionFlag != "" {
+		cfg.Region = *regionFlag
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	model := info.New(client, bedrock.Options{
+		Retry:   bedrock.RetryPolicy{MaxAttempts: *maxAttemptsFlag},
+		Timeout: *timeoutFlag,
+	})
+
+	items := make(chan batchItem)
+	readErr := make(chan error, 1)
+	if *inputFileFlag == "" {
+		go func() {
+			items <- batchItem{ID: "1", Input: text}
+			close(items)
+			readErr <- nil
+		}()
+	} else {
+		input, _, err := openBatchInput(ctx, cfg, *inputFileFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		defer input.Close()
+		go func() {
+			readErr <- readItems(input, false, items)
+			close(items)
+		}()
+	}
+
+	log.Printf("Embedding with %s in %s...", info.DisplayName, cfg.Region)
+	req := bedrock.EmbeddingRequest{Dimensions: *dimensionsFlag, Normalize: *normalizeFlag, InputType: strings.ToLower(*inputTypeFlag)}
+	w := &embeddingWriter{out: os.Stdout, json: *formatFlag == "json"}
+	count, tokens := 0, 0
+	embedBatches(ctx, model, req, items, *batchSizeFlag, *concurrencyFlag, func(result embedResult) {
+		if result.err != nil {
+			fatalf("Error embedding lines %s to %s: %v", result.items[0].ID, result.items[len(result.items)-1].ID, result.err)
+		}
+		for i, item := range result.items {
+			w.write(embeddingOutput{ID: item.ID, Text: item.Input, Embedding: result.result.Embeddings[i]})
+		}
+		count += len(result.items)
+		tokens += result.result.InputTokens
+	})
+	// The reader may be blocked on the items the interrupted run never took
+	if ctx.Err() != nil {
+		fatalf("Interrupted after embedding %d text(s)", count)
+	}
+	if err := <-readErr; err != nil {
+		fatalf("Error: %v", err)
+	}
+	w.close()
+	log.Printf("Embedded %d text(s), %d input tokens", count, tokens)
+}
+
+// embedBatches groups the items into batches and embeds them on a pool of workers. emit is
+// called from a single goroutine, in input order.
+func embedBatches(ctx context.Context, model bedrock.EmbeddingModel, req bedrock.EmbeddingRequest, items <-chan batchItem, batchSize, workers int, emit func(embedResult)) {
+	batches := make(chan embedBatch)
+	results := make(chan embedResult)
+	go func() {
+		defer close(batches)
+		batch := embedBatch{}
+		for item := range items {
+			batch.items = append(batch.items, item)
+			if len(batch.items) < batchSize {
+				continue
+			}
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+			batch = embedBatch{index: batch.index + 1}
+		}
+		if len(batch.items) > 0 {
+			batches <- batch
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				batchReq := req
+				batchReq.Texts = make([]string, len(batch.items))
+				for i, item := range batch.items {
+					batchReq.Texts[i] = item.Input
+				}
+				result, err := model.Embed(ctx, batchReq)
+				if err == nil && len(result.Embeddings) != len(batch.items) {
+					err = fmt.Errorf("the model returned %d vectors for %d texts", len(result.Embeddings), len(batch.items))
+				}
+				results <- embedResult{batch, result, err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Batches that finish ahead of an earlier one wait here
+	pending := map[int]embedResult{}
+	next := 0
+	for result := range results {
+		pending[result.index] = result
+		for out, ok := pending[next]; ok; out, ok = pending[next] {
+			delete(pending, next)
+			emit(out)
+			next++
+		}
+	}
+}
+
+// embeddingWriter writes the vectors as JSONL records, or as the elements of a JSON array
+type embeddingWriter struct {
+	out     io.Writer
+	json    bool
+	written int
+}
+
+func (w *embeddingWriter) write(out embeddingOutput) {
+	line, err := json.Marshal(out)
+	if err != nil {
+		fatalf("Error encoding output for %s: %v", out.ID, err)
+	}
+	prefix := ""
+	if w.json {
+		prefix = ",\n"
+		if w.written == 0 {
+			prefix = "[\n"
+		}
+	}
+	if _, err := fmt.Fprintf(w.out, "%s%s", prefix, line); err != nil {
+		fatalf("Error writing output: %v", err)
+	}
+	if !w.json {
+		fmt.Fprintln(w.out)
+	}
+	w.written++
+}
+
+// close ends the JSON array
+func (w *embeddingWriter) close() {
+	if !w.json {
+		return
+	}
+	if w.written == 0 {
+		fmt.Fprintln(w.out, "[]")
+		return
+	}
+	fmt.Fprintln(w.out, "\n]")
+}
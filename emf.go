@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bedrock-llama/pricing"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// emfMetric names a metric in the CloudWatch Embedded Metric Format directive
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// emfMetrics lists the metrics of every invocation record
+var emfMetrics = []emfMetric{
+	{"Invocations", "Count"},
+	{"Errors", "Count"},
+	{"Latency", "Milliseconds"},
+	{"InputTokens", "Count"},
+	{"OutputTokens", "Count"},
+	{"EstimatedCost", "None"},
+}
+
+// emfWriter writes one CloudWatch Embedded Metric Format record per model invocation. The
+// records are plain log lines, so CloudWatch Logs turns them into metrics wherever the
+// process's logs are shipped (Lambda, ECS, or the CloudWatch agent) without any API calls.
+type emfWriter struct {
+	namespace string
+	task      string
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newEMFWriter writes records to w under a CloudWatch namespace
+func newEMFWriter(w io.Writer, namespace, task string) *emfWriter {
+	return &emfWriter{namespace: namespace, task: task, enc: json.NewEncoder(w)}
+}
+
+// record writes the metrics of one invocation, dimensioned by model and by model and task
+func (w *emfWriter) record(model string, latency time.Duration, inputTokens, outputTokens int, err error) {
+	errors := 0
+	if err != nil {
+		errors = 1
+	}
+	record := map[string]any{
+		"_aws": map[string]any{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]any{{
+				"Namespace":  w.namespace,
+				"Dimensions": [][]string{{"Model"}, {"Model", "Task"}},
+				"Metrics":    emfMetrics,
+			}},
+		},
+		"Model":         model,
+		"Task":          w.task,
+		"Invocations":   1,
+		"Errors":        errors,
+		"Latency":       latency.Milliseconds(),
+		"InputTokens":   inputTokens,
+		"OutputTokens":  outputTokens,
+		"EstimatedCost": pricing.Cost(model, inputTokens, outputTokens),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(record); err != nil {
+		log.Printf("Error writing EMF record: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bedrock-llama/models"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// evalCase is a labeled input of an eval dataset
+type evalCase struct {
+	// ID identifies the case in the report; it defaults to the line number
+	ID    string `json:"id"`
+	Input string `json:"input"`
+	// Expected is the value the task should extract from the input
+	Expected string `json:"expected"`
+}
+
+// evalResult is the outcome of one case for one model
+type evalResult struct {
+	ID       string `json:"id"`
+	Model    string `json:"model"`
+	Input    string `json:"input"`
+	Expected string `json:"expected"`
+	// Predicted is the -field value of the first extracted record; empty without records
+	Predicted string `json:"predicted"`
+	// Exact is set when Predicted is Expected; Normalized when they are equal once case,
+	// punctuation and spacing are ignored; Match when the -match comparison counts it as correct
+	Exact      bool   `json:"exact"`
+	Normalized bool   `json:"normalized"`
+	Match      bool   `json:"match"`
+	Error      string `json:"error,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+}
+
+// evalScore is the accuracy of a model over the dataset, a row of the eval report
+type evalScore struct {
+	Model string `json:"model"`
+	Cases int    `json:"cases"`
+	// Errors counts the cases the model failed on, which count as mismatches. Matches and
+	// Accuracy are those of the -match comparison.
+	Errors             int     `json:"errors"`
+	ExactMatches       int     `json:"exact_matches"`
+	ExactAccuracy      float64 `json:"exact_accuracy"`
+	NormalizedMatches  int     `json:"normalized_matches"`
+	NormalizedAccuracy float64 `json:"normalized_accuracy"`
+	Matches            int     `json:"matches"`
+	Accuracy           float64 `json:"accuracy"`
+	InputTokens        int     `json:"input_tokens"`
+	OutputTokens       int     `json:"output_tokens"`
+	CostUSD            float64 `json:"estimated_cost_usd"`
+}
+
+// evalReport is the JSON report of an eval run; Match is the -match comparison, e.g. token-set:0.6
+type evalReport struct {
+	Dataset string       `json:"dataset"`
+	Task    string       `json:"task"`
+	Field   string       `json:"field"`
+	Match   string       `json:"match"`
+	Scores  []*evalScore `json:"scores"`
+	Results []evalResult `json:"results"`
+}
+
+// runEval runs a labeled dataset through one or more models and reports their accuracy
+func runEval(args []string) {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	f := registerFlags(fs)
+	datasetFlag := fs.String("dataset", "", `Labeled dataset: JSONL lines like {"input": "the.office.s01e01.mkv", "expected": "The Office"}, or a CSV file of input,expected rows`)
+	modelsFlag := fs.String("models", "", "Comma-separated models to evaluate, each on its own without fallbacks; defaults to -model")
+	fieldFlag := fs.String("field", "", "Record field compared with the expected value; defaults to the task's first field")
+	matchFlag := fs.String("match", "normalized", "Comparison that decides whether an answer is correct: "+matcherUsage())
+	formatFlag := fs.String("format", "table", "Report format: table, csv or json")
+	concurrencyFlag := fs.Int("concurrency", 1, "Number of cases evaluated in parallel")
+	minAccuracyFlag := fs.Float64("min-accuracy", 0, "Exit with status 1 when a model's -match accuracy is below this share of the cases, e.g. 0.95; 0 to never fail")
+	junitFlag := fs.String("junit", "", "Also write the results as a JUnit XML report to this file, for CI")
+	fs.Parse(args)
+
+	if *datasetFlag == "" {
+		fatalf("Eval needs a dataset. Provide one using the -dataset flag.")
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "table" && format != "csv" && format != "json" {
+		fatalf("Invalid format %q. Use table, csv or json", *formatFlag)
+	}
+	if *concurrencyFlag < 1 {
+		fatalf("-concurrency must be at least 1")
+	}
+	if *minAccuracyFlag < 0 || *minAccuracyFlag > 1 {
+		fatalf("-min-accuracy must be between 0 and 1")
+	}
+	match, err := parseMatcher(*matchFlag)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	cases, err := readEvalDataset(*datasetFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if len(cases) == 0 {
+		fatalf("The dataset %s has no cases", *datasetFlag)
+	}
+
+	ctx := context.Background()
+	runWithExtractor(ctx, f, func(e *extractor) error {
+		field := *fieldFlag
+		if field == "" {
+			field = e.task.Schema.Fields[0].Name
+		}
+		known := false
+		for _, schemaField := range e.task.Schema.Fields {
+			known = known || schemaField.Name == field
+		}
+		if !known {
+			return fmt.Errorf("the %s task has no field %q", e.task.Name, field)
+		}
+
+		evaluated := []*extractor{e}
+		if *modelsFlag != "" {
+			e.fallbacks = nil
+			evaluated = nil
+			for _, name := range strings.Split(strings.ToLower(*modelsFlag), ",") {
+				info, ok := e.lookupModel(strings.TrimSpace(name))
+				if !ok {
+					return fmt.Errorf("invalid model specified. Use %s", models.Usage())
+				}
+				other, err := e.withModel(info)
+				if err != nil {
+					return err
+				}
+				evaluated = append(evaluated, other)
+			}
+		}
+
+		report := &evalReport{Dataset: *datasetFlag, Task: e.task.Name, Field: field, Match: match.spec}
+		expected := make(map[string]evalCase, len(cases))
+		for _, c := range cases {
+			expected[c.ID] = c
+		}
+		for _, ex := range evaluated {
+			name := ex.modelInfo.Name
+			log.Printf("Evaluating %s on %d cases, matching with %s", name, len(cases), match.spec)
+			score := &evalScore{Model: name, Cases: len(cases)}
+
+			items := make(chan batchItem)
+			go func() {
+				for _, c := range cases {
+					items <- batchItem{ID: c.ID, Input: c.Input}
+				}
+				close(items)
+			}()
+			processItems(ctx, items, *concurrencyFlag, true, []runFunc{ex.runItem}, func(out batchOutput) {
+				result := scoreEvalCase(expected[out.ID], name, field, match, out)
+				score.add(result, out)
+				report.Results = append(report.Results, result)
+			})
+			score.finish()
+			report.Scores = append(report.Scores, score)
+		}
+
+		if err := writeEvalReport(os.Stdout, format, report); err != nil {
+			return fmt.Errorf("failed to write the report: %v", err)
+		}
+		if *junitFlag != "" {
+			if err := writeEvalJUnit(*junitFlag, report, *minAccuracyFlag); err != nil {
+				return err
+			}
+		}
+		below := false
+		for _, score := range report.Scores {
+			if score.Accuracy < *minAccuracyFlag {
+				warnf("%s accuracy %.4f is below -min-accuracy %g", score.Model, score.Accuracy, *minAccuracyFlag)
+				below = true
+			}
+		}
+		if below {
+			return exitStatus(1)
+		}
+		return nil
+	})
+}
+
+// readEvalDataset reads the cases of a JSONL dataset, or of a CSV one when the file name ends
+// in .csv. A CSV header row naming the input and expected columns is skipped.
+func readEvalDataset(path string) ([]evalCase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dataset: %v", err)
+	}
+	defer file.Close()
+
+	var cases []evalCase
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		r := csv.NewReader(file)
+		r.FieldsPerRecord = 2
+		for row := 1; ; row++ {
+			fields, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("dataset %s: %v", path, err)
+			}
+			if row == 1 && strings.EqualFold(fields[0], "input") && strings.EqualFold(fields[1], "expected") {
+				continue
+			}
+			cases = append(cases, evalCase{ID: strconv.Itoa(row), Input: fields[0], Expected: fields[1]})
+		}
+		return cases, nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var c evalCase
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return nil, fmt.Errorf("dataset %s line %d: invalid JSON: %v", path, lineNumber, err)
+		}
+		if c.Input == "" {
+			return nil, fmt.Errorf("dataset %s line %d: missing input", path, lineNumber)
+		}
+		if c.ID == "" {
+			c.ID = strconv.Itoa(lineNumber)
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dataset: %v", err)
+	}
+	return cases, nil
+}
+
+// scoreEvalCase compares the first record the model extracted for a case with the expected value
+func scoreEvalCase(c evalCase, model, field string, match *matcher, out batchOutput) evalResult {
+	result := evalResult{ID: c.ID, Model: model, Input: c.Input, Expected: c.Expected, Error: out.Error, LatencyMs: out.LatencyMs}
+	if out.Error == "" {
+		var records []map[string]any
+		json.Unmarshal(out.Records, &records)
+		if len(records) > 0 {
+			if value, ok := records[0][field]; ok && value != nil {
+				result.Predicted = fmt.Sprint(value)
+			}
+		}
+		result.Exact = result.Predicted == c.Expected
+		result.Normalized = normalizeAnswer(result.Predicted) == normalizeAnswer(c.Expected)
+		result.Match = match.match(result.Predicted, c.Expected)
+	}
+	if !result.Match && result.Error == "" {
+		log.Printf("%s: %s mismatch on %q: expected %q, got %q", c.ID, model, c.Input, c.Expected, result.Predicted)
+	}
+	return result
+}
+
+// normalizeAnswer lowercases an answer and reduces its punctuation and spacing to single
+// spaces, so that "The Office (US)" and "the office us" compare equal; & reads as "and"
+func normalizeAnswer(answer string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(answer) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == '&':
+			b.WriteString(" and ")
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// add counts the result of a case and the consumption of its extraction
+func (s *evalScore) add(result evalResult, out batchOutput) {
+	if result.Error != "" {
+		s.Errors++
+	}
+	if result.Exact {
+		s.ExactMatches++
+	}
+	if result.Normalized {
+		s.NormalizedMatches++
+	}
+	if result.Match {
+		s.Matches++
+	}
+	s.InputTokens += out.InputTokens
+	s.OutputTokens += out.OutputTokens
+	s.CostUSD += out.CostUSD
+}
+
+// finish computes the accuracies once every case is counted
+func (s *evalScore) finish() {
+	if s.Cases > 0 {
+		s.ExactAccuracy = float64(s.ExactMatches) / float64(s.Cases)
+		s.NormalizedAccuracy = float64(s.NormalizedMatches) / float64(s.Cases)
+		s.Accuracy = float64(s.Matches) / float64(s.Cases)
+	}
+}
+
+// writeEvalReport writes the scores as an aligned table or CSV, or the whole report, with the
+// result of every case, as JSON
+func writeEvalReport(out io.Writer, format string, report *evalReport) error {
+	header := []string{"model", "cases", "errors", "exact_matches", "exact_accuracy", "normalized_matches", "normalized_accuracy", "matches", "accuracy", "input_tokens", "output_tokens", "estimated_cost_usd"}
+	values := func(s *evalScore) []string {
+		return []string{s.Model, strconv.Itoa(s.Cases), strconv.Itoa(s.Errors),
+			strconv.Itoa(s.ExactMatches), strconv.FormatFloat(s.ExactAccuracy, 'f', 4, 64),
+			strconv.Itoa(s.NormalizedMatches), strconv.FormatFloat(s.NormalizedAccuracy, 'f', 4, 64),
+			strconv.Itoa(s.Matches), strconv.FormatFloat(s.Accuracy, 'f', 4, 64),
+			strconv.Itoa(s.InputTokens), strconv.Itoa(s.OutputTokens), strconv.FormatFloat(s.CostUSD, 'f', 4, 64)}
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write(header)
+		for _, score := range report.Scores {
+			w.Write(values(score))
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+	for _, score := range report.Scores {
+		fmt.Fprintln(w, strings.Join(values(score), "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,131 @@
+package extract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoJSON is returned when the text doesn't contain a valid JSON object or array
+var ErrNoJSON = errors.New("no JSON object or array found")
+
+// StripFences removes markdown code fences (```json ... ```) around the model output
+func StripFences(text string) string {
+	text = strings.TrimSpace(text)
+	start := strings.Index(text, "```")
+	if start < 0 {
+		return text
+	}
+
+	// Skip the opening fence and its optional language tag
+	body := text[start+3:]
+	if newline := strings.IndexByte(body, '\n'); newline >= 0 {
+		body = body[newline+1:]
+	}
+	if end := strings.Index(body, "```"); end >= 0 {
+		body = body[:end]
+	}
+	return strings.TrimSpace(body)
+}
+
+// FirstJSON locates the first balanced, valid JSON object or array in the text.
+// Brackets inside JSON strings (including escaped quotes) don't affect the balance.
+func FirstJSON(text string) (string, error) {
+	text = StripFences(text)
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && text[start] != '[' {
+			continue
+		}
+		end := balancedEnd(text, start)
+		if end < 0 {
+			continue
+		}
+		if candidate := text[start : end+1]; json.Valid([]byte(candidate)) {
+			return candidate, nil
+		}
+	}
+	return "", ErrNoJSON
+}
+
+// balancedEnd returns the index of the bracket closing the one at start, or -1 if it is never closed
+func balancedEnd(text string, start int) int {
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Unmarshal finds the first JSON value in the text and unmarshals it into v
+func Unmarshal(text string, v any) error {
+	raw, err := FirstJSON(text)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(raw), v)
+}
+
+// seriesRecord is a single series extraction result
+type seriesRecord struct {
+	Series string `json:"series"`
+}
+
+// Series extracts the series name from model output, accepting either the
+// requested [{"series": "..."}] array or a bare {"series": "..."} object
+func Series(text string) (string, error) {
+	raw, err := FirstJSON(text)
+	if err != nil {
+		return "", err
+	}
+
+	var records []seriesRecord
+	if err := json.Unmarshal([]byte(raw), &records); err == nil {
+		if len(records) == 0 || records[0].Series == "" {
+			return "", errors.New("no series name in JSON output")
+		}
+		return records[0].Series, nil
+	}
+
+	var record seriesRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return "", fmt.Errorf("unexpected JSON shape: %v", err)
+	}
+	if record.Series == "" {
+		return "", errors.New("no series name in JSON output")
+	}
+	return record.Series, nil
+}
+
+// FormatSeries renders a series name in the tool's [{"series": "..."}] output format
+func FormatSeries(series string) string {
+	var quoted strings.Builder
+	encoder := json.NewEncoder(&quoted)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(series)
+	return fmt.Sprintf("[{\"series\": %s}]", strings.TrimSpace(quoted.String()))
+}
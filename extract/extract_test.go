@@ -0,0 +1,114 @@
+package extract
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStripFences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"no fence", `  [{"series": "Friends"}]  `, `[{"series": "Friends"}]`},
+		{"json fence", "```json\n[{\"series\": \"Friends\"}]\n```", `[{"series": "Friends"}]`},
+		{"bare fence", "```\n{\"series\": \"Friends\"}\n```", `{"series": "Friends"}`},
+		{"prose around the fence", "Here you go:\n```json\n[]\n```\nAnything else?", `[]`},
+		{"unclosed fence", "```json\n[{\"series\": \"Friends\"}]", `[{"series": "Friends"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripFences(tt.text); got != tt.want {
+				t.Errorf("StripFences(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		// err is the expected error; the JSON must be found when nil
+		err error
+	}{
+		{"array", `[{"series": "Friends"}]`, `[{"series": "Friends"}]`, nil},
+		{"object", `{"series": "Friends"}`, `{"series": "Friends"}`, nil},
+		{"fenced block", "```json\n[{\"series\": \"Friends\"}]\n```", `[{"series": "Friends"}]`, nil},
+		{"leading prose", `The answer is [{"series": "Friends"}]`, `[{"series": "Friends"}]`, nil},
+		{"trailing prose", `[{"series": "Friends"}] I hope this helps! {not json}`, `[{"series": "Friends"}]`, nil},
+		{"nested", `{"a": {"b": [1, {"c": []}]}} and more`, `{"a": {"b": [1, {"c": []}]}}`, nil},
+		{"braces inside strings", `[{"series": "The {Office} ]["}] done`, `[{"series": "The {Office} ]["}]`, nil},
+		{"escaped quotes", `[{"series": "The \"Office\" }"}] done`, `[{"series": "The \"Office\" }"}]`, nil},
+		{"escaped backslash before a quote", `[{"series": "C:\\"}] ]`, `[{"series": "C:\\"}]`, nil},
+		{"invalid bracket first", `[see below] {"series": "Friends"}`, `{"series": "Friends"}`, nil},
+		{"unbalanced then valid", `[{"series": "Friends"} {"series": "Lost"}`, `{"series": "Friends"}`, nil},
+		{"no JSON at all", `I couldn't find a series name.`, "", ErrNoJSON},
+		{"empty", ``, "", ErrNoJSON},
+		{"never closed", `[{"series": "Friends"`, "", ErrNoJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FirstJSON(tt.text)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("FirstJSON(%q) error = %v, want %v", tt.text, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("FirstJSON(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeries(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		// wantErr is set when no series name must be found
+		wantErr bool
+	}{
+		{"array", `[{"series": "Friends"}]`, "Friends", false},
+		{"bare object", `{"series": "Friends"}`, "Friends", false},
+		{"fenced with prose", "Sure!\n```json\n[{\"series\": \"The Office\"}]\n```", "The Office", false},
+		{"first of several records", `[{"series": "Friends"}, {"series": "Lost"}]`, "Friends", false},
+		{"empty array", `[]`, "", true},
+		{"empty name", `[{"series": ""}]`, "", true},
+		{"other fields only", `{"title": "Friends"}`, "", true},
+		{"wrong shape", `"Friends"`, "", true},
+		{"no JSON", `Friends`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Series(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Series(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Series(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSeries(t *testing.T) {
+	tests := []struct {
+		series string
+		want   string
+	}{
+		{"Friends", `[{"series": "Friends"}]`},
+		{`The "Office"`, `[{"series": "The \"Office\""}]`},
+		{"Law & Order", `[{"series": "Law & Order"}]`},
+	}
+	for _, tt := range tests {
+		got := FormatSeries(tt.series)
+		if got != tt.want {
+			t.Errorf("FormatSeries(%q) = %q, want %q", tt.series, got, tt.want)
+		}
+		if series, err := Series(got); err != nil || series != tt.series {
+			t.Errorf("Series(FormatSeries(%q)) = %q, %v", tt.series, series, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// newFallbacks creates the extractors of the models tried in order when -model fails. The
+// models that can't think answer without extended thinking.
+func (e *extractor) newFallbacks(chain []models.Info) ([]*extractor, error) {
+	fallbacks := make([]*extractor, 0, len(chain))
+	for _, info := range chain {
+		if e.opts.Structured != nil && !info.SupportsStructuredOutput {
+			return nil, fmt.Errorf("structured output is not supported by the fallback model %s", info.Name)
+		}
+		if len(e.images) > 0 && !info.SupportsImages {
+			return nil, fmt.Errorf("images are not supported by the fallback model %s", info.Name)
+		}
+		if len(e.videos) > 0 && !info.SupportsVideo {
+			return nil, fmt.Errorf("videos are not supported by the fallback model %s", info.Name)
+		}
+		other := *e
+		other.modelInfo = info
+		other.opts.Provisioned = nil
+		if !info.SupportsThinking {
+			other.opts.Thinking = nil
+		}
+		other.hedge = nil
+		other.fallbacks = nil
+		other.model = other.newModel(info, other.opts)
+		fallbacks = append(fallbacks, &other)
+	}
+	return fallbacks, nil
+}
+
+// extractWithFallback runs the extraction on the model, hedged when a hedge is set, and then
+// on each fallback model in turn until one produces valid output. Errors, blocked content and
+// output that is still invalid after the corrective re-prompts all move on to the next model,
+// except for a guardrail's intervention and a prompt Llama Guard blocked, since they screen
+// every model alike. The result lists the models that failed before it; when every model
+// fails, the last model's outcome is returned.
+func (e *extractor) extractWithFallback(ctx context.Context, input string) (*bedrock.Result, error) {
+	first := e.extractOnce
+	if e.hedge != nil {
+		first = e.extractHedged
+	}
+	result, err := first(ctx, input)
+
+	var failed []string
+	model := e.modelInfo.Name
+	if result != nil {
+		// A hedge may have produced the result
+		model = result.Model
+	}
+	for _, next := range e.fallbacks {
+		var moderationErr *bedrock.ModerationError
+		if err == nil || ctx.Err() != nil || errors.Is(err, bedrock.ErrGuardrailIntervened) ||
+			(errors.As(err, &moderationErr) && moderationErr.Source == bedrock.GuardrailSourceInput) {
+			break
+		}
+		if next.modelInfo.Name == e.modelInfo.Name {
+			// A request picked this fallback as its model
+			continue
+		}
+		failed = append(failed, model)
+		log.Printf("%s failed (%v), falling back to %s", model, err, next.modelInfo.Name)
+		result, err = next.extractOnce(ctx, input)
+		model = next.modelInfo.Name
+	}
+	if result != nil && len(failed) > 0 {
+		result.FallbackFrom = failed
+	}
+	return result, err
+}
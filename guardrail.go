@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// exitIntervened is the exit status of the guardrail subcommand when the guardrail intervened,
+// set apart from 1 for failed requests and 2 for invalid flags so that scripts can tell them apart
+const exitIntervened = 3
+
+// runGuardrail implements the guardrail subcommand, which screens text with a Bedrock
+// Guardrail without invoking a model
+func runGuardrail(args []string) {
+	fs := flag.NewFlagSet("guardrail", flag.ExitOnError)
+	f := &flags{}
+	idFlag := fs.String("guardrail-id", "", "ID or ARN of the guardrail to apply")
+	versionFlag := fs.String("guardrail-version", "", "Version of the guardrail; the working draft (DRAFT) when empty")
+	sourceFlag := fs.String("source", bedrock.GuardrailSourceInput, "Screen the text as a prompt (input) or as a model response (output)")
+	textFlag := fs.String("text", "", "The text to screen; defaults to the arguments")
+	inputFileFlag := fs.String("input-file", "", "File holding the text, or - for stdin, instead of -text")
+	formatFlag := fs.String("format", "text", "Output format: text (a table of findings) or json")
+	regionFlag := fs.String("region", "", "Region of the guardrail; defaults to $AWS_REGION")
+	f.endpoint = fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL; defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	f.fips = fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	maxAttemptsFlag := fs.Int("max-attempts", 5, "Maximum attempts when Bedrock throttles the request (1 disables retries)")
+	timeoutFlag := fs.Duration("timeout", 30*time.Second, "Time limit for the request")
+	registerSDKRetryFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	text := *textFlag
+	if text == "" {
+		text = strings.Join(fs.Args(), " ")
+	}
+	if *inputFileFlag != "" {
+		var data []byte
+		var err error
+		if *inputFileFlag == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*inputFileFlag)
+		}
+		if err != nil {
+			fatalf("Error reading input: %v", err)
+		}
+		text = string(data)
+	}
+	if *idFlag == "" || strings.TrimSpace(text) == "" {
+		fatalf("Usage: %s guardrail -guardrail-id ID [flags] TEXT, or %s guardrail -guardrail-id ID -input-file FILE [flags]", os.Args[0], os.Args[0])
+	}
+	source := strings.ToLower(*sourceFlag)
+	if source != bedrock.GuardrailSourceInput && source != bedrock.GuardrailSourceOutput {
+		fatalf("Invalid source %q. Use input or output", *sourceFlag)
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "text" && format != "json" {
+		fatalf("Invalid format %q. Use text or json", *formatFlag)
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *regionFlag != "" {
+		cfg.Region = *regionFlag
+	}
+	ctx := context.Background()
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	guardrail := bedrock.NewGuardrail(*idFlag, *versionFlag)
+
+	log.Printf("Screening %d characters as %s with guardrail %s...", len(text), source, guardrail)
+	assessment, err := bedrock.ApplyGuardrail(ctx, client, guardrail, source, text, bedrock.RetryPolicy{MaxAttempts: *maxAttemptsFlag}, *timeoutFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if format == "json" {
+		data, _ := json.MarshalIndent(assessment, "", "  ")
+		fmt.Println(string(data))
+	} else if len(assessment.Findings) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "POLICY\tTYPE\tNAME\tMATCH\tDETAIL\tACTION")
+		for _, finding := range assessment.Findings {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", finding.Policy, orDash(finding.Type), orDash(finding.Name), orDash(finding.Match), orDash(finding.Detail), finding.Action)
+		}
+		w.Flush()
+	}
+
+	if !assessment.Intervened {
+		log.Printf("The guardrail let the text through")
+		return
+	}
+	if assessment.Reason != "" {
+		log.Printf("The guardrail intervened: %s", assessment.Reason)
+	} else {
+		log.Printf("The guardrail intervened")
+	}
+	if assessment.Output != "" && format == "text" {
+		log.Printf("Guardrail output: %s", assessment.Output)
+	}
+	os.Exit(exitIntervened)
+}
+
+// logGuardrailTrace logs which policies fired when err is the intervention of a guardrail. The
+// model's response from before the intervention is only logged with -verbose.
+func logGuardrailTrace(err error) {
+	var guardrailErr *bedrock.GuardrailError
+	if !errors.As(err, &guardrailErr) || guardrailErr.Trace == nil {
+		return
+	}
+	trace := guardrailErr.Trace
+	if trace.Reason != "" {
+		log.Printf("Guardrail: %s", trace.Reason)
+	}
+	for _, finding := range trace.Input {
+		log.Printf("Guardrail finding in the prompt: %s", finding)
+	}
+	for _, finding := range trace.Output {
+		log.Printf("Guardrail finding in the response: %s", finding)
+	}
+	for _, output := range trace.ModelOutput {
+		logging.Debugf("Response before the guardrail: %s", output)
+	}
+}
+
+// orDash returns s, or a dash for an empty table cell
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
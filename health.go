@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readyCheckTimeout bounds the Bedrock invocation made by a readiness check
+const readyCheckTimeout = 10 * time.Second
+
+// readiness reports whether the server should receive traffic
+type readiness struct {
+	// draining is set once shutdown has started
+	draining atomic.Bool
+
+	// check verifies that Bedrock is reachable; nil when readiness doesn't depend on it
+	check func(ctx context.Context) error
+	// interval is how long a check result is reused, so probes don't invoke the model each time
+	interval time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	lastErr   error
+}
+
+// bedrockCheck returns a readiness check that sends the smoke test prompt to the default
+// model on demand. It bypasses provisioned throughput and the rate limiter so probes never
+// take capacity from real requests.
+func (e *extractor) bedrockCheck() func(ctx context.Context) error {
+	model := e.modelInfo.New(e.client, bedrock.Options{Latency: bedrock.LatencyStandard})
+	return func(ctx context.Context) error {
+		result, err := model.Invoke(ctx, smokePrompt)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(result.Text) == "" {
+			return errors.New("empty response")
+		}
+		return nil
+	}
+}
+
+// ready reports why the server isn't ready, or nil when it is
+func (r *readiness) ready(ctx context.Context) error {
+	if r.draining.Load() {
+		return errors.New("shutting down")
+	}
+	if r.check == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) >= r.interval {
+		checkCtx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+		r.lastErr = r.check(checkCtx)
+		cancel()
+		r.checkedAt = time.Now()
+	}
+	return r.lastErr
+}
+
+// handleHealthz reports that the process is alive
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the server can take traffic: 503 while draining or when the
+// Bedrock check fails
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.readiness.ready(r.Context()); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// hedge is the second target an extraction is raced against when the first is slow
+type hedge struct {
+	// extractor invokes the hedge target; its own hedge is nil
+	extractor *extractor
+	// delay is how long the primary target gets before the hedge is sent
+	delay time.Duration
+	// label names the target in logs, e.g. "llama70b@us-west-2"
+	label string
+}
+
+// newHedge creates the hedge target described by spec: a model name, name@region, or @region
+// to race the same model in another region
+func (e *extractor) newHedge(ctx context.Context, spec string, delay time.Duration) (*hedge, error) {
+	name, region, _ := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), "@")
+	info := e.modelInfo
+	if name != "" {
+		var ok bool
+		if info, ok = e.lookupModel(name); !ok {
+			return nil, fmt.Errorf("invalid -hedge model %q: use %s", name, models.Usage())
+		}
+	}
+	if region == "" {
+		region = e.awsConfig.Region
+	}
+	if info.Name == e.modelInfo.Name && region == e.awsConfig.Region {
+		return nil, fmt.Errorf("-hedge %q is the same target as -model", spec)
+	}
+	if e.opts.Structured != nil && !info.SupportsStructuredOutput {
+		return nil, fmt.Errorf("structured output is not supported by the -hedge model %s", info.Name)
+	}
+	if len(e.images) > 0 && !info.SupportsImages {
+		return nil, fmt.Errorf("images are not supported by the -hedge model %s", info.Name)
+	}
+	if len(e.videos) > 0 && !info.SupportsVideo {
+		return nil, fmt.Errorf("videos are not supported by the -hedge model %s", info.Name)
+	}
+
+	other := *e
+	other.modelInfo = info
+	other.opts.Provisioned = nil
+	other.hedge = nil
+	if region != e.awsConfig.Region {
+		cfg := e.awsConfig
+		cfg.Region = region
+		client, err := bedrock.NewClient(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		other.awsConfig, other.client = cfg, client
+	}
+	other.model = other.newModel(info, other.opts)
+	return &hedge{extractor: &other, delay: delay, label: info.Name + "@" + region}, nil
+}
+
+// hedgedAttempt is the outcome of one of the raced extractions
+type hedgedAttempt struct {
+	result *bedrock.Result
+	err    error
+	hedged bool
+}
+
+// extractHedged runs the extraction on the primary target and, when it hasn't produced valid
+// output within the hedge delay or fails before then, on the hedge target too. The first valid
+// result wins and the other call is cancelled. When both fail, the primary's outcome is returned.
+func (e *extractor) extractHedged(ctx context.Context, input string) (*bedrock.Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so the losing call can finish after the winner has returned
+	attempts := make(chan hedgedAttempt, 2)
+	run := func(x *extractor, hedged bool) {
+		result, err := x.extractOnce(ctx, input)
+		attempts <- hedgedAttempt{result, err, hedged}
+	}
+	go run(e, false)
+
+	timer := time.NewTimer(e.hedge.delay)
+	defer timer.Stop()
+	launched, finished := 1, 0
+	launch := func(reason string) {
+		if launched == 1 {
+			log.Printf("%s, hedging with %s", reason, e.hedge.label)
+			go run(e.hedge.extractor, true)
+			launched = 2
+		}
+	}
+
+	var primary *hedgedAttempt
+	for {
+		select {
+		case <-timer.C:
+			launch(fmt.Sprintf("No valid %s output after %s", e.modelInfo.Name, e.hedge.delay))
+		case attempt := <-attempts:
+			finished++
+			if attempt.err == nil {
+				if attempt.hedged {
+					log.Printf("Hedge %s answered first", e.hedge.label)
+				}
+				return attempt.result, nil
+			}
+			if !attempt.hedged {
+				primary = &attempt
+				launch(fmt.Sprintf("%s failed (%v)", e.modelInfo.Name, attempt.err))
+			}
+			if finished == launched {
+				return primary.result, primary.err
+			}
+		}
+	}
+}
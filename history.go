@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/history"
+	"bedrock-llama/models"
+	"bedrock-llama/pricing"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyFileEnv is the invocation history used when -history isn't set
+const historyFileEnv = "BEDROCK_LLAMA_HISTORY"
+
+// historyInputWidth is how much of an input the history list shows
+const historyInputWidth = 40
+
+// defaultHistoryFile is the history database named by the environment, or empty. The history
+// is opt-in: it stores inputs and outputs verbatim, whatever -audit-redact is.
+func defaultHistoryFile() string {
+	return os.Getenv(historyFileEnv)
+}
+
+// historyRecorder writes every extraction of a run to the history database
+type historyRecorder struct {
+	store *history.Store
+	run   string
+}
+
+// newHistoryRecorder opens the history database at path
+func newHistoryRecorder(path string) (*historyRecorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	store, err := history.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &historyRecorder{store: store, run: newRunID()}, nil
+}
+
+// record stores the outcome of an extraction. Failures are only logged, since losing the
+// history must not fail the run.
+func (h *historyRecorder) record(ctx context.Context, e *extractor, input string, start time.Time, result *bedrock.Result, err error) {
+	if h == nil {
+		return
+	}
+	entry := history.Entry{
+		Time:          start,
+		Run:           h.run,
+		Task:          e.task.Name,
+		Prompt:        e.task.PromptRef(),
+		PromptVersion: e.task.Version(),
+		Model:         e.modelInfo.Name,
+		ModelID:       e.modelInfo.ModelID,
+		Input:         input,
+		Status:        history.StatusOK,
+		LatencyMs:     time.Since(start).Milliseconds(),
+	}
+	if turns, err := e.conversation(input); err == nil {
+		hash := sha256.Sum256([]byte(bedrock.FormatTranscript(turns)))
+		entry.PromptSHA256 = hex.EncodeToString(hash[:])
+	}
+	if result != nil {
+		// A fallback model may have produced the result
+		if info, ok := models.Lookup(result.Model); ok {
+			entry.Model, entry.ModelID = info.Name, info.ModelID
+		}
+		entry.Output = result.Text
+		entry.InputTokens, entry.OutputTokens = result.InputTokens, result.OutputTokens
+		entry.CostUSD = pricing.Cost(result.VersionedModel(), result.InputTokens, result.OutputTokens)
+		entry.Repairs = result.Repairs
+		entry.Cached = result.Cached
+	}
+	var parseErr *bedrock.ParseError
+	if errors.As(err, &parseErr) {
+		entry.Status = history.StatusInvalid
+		entry.Error = parseErr.Err.Error()
+	} else if err != nil {
+		entry.Status = history.StatusError
+		entry.Error = err.Error()
+	}
+	// Recorded even when the run is being cancelled
+	if _, err := h.store.Add(context.WithoutCancel(ctx), entry); err != nil {
+		log.Printf("Error recording history: %v", err)
+	}
+}
+
+// close closes the history database
+func (h *historyRecorder) close() {
+	if h == nil {
+		return
+	}
+	if err := h.store.Close(); err != nil {
+		log.Printf("Error closing history: %v", err)
+	}
+}
+
+// runHistory implements the history subcommands
+func runHistory(args []string) {
+	usage := fmt.Sprintf("Usage: %s history list|show|inputs|migrate [flags]", os.Args[0])
+	if len(args) == 0 {
+		fatalf("%s", usage)
+	}
+	command := args[0]
+	if command == "migrate" {
+		runHistoryMigrate(args[1:])
+		return
+	}
+	if command != "list" && command != "show" && command != "inputs" {
+		fatalf("%s", usage)
+	}
+
+	fs := flag.NewFlagSet("history "+command, flag.ExitOnError)
+	fileFlag := fs.String("history", defaultHistoryFile(), "History database written by earlier runs; defaults to $"+historyFileEnv)
+	sinceFlag := fs.String("since", "", "First day to include, as YYYY-MM-DD")
+	untilFlag := fs.String("until", "", "Last day to include, as YYYY-MM-DD")
+	taskFlag := fs.String("task", "", "Only include extractions of this task")
+	modelFlag := fs.String("model", "", "Only include extractions answered by this model")
+	statusFlag := fs.String("status", "", "Only include extractions with this status: "+strings.Join([]string{history.StatusOK, history.StatusInvalid, history.StatusError}, ", "))
+	hashFlag := fs.String("prompt-sha256", "", "Only include extractions of the prompt with this hash")
+	limitFlag := fs.Int("limit", 50, "Maximum number of extractions, the most recent first (0 for no limit)")
+	formatFlag := fs.String("format", "table", "List format: table or json")
+	fs.Parse(args[1:])
+
+	filter := history.Filter{
+		Task:         strings.ToLower(*taskFlag),
+		Model:        strings.ToLower(*modelFlag),
+		Status:       strings.ToLower(*statusFlag),
+		PromptSHA256: strings.ToLower(*hashFlag),
+		Limit:        *limitFlag,
+	}
+	switch filter.Status {
+	case "", history.StatusOK, history.StatusInvalid, history.StatusError:
+	default:
+		fatalf("Invalid status %q. Use %s, %s or %s", *statusFlag, history.StatusOK, history.StatusInvalid, history.StatusError)
+	}
+	if *sinceFlag != "" {
+		day, err := time.ParseInLocation(dayLayout, *sinceFlag, time.Local)
+		if err != nil {
+			fatalf("Invalid day %q: use YYYY-MM-DD", *sinceFlag)
+		}
+		filter.Since = day
+	}
+	if *untilFlag != "" {
+		day, err := time.ParseInLocation(dayLayout, *untilFlag, time.Local)
+		if err != nil {
+			fatalf("Invalid day %q: use YYYY-MM-DD", *untilFlag)
+		}
+		filter.Until = day.AddDate(0, 0, 1)
+	}
+	if filter.Limit < 0 {
+		fatalf("-limit can't be negative")
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "table" && format != "json" {
+		fatalf("Invalid format %q. Use table or json", *formatFlag)
+	}
+	if *fileFlag == "" {
+		fatalf("No history database: set -history or $%s", historyFileEnv)
+	}
+	if _, err := os.Stat(*fileFlag); err != nil {
+		fatalf("Error: no history at %s: %v", *fileFlag, err)
+	}
+
+	store, err := history.Open(*fileFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	if command == "show" {
+		if fs.NArg() != 1 {
+			fatalf("Usage: %s history show [flags] ID", os.Args[0])
+		}
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			fatalf("Invalid history ID %q", fs.Arg(0))
+		}
+		entry, ok, err := store.Get(ctx, id)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		if !ok {
+			fatalf("No history entry %d", id)
+		}
+		data, _ := json.MarshalIndent(entry, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	entries, err := store.List(ctx, filter)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if command == "inputs" {
+		// Oldest first, as batch JSONL input for a re-run
+		for i := len(entries) - 1; i >= 0; i-- {
+			data, _ := json.Marshal(batchItem{ID: strconv.FormatInt(entries[i].ID, 10), Input: entries[i].Input})
+			fmt.Println(string(data))
+		}
+		return
+	}
+	if format == "json" {
+		if entries == nil {
+			entries = []history.Entry{}
+		}
+		data, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tTASK\tMODEL\tSTATUS\tINPUT_TOKENS\tOUTPUT_TOKENS\tLATENCY_MS\tINPUT")
+	for _, entry := range entries {
+		input, cut := truncate(strings.Join(strings.Fields(entry.Input), " "), historyInputWidth)
+		if cut {
+			input += "..."
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", entry.ID, entry.Time.Local().Format("2006-01-02 15:04:05"),
+			entry.Task, entry.Model, entry.Status, entry.InputTokens, entry.OutputTokens, entry.LatencyMs, input)
+	}
+	w.Flush()
+}
+
+// runHistoryMigrate migrates the history database to the schema of this build, as any command
+// opening it would, or with -check-migrations reports the migrations it needs without applying them
+func runHistoryMigrate(args []string) {
+	fs := flag.NewFlagSet("history migrate", flag.ExitOnError)
+	fileFlag := fs.String("history", defaultHistoryFile(), "History database to migrate; defaults to $"+historyFileEnv)
+	checkFlag := fs.Bool("check-migrations", false, "Only report the migrations the database needs, and exit with status 1 when it needs any")
+	fs.Parse(args)
+	if *fileFlag == "" {
+		fatalf("No history database: set -history or $%s", historyFileEnv)
+	}
+
+	version, err := history.Version(*fileFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	latest := history.SchemaVersion()
+	switch {
+	case version > latest:
+		fatalf("The history %s has schema version %d, newer than this build's %d; use a newer build", *fileFlag, version, latest)
+	case version == latest:
+		fmt.Printf("The history %s is at schema version %d, up to date\n", *fileFlag, version)
+		return
+	case *checkFlag:
+		fmt.Printf("The history %s is at schema version %d and needs migrating to version %d\n", *fileFlag, version, latest)
+		os.Exit(1)
+	}
+	store, err := history.Open(*fileFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		fatalf("Error: %v", err)
+	}
+	fmt.Printf("Migrated the history %s from schema version %d to %d\n", *fileFlag, version, latest)
+}
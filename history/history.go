@@ -0,0 +1,311 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Statuses of an extraction
+const (
+	// StatusOK means the output passed the task's checks
+	StatusOK = "ok"
+	// StatusInvalid means the output never passed the checks, even after corrective re-prompts
+	StatusInvalid = "invalid"
+	// StatusError means the model couldn't be invoked
+	StatusError = "error"
+)
+
+// migrations bring a database up to the schema of this build: migrations[i] moves it from
+// version i, kept in PRAGMA user_version, to version i+1. Databases written before the schema
+// was versioned are at version 0 with the tables of version 1, which is why the first migration
+// only creates what is missing.
+var migrations = []string{
+	// Version 1 is the invocations table and the indexes the queries filter on
+	`
+CREATE TABLE IF NOT EXISTS invocations (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	time           TEXT    NOT NULL,
+	run            TEXT    NOT NULL,
+	task           TEXT    NOT NULL,
+	prompt         TEXT    NOT NULL,
+	prompt_version TEXT    NOT NULL,
+	model          TEXT    NOT NULL,
+	model_id       TEXT    NOT NULL,
+	input          TEXT    NOT NULL,
+	prompt_sha256  TEXT    NOT NULL,
+	status         TEXT    NOT NULL,
+	output         TEXT    NOT NULL,
+	input_tokens   INTEGER NOT NULL,
+	output_tokens  INTEGER NOT NULL,
+	cost_usd       REAL    NOT NULL,
+	latency_ms     INTEGER NOT NULL,
+	repairs        INTEGER NOT NULL,
+	cached         INTEGER NOT NULL,
+	error          TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS invocations_time ON invocations (time);
+CREATE INDEX IF NOT EXISTS invocations_prompt_sha256 ON invocations (prompt_sha256);
+`,
+}
+
+// timeLayout stores times in UTC with a fixed width, so they sort as text
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+// columns lists the columns of an Entry in scan order
+const columns = "id, time, run, task, prompt, prompt_version, model, model_id, input, prompt_sha256, status, output, input_tokens, output_tokens, cost_usd, latency_ms, repairs, cached, error"
+
+// Entry is one extraction: its input, the model's answer and what it cost
+type Entry struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	// Run identifies the command invocation the extraction belongs to
+	Run  string `json:"run"`
+	Task string `json:"task"`
+	// Prompt is the registry reference of the prompt, or the task name, and PromptVersion the
+	// hash of its template and schema
+	Prompt        string `json:"prompt"`
+	PromptVersion string `json:"prompt_version"`
+	Model         string `json:"model"`
+	ModelID       string `json:"model_id"`
+	Input         string `json:"input"`
+	// PromptSHA256 hashes the full conversation sent to the model
+	PromptSHA256 string  `json:"prompt_sha256"`
+	Status       string  `json:"status"`
+	Output       string  `json:"output"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CostUSD      float64 `json:"estimated_cost_usd"`
+	LatencyMs    int64   `json:"latency_ms"`
+	Repairs      int     `json:"repairs"`
+	Cached       bool    `json:"cached"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// Filter selects entries; zero fields match everything
+type Filter struct {
+	// Since and Until bound the entry time, inclusive and exclusive
+	Since, Until time.Time
+	Task         string
+	Model        string
+	Status       string
+	PromptSHA256 string
+	// Limit caps the number of entries, the most recent first
+	Limit int
+}
+
+// Store is a SQLite database of extractions. It is safe for concurrent use, also by several
+// processes sharing the file.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database at path
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %v", err)
+	}
+	// SQLite allows one writer at a time; a single connection queues them instead of failing
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open history %s: %v", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// SchemaVersion is the schema version this build migrates databases to
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// Version returns the schema version of the database at path without migrating it, for a dry
+// run of the migrations Open would apply
+func Version(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("failed to open history: %v", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return 0, fmt.Errorf("failed to open history: %v", err)
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read the schema version of %s: %v", path, err)
+	}
+	return version, nil
+}
+
+// migrate applies the migrations the database is missing, each in a transaction with its
+// version bump
+func migrate(db *sql.DB) error {
+	for {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		var version int
+		if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if version >= len(migrations) {
+			tx.Rollback()
+			if version > len(migrations) {
+				return fmt.Errorf("schema version %d is newer than this build's %d", version, len(migrations))
+			}
+			return nil
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration to schema version %d: %v", version+1, err)
+		}
+		// PRAGMA takes no parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+// Add stores an entry and returns its ID
+func (s *Store) Add(ctx context.Context, entry Entry) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "INSERT INTO invocations ("+strings.TrimPrefix(columns, "id, ")+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Time.UTC().Format(timeLayout), entry.Run, entry.Task, entry.Prompt, entry.PromptVersion, entry.Model,
+		entry.ModelID, entry.Input, entry.PromptSHA256, entry.Status, entry.Output, entry.InputTokens, entry.OutputTokens,
+		entry.CostUSD, entry.LatencyMs, entry.Repairs, entry.Cached, entry.Error)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write history: %v", err)
+	}
+	return res.LastInsertId()
+}
+
+// Get returns the entry with an ID; ok is false when there is none
+func (s *Store) Get(ctx context.Context, id int64) (entry Entry, ok bool, err error) {
+	entries, err := s.query(ctx, "WHERE id = ?", id)
+	if err != nil || len(entries) == 0 {
+		return Entry{}, false, err
+	}
+	return entries[0], true, nil
+}
+
+// List returns the entries matching a filter, the most recent first
+func (s *Store) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	var conditions []string
+	var args []any
+	add := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if !filter.Since.IsZero() {
+		add("time >= ?", filter.Since.UTC().Format(timeLayout))
+	}
+	if !filter.Until.IsZero() {
+		add("time < ?", filter.Until.UTC().Format(timeLayout))
+	}
+	if filter.Task != "" {
+		add("task = ?", filter.Task)
+	}
+	if filter.Model != "" {
+		add("model = ?", filter.Model)
+	}
+	if filter.Status != "" {
+		add("status = ?", filter.Status)
+	}
+	if filter.PromptSHA256 != "" {
+		add("prompt_sha256 = ?", filter.PromptSHA256)
+	}
+
+	clause := ""
+	if len(conditions) > 0 {
+		clause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	clause += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		clause += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	return s.query(ctx, clause, args...)
+}
+
+// query returns the entries selected by a WHERE/ORDER BY clause
+func (s *Store) query(ctx context.Context, clause string, args ...any) ([]Entry, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM invocations "+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var at string
+		err := rows.Scan(&entry.ID, &at, &entry.Run, &entry.Task, &entry.Prompt, &entry.PromptVersion, &entry.Model,
+			&entry.ModelID, &entry.Input, &entry.PromptSHA256, &entry.Status, &entry.Output, &entry.InputTokens, &entry.OutputTokens,
+			&entry.CostUSD, &entry.LatencyMs, &entry.Repairs, &entry.Cached, &entry.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history: %v", err)
+		}
+		if entry.Time, err = time.Parse(timeLayout, at); err != nil {
+			return nil, fmt.Errorf("history entry %d: invalid time %q", entry.ID, at)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	return entries, nil
+}
+
+// Compact clears the inputs and outputs of the entries before stripBefore, keeping their
+// prompt hash, tokens, cost and latency, and deletes the entries before deleteBefore; a zero
+// time skips either. With dryRun, it only counts the entries it would change.
+func (s *Store) Compact(ctx context.Context, stripBefore, deleteBefore time.Time, dryRun bool) (stripped, deleted int64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to compact history: %v", err)
+	}
+	defer tx.Rollback()
+	if !deleteBefore.IsZero() {
+		res, err := tx.ExecContext(ctx, "DELETE FROM invocations WHERE time < ?", deleteBefore.UTC().Format(timeLayout))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to compact history: %v", err)
+		}
+		deleted, _ = res.RowsAffected()
+	}
+	if !stripBefore.IsZero() {
+		res, err := tx.ExecContext(ctx, "UPDATE invocations SET input = '', output = '' WHERE time < ? AND (input != '' OR output != '')", stripBefore.UTC().Format(timeLayout))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to compact history: %v", err)
+		}
+		stripped, _ = res.RowsAffected()
+	}
+	if dryRun {
+		return stripped, deleted, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to compact history: %v", err)
+	}
+	// VACUUM gives the freed pages back to the file system; it can't run in a transaction
+	if stripped > 0 || deleted > 0 {
+		if _, err := s.db.ExecContext(ctx, "VACUUM"); err != nil {
+			return stripped, deleted, fmt.Errorf("failed to vacuum history: %v", err)
+		}
+	}
+	return stripped, deleted, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
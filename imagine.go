@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runImagine implements the imagine subcommand, which generates images from a text prompt
+func runImagine(args []string) {
+	fs := flag.NewFlagSet("imagine", flag.ExitOnError)
+	f := &flags{}
+	modelFlag := fs.String("model", models.ImageNames()[0], "The image model to use: "+strings.Join(models.ImageNames(), ", "))
+	promptFlag := fs.String("prompt", "", "Description of the image to generate; defaults to the arguments")
+	negativeFlag := fs.String("negative-prompt", "", "What the image must not show")
+	widthFlag := fs.Int("width", 0, "Image width in pixels (0 for the model's default)")
+	heightFlag := fs.Int("height", 0, "Image height in pixels (0 for the model's default)")
+	countFlag := fs.Int("count", 1, "Number of images to generate")
+	seedFlag := fs.Int("seed", -1, "Seed to reproduce earlier images with (-1 for a random seed)")
+	cfgScaleFlag := fs.Float64("cfg-scale", 0, "How closely the image follows the prompt (0 for the model's default)")
+	stepsFlag := fs.Int("steps", 0, "Number of diffusion steps, for models that take it such as sdxl (0 for the model's default)")
+	qualityFlag := fs.String("quality", "", "Image quality, 'standard' or 'premium', for models that take it")
+	outputFlag := fs.String("output", "image.png", "PNG file to write; with -count above 1, the images are numbered, e.g. image-1.png")
+	regionFlag := fs.String("region", "", "Region to generate in, where the image model is available; defaults to $AWS_REGION")
+	f.endpoint = fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL; defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	f.fips = fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	maxAttemptsFlag := fs.Int("max-attempts", 5, "Maximum attempts when Bedrock throttles the request (1 disables retries)")
+	timeoutFlag := fs.Duration("timeout", 2*time.Minute, "Time limit for the request")
+	registerSDKRetryFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	info, ok := models.LookupImage(strings.ToLower(*modelFlag))
+	if !ok {
+		fatalf("Unknown image model %q. Use %s", *modelFlag, strings.Join(models.ImageNames(), ", "))
+	}
+	prompt := *promptFlag
+	if prompt == "" {
+		prompt = strings.Join(fs.Args(), " ")
+	}
+	if strings.TrimSpace(prompt) == "" {
+		fatalf("Usage: %s imagine [flags] PROMPT", os.Args[0])
+	}
+	if *countFlag < 1 || *widthFlag < 0 || *heightFlag < 0 || *stepsFlag < 0 || *cfgScaleFlag < 0 {
+		fatalf("-count must be positive, and -width, -height, -steps and -cfg-scale can't be negative")
+	}
+	quality := strings.ToLower(*qualityFlag)
+	if quality != "" && quality != "standard" && quality != "premium" {
+		fatalf("Invalid quality %q. Use standard or premium", *qualityFlag)
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *regionFlag != "" {
+		cfg.Region = *regionFlag
+	}
+	ctx := context.Background()
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	model := info.New(client, bedrock.Options{
+		Retry:   bedrock.RetryPolicy{MaxAttempts: *maxAttemptsFlag},
+		Timeout: *timeoutFlag,
+	})
+
+	log.Printf("Generating %d image(s) with %s in %s...", *countFlag, info.DisplayName, cfg.Region)
+	result, err := model.Generate(ctx, bedrock.ImageRequest{
+		Prompt:         prompt,
+		NegativePrompt: *negativeFlag,
+		Width:          *widthFlag,
+		Height:         *heightFlag,
+		Count:          *countFlag,
+		Seed:           *seedFlag,
+		CFGScale:       *cfgScaleFlag,
+		Steps:          *stepsFlag,
+		Quality:        quality,
+	})
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	log.Printf("Seed: %d", result.Seed)
+
+	for i, image := range result.Images {
+		path := *outputFlag
+		if len(result.Images) > 1 {
+			ext := filepath.Ext(path)
+			path = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+		}
+		if err := os.WriteFile(path, image, 0o644); err != nil {
+			fatalf("Error writing image: %v", err)
+		}
+		fmt.Println(path)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// junitTestSuites is the root of a JUnit XML report, the format CI systems display test results in
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the cases of one evaluated model
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// junitTestCase is a dataset case; Failure is set for a mismatch and Error for a failed
+// extraction, or SystemOut describes them when the accuracy case decides the outcome
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeEvalJUnit writes the eval report to a JUnit XML file, with a test suite per model and a
+// test case per dataset case. With a minAccuracy above 0, each suite also gets an accuracy case
+// that fails when the model's accuracy is below it, and is the only case that fails: like the
+// exit status of eval, the report then passes when enough cases match, and the mismatches and
+// failed extractions are described in the system-out of their cases.
+func writeEvalJUnit(path string, report *evalReport, minAccuracy float64) error {
+	root := junitTestSuites{Name: "eval " + report.Dataset}
+	var totalMs int64
+	for _, score := range report.Scores {
+		suite := junitTestSuite{
+			Name: score.Model,
+			Properties: []junitProperty{
+				{"dataset", report.Dataset},
+				{"task", report.Task},
+				{"field", report.Field},
+				{"match", report.Match},
+				{"accuracy", strconv.FormatFloat(score.Accuracy, 'f', 4, 64)},
+			},
+		}
+		var suiteMs int64
+		for _, result := range report.Results {
+			if result.Model != score.Model {
+				continue
+			}
+			tc := junitTestCase{Name: result.ID, ClassName: "eval." + score.Model, Time: junitSeconds(result.LatencyMs)}
+			switch {
+			case result.Error != "" && minAccuracy > 0:
+				tc.SystemOut = fmt.Sprintf("error: %s\ninput: %s", result.Error, result.Input)
+			case result.Error != "":
+				tc.Error = &junitFailure{Message: result.Error, Type: "error", Text: "input: " + result.Input}
+				suite.Errors++
+			case !result.Match && minAccuracy > 0:
+				tc.SystemOut = fmt.Sprintf("%s mismatch: expected %q, got %q\ninput: %s", report.Match, result.Expected, result.Predicted, result.Input)
+			case !result.Match:
+				tc.Failure = &junitFailure{
+					Message: fmt.Sprintf("expected %q, got %q", result.Expected, result.Predicted),
+					Type:    report.Match,
+					Text:    "input: " + result.Input,
+				}
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, tc)
+			suiteMs += result.LatencyMs
+		}
+		if minAccuracy > 0 {
+			tc := junitTestCase{Name: "accuracy", ClassName: "eval." + score.Model, Time: junitSeconds(0)}
+			if score.Accuracy < minAccuracy {
+				tc.Failure = &junitFailure{
+					Message: fmt.Sprintf("accuracy %.4f is below %g", score.Accuracy, minAccuracy),
+					Type:    "min-accuracy",
+					Text:    fmt.Sprintf("%d of %d cases matched with %s", score.Matches, score.Cases, report.Match),
+				}
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		suite.Tests = len(suite.Cases)
+		suite.Time = junitSeconds(suiteMs)
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Errors += suite.Errors
+		totalMs += suiteMs
+		root.Suites = append(root.Suites, suite)
+	}
+	root.Time = junitSeconds(totalMs)
+
+	data, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JUnit report: %v", err)
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %v", err)
+	}
+	return nil
+}
+
+// junitSeconds formats a duration in milliseconds as the seconds of a JUnit time attribute
+func junitSeconds(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', 3, 64)
+}
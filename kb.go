@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runKB implements the kb subcommand, which answers a query from a Bedrock Knowledge Base, or
+// only returns the chunks that match it
+func runKB(args []string) {
+	fs := flag.NewFlagSet("kb", flag.ExitOnError)
+	f := &flags{}
+	kbIDFlag := fs.String("kb-id", "", "ID of the knowledge base to query")
+	queryFlag := fs.String("query", "", "The question or search text; defaults to the arguments")
+	retrieveFlag := fs.Bool("retrieve", false, "Only list the matching chunks with their scores and sources, without generating an answer")
+	modelFlag := fs.String("model", "nova", "Model that generates the answer: "+models.Usage()+", or a model ID or ARN")
+	resultsFlag := fs.Int("results", 0, "Number of chunks to retrieve (0 for the service's default of 5)")
+	searchFlag := fs.String("search", "", "Search type: 'hybrid' or 'semantic'; the knowledge base's default when empty")
+	minScoreFlag := fs.Float64("min-score", 0, "With -retrieve, leave out chunks scoring below this relevance")
+	sessionIDFlag := fs.String("session-id", "", "Session of an earlier answer to continue, as logged by that run")
+	formatFlag := fs.String("format", "text", "Output format: text (the answer, or a table of chunks) or json")
+	regionFlag := fs.String("region", "", "Region of the knowledge base; defaults to $AWS_REGION")
+	// Knowledge bases are queried through the Bedrock Agents runtime, so there's no Bedrock runtime endpoint to override
+	f.endpoint = new(string)
+	f.fips = fs.Bool("fips", false, "Use the FIPS endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	maxAttemptsFlag := fs.Int("max-attempts", 5, "Maximum attempts when Bedrock throttles the request (1 disables retries)")
+	timeoutFlag := fs.Duration("timeout", 2*time.Minute, "Time limit for the request")
+	registerSDKRetryFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	query := *queryFlag
+	if query == "" {
+		query = strings.Join(fs.Args(), " ")
+	}
+	if *kbIDFlag == "" || strings.TrimSpace(query) == "" {
+		fatalf("Usage: %s kb -kb-id ID [flags] QUERY, or %s kb -kb-id ID -retrieve [flags] QUERY", os.Args[0], os.Args[0])
+	}
+	if *resultsFlag < 0 || *resultsFlag > 100 {
+		fatalf("-results must be between 0 and 100")
+	}
+	if err := bedrock.ValidateSearchType(*searchFlag); err != nil {
+		fatalf("%v", err)
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "text" && format != "json" {
+		fatalf("Invalid format %q. Use text or json", *formatFlag)
+	}
+	if *retrieveFlag && *sessionIDFlag != "" {
+		fatalf("-session-id only applies to generated answers, not to -retrieve")
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *regionFlag != "" {
+		cfg.Region = *regionFlag
+	}
+	ctx := context.Background()
+	client, err := bedrock.NewAgentClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	kbQuery := bedrock.KnowledgeBaseQuery{
+		KnowledgeBaseID: *kbIDFlag,
+		Text:            query,
+		Results:         *resultsFlag,
+		SearchType:      strings.ToLower(*searchFlag),
+		Retry:           bedrock.RetryPolicy{MaxAttempts: *maxAttemptsFlag},
+		Timeout:         *timeoutFlag,
+	}
+
+	if *retrieveFlag {
+		log.Printf("Searching knowledge base %s...", *kbIDFlag)
+		chunks, err := bedrock.Retrieve(ctx, client, kbQuery)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		kept := []bedrock.RetrievedChunk{}
+		for _, chunk := range chunks {
+			if chunk.Score >= *minScoreFlag {
+				kept = append(kept, chunk)
+			}
+		}
+		if format == "json" {
+			data, _ := json.MarshalIndent(kept, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RANK\tSCORE\tSOURCE\tTEXT")
+		for i, chunk := range kept {
+			text, cut := truncate(strings.Join(strings.Fields(chunk.Text), " "), 80)
+			if cut {
+				text += "…"
+			}
+			fmt.Fprintf(w, "%d\t%.4f\t%s\t%s\n", i+1, chunk.Score, chunk.Source, text)
+		}
+		w.Flush()
+		return
+	}
+
+	modelARN := *modelFlag
+	if info, ok := models.Lookup(strings.ToLower(modelARN)); ok {
+		modelARN = info.ModelID
+	}
+	modelARN = bedrock.FoundationModelARN(cfg.Region, modelARN)
+	log.Printf("Asking knowledge base %s with %s...", *kbIDFlag, modelARN)
+	answer, err := bedrock.RetrieveAndGenerate(ctx, client, bedrock.GenerateRequest{
+		KnowledgeBaseQuery: kbQuery,
+		ModelARN:           modelARN,
+		SessionID:          *sessionIDFlag,
+	})
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if format == "json" {
+		data, _ := json.MarshalIndent(answer, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		fmt.Println(strings.TrimSpace(answer.Text))
+		seen := map[string]bool{}
+		for _, citation := range answer.Citations {
+			for _, source := range citation.Sources {
+				if !seen[source] {
+					seen[source] = true
+					log.Printf("Source: %s", source)
+				}
+			}
+		}
+	}
+	log.Printf("Continue the conversation with -session-id %s", answer.SessionID)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// lambdaFlagsEnv holds the flags of the Lambda handler when the runtime starts the binary
+// without arguments, e.g. "-task=movie -model=claude"
+const lambdaFlagsEnv = "BEDROCK_LLAMA_FLAGS"
+
+// runLambda serves the extraction as an AWS Lambda handler. Both API Gateway proxy events
+// (REST and HTTP APIs) and direct invocations are accepted.
+func runLambda(args []string) {
+	fs := flag.NewFlagSet("lambda", flag.ExitOnError)
+	f := registerFlags(fs)
+	fs.Parse(args)
+
+	e := newExtractor(context.Background(), f)
+	log.Printf("Starting Lambda handler for the %s task with %s", e.task.Name, e.modelInfo.Name)
+	// Each invocation flushes what it recorded; the extractor is closed when the runtime shuts
+	// the execution environment down, which it signals with SIGTERM
+	lambda.StartWithOptions(e.handleLambda, lambda.WithEnableSIGTERM(e.close))
+}
+
+// lambdaArgs returns the flags for the Lambda handler from the environment
+func lambdaArgs() []string {
+	return strings.Fields(os.Getenv(lambdaFlagsEnv))
+}
+
+// apiGatewayEnvelope holds the fields shared by API Gateway REST and HTTP API proxy events
+type apiGatewayEnvelope struct {
+	RequestContext  json.RawMessage `json:"requestContext"`
+	Body            string          `json:"body"`
+	IsBase64Encoded bool            `json:"isBase64Encoded"`
+}
+
+// handleLambda answers one Lambda event. API Gateway events carry the same body as
+// POST /extract and get the same responses. Direct invocations pass that body as the event,
+// or just the input as a JSON string, and get the result back; failed model calls are returned
+// as errors so Lambda's retries and failure destinations apply.
+func (e *extractor) handleLambda(ctx context.Context, event json.RawMessage) (any, error) {
+	// The execution environment may be frozen as soon as the handler returns
+	defer flushTraces(ctx)
+	defer e.audit.flush()
+	defer e.metrics.usage.flush()
+
+	if bytes.HasPrefix(bytes.TrimSpace(event), []byte(`"`)) {
+		var input string
+		if err := json.Unmarshal(event, &input); err != nil {
+			return nil, fmt.Errorf("invalid event: %v", err)
+		}
+		return e.invokeDirect(ctx, extractRequest{batchItem: batchItem{Input: input}})
+	}
+
+	var envelope apiGatewayEnvelope
+	if err := json.Unmarshal(event, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	if envelope.RequestContext != nil {
+		return e.handleAPIGateway(ctx, envelope), nil
+	}
+
+	var req extractRequest
+	if err := json.Unmarshal(event, &req); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	return e.invokeDirect(ctx, req)
+}
+
+// invokeDirect runs the task for a direct invocation
+func (e *extractor) invokeDirect(ctx context.Context, req extractRequest) (*batchOutput, error) {
+	if strings.TrimSpace(req.Input) == "" {
+		return nil, errors.New(`"input" is required`)
+	}
+	e, err := e.forModel(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Invoke model=%s input=%q", e.modelInfo.Name, req.Input)
+	out := e.runItem(ctx, req.batchItem)
+	if out.Error != "" && !out.invalid {
+		return nil, errors.New(out.Error)
+	}
+	return &out, nil
+}
+
+// handleAPIGateway runs the task for an API Gateway request, answering like POST /extract
+func (e *extractor) handleAPIGateway(ctx context.Context, envelope apiGatewayEnvelope) events.APIGatewayProxyResponse {
+	body := []byte(envelope.Body)
+	if envelope.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(envelope.Body)
+		if err != nil {
+			return apiGatewayError(http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		}
+		body = decoded
+	}
+	if len(body) > maxRequestSize {
+		return apiGatewayError(http.StatusRequestEntityTooLarge, "request body too large")
+	}
+
+	var req extractRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return apiGatewayError(http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+	}
+	if strings.TrimSpace(req.Input) == "" {
+		return apiGatewayError(http.StatusBadRequest, `"input" is required`)
+	}
+	e, err := e.forModel(req.Model)
+	if err != nil {
+		return apiGatewayError(http.StatusBadRequest, err.Error())
+	}
+
+	log.Printf("API Gateway request model=%s input=%q", e.modelInfo.Name, req.Input)
+	out := e.runItem(ctx, req.batchItem)
+	return apiGatewayJSON(outputStatus(out), out)
+}
+
+// apiGatewayJSON builds a JSON proxy response
+func apiGatewayJSON(status int, body any) events.APIGatewayProxyResponse {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		status, data = http.StatusInternalServerError, []byte(`{"error":"failed to encode response"}`)
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(data),
+	}
+}
+
+// apiGatewayError builds a JSON error proxy response
+func apiGatewayError(status int, message string) events.APIGatewayProxyResponse {
+	return apiGatewayJSON(status, map[string]string{"error": message})
+}
@@ -1,22 +1,50 @@
 package llama70b
 
 import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/extract"
+	"bedrock-llama/logging"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 )
 
 // ModelID is the AWS Bedrock inference profile ARN for Llama 3.3 70B
 const ModelID = "arn:aws:bedrock:us-east-2:913524932967:inference-profile/us.meta.llama3-3-70b-instruct-v1:0"
 
+// Name is the short model name used on the command line
+const Name = "llama70b"
+
+// SupportsLatencyOptimized reports whether the model accepts performanceConfig latency=optimized
+// (Llama 3.3 70B does not support latency-optimized inference)
+const SupportsLatencyOptimized = false
+
+// SupportsStructuredOutput reports whether the model can be forced to call a tool for structured output
+const SupportsStructuredOutput = false
+
+// ContextWindow is the maximum number of tokens of a prompt and its response (Llama 3.3 70B)
+const ContextWindow = 128000
+
+// SupportsCountTokens reports whether Bedrock's CountTokens API counts the model's prompts
+const SupportsCountTokens = false
+
+// SupportsImages reports whether the model accepts images in its messages
+const SupportsImages = false
+
+// SupportsVideo reports whether the model accepts videos in its messages
+const SupportsVideo = false
+
+// SupportsThinking reports whether the model can reason before it answers (extended thinking)
+const SupportsThinking = false
+
+// AlwaysReasons reports whether the model reasons before every answer, without a thinking budget
+const AlwaysReasons = false
+
 // Payload represents the request payload for the Meta Llama 3.3 70B model
 // Note: The structure is the same as Llama 3.2, but we use different default values
 type Payload struct {
@@ -28,40 +56,40 @@ type Payload struct {
 
 // Response represents the response from the Meta Llama 3.3 70B model
 type Response struct {
-	Generation string `json:"generation"`
-	Usage      struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
+	Generation           string `json:"generation"`
+	StopReason           string `json:"stop_reason"`
+	PromptTokenCount     int    `json:"prompt_token_count"`
+	GenerationTokenCount int    `json:"generation_token_count"`
+	// Usage holds the token counts of the body, as the other model packages report them
+	Usage struct {
+		InputTokens  int
+		OutputTokens int
+	} `json:"-"`
+	// Latency is the performanceConfig latency Bedrock served, taken from the response metadata
+	Latency string `json:"-"`
+	// Guardrail is the trace of the guardrail when it intervened
+	Guardrail *bedrock.GuardrailTrace `json:"-"`
 }
 
 // InvokeModel calls the Llama 3.3 70B model with the given prompt
-func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKey, awsRegion string) (*Response, error) {
-	// Debug output to verify prompt
-	log.Printf("=== PROMPT ===\n%s\n============", prompt)
-
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(awsRegion),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			accessKeyId,
-			secretAccessKey,
-			"", // Session token (empty for regular access keys)
-		)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
-	}
+func InvokeModel(ctx context.Context, client bedrock.Invoker, prompt string, opts bedrock.Options) (*Response, error) {
+	return InvokeConversation(ctx, client, bedrock.UserTurn(prompt), opts)
+}
 
-	// Create a Bedrock Runtime client
-	client := bedrockruntime.NewFromConfig(cfg)
+// InvokeConversation calls the Llama 3.3 70B model with a multi-turn conversation
+func InvokeConversation(ctx context.Context, client bedrock.Invoker, turns []bedrock.Turn, opts bedrock.Options) (*Response, error) {
+	prompt := bedrock.FormatLlama3(opts.System, turns)
+
+	// Debug output to verify prompt
+	logging.Debugf("=== PROMPT ===\n%s\n============", prompt)
 
 	// Prepare payload according to Meta Llama 3.3 70B requirements
 	// Using recommended settings for the 70B model with lower temperature
 	payload := Payload{
 		Prompt:      prompt,
-		MaxGenLen:   64,   // Reduced from 128 to further limit output
-		Temperature: 0.01, // Further reduced to make output more deterministic
-		TopP:        0.5,  // Reduced to focus on the most likely tokens
+		MaxGenLen:   opts.MaxTokensOr(64),     // Reduced from 128 to further limit output
+		Temperature: opts.TemperatureOr(0.01), // Further reduced to make output more deterministic
+		TopP:        opts.TopPOr(0.5),         // Reduced to focus on the most likely tokens
 	}
 
 	payloadBytes, err := json.Marshal(payload)
@@ -70,24 +98,25 @@ func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKe
 	}
 
 	// Debug: Log the payload being sent to the model
-	log.Printf("=== PAYLOAD ===\n%s\n=============", string(payloadBytes))
+	logging.Debugf("=== PAYLOAD ===\n%s\n=============", string(payloadBytes))
 
 	// Create the input for the InvokeModel operation
 	input := &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(ModelID),
-		ContentType: aws.String("application/json"),
-		Accept:      aws.String("application/json"),
-		Body:        payloadBytes,
+		ModelId:                  aws.String(ModelID),
+		ContentType:              aws.String("application/json"),
+		Accept:                   aws.String("application/json"),
+		Body:                     payloadBytes,
+		PerformanceConfigLatency: opts.PerformanceLatency("Llama 3.3 70B", SupportsLatencyOptimized),
 	}
 
 	// Invoke the model
-	output, err := client.InvokeModel(ctx, input)
+	output, err := bedrock.Invoke(ctx, client, input, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error invoking Bedrock Llama 3.3 70B model: %v", err)
+		return nil, fmt.Errorf("error invoking Bedrock Llama 3.3 70B model: %w", err)
 	}
 
 	// Debug: Log the raw response
-	log.Printf("=== RAW RESPONSE ===\n%s\n==================", string(output.Body))
+	logging.Debugf("=== RAW RESPONSE ===\n%s\n==================", string(output.Body))
 
 	var response Response
 	if err := json.Unmarshal(output.Body, &response); err != nil {
@@ -96,28 +125,91 @@ func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKe
 
 	// Debug: Log the parsed response structure
 	responseBytes, _ := json.MarshalIndent(response, "", "  ")
-	log.Printf("=== PARSED RESPONSE ===\n%s\n=====================", string(responseBytes))
+	logging.Debugf("=== PARSED RESPONSE ===\n%s\n=====================", string(responseBytes))
+
+	response.Usage.InputTokens, response.Usage.OutputTokens = response.PromptTokenCount, response.GenerationTokenCount
+
+	if bedrock.GuardrailIntervened(output.Body) {
+		response.StopReason = bedrock.StopGuardrailIntervened
+		response.Guardrail = bedrock.ParseGuardrailTrace(output.Body)
+	}
+	response.Latency = string(output.PerformanceConfigLatency)
+	opts.LogLatency(response.Latency)
 
 	return &response, nil
 }
 
+// Text returns the generated text from the Llama 3.3 70B response
+func (r *Response) Text() string {
+	return r.Generation
+}
+
+type model struct {
+	client bedrock.Runtime
+	opts   bedrock.Options
+}
+
+// New returns a bedrock.Model that invokes Llama 3.3 70B through the given client
+func New(client bedrock.Runtime, opts bedrock.Options) bedrock.Model {
+	return &model{client: client, opts: opts}
+}
+
+func (m *model) Name() string {
+	return Name
+}
+
+func (m *model) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *model) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	// The native request body can't carry documents or stop sequences, the Converse API can
+	if bedrock.HasDocuments(turns) || len(m.opts.StopSequences) > 0 {
+		return m.Stream(ctx, turns, func(string) {})
+	}
+	response, err := InvokeConversation(ctx, m.client, turns, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &bedrock.Result{
+		Model:        Name,
+		Text:         response.Text(),
+		InputTokens:  response.Usage.InputTokens,
+		OutputTokens: response.Usage.OutputTokens,
+		Latency:      response.Latency,
+		Guardrail:    response.Guardrail,
+		StopReason:   response.StopReason,
+	}
+	// A filtered response is returned with the error, so its tokens are still accounted for
+	return result, bedrock.CheckStopReason(result)
+}
+
+// Stream streams the conversation through the Converse API
+func (m *model) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	return bedrock.ConverseStream(ctx, m.client, bedrock.StreamRequest{
+		Model:         Name,
+		ModelID:       ModelID,
+		Latency:       m.opts.PerformanceLatency(Name, SupportsLatencyOptimized),
+		Retry:         m.opts.Retry,
+		Timeout:       m.opts.Timeout,
+		MaxTokens:     m.opts.MaxTokens,
+		System:        m.opts.System,
+		StopSequences: m.opts.StopSequences,
+		Temperature:   m.opts.Temperature,
+		TopP:          m.opts.TopP,
+		Guardrail:     m.opts.Guardrail,
+	}, turns, onText)
+}
+
 // PrintResponse formats and prints the Llama 3.3 70B model response
 func PrintResponse(response *Response) {
 	output := response.Generation
 
-	// Try to find the JSON array pattern and extract it
-	jsonPattern := regexp.MustCompile(`\[\s*{\s*"series"\s*:\s*"([^"]*)"\s*}\s*\]`)
-	if match := jsonPattern.FindStringSubmatch(output); len(match) > 1 {
-		fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
+	if series, err := extract.Series(output); err == nil {
+		fmt.Println(extract.FormatSeries(series))
 	} else {
-		// Try a fallback approach to extract just the series name
-		seriesPattern := regexp.MustCompile(`"series"\s*:\s*"([^"]*)"`)
-		if match := seriesPattern.FindStringSubmatch(output); len(match) > 1 {
-			fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
-		} else {
-			// Last resort: print the cleaned response
-			fmt.Println(strings.TrimSpace(output))
-		}
+		// Last resort: print the cleaned response
+		fmt.Println(strings.TrimSpace(output))
 	}
 
 	// Print token usage information as logs
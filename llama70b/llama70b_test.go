@@ -0,0 +1,166 @@
+package llama70b
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/bedrock/bedrocktest"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// answer is a canned response body of a plain text answer
+const answer = `{"generation": "[{\"series\": \"Friends\"}]", "prompt_token_count": 95,
+	"generation_token_count": 10, "stop_reason": "stop"}`
+
+// field is the expected value at a path of the marshalled payload; nil when it must be absent
+type field struct {
+	path []any
+	want any
+}
+
+func TestInvokeConversationPayload(t *testing.T) {
+	turns := []bedrock.Turn{{Role: bedrock.RoleUser, Text: "Friends Season 1 Episode 3"}}
+	tests := []struct {
+		name   string
+		turns  []bedrock.Turn
+		opts   bedrock.Options
+		fields []field
+	}{
+		{
+			name:  "defaults",
+			turns: turns,
+			fields: []field{
+				{[]any{"prompt"}, "<|begin_of_text|><|start_header_id|>user<|end_header_id|>\n\nFriends Season 1 Episode 3<|eot_id|>" +
+					"<|start_header_id|>assistant<|end_header_id|>\n\n"},
+				{[]any{"max_gen_len"}, 64.0},
+				{[]any{"temperature"}, 0.01},
+				{[]any{"top_p"}, 0.5},
+			},
+		},
+		{
+			name:  "output cap and system prompt",
+			turns: turns,
+			opts:  bedrock.Options{MaxTokens: 32, System: "Answer in JSON."},
+			fields: []field{
+				{[]any{"prompt"}, "<|begin_of_text|><|start_header_id|>system<|end_header_id|>\n\nAnswer in JSON.<|eot_id|>" +
+					"<|start_header_id|>user<|end_header_id|>\n\nFriends Season 1 Episode 3<|eot_id|>" +
+					"<|start_header_id|>assistant<|end_header_id|>\n\n"},
+				{[]any{"max_gen_len"}, 32.0},
+			},
+		},
+		{
+			name: "conversation",
+			turns: []bedrock.Turn{
+				{Role: bedrock.RoleUser, Text: "Which series?"},
+				{Role: bedrock.RoleAssistant, Text: "Friends"},
+				{Role: bedrock.RoleUser, Text: "As JSON"},
+			},
+			fields: []field{
+				{[]any{"prompt"}, "<|begin_of_text|><|start_header_id|>user<|end_header_id|>\n\nWhich series?<|eot_id|>" +
+					"<|start_header_id|>assistant<|end_header_id|>\n\nFriends<|eot_id|>" +
+					"<|start_header_id|>user<|end_header_id|>\n\nAs JSON<|eot_id|>" +
+					"<|start_header_id|>assistant<|end_header_id|>\n\n"},
+			},
+		},
+		{
+			name:  "prompt already in the template",
+			turns: []bedrock.Turn{{Role: bedrock.RoleUser, Text: "<|begin_of_text|>raw prompt"}},
+			fields: []field{
+				{[]any{"prompt"}, "<|begin_of_text|>raw prompt"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: answer}
+			if _, err := InvokeConversation(context.Background(), client, tt.turns, tt.opts); err != nil {
+				t.Fatalf("InvokeConversation: %v", err)
+			}
+			if got := client.ModelID(t); got != ModelID {
+				t.Errorf("model ID = %q, want %q", got, ModelID)
+			}
+			payload := client.Payload(t)
+			for _, f := range tt.fields {
+				if got := bedrocktest.Field(payload, f.path...); !reflect.DeepEqual(got, f.want) {
+					t.Errorf("%v = %#v, want %#v", f.path, got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvokeConversationResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		text         string
+		stopReason   string
+		inputTokens  int
+		outputTokens int
+	}{
+		{
+			name:         "text",
+			body:         answer,
+			text:         `[{"series": "Friends"}]`,
+			stopReason:   "stop",
+			inputTokens:  95,
+			outputTokens: 10,
+		},
+		{
+			name:         "cut off at the output cap",
+			body:         `{"generation": "[{\"series\": \"Fri", "prompt_token_count": 95, "generation_token_count": 4, "stop_reason": "length"}`,
+			text:         `[{"series": "Fri`,
+			stopReason:   "length",
+			inputTokens:  95,
+			outputTokens: 4,
+		},
+		{
+			name: "guardrail intervention",
+			body: `{"generation": "Sorry.", "prompt_token_count": 10, "generation_token_count": 2, "stop_reason": "stop",
+				"amazon-bedrock-guardrailAction": "INTERVENED"}`,
+			text:         "Sorry.",
+			stopReason:   bedrock.StopGuardrailIntervened,
+			inputTokens:  10,
+			outputTokens: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: tt.body}
+			response, err := InvokeModel(context.Background(), client, "Friends Season 1 Episode 3", bedrock.Options{})
+			if err != nil {
+				t.Fatalf("InvokeModel: %v", err)
+			}
+			if got := response.Text(); got != tt.text {
+				t.Errorf("Text() = %q, want %q", got, tt.text)
+			}
+			if response.StopReason != tt.stopReason {
+				t.Errorf("StopReason = %q, want %q", response.StopReason, tt.stopReason)
+			}
+			if response.Usage.InputTokens != tt.inputTokens || response.Usage.OutputTokens != tt.outputTokens {
+				t.Errorf("usage = %d/%d tokens, want %d/%d", response.Usage.InputTokens, response.Usage.OutputTokens, tt.inputTokens, tt.outputTokens)
+			}
+		})
+	}
+}
+
+func TestInvokeConversationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *bedrocktest.Invoker
+		want   string
+	}{
+		{"request fails", &bedrocktest.Invoker{Err: errors.New("connection refused")}, "error invoking Bedrock Llama 3.3 70B model"},
+		{"invalid body", &bedrocktest.Invoker{Body: "not json"}, "failed to unmarshal Llama 3.3 70B response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := InvokeModel(context.Background(), tt.client, "Friends", bedrock.Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
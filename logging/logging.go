@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// Level controls how much diagnostic output is written to stderr. Results always go to stdout
+// and are never affected.
+type Level int
+
+const (
+	// Quiet shows only warnings and errors
+	Quiet Level = iota
+	// Normal also shows progress, usage and configuration details
+	Normal
+	// Verbose also shows prompts, request payloads and raw model responses
+	Verbose
+)
+
+var (
+	level = Normal
+	// alerts writes warnings and errors, which are shown at every level
+	alerts = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// SetLevel sets the diagnostic level. Below Normal the standard logger is silenced, so
+// diagnostics logged with the log package are dropped.
+func SetLevel(l Level) {
+	level = l
+	if l < Normal {
+		log.SetOutput(io.Discard)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// Debugf logs a message shown only at the Verbose level
+func Debugf(format string, args ...any) {
+	if level >= Verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// Warn logs a warning, which is shown even in quiet mode
+func Warn(message string) {
+	alerts.Print(message)
+}
+
+// Fatal logs an error, which is shown even in quiet mode, and exits with status 1
+func Fatal(message string) {
+	alerts.Fatal(message)
+}
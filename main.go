@@ -1,135 +1,349 @@
 package main
 
 import (
-	"bedrock-llama/claude"
-	"bedrock-llama/deepseek"
-	"bedrock-llama/llama"
-	"bedrock-llama/llama70b"
-	"bedrock-llama/nova"
+	"bedrock-llama/bedrock"
+	"bedrock-llama/color"
+	"bedrock-llama/logging"
+	"bedrock-llama/pricing"
+	"bedrock-llama/prompts"
+	"bedrock-llama/render"
+	"bedrock-llama/tasks"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-
-	"github.com/joho/godotenv"
+	"time"
 )
 
-const (
-	// The template for the series name extraction prompt
-	promptTemplate = `You are a series name extraction tool that ONLY outputs valid JSON.
+// imageInput is the input of an -image extraction without -input, for the prompt to refer to
+const imageInput = "the attached image"
 
-INPUT: "%s"
+// documentInput is the input of a -document extraction without -input
+const documentInput = "the attached document"
 
-INSTRUCTIONS:
-1. Extract ONLY the series name (text that appears before "Season" or "Episode")
-2. Return ONLY a valid JSON array with format: [{"series": "extracted name"}]
-3. DO NOT include any explanation, additional examples, or commentary
-4. The response must contain NOTHING except the JSON array
+// videoInput is the input of a -video extraction without -input
+const videoInput = "the attached video"
 
-For example, from "Friends Season 1", extract just "Friends" and output [{"series": "Friends"}]`
+// Palettes for colored terminal output, disabled when the stream isn't a TTY or NO_COLOR is set
+var (
+	stdout = color.New(os.Stdout)
+	stderr = color.New(os.Stderr)
 )
 
 func main() {
+	loadPrices()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "batch":
+			runBatch(os.Args[2:])
+			return
+		case "rerun":
+			runRerun(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "smoke":
+			runSmoke(os.Args[2:])
+			return
+		case "eval":
+			runEval(os.Args[2:])
+			return
+		case "stats":
+			runStats(os.Args[2:])
+			return
+		case "simulate":
+			runSimulate(os.Args[2:])
+			return
+		case "usage":
+			runUsage(os.Args[2:])
+			return
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "compact":
+			runCompact(os.Args[2:])
+			return
+		case "pricing":
+			runPricing(os.Args[2:])
+			return
+		case "prompts":
+			runPrompts(os.Args[2:])
+			return
+		case "tokens":
+			runTokens(os.Args[2:])
+			return
+		case "imagine":
+			runImagine(os.Args[2:])
+			return
+		case "reel":
+			runReel(os.Args[2:])
+			return
+		case "embed":
+			runEmbed(os.Args[2:])
+			return
+		case "rerank":
+			runRerank(os.Args[2:])
+			return
+		case "speech":
+			runSpeech(os.Args[2:])
+			return
+		case "agent":
+			runAgent(os.Args[2:])
+			return
+		case "kb":
+			runKB(os.Args[2:])
+			return
+		case "guardrail":
+			runGuardrail(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "stub-server":
+			runStubServer(os.Args[2:])
+			return
+		case "worker":
+			runWorker(os.Args[2:])
+			return
+		case "mcp":
+			runMCP(os.Args[2:])
+			return
+		case "lambda":
+			runLambda(os.Args[2:])
+			return
+		}
+	} else if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		// The Lambda runtime starts the binary without arguments
+		runLambda(lambdaArgs())
+		return
+	}
+
 	// Define command-line flags
-	modelFlag := flag.String("model", "nova", "The LLM model to use: 'nova', 'llama', 'llama70b', 'claude', or 'deepseek'")
-	inputSeriesNameFlag := flag.String("input", "", "The input series name to extract")
+	f := registerFlags(flag.CommandLine)
+	inputSeriesNameFlag := flag.String("input", "", "The input to extract from, e.g. a media filename")
 
 	// Parse command-line flags
 	flag.Parse()
 
-	// Convert model name to lowercase for case-insensitive comparison
-	modelName := strings.ToLower(*modelFlag)
-	inputSeriesName := *inputSeriesNameFlag
+	if *f.listPrompts {
+		listPrompts(*f.promptsDir)
+		return
+	}
+
+	ctx := context.Background()
+	runWithExtractor(ctx, f, func(e *extractor) error {
+		return runOnce(ctx, e, *inputSeriesNameFlag)
+	})
+}
+
+// runOnce extracts from a single input and prints the result
+func runOnce(ctx context.Context, e *extractor, inputSeriesName string) error {
+	switch {
+	case inputSeriesName != "":
+	case len(e.documents) > 0:
+		inputSeriesName = documentInput
+	case len(e.videos) > 0:
+		inputSeriesName = videoInput
+	case len(e.images) > 0:
+		inputSeriesName = imageInput
+	}
 	if inputSeriesName == "" {
-		inputSeriesName = "Friends Season 001 Episode 001"
+		inputSeriesName = e.task.DefaultInput
+	}
+	if inputSeriesName == "" {
+		return errors.New("input series name cannot be empty. Provide a valid input using the -input flag")
 	}
 
-	// Format the prompt with the input series name
-	prompt := fmt.Sprintf(promptTemplate, inputSeriesName)
+	// Format the prompt with the input series name, preceded by any few-shot examples
+	turns, err := e.conversation(inputSeriesName)
+	if err != nil {
+		return err
+	}
+	prompt := turns[len(turns)-1].Text
 
-	// Validate model selection
-	validModels := map[string]bool{
-		"nova":     true,
-		"llama":    true,
-		"llama70b": true,
-		"claude":   true,
-		"deepseek": true,
+	log.Printf("Invoking Amazon Bedrock %s model...", stderr.Highlight(e.modelInfo.DisplayName))
+	log.Printf("Prompt: %s", prompt)
+	start := time.Now()
+	result, err := e.extract(ctx, inputSeriesName)
+	var parseErr *bedrock.ParseError
+	if errors.As(err, &parseErr) {
+		warnf("%s output is still invalid after %d correction attempts: %v", e.modelInfo.Name, result.Repairs, parseErr.Err)
+	} else if err != nil {
+		logGuardrailTrace(err)
+		return err
+	}
+	if e.showThinking && result.Thinking != "" {
+		log.Printf("Thinking:\n%s", strings.TrimSpace(result.Thinking))
 	}
+	if result.Status() == bedrock.StatusTruncated {
+		warnf("The response was cut off (%s), the output may be incomplete", result.StopReason)
+	}
+	var usage *callUsage
+	if e.reportUsage {
+		usage = newCallUsage(result, time.Since(start))
+	}
+	printResult(result, e.task, e.outputFormat, usage)
+	return nil
+}
 
-	if !validModels[modelName] {
-		log.Fatalf("Invalid model specified. Use 'nova', 'llama', 'llama70b', 'claude', or 'deepseek'")
+// usageOutput is the JSON printed with -usage: the records, or the raw text when the output
+// never validated, together with the usage
+type usageOutput struct {
+	Records json.RawMessage `json:"records,omitempty"`
+	Text    string          `json:"text,omitempty"`
+	// Moderation holds the -llama-guard verdicts
+	Moderation *bedrock.Moderation `json:"moderation,omitempty"`
+	Usage      *callUsage          `json:"usage"`
+}
+
+// printResult prints the extracted records in the requested format and logs token usage.
+// With a usage, the records are printed as JSON together with it.
+func printResult(result *bedrock.Result, task *tasks.Task, outputFormat string, usage *callUsage) {
+	records, issues, err := task.Check(result.Text)
+	result.Issues = issues
+	if usage != nil {
+		out := usageOutput{Moderation: result.Moderation, Usage: usage}
+		if err == nil {
+			out.Records = json.RawMessage(task.Schema.Format(records))
+		} else {
+			out.Text = strings.TrimSpace(result.Text)
+		}
+		data, _ := json.Marshal(out)
+		output := string(data)
+		if err != nil || result.Repairs > 0 || len(issues) > 0 {
+			output = stdout.Yellow(output)
+		}
+		fmt.Println(output)
+	} else if err == nil {
+		output, _ := render.Records(outputFormat, task.Schema, records)
+		if result.Repairs > 0 || len(issues) > 0 {
+			// Output that needed corrections or failed a quality rule is shown as low confidence
+			output = stdout.Yellow(output)
+		}
+		fmt.Println(output)
+	} else {
+		// Last resort: print the cleaned response, flagged as low confidence
+		fmt.Println(stdout.Yellow(strings.TrimSpace(result.Text)))
 	}
 
-	if inputSeriesName == "" {
-		log.Fatalf("Input series name cannot be empty. Provide a valid input using the -input flag.")
+	// Print token usage information as logs to not interfere with JSON output
+	if result.Cached {
+		log.Printf("Served from the cache\n")
+	}
+	if len(result.FallbackFrom) > 0 {
+		log.Printf("Produced by %s after %s failed\n", result.Model, strings.Join(result.FallbackFrom, ", "))
+	}
+	log.Printf("Input tokens: %d\n", result.InputTokens)
+	log.Printf("Output tokens: %d\n", result.OutputTokens)
+	log.Printf("Estimated cost: $%.6f\n", pricing.Cost(result.VersionedModel(), result.InputTokens, result.OutputTokens))
+	if result.Continuations > 0 {
+		log.Printf("Continuations: %d\n", result.Continuations)
+	}
+	if result.Repairs > 0 {
+		log.Printf("Corrective re-prompts: %d\n", result.Repairs)
+	}
+	for _, issue := range result.Issues {
+		warnf("low quality output: %s", issue)
+	}
+	log.Printf("Prompt version: %s %s (task %s, build %s)\n", result.Prompt, result.PromptVersion, task.Name, revisionOrUnknown(result.Revision))
+}
+
+// listPrompts prints every prompt version in the registry
+func listPrompts(dir string) {
+	registry, err := prompts.Open(dir)
+	if err != nil {
+		fatalf("Error: %v", err)
 	}
+	for _, version := range registry.List() {
+		fmt.Printf("%s\ttask=%s\t%s\n", version.Ref(), version.Metadata.Task, version.Metadata.Description)
+	}
+}
+
+// exitStatus is an error that ends the program with its status, the failure having already
+// been reported
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
 
-	fmt.Println("Loading environment variables...")
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: Error loading .env file: %v", err)
+// runWithExtractor runs a command with the extractor described by the flags. The extractor is
+// closed before the program exits, even when run fails, so the audit log, the traces, the
+// history and the usage are flushed; os.Exit would skip a deferred close.
+func runWithExtractor(ctx context.Context, f *flags, run func(e *extractor) error) {
+	e := newExtractor(ctx, f)
+	err := run(e)
+	e.close()
+	var status exitStatus
+	switch {
+	case errors.As(err, &status):
+		os.Exit(int(status))
+	case err != nil:
+		fatalf("Error: %v", err)
 	}
+}
 
-	accessKeyId := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	awsRegion := os.Getenv("AWS_REGION")
+// fatalf logs an error in red and exits
+func fatalf(format string, args ...any) {
+	logging.Fatal(stderr.Red(fmt.Sprintf(format, args...)))
+}
 
-	// Additional diagnostic information
-	log.Printf("Using AWS region: %s", awsRegion)
-	log.Printf("AWS access key ID present: %v", accessKeyId != "")
-	log.Printf("AWS secret access key present: %v", secretAccessKey != "")
+// warnf logs a warning in yellow
+func warnf(format string, args ...any) {
+	logging.Warn(stderr.Yellow("Warning: " + fmt.Sprintf(format, args...)))
+}
 
-	if accessKeyId == "" || secretAccessKey == "" || awsRegion == "" {
-		log.Fatalf("Missing required environment variables: AWS_ACCESS_KEY_ID and/or AWS_SECRET_ACCESS_KEY and/or AWS_REGION")
+// revisionOrUnknown returns the build revision, or "unknown" when it wasn't recorded
+func revisionOrUnknown(revision string) string {
+	if revision == "" {
+		return "unknown"
 	}
+	return revision
+}
 
-	ctx := context.Background()
+// floatFlag is a number flag that tells unset apart from zero; value is nil when unset
+type floatFlag struct {
+	value *float64
+}
 
-	switch modelName {
-	case "nova":
-		// Run Nova model
-		fmt.Println("Invoking Amazon Bedrock Nova model...")
-		fmt.Printf("Prompt: %s\n", prompt)
-		response, err := nova.InvokeModel(ctx, prompt, accessKeyId, secretAccessKey, awsRegion)
-		if err != nil {
-			log.Fatalf("Error: %v", err)
-		}
-		nova.PrintResponse(response)
-	case "llama":
-		// Run Llama model
-		fmt.Println("Invoking Amazon Bedrock Llama model...")
-		response, err := llama.InvokeModel(ctx, prompt, accessKeyId, secretAccessKey, awsRegion)
-		if err != nil {
-			log.Fatalf("Error: %v", err)
-		}
-		llama.PrintResponse(response)
-	case "llama70b":
-		// Run Llama 3.3 70B model
-		fmt.Println("Invoking Amazon Bedrock Llama 3.3 70B model...")
-		fmt.Printf("Prompt: %s\n", prompt)
-		response, err := llama70b.InvokeModel(ctx, prompt, accessKeyId, secretAccessKey, awsRegion)
-		if err != nil {
-			log.Fatalf("Error: %v", err)
-		}
-		llama70b.PrintResponse(response)
-	case "claude":
-		// Run Claude model
-		fmt.Println("Invoking Amazon Bedrock Claude 3 Sonnet model...")
-		fmt.Printf("Prompt: %s\n", prompt)
-		response, err := claude.InvokeModel(ctx, prompt, accessKeyId, secretAccessKey, awsRegion)
-		if err != nil {
-			log.Fatalf("Error: %v", err)
-		}
-		claude.PrintResponse(response)
-	case "deepseek":
-		// Run DeepSeek model
-		fmt.Println("Invoking Amazon Bedrock DeepSeek model...")
-		fmt.Printf("Prompt: %s\n", prompt)
-		response, err := deepseek.InvokeModel(ctx, prompt, accessKeyId, secretAccessKey, awsRegion)
-		if err != nil {
-			log.Fatalf("Error: %v", err)
-		}
-		deepseek.PrintResponse(response)
+func (f *floatFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f.value, 'g', -1, 64)
+}
+
+func (f *floatFlag) Set(s string) error {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	f.value = &value
+	return nil
+}
+
+// varsFlag collects repeated -var key=value flags
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	pairs := make([]string, 0, len(v))
+	for key, value := range v {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v varsFlag) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", pair)
 	}
+	v[key] = value
+	return nil
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bedrock-llama/render"
+	"bedrock-llama/s3io"
+	"bedrock-llama/tasks"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// manifestVersion is the format version of the run manifests written by this build
+const manifestVersion = 1
+
+// runManifest describes a batch run closely enough to reproduce it with rerun, or to show
+// later which configuration, prompt and models produced its results
+type runManifest struct {
+	Version   int    `json:"manifest_version"`
+	CreatedAt string `json:"created_at"`
+	// Revision is the git commit the binary was built from
+	Revision string `json:"revision,omitempty"`
+	// Args are the batch arguments as given; Flags is the value of every flag, defaults included
+	Args  []string          `json:"args"`
+	Flags map[string]string `json:"flags"`
+	Task  string            `json:"task"`
+	// Prompt is the registry reference of the prompt; PromptVersion hashes its template and schema
+	Prompt        string `json:"prompt"`
+	PromptVersion string `json:"prompt_version"`
+	// ExamplesSHA256 is the checksum of the -examples file
+	ExamplesSHA256 string           `json:"examples_sha256,omitempty"`
+	Models         []manifestModel  `json:"models"`
+	Sampling       manifestSampling `json:"sampling"`
+	Input          manifestChecksum `json:"input"`
+	Output         manifestChecksum `json:"output"`
+	Items          int              `json:"items"`
+	Failed         int              `json:"failed"`
+	Interrupted    int              `json:"interrupted,omitempty"`
+	// Results maps the id of every processed item to a hash of its records, or to "error"
+	Results map[string]string `json:"results"`
+	// Complete is set when every input was read and processed; the checksums of an incomplete
+	// run only cover what it got through
+	Complete bool `json:"complete"`
+}
+
+// manifestModel is a model a batch run invoked
+type manifestModel struct {
+	// Role is primary, fallback or hedge
+	Role        string `json:"role"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	ModelID     string `json:"model_id"`
+}
+
+// manifestSampling holds the generation settings of a run. Bedrock's models take no seed, so
+// these settings are all that a rerun can hold fixed.
+type manifestSampling struct {
+	MaxTokens      int      `json:"max_tokens"`
+	TopK           int      `json:"top_k,omitempty"`
+	StopSequences  []string `json:"stop_sequences,omitempty"`
+	ThinkingBudget int      `json:"thinking_budget,omitempty"`
+	Temperature    *float64 `json:"temperature,omitempty"`
+	TopP           *float64 `json:"top_p,omitempty"`
+}
+
+// manifestChecksum identifies the input or the output of a run by its SHA-256
+type manifestChecksum struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// resultHash is the Results entry of a batch output: a short hash of its records, which leaves
+// out the latency and other details that differ between identical answers
+func resultHash(out batchOutput) string {
+	if out.Error != "" {
+		return "error"
+	}
+	sum := sha256.Sum256(out.Records)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// checksum hashes the bytes written to it, for the input and output of a run
+type checksum struct {
+	hash  hash.Hash
+	bytes int64
+}
+
+func newChecksum() *checksum {
+	return &checksum{hash: sha256.New()}
+}
+
+func (c *checksum) Write(p []byte) (int, error) {
+	c.bytes += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// of returns the checksum of what was written so far, under the path it belongs to
+func (c *checksum) of(path string) manifestChecksum {
+	return manifestChecksum{Path: path, SHA256: hex.EncodeToString(c.hash.Sum(nil)), Bytes: c.bytes}
+}
+
+// newRunManifest snapshots the configuration of a batch run before it starts
+func (e *extractor) newRunManifest(fs *flag.FlagSet, args []string, examples string) (*runManifest, error) {
+	m := &runManifest{
+		Version:       manifestVersion,
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		Revision:      tasks.BuildRevision(),
+		Args:          args,
+		Flags:         map[string]string{},
+		Results:       map[string]string{},
+		Task:          e.task.Name,
+		Prompt:        e.task.PromptRef(),
+		PromptVersion: e.task.Version(),
+		Sampling: manifestSampling{
+			MaxTokens:     e.opts.MaxTokens,
+			TopK:          e.opts.TopK,
+			StopSequences: e.opts.StopSequences,
+			Temperature:   e.opts.Temperature,
+			TopP:          e.opts.TopP,
+		},
+	}
+	fs.VisitAll(func(fl *flag.Flag) {
+		m.Flags[fl.Name] = fl.Value.String()
+	})
+	if e.opts.Thinking != nil {
+		m.Sampling.ThinkingBudget = e.opts.Thinking.BudgetTokens
+	}
+	if examples != "" {
+		data, err := os.ReadFile(examples)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read examples: %v", err)
+		}
+		sum := sha256.Sum256(data)
+		m.ExamplesSHA256 = hex.EncodeToString(sum[:])
+	}
+	m.Models = append(m.Models, manifestModel{"primary", e.modelInfo.Name, e.modelInfo.DisplayName, e.modelInfo.ModelID})
+	for _, fallback := range e.fallbacks {
+		m.Models = append(m.Models, manifestModel{"fallback", fallback.modelInfo.Name, fallback.modelInfo.DisplayName, fallback.modelInfo.ModelID})
+	}
+	if e.hedge != nil {
+		info := e.hedge.extractor.modelInfo
+		m.Models = append(m.Models, manifestModel{"hedge", info.Name, info.DisplayName, info.ModelID})
+	}
+	return m, nil
+}
+
+// compare warns about the differences between the run being repeated and this one that can
+// change its results: the build, the prompt, the examples and the models
+func (m *runManifest) compare(rerun *runManifest) {
+	if m.Revision != rerun.Revision {
+		warnf("the original run was built from %s, this one from %s", revisionOrUnknown(m.Revision), revisionOrUnknown(rerun.Revision))
+	}
+	if m.PromptVersion != rerun.PromptVersion {
+		warnf("the prompt changed: the original run used %s version %s, this one %s version %s", m.Prompt, m.PromptVersion, rerun.Prompt, rerun.PromptVersion)
+	}
+	if m.ExamplesSHA256 != rerun.ExamplesSHA256 {
+		warnf("the -examples differ from the original run's")
+	}
+	if !slices.Equal(m.Models, rerun.Models) {
+		warnf("the models differ from the original run's: %v, now %v", m.Models, rerun.Models)
+	}
+}
+
+// applyFlags sets the flags that the rerun's arguments leave unset to their values in the
+// original run, in case this build has other defaults
+func (m *runManifest) applyFlags(fs *flag.FlagSet) {
+	given := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) {
+		given[fl.Name] = true
+	})
+	for name, value := range m.Flags {
+		fl := fs.Lookup(name)
+		switch {
+		case fl == nil:
+			warnf("the original run's -%s flag no longer exists", name)
+		case !given[name] && fl.Value.String() != value:
+			if err := fs.Set(name, value); err != nil {
+				warnf("can't restore -%s=%s: %v", name, value, err)
+				continue
+			}
+			log.Printf("Using the original run's -%s=%s", name, value)
+		}
+	}
+}
+
+// verify compares the input and the output of the rerun with the original run's
+func (m *runManifest) verify(rerun *runManifest) {
+	if !m.Complete || !rerun.Complete {
+		warnf("a run didn't process all its inputs, so the checksums can't be compared")
+		return
+	}
+	if m.Input.SHA256 != rerun.Input.SHA256 {
+		warnf("the input differs from the original run's (sha256 %s, now %s)", m.Input.SHA256, rerun.Input.SHA256)
+	}
+	var changed []string
+	for id, hash := range rerun.Results {
+		if original, ok := m.Results[id]; !ok || original != hash {
+			changed = append(changed, id)
+		}
+	}
+	if len(changed) == 0 {
+		log.Printf("The records of all %d items are identical to the original run's", len(rerun.Results))
+		return
+	}
+	slices.Sort(changed)
+	shown := changed
+	if len(shown) > 10 {
+		shown = shown[:10]
+	}
+	log.Printf("The records of %d of %d items differ from the original run's: %s", len(changed), len(rerun.Results), strings.Join(shown, ", "))
+}
+
+// writeManifest saves the manifest to a local file or an s3://bucket/key
+func (e *extractor) writeManifest(ctx context.Context, path string, m *runManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	data = append(data, '\n')
+	location, isS3, err := s3io.Parse(path)
+	if err != nil {
+		return err
+	}
+	if !isS3 {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return fmt.Errorf("failed to write manifest: %v", err)
+		}
+		return nil
+	}
+	client, err := e.s3Client(ctx)
+	if err != nil {
+		return err
+	}
+	w := s3io.Create(ctx, client, location, "application/json")
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return w.Close()
+}
+
+// readManifest reads the manifest of an earlier run
+func readManifest(path string) (*runManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var m runManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest %s: invalid JSON: %v", path, err)
+	}
+	if m.Version < 1 || m.Version > manifestVersion {
+		return nil, fmt.Errorf("manifest %s has version %d; this build reads versions 1 to %d", path, m.Version, manifestVersion)
+	}
+	return &m, nil
+}
+
+// runRerun repeats the batch run of a manifest with its original arguments and flag values.
+// Other arguments override the original ones, e.g. -model to try the same inputs on another
+// model. The rerun invokes the models again rather than reading -cache or -checkpoint, writes
+// the results to stdout and its own manifest next to the original.
+func runRerun(args []string) {
+	path := ""
+	var overrides []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case path == "" && (arg == "-manifest" || arg == "--manifest") && i+1 < len(args):
+			path = args[i+1]
+			i++
+		case path == "" && (strings.HasPrefix(arg, "-manifest=") || strings.HasPrefix(arg, "--manifest=")):
+			_, path, _ = strings.Cut(arg, "=")
+		default:
+			overrides = append(overrides, arg)
+		}
+	}
+	if path == "" {
+		fatalf("Rerun needs the manifest of a batch run. Provide one using the -manifest flag.")
+	}
+	original, err := readManifest(path)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	batchArgs := slices.Clone(original.Args)
+	batchArgs = append(batchArgs, "-checkpoint=", "-no-cache", "-output="+render.JSON, "-manifest="+strings.TrimSuffix(path, ".json")+".rerun.json")
+	batchArgs = append(batchArgs, overrides...)
+	log.Printf("Rerunning the batch of %s from %s", original.CreatedAt, path)
+	runBatchWith(batchArgs, original)
+}
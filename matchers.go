@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// matcher decides whether a predicted eval answer counts as the expected one
+type matcher struct {
+	// spec is the -match value the matcher was parsed from, e.g. token-set:0.6
+	spec  string
+	match func(predicted, expected string) bool
+}
+
+// matcherKind is a comparison the eval -match flag can select. Those with a threshold take it
+// as a similarity between 0 and 1, after a colon.
+type matcherKind struct {
+	description string
+	// threshold is the default threshold; zero for the comparisons without one
+	threshold float64
+	new       func(threshold float64) func(predicted, expected string) bool
+}
+
+// matcherKinds are the comparisons of -match, by name
+var matcherKinds = map[string]matcherKind{
+	"exact": {
+		description: "identical values",
+		new: func(float64) func(string, string) bool {
+			return func(predicted, expected string) bool { return predicted == expected }
+		},
+	},
+	"case-insensitive": {
+		description: "equal values ignoring case and surrounding spaces",
+		new: func(float64) func(string, string) bool {
+			return func(predicted, expected string) bool {
+				return strings.EqualFold(strings.TrimSpace(predicted), strings.TrimSpace(expected))
+			}
+		},
+	},
+	"normalized": {
+		description: "equal values ignoring case, punctuation and spacing",
+		new: func(float64) func(string, string) bool {
+			return func(predicted, expected string) bool {
+				return normalizeAnswer(predicted) == normalizeAnswer(expected)
+			}
+		},
+	},
+	"levenshtein": {
+		description: "normalized values whose edit distance leaves them at least the threshold similar",
+		threshold:   0.8,
+		new: func(threshold float64) func(string, string) bool {
+			return func(predicted, expected string) bool {
+				return levenshteinSimilarity(normalizeAnswer(predicted), normalizeAnswer(expected)) >= threshold
+			}
+		},
+	},
+	"token-set": {
+		description: "normalized values sharing at least the threshold of their distinct words",
+		threshold:   0.6,
+		new: func(threshold float64) func(string, string) bool {
+			return func(predicted, expected string) bool {
+				return tokenSetSimilarity(normalizeAnswer(predicted), normalizeAnswer(expected)) >= threshold
+			}
+		},
+	},
+}
+
+// matcherUsage describes the -match comparisons for the flag's help
+func matcherUsage() string {
+	names := make([]string, 0, len(matcherKinds))
+	for name := range matcherKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	descriptions := make([]string, len(names))
+	for i, name := range names {
+		kind := matcherKinds[name]
+		if kind.threshold > 0 {
+			name += fmt.Sprintf("[:threshold, default %g]", kind.threshold)
+		}
+		descriptions[i] = name + " (" + kind.description + ")"
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// parseMatcher returns the matcher of a -match value: a comparison name, followed by a
+// threshold for the fuzzy ones, e.g. levenshtein:0.9
+func parseMatcher(spec string) (*matcher, error) {
+	name, value, hasThreshold := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), ":")
+	kind, ok := matcherKinds[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown -match comparison %q. Use %s", name, matcherUsage())
+	}
+	threshold := kind.threshold
+	if hasThreshold {
+		if kind.threshold == 0 {
+			return nil, fmt.Errorf("the %s comparison takes no threshold", name)
+		}
+		var err error
+		if threshold, err = strconv.ParseFloat(value, 64); err != nil || threshold <= 0 || threshold > 1 {
+			return nil, fmt.Errorf("invalid %s threshold %q: use a similarity above 0 and at most 1", name, value)
+		}
+	}
+	if kind.threshold > 0 {
+		spec = name + ":" + strconv.FormatFloat(threshold, 'g', -1, 64)
+	} else {
+		spec = name
+	}
+	return &matcher{spec: spec, match: kind.new(threshold)}, nil
+}
+
+// levenshteinSimilarity is 1 minus the edit distance between a and b over the length of the
+// longer one, in runes: 1 for equal strings, 0 for entirely different ones
+func levenshteinSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	// previous and current are the distances from a prefix of ra to every prefix of rb
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(rb)])/float64(longest)
+}
+
+// tokenSetSimilarity is the share of the distinct words of a and b that they have in common
+// (their Jaccard index): "the office us" and "the office" are 2/3 similar
+func tokenSetSimilarity(a, b string) float64 {
+	words := func(s string) map[string]bool {
+		set := map[string]bool{}
+		for _, word := range strings.Fields(s) {
+			set[word] = true
+		}
+		return set
+	}
+	wa, wb := words(a), words(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+	common := 0
+	for word := range wa {
+		if wb[word] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(wa)+len(wb)-common)
+}
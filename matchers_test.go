@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLevenshteinSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"friends", "", 0},
+		{"", "friends", 0},
+		{"friends", "friends", 1},
+		{"abc", "xyz", 0},
+		{"the office", "the ofice", 0.9},
+		{"kitten", "sitting", 1 - 3.0/7},
+		// Distances are counted in runes, not bytes: é is one edit, not two
+		{"amélie", "amelie", 1 - 1.0/6},
+		{"東京", "東京都", 1 - 1.0/3},
+		{"café", "café", 1},
+	}
+	for _, tt := range tests {
+		got := levenshteinSimilarity(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("levenshteinSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if reverse := levenshteinSimilarity(tt.b, tt.a); math.Abs(reverse-got) > 1e-9 {
+			t.Errorf("levenshteinSimilarity(%q, %q) = %v, but %v the other way", tt.a, tt.b, got, reverse)
+		}
+	}
+}
+
+func TestTokenSetSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"the office", "", 0},
+		{"the office us", "the office", 2.0 / 3},
+		{"the office", "office the", 1},
+		{"the the office", "the office", 1},
+		{"friends", "lost", 0},
+		{"amélie poulain", "amélie", 0.5},
+		{"crème brûlée", "creme brulee", 0},
+	}
+	for _, tt := range tests {
+		got := tokenSetSimilarity(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("tokenSetSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseMatcher(t *testing.T) {
+	tests := []struct {
+		spec string
+		// want is the canonical spec; empty when the spec must be rejected
+		want string
+	}{
+		{"exact", "exact"},
+		{" Normalized ", "normalized"},
+		{"case-insensitive", "case-insensitive"},
+		{"levenshtein", "levenshtein:0.8"},
+		{"levenshtein:0.9", "levenshtein:0.9"},
+		{"token-set", "token-set:0.6"},
+		{"token-set:1", "token-set:1"},
+		{"token-set:0", ""},
+		{"token-set:-0.5", ""},
+		{"token-set:1.5", ""},
+		{"levenshtein:high", ""},
+		{"exact:0.9", ""},
+		{"normalized:1", ""},
+		{"fuzzy", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		m, err := parseMatcher(tt.spec)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseMatcher(%q) = %q, want an error", tt.spec, m.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMatcher(%q): %v", tt.spec, err)
+		} else if m.spec != tt.want {
+			t.Errorf("parseMatcher(%q).spec = %q, want %q", tt.spec, m.spec, tt.want)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		spec                string
+		predicted, expected string
+		want                bool
+	}{
+		{"exact", "The Office", "The Office", true},
+		{"exact", "the office", "The Office", false},
+		{"case-insensitive", " the office ", "The Office", true},
+		{"case-insensitive", "The Office!", "The Office", false},
+		{"normalized", "the-office!", "The Office", true},
+		{"normalized", "Law & Order", "law and order", true},
+		{"levenshtein", "The Ofice", "The Office", true},
+		{"levenshtein:0.95", "The Ofice", "The Office", false},
+		{"token-set", "The Office (US)", "the office", true},
+		{"token-set:0.7", "The Office (US)", "the office", false},
+		{"token-set:1", "Office, The", "the office", true},
+	}
+	for _, tt := range tests {
+		m, err := parseMatcher(tt.spec)
+		if err != nil {
+			t.Fatalf("parseMatcher(%q): %v", tt.spec, err)
+		}
+		if got := m.match(tt.predicted, tt.expected); got != tt.want {
+			t.Errorf("%s match of %q and %q = %v, want %v", tt.spec, tt.predicted, tt.expected, got, tt.want)
+		}
+	}
+}
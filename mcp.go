@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// mcpProtocolVersion is the latest Model Context Protocol revision the server implements
+const mcpProtocolVersion = "2025-06-18"
+
+// mcpProtocolVersions lists the protocol revisions the server can speak
+var mcpProtocolVersions = []string{"2024-11-05", "2025-03-26", mcpProtocolVersion}
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error of a JSON-RPC response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool describes a tool in the tools/list response
+type mcpTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema any    `json:"inputSchema"`
+}
+
+// mcpContent is a text content block of a tool result
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpToolResult is the result of tools/call. Tool failures are reported in the result with
+// isError set so the calling model can see them.
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// mcpServer answers MCP requests over stdio
+type mcpServer struct {
+	extractor *extractor
+
+	// out receives newline-delimited JSON-RPC messages; writes are serialized by mu
+	mu  sync.Mutex
+	out io.Writer
+	// cancels stops in-flight tool calls by request id
+	cancels map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// runMCP serves the Model Context Protocol on stdin and stdout until stdin is closed.
+// All diagnostics go to stderr so that stdout only carries protocol messages.
+func runMCP(args []string) {
+	fs := flag.NewFlagSet("mcp", flag.ExitOnError)
+	f := registerFlags(fs)
+	fs.Parse(args)
+
+	runWithExtractor(context.Background(), f, func(e *extractor) error {
+		s := &mcpServer{extractor: e, out: os.Stdout, cancels: map[string]context.CancelFunc{}}
+		log.Printf("Serving MCP on stdio for the %s task with %s", e.task.Name, e.modelInfo.Name)
+		return s.serve(os.Stdin)
+	})
+}
+
+// serve reads messages until in is closed, then waits for in-flight tool calls
+func (s *mcpServer) serve(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRequestSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			s.send(rpcMessage{ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, fmt.Sprintf("parse error: %v", err)}})
+			continue
+		}
+		s.handle(msg)
+	}
+	s.wg.Wait()
+	return scanner.Err()
+}
+
+// handle dispatches one message. Responses from the client are ignored because the server
+// sends no requests of its own.
+func (s *mcpServer) handle(msg rpcMessage) {
+	if msg.Method == "" {
+		return
+	}
+	notification := msg.ID == nil
+	if msg.JSONRPC != "2.0" {
+		if !notification {
+			s.reply(msg, nil, &rpcError{rpcInvalidRequest, `"jsonrpc" must be "2.0"`})
+		}
+		return
+	}
+
+	switch msg.Method {
+	case "initialize":
+		s.reply(msg, s.initialize(msg.Params), nil)
+	case "ping":
+		s.reply(msg, struct{}{}, nil)
+	case "tools/list":
+		s.reply(msg, map[string]any{"tools": s.tools()}, nil)
+	case "tools/call":
+		ctx, cancel := context.WithCancel(context.Background())
+		s.mu.Lock()
+		s.cancels[string(msg.ID)] = cancel
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			result, err := s.callTool(ctx, msg.Params)
+			s.mu.Lock()
+			delete(s.cancels, string(msg.ID))
+			s.mu.Unlock()
+			if ctx.Err() != nil {
+				// The client cancelled the request and expects no response
+				return
+			}
+			cancel()
+			s.reply(msg, result, err)
+		}()
+	case "notifications/cancelled":
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.mu.Lock()
+			if cancel, ok := s.cancels[string(params.RequestID)]; ok {
+				cancel()
+			}
+			s.mu.Unlock()
+		}
+	default:
+		if !notification {
+			s.reply(msg, nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method %q not found", msg.Method)})
+		}
+	}
+}
+
+// initialize agrees on a protocol version and advertises the tools capability
+func (s *mcpServer) initialize(params json.RawMessage) map[string]any {
+	var req struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ClientInfo      struct {
+			Name string `json:"name"`
+		} `json:"clientInfo"`
+	}
+	json.Unmarshal(params, &req)
+
+	version := mcpProtocolVersion
+	for _, supported := range mcpProtocolVersions {
+		if req.ProtocolVersion == supported {
+			version = supported
+		}
+	}
+	log.Printf("MCP client %q connected (protocol %s)", req.ClientInfo.Name, version)
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities":    map[string]any{"tools": map[string]any{}},
+		"serverInfo":      map[string]string{"name": "bedrock-llama", "version": buildVersion()},
+	}
+}
+
+// tools lists the tools: a raw model invocation and the configured extraction task
+func (s *mcpServer) tools() []mcpTool {
+	modelProperty := map[string]any{
+		"type":        "string",
+		"enum":        models.Names(),
+		"description": fmt.Sprintf("The Bedrock model to use; defaults to %s", s.extractor.modelInfo.Name),
+	}
+	return []mcpTool{
+		{
+			Name:        "invoke",
+			Description: "Send a prompt to an Amazon Bedrock model and return its reply",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"prompt": map[string]any{"type": "string", "description": "The prompt to send"},
+					"model":  modelProperty,
+				},
+				"required": []string{"prompt"},
+			},
+		},
+		{
+			Name:        "extract",
+			Description: fmt.Sprintf("%s; returns the records as a JSON array", s.extractor.task.Description),
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"input": map[string]any{"type": "string", "description": "The input to extract from, e.g. a media filename"},
+					"model": modelProperty,
+				},
+				"required": []string{"input"},
+			},
+		},
+	}
+}
+
+// callTool runs a tools/call request
+func (s *mcpServer) callTool(ctx context.Context, params json.RawMessage) (*mcpToolResult, *rpcError) {
+	var req struct {
+		Name      string `json:"name"`
+		Arguments struct {
+			Prompt string `json:"prompt"`
+			Input  string `json:"input"`
+			Model  string `json:"model"`
+		} `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("invalid params: %v", err)}
+	}
+	args := req.Arguments
+
+	switch req.Name {
+	case "invoke":
+		if strings.TrimSpace(args.Prompt) == "" {
+			return nil, &rpcError{rpcInvalidParams, `"prompt" is required`}
+		}
+		model, err := s.invokeModel(args.Model)
+		if err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		log.Printf("MCP invoke model=%s", model.Name())
+		result, err := model.Invoke(ctx, args.Prompt)
+		if err != nil {
+			return toolError(err), nil
+		}
+		return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: result.Text}}}, nil
+	case "extract":
+		if strings.TrimSpace(args.Input) == "" {
+			return nil, &rpcError{rpcInvalidParams, `"input" is required`}
+		}
+		e, err := s.extractor.forModel(args.Model)
+		if err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		log.Printf("MCP extract model=%s input=%q", e.modelInfo.Name, args.Input)
+		out := e.runItem(ctx, batchItem{Input: args.Input})
+		if out.Error != "" {
+			return toolError(errors.New(out.Error)), nil
+		}
+		return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(out.Records)}}}, nil
+	}
+	return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown tool %q", req.Name)}
+}
+
+// invokeModel returns the model for the invoke tool, with the options of a conversation rather
+// than the task's; the configured model keeps its provisioned throughput routing
+func (s *mcpServer) invokeModel(name string) (bedrock.Model, error) {
+	info := s.extractor.modelInfo
+	if name != "" && !strings.EqualFold(name, info.Name) {
+		var ok bool
+		if info, ok = s.extractor.lookupModel(name); !ok {
+			return nil, fmt.Errorf("unknown model %q: use %s", name, models.Usage())
+		}
+	}
+	return s.extractor.newModel(info, s.extractor.chatOptions(info)), nil
+}
+
+// buildVersion is the module version the binary was built from
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// toolError reports a failed tool call to the client
+func toolError(err error) *mcpToolResult {
+	return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}
+}
+
+// reply answers a request; notifications get no response
+func (s *mcpServer) reply(req rpcMessage, result any, err *rpcError) {
+	if req.ID == nil {
+		return
+	}
+	if err != nil {
+		s.send(rpcMessage{ID: req.ID, Error: err})
+		return
+	}
+	s.send(rpcMessage{ID: req.ID, Result: result})
+}
+
+// send writes one message as a line of JSON
+func (s *mcpServer) send(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding MCP message: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.out.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing MCP message: %v", err)
+	}
+}
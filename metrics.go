@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/pricing"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the Prometheus metrics of model invocations
+type metrics struct {
+	registry    *prometheus.Registry
+	invocations *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	tokens      *prometheus.CounterVec
+	cost        *prometheus.CounterVec
+	repairs     *prometheus.CounterVec
+	retries     *prometheus.CounterVec
+	throttles   *prometheus.CounterVec
+
+	// emf also writes each invocation as a CloudWatch Embedded Metric Format record; nil when disabled
+	emf *emfWriter
+	// usage totals the tokens of the run for the usage store; nil when disabled
+	usage *usageRecorder
+}
+
+// newMetrics registers the metrics, along with the Go runtime and process collectors
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		invocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bedrock_invocations_total",
+			Help: "Model invocations by model and status (ok or error).",
+		}, []string{"model", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bedrock_invocation_duration_seconds",
+			Help:    "Model invocation latency, including SDK retries.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+		}, []string{"model"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bedrock_tokens_total",
+			Help: "Tokens processed by model and direction (input or output).",
+		}, []string{"model", "direction"}),
+		cost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bedrock_estimated_cost_dollars_total",
+			Help: "Estimated on-demand cost of the invocations in USD.",
+		}, []string{"model"}),
+		repairs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bedrock_repairs_total",
+			Help: "Corrective re-prompts sent after invalid output.",
+		}, []string{"model"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bedrock_retries_total",
+			Help: "Bedrock API requests retried by the SDK, by operation.",
+		}, []string{"operation"}),
+		throttles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bedrock_throttles_total",
+			Help: "Bedrock API attempts rejected with a ThrottlingException, by operation.",
+		}, []string{"operation"}),
+	}
+	m.registry.MustRegister(
+		m.invocations, m.duration, m.tokens, m.cost, m.repairs, m.retries, m.throttles,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// handler serves the metrics in the Prometheus exposition format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observe records one model invocation
+func (m *metrics) observe(model string, start time.Time, result *bedrock.Result, err error) {
+	latency := time.Since(start)
+	m.duration.WithLabelValues(model).Observe(latency.Seconds())
+	// Usage and EMF costs are kept by the version that answered, priced at its own rates
+	inputTokens, outputTokens, versioned := 0, 0, model
+	if result != nil {
+		inputTokens, outputTokens, versioned = result.InputTokens, result.OutputTokens, result.VersionedModel()
+	}
+	if m.emf != nil {
+		m.emf.record(versioned, latency, inputTokens, outputTokens, err)
+	}
+	if m.usage != nil {
+		m.usage.add(versioned, inputTokens, outputTokens, err)
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.invocations.WithLabelValues(model, status).Inc()
+	if result != nil {
+		m.tokens.WithLabelValues(model, "input").Add(float64(result.InputTokens))
+		m.tokens.WithLabelValues(model, "output").Add(float64(result.OutputTokens))
+		m.cost.WithLabelValues(model).Add(pricing.Cost(versioned, result.InputTokens, result.OutputTokens))
+	}
+}
+
+// observeRepairs records the corrective re-prompts of an extraction
+func (m *metrics) observeRepairs(model string, repairs int) {
+	if repairs > 0 {
+		m.repairs.WithLabelValues(model).Add(float64(repairs))
+	}
+}
+
+// apiOption adds middleware that counts the retries and throttled attempts of each Bedrock
+// API request. It runs before the SDK's retry middleware so it sees every attempt's result.
+func (m *metrics) apiOption(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("BedrockMetrics",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			operation := awsmiddleware.GetOperationName(ctx)
+			if attempts, ok := retry.GetAttemptResults(metadata); ok {
+				if len(attempts.Results) > 1 {
+					m.retries.WithLabelValues(operation).Add(float64(len(attempts.Results) - 1))
+				}
+				for _, attempt := range attempts.Results {
+					if throttled(attempt.Err) {
+						m.throttles.WithLabelValues(operation).Inc()
+					}
+				}
+			} else if throttled(err) {
+				m.throttles.WithLabelValues(operation).Inc()
+			}
+			return out, metadata, err
+		}), "Retry", middleware.Before)
+}
+
+// throttled reports whether an API error is a throttling rejection
+func throttled(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ThrottlingException"
+}
+
+// wrap returns a model whose invocations are recorded
+func (m *metrics) wrap(model bedrock.Model) bedrock.Model {
+	return &measuredModel{Model: model, metrics: m}
+}
+
+// measuredModel is a Model whose invocations are recorded in the metrics
+type measuredModel struct {
+	bedrock.Model
+	metrics *metrics
+}
+
+func (m *measuredModel) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *measuredModel) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	start := time.Now()
+	result, err := m.Model.Chat(ctx, turns)
+	m.metrics.observe(m.Name(), start, result, err)
+	return result, err
+}
+
+func (m *measuredModel) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	streamer, ok := m.Model.(bedrock.Streamer)
+	if !ok {
+		return nil, fmt.Errorf("the %s model doesn't support streaming", m.Name())
+	}
+	start := time.Now()
+	result, err := streamer.Stream(ctx, turns, onText)
+	m.metrics.observe(m.Name(), start, result, err)
+	return result, err
+}
+
+func (m *measuredModel) UseTools(ctx context.Context, turns []bedrock.Turn, tools []bedrock.Tool, onCall func(call bedrock.ToolCall)) (*bedrock.Result, error) {
+	toolUser, ok := m.Model.(bedrock.ToolUser)
+	if !ok {
+		return nil, fmt.Errorf("the %s model can't call tools", m.Name())
+	}
+	start := time.Now()
+	result, err := toolUser.UseTools(ctx, turns, tools, onCall)
+	m.metrics.observe(m.Name(), start, result, err)
+	return result, err
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/canvas"
+	"bedrock-llama/claude"
+	"bedrock-llama/cohere"
+	"bedrock-llama/deepseek"
+	"bedrock-llama/llama"
+	"bedrock-llama/llama70b"
+	"bedrock-llama/nova"
+	"bedrock-llama/stability"
+	"bedrock-llama/titan"
+	"fmt"
+	"strings"
+)
+
+// Info describes a model available on the command line
+type Info struct {
+	// Name is the short model name (e.g. "nova")
+	Name string
+	// DisplayName is the human-readable model name
+	DisplayName string
+	// ModelID is the Bedrock model ID or inference profile ARN
+	ModelID string
+	// SupportsLatencyOptimized reports whether the model accepts latency-optimized inference
+	SupportsLatencyOptimized bool
+	// SupportsStructuredOutput reports whether the model supports forced tool use
+	SupportsStructuredOutput bool
+	// ContextWindow is the maximum number of tokens of a prompt and its response
+	ContextWindow int
+	// SupportsCountTokens reports whether Bedrock's CountTokens API counts the model's prompts
+	SupportsCountTokens bool
+	// SupportsImages reports whether the model accepts images in its messages
+	SupportsImages bool
+	// SupportsVideo reports whether the model accepts videos in its messages
+	SupportsVideo bool
+	// SupportsThinking reports whether the model can reason before it answers (extended thinking)
+	SupportsThinking bool
+	// AlwaysReasons reports whether the model reasons before every answer, like DeepSeek-R1
+	AlwaysReasons bool
+	// New creates a bedrock.Model for this model
+	New func(client bedrock.Runtime, opts bedrock.Options) bedrock.Model
+}
+
+// registry lists the available models, default first
+var registry = []Info{
+	{nova.Name, "Nova", nova.ModelID, nova.SupportsLatencyOptimized, nova.SupportsStructuredOutput, nova.ContextWindow, nova.SupportsCountTokens, nova.SupportsImages, nova.SupportsVideo, nova.SupportsThinking, nova.AlwaysReasons, nova.New},
+	{llama.Name, "Llama", llama.ModelID, llama.SupportsLatencyOptimized, llama.SupportsStructuredOutput, llama.ContextWindow, llama.SupportsCountTokens, llama.SupportsImages, llama.SupportsVideo, llama.SupportsThinking, llama.AlwaysReasons, llama.New},
+	{llama70b.Name, "Llama 3.3 70B", llama70b.ModelID, llama70b.SupportsLatencyOptimized, llama70b.SupportsStructuredOutput, llama70b.ContextWindow, llama70b.SupportsCountTokens, llama70b.SupportsImages, llama70b.SupportsVideo, llama70b.SupportsThinking, llama70b.AlwaysReasons, llama70b.New},
+	{claude.Name, "Claude 3.5 Sonnet v2", claude.ModelID, claude.SupportsLatencyOptimized, claude.SupportsStructuredOutput, claude.ContextWindow, claude.SupportsCountTokens, claude.SupportsImages, claude.SupportsVideo, claude.SupportsThinking, claude.AlwaysReasons, claude.New},
+	{deepseek.Name, "DeepSeek", deepseek.ModelID, deepseek.SupportsLatencyOptimized, deepseek.SupportsStructuredOutput, deepseek.ContextWindow, deepseek.SupportsCountTokens, deepseek.SupportsImages, deepseek.SupportsVideo, deepseek.SupportsThinking, deepseek.AlwaysReasons, deepseek.New},
+}
+
+// Versions returns the names of the versions the named model can be pinned to; nil for the
+// models without versions
+func Versions(name string) []string {
+	if name == claude.Name {
+		return claude.VersionNames()
+	}
+	return nil
+}
+
+// PinVersion returns a copy of the model registered under name that invokes one of its
+// versions, the names of which come from the model package's version table; only Claude has
+// versions. The copy is invoked under the model's name, and the registry is left as it is.
+func PinVersion(name, version string) (Info, error) {
+	info, ok := Lookup(name)
+	if !ok {
+		return Info{}, fmt.Errorf("unknown model %q", name)
+	}
+	if Versions(name) == nil {
+		return Info{}, fmt.Errorf("the %s model has no versions to pin", name)
+	}
+	v, ok := claude.LookupVersion(version)
+	if !ok {
+		return Info{}, fmt.Errorf("unknown Claude version %q. Use %s", version, strings.Join(claude.VersionNames(), ", "))
+	}
+	info.DisplayName = v.DisplayName
+	info.ModelID = v.ModelID
+	info.SupportsThinking = v.SupportsThinking
+	info.New = func(client bedrock.Runtime, opts bedrock.Options) bedrock.Model {
+		opts.ModelVersion = v.Name
+		return claude.New(client, opts)
+	}
+	return info, nil
+}
+
+// Lookup returns the model registered under the given name
+func Lookup(name string) (Info, bool) {
+	for _, info := range registry {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return Info{}, false
+}
+
+// Names returns the names of all registered models
+func Names() []string {
+	names := make([]string, len(registry))
+	for i, info := range registry {
+		names[i] = info.Name
+	}
+	return names
+}
+
+// Usage returns the model names formatted for help and error messages, e.g. "'nova', 'llama' or 'claude'"
+func Usage() string {
+	quoted := make([]string, len(registry))
+	for i, info := range registry {
+		quoted[i] = "'" + info.Name + "'"
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + ", or " + quoted[len(quoted)-1]
+}
+
+// ImageInfo describes an image generation model available to the imagine subcommand
+type ImageInfo struct {
+	// Name is the short model name (e.g. "canvas")
+	Name string
+	// DisplayName is the human-readable model name
+	DisplayName string
+	// ModelID is the Bedrock model ID
+	ModelID string
+	// New creates a bedrock.ImageModel for this model
+	New func(client bedrock.Invoker, opts bedrock.Options) bedrock.ImageModel
+}
+
+// imageRegistry lists the available image generation models, default first
+var imageRegistry = []ImageInfo{
+	{canvas.Name, "Nova Canvas", canvas.ModelID, canvas.New},
+	{canvas.TitanName, "Titan Image Generator v2", canvas.TitanModelID, canvas.NewTitan},
+	{stability.Name, "Stable Diffusion 3.5 Large", stability.ModelID, stability.New},
+	{stability.SDXLName, "Stable Diffusion XL", stability.SDXLModelID, stability.NewSDXL},
+}
+
+// LookupImage returns the image generation model registered under the given name
+func LookupImage(name string) (ImageInfo, bool) {
+	for _, info := range imageRegistry {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return ImageInfo{}, false
+}
+
+// ImageNames returns the names of all registered image generation models
+func ImageNames() []string {
+	names := make([]string, len(imageRegistry))
+	for i, info := range imageRegistry {
+		names[i] = info.Name
+	}
+	return names
+}
+
+// EmbeddingInfo describes an embedding model available to the embed subcommand
+type EmbeddingInfo struct {
+	// Name is the short model name (e.g. "titan-embed")
+	Name string
+	// DisplayName is the human-readable model name
+	DisplayName string
+	// ModelID is the Bedrock model ID
+	ModelID string
+	// New creates a bedrock.EmbeddingModel for this model
+	New func(client bedrock.Invoker, opts bedrock.Options) bedrock.EmbeddingModel
+}
+
+// embeddingRegistry lists the available embedding models, default first
+var embeddingRegistry = []EmbeddingInfo{
+	{titan.Name, "Titan Text Embeddings V2", titan.ModelID, titan.New},
+	{cohere.Name, "Cohere Embed English v3", cohere.ModelID, cohere.New},
+	{cohere.MultilingualName, "Cohere Embed Multilingual v3", cohere.MultilingualModelID, cohere.NewMultilingual},
+}
+
+// LookupEmbedding returns the embedding model registered under the given name
+func LookupEmbedding(name string) (EmbeddingInfo, bool) {
+	for _, info := range embeddingRegistry {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return EmbeddingInfo{}, false
+}
+
+// EmbeddingNames returns the names of all registered embedding models
+func EmbeddingNames() []string {
+	names := make([]string, len(embeddingRegistry))
+	for i, info := range embeddingRegistry {
+		names[i] = info.Name
+	}
+	return names
+}
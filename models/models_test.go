@@ -0,0 +1,80 @@
+package models
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/bedrock/bedrocktest"
+	"context"
+	"testing"
+)
+
+// The fake must stand in for the Bedrock client of every registered model
+var _ bedrock.Runtime = (*bedrocktest.Invoker)(nil)
+
+func TestRegistryNew(t *testing.T) {
+	llamaAnswer := `{"generation": "[{\"series\": \"Friends\"}]", "prompt_token_count": 95, "generation_token_count": 10, "stop_reason": "stop"}`
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"nova", `{"output": {"message": {"role": "assistant", "content": [{"text": "[{\"series\": \"Friends\"}]"}]}}, "stopReason": "end_turn", "usage": {"inputTokens": 130, "outputTokens": 9}}`},
+		{"llama", llamaAnswer},
+		{"llama70b", llamaAnswer},
+		{"claude", `{"role": "assistant", "content": [{"type": "text", "text": "[{\"series\": \"Friends\"}]"}], "stop_reason": "end_turn", "usage": {"input_tokens": 118, "output_tokens": 11}}`},
+		{"deepseek", `{"choices": [{"text": "Friends.\n</think>\n\n[{\"series\": \"Friends\"}]", "stop_reason": "stop"}]}`},
+	}
+	if len(tests) != len(Names()) {
+		t.Fatalf("the test covers %d models, the registry has %v", len(tests), Names())
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := Lookup(tt.name)
+			if !ok {
+				t.Fatalf("%s isn't registered", tt.name)
+			}
+			client := &bedrocktest.Invoker{Body: tt.body}
+			model := info.New(client, bedrock.Options{})
+			if got := model.Name(); got != tt.name {
+				t.Errorf("Name() = %q, want %q", got, tt.name)
+			}
+			result, err := model.Invoke(context.Background(), "Which series is Friends.S01E03.mkv?")
+			if err != nil {
+				t.Fatalf("Invoke: %v", err)
+			}
+			if got := client.ModelID(t); got != info.ModelID {
+				t.Errorf("model ID = %q, want %q", got, info.ModelID)
+			}
+			if want := `[{"series": "Friends"}]`; result.Text != want {
+				t.Errorf("text = %q, want %q", result.Text, want)
+			}
+		})
+	}
+}
+
+func TestPinVersion(t *testing.T) {
+	registered, _ := Lookup("claude")
+	pinned, err := PinVersion("claude", "sonnet-4")
+	if err != nil {
+		t.Fatalf("PinVersion: %v", err)
+	}
+	if pinned.Name != "claude" || pinned.DisplayName != "Claude Sonnet 4" || !pinned.SupportsThinking {
+		t.Errorf("pinned = %s (%s, thinking %v), want claude (Claude Sonnet 4, thinking true)", pinned.Name, pinned.DisplayName, pinned.SupportsThinking)
+	}
+	client := &bedrocktest.Invoker{Body: `{"role": "assistant", "content": [{"type": "text", "text": "[]"}], "stop_reason": "end_turn", "usage": {"input_tokens": 1, "output_tokens": 1}}`}
+	if _, err := pinned.New(client, bedrock.Options{}).Invoke(context.Background(), "Which series is Friends.S01E03.mkv?"); err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if got := client.ModelID(t); got != pinned.ModelID {
+		t.Errorf("model ID = %q, want the pinned %q", got, pinned.ModelID)
+	}
+
+	// The registry keeps the default version
+	if got, _ := Lookup("claude"); got.ModelID != registered.ModelID || got.DisplayName != "Claude 3.5 Sonnet v2" {
+		t.Errorf("Lookup after PinVersion = %s (%s), want %s (Claude 3.5 Sonnet v2)", got.ModelID, got.DisplayName, registered.ModelID)
+	}
+
+	for _, tt := range []struct{ name, version string }{{"nova", "sonnet-4"}, {"claude", "sonnet-9"}, {"gpt", "sonnet-4"}} {
+		if _, err := PinVersion(tt.name, tt.version); err == nil {
+			t.Errorf("PinVersion(%q, %q) succeeded", tt.name, tt.version)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Moderation modes and actions of -moderate and -moderation-action
+const (
+	moderateInput  = "input"
+	moderateOutput = "output"
+	moderateBoth   = "both"
+
+	moderationBlock = "block"
+	moderationFlag  = "flag"
+)
+
+// moderator classifies the prompts and responses of every invocation with Llama Guard, from
+// -llama-guard. Unsafe content is blocked with a *bedrock.ModerationError, or only flagged in
+// the result's moderation verdicts.
+type moderator struct {
+	client  bedrock.Invoker
+	modelID string
+	input   bool
+	output  bool
+	block   bool
+	retry   bedrock.RetryPolicy
+	timeout time.Duration
+}
+
+// newModerator creates the moderator of -llama-guard; nil without a model ID
+func newModerator(client bedrock.Invoker, modelID, mode, action string, retry bedrock.RetryPolicy, timeout time.Duration) (*moderator, error) {
+	if modelID == "" {
+		return nil, nil
+	}
+	m := &moderator{client: client, modelID: modelID, retry: retry, timeout: timeout}
+	switch strings.ToLower(mode) {
+	case moderateInput:
+		m.input = true
+	case moderateOutput:
+		m.output = true
+	case moderateBoth:
+		m.input, m.output = true, true
+	default:
+		return nil, fmt.Errorf("invalid -moderate %q. Use %s, %s or %s", mode, moderateInput, moderateOutput, moderateBoth)
+	}
+	switch strings.ToLower(action) {
+	case moderationBlock:
+		m.block = true
+	case moderationFlag:
+	default:
+		return nil, fmt.Errorf("invalid -moderation-action %q. Use %s or %s", action, moderationBlock, moderationFlag)
+	}
+	return m, nil
+}
+
+func (m *moderator) String() string {
+	switch {
+	case m.input && m.output:
+		return "prompts and responses"
+	case m.input:
+		return "prompts"
+	}
+	return "responses"
+}
+
+// moderationSettings are what decides which answers a moderator lets through, for the response
+// cache to key results by
+type moderationSettings struct {
+	ModelID string `json:"model_id"`
+	Input   bool   `json:"input"`
+	Output  bool   `json:"output"`
+	Block   bool   `json:"block"`
+}
+
+// settings returns the moderator's settings; nil without a moderator
+func (m *moderator) settings() *moderationSettings {
+	if m == nil {
+		return nil
+	}
+	return &moderationSettings{ModelID: m.modelID, Input: m.input, Output: m.output, Block: m.block}
+}
+
+// wrap returns a model whose prompts and responses are moderated; the model itself without a moderator
+func (m *moderator) wrap(model bedrock.Model) bedrock.Model {
+	if m == nil {
+		return model
+	}
+	return &moderatedModel{Model: model, moderator: m}
+}
+
+// moderate classifies the prompt before invoking the model with call, and the response after.
+// A failed classification fails the invocation, so that nothing goes through unmoderated.
+func (m *moderator) moderate(ctx context.Context, name string, turns []bedrock.Turn, call func() (*bedrock.Result, error)) (*bedrock.Result, error) {
+	moderation := &bedrock.Moderation{}
+	if m.input {
+		verdict, err := m.classify(ctx, name, bedrock.GuardrailSourceInput, turns)
+		if err != nil {
+			return nil, err
+		}
+		moderation.Input = verdict
+	}
+
+	result, err := call()
+	if result == nil {
+		return nil, err
+	}
+	if m.input {
+		result.Moderation = moderation
+	}
+	if err != nil || !m.output || strings.TrimSpace(result.Text) == "" {
+		return result, err
+	}
+	answered := append(append([]bedrock.Turn(nil), turns...), bedrock.Turn{Role: bedrock.RoleAssistant, Text: result.Text})
+	moderation.Output, err = m.classify(ctx, name, bedrock.GuardrailSourceOutput, answered)
+	result.Moderation = moderation
+	return result, err
+}
+
+// classify returns Llama Guard's verdict on the last turn, or a *bedrock.ModerationError when
+// it's unsafe and unsafe content is blocked
+func (m *moderator) classify(ctx context.Context, name, source string, turns []bedrock.Turn) (*bedrock.ModerationVerdict, error) {
+	verdict, err := bedrock.ClassifyLlamaGuard(ctx, m.client, m.modelID, source, turns, m.retry, m.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if verdict.Safe {
+		return verdict, nil
+	}
+	if m.block {
+		return verdict, &bedrock.ModerationError{Model: name, Source: source, Verdict: verdict}
+	}
+	what := "response"
+	if source == bedrock.GuardrailSourceInput {
+		what = "prompt"
+	}
+	warnf("Llama Guard flagged the %s %s as unsafe: %s", name, what, orDash(verdict.Labels()))
+	return verdict, nil
+}
+
+// moderatedModel is a Model whose prompts and responses are classified by Llama Guard
+type moderatedModel struct {
+	bedrock.Model
+	moderator *moderator
+}
+
+func (m *moderatedModel) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *moderatedModel) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	return m.moderator.moderate(ctx, m.Name(), turns, func() (*bedrock.Result, error) {
+		return m.Model.Chat(ctx, turns)
+	})
+}
+
+// Stream streams the response as it's generated, so the response is only classified once it has
+// been streamed; a blocked response is still returned with the error
+func (m *moderatedModel) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	streamer, ok := m.Model.(bedrock.Streamer)
+	if !ok {
+		return nil, fmt.Errorf("the %s model doesn't support streaming", m.Name())
+	}
+	return m.moderator.moderate(ctx, m.Name(), turns, func() (*bedrock.Result, error) {
+		return streamer.Stream(ctx, turns, onText)
+	})
+}
+
+func (m *moderatedModel) UseTools(ctx context.Context, turns []bedrock.Turn, tools []bedrock.Tool, onCall func(call bedrock.ToolCall)) (*bedrock.Result, error) {
+	toolUser, ok := m.Model.(bedrock.ToolUser)
+	if !ok {
+		return nil, fmt.Errorf("the %s model can't call tools", m.Name())
+	}
+	return m.moderator.moderate(ctx, m.Name(), turns, func() (*bedrock.Result, error) {
+		return toolUser.UseTools(ctx, turns, tools, onCall)
+	})
+}
@@ -1,121 +1,271 @@
 package nova
 
 import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/extract"
+	"bedrock-llama/logging"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 )
 
 // ModelID is the AWS Bedrock Nova inference profile ARN
 const ModelID = "arn:aws:bedrock:us-east-2:913524932967:inference-profile/us.amazon.nova-pro-v1:0"
 
-// Content represents a message content item
+// Name is the short model name used on the command line
+const Name = "nova"
+
+// SupportsLatencyOptimized reports whether the model accepts performanceConfig latency=optimized
+// (Amazon Nova Pro supports latency-optimized inference)
+const SupportsLatencyOptimized = true
+
+// SupportsStructuredOutput reports whether the model can be forced to call a tool for structured output
+const SupportsStructuredOutput = true
+
+// ContextWindow is the maximum number of tokens of a prompt and its response (Nova Pro)
+const ContextWindow = 300000
+
+// SupportsCountTokens reports whether Bedrock's CountTokens API counts the model's prompts
+const SupportsCountTokens = false
+
+// SupportsImages reports whether the model accepts images in its messages
+const SupportsImages = true
+
+// SupportsVideo reports whether the model accepts videos in its messages
+const SupportsVideo = true
+
+// SupportsThinking reports whether the model can reason before it answers (extended thinking)
+const SupportsThinking = false
+
+// AlwaysReasons reports whether the model reasons before every answer, without a thinking budget
+const AlwaysReasons = false
+
+// Content represents a message content item: text, an image, a video, a tool call or its result
 type Content struct {
+	Text       string      `json:"text,omitempty"`
+	Image      *Image      `json:"image,omitempty"`
+	Video      *Video      `json:"video,omitempty"`
+	ToolUse    *ToolUse    `json:"toolUse,omitempty"`
+	ToolResult *ToolResult `json:"toolResult,omitempty"`
+}
+
+// Image represents an image content item
+type Image struct {
+	Format string      `json:"format"`
+	Source ImageSource `json:"source"`
+}
+
+// ImageSource holds the image data, base64-encoded in the JSON payload
+type ImageSource struct {
+	Bytes []byte `json:"bytes"`
+}
+
+// Video represents a video content item
+type Video struct {
+	Format string      `json:"format"`
+	Source VideoSource `json:"source"`
+}
+
+// VideoSource holds the video data, base64-encoded in the JSON payload, or its S3 location
+type VideoSource struct {
+	Bytes      []byte      `json:"bytes,omitempty"`
+	S3Location *S3Location `json:"s3Location,omitempty"`
+}
+
+// S3Location is an S3 object the model reads
+type S3Location struct {
+	URI string `json:"uri"`
+}
+
+// ToolUse represents a tool call requested by the model
+type ToolUse struct {
+	ToolUseID string          `json:"toolUseId"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+}
+
+// ToolResult returns the outcome of a tool call to the model
+type ToolResult struct {
+	ToolUseID string              `json:"toolUseId"`
+	Content   []ToolResultContent `json:"content"`
+	// Status is "error" when the tool failed
+	Status string `json:"status,omitempty"`
+}
+
+// ToolResultContent is a text item of a tool result
+type ToolResultContent struct {
 	Text string `json:"text"`
 }
 
+// InputSchema wraps the JSON schema of a tool's input
+type InputSchema struct {
+	JSON json.RawMessage `json:"json"`
+}
+
+// ToolSpec describes a tool the model can call
+type ToolSpec struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema InputSchema `json:"inputSchema"`
+}
+
+// Tool represents a tool definition in the tool configuration
+type Tool struct {
+	ToolSpec ToolSpec `json:"toolSpec"`
+}
+
+// SpecificTool names the tool the model must call
+type SpecificTool struct {
+	Name string `json:"name"`
+}
+
+// ToolChoice controls how the model uses the provided tools: Auto lets it decide whether to
+// call one, Tool forces a call of the named tool
+type ToolChoice struct {
+	Auto *struct{}     `json:"auto,omitempty"`
+	Tool *SpecificTool `json:"tool,omitempty"`
+}
+
+// ToolConfig represents the tool configuration for the inference
+type ToolConfig struct {
+	Tools      []Tool      `json:"tools"`
+	ToolChoice *ToolChoice `json:"toolChoice,omitempty"`
+}
+
 // Message represents a message in the conversation
 type Message struct {
 	Role    string    `json:"role"`
 	Content []Content `json:"content"`
 }
 
+// SchemaVersion is the version of the request schema the payload follows
+const SchemaVersion = "messages-v1"
+
 // InferenceConfig represents the configuration for the inference
 type InferenceConfig struct {
 	MaxNewTokens int     `json:"max_new_tokens"`
 	Temperature  float64 `json:"temperature"`
 	TopP         float64 `json:"top_p"`
+	// TopK limits sampling to the K most likely tokens; the model's default when zero
+	TopK          int      `json:"top_k,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+// SystemContent is an item of the system prompt
+type SystemContent struct {
+	Text string `json:"text"`
 }
 
 // Payload represents the request payload for the Amazon Nova model
 type Payload struct {
+	SchemaVersion   string          `json:"schemaVersion"`
+	System          []SystemContent `json:"system,omitempty"`
 	InferenceConfig InferenceConfig `json:"inferenceConfig"`
 	Messages        []Message       `json:"messages"`
+	ToolConfig      *ToolConfig     `json:"toolConfig,omitempty"`
 }
 
 // Response represents the response from the Amazon Nova model
 type Response struct {
 	Output struct {
-		Role    string    `json:"role"`
-		Content []Content `json:"content"`
-		Stop    bool      `json:"stop"`
+		Message Message `json:"message"`
 	} `json:"output"`
-	Usage struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
+	StopReason string `json:"stopReason"`
+	Usage      struct {
+		InputTokens  int `json:"inputTokens"`
+		OutputTokens int `json:"outputTokens"`
 	} `json:"usage"`
+	// Latency is the performanceConfig latency Bedrock served, taken from the response metadata
+	Latency string `json:"-"`
+	// Guardrail is the trace of the guardrail when it intervened
+	Guardrail *bedrock.GuardrailTrace `json:"-"`
 }
 
 // InvokeModel calls the Nova model with the given prompt
-func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKey, awsRegion string) (*Response, error) {
-	// Debug output to verify prompt
-	log.Printf("Sending prompt to Nova model: %s", prompt)
-
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(awsRegion),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			accessKeyId,
-			secretAccessKey,
-			"", // Session token (empty for regular access keys)
-		)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
-	}
+func InvokeModel(ctx context.Context, client bedrock.Invoker, prompt string, opts bedrock.Options) (*Response, error) {
+	return InvokeConversation(ctx, client, bedrock.UserTurn(prompt), opts)
+}
 
-	// Create a Bedrock Runtime client
-	client := bedrockruntime.NewFromConfig(cfg)
+// InvokeConversation calls the Nova model with a multi-turn conversation
+func InvokeConversation(ctx context.Context, client bedrock.Invoker, turns []bedrock.Turn, opts bedrock.Options) (*Response, error) {
+	prompt := turns[len(turns)-1].Text
+
+	// Debug output to verify prompt
+	logging.Debugf("Sending prompt to Nova model: %s", prompt)
 
 	// Prepare payload according to Amazon Nova requirements
-	payload := Payload{
-		InferenceConfig: InferenceConfig{
-			MaxNewTokens: 512,
-			Temperature:  0.7,
-			TopP:         0.9,
-		},
-		Messages: []Message{
-			{
-				Role: "user",
-				Content: []Content{
-					{
-						Text: prompt,
+	payload := newPayload(turns, opts)
+
+	// Force a single tool call whose input schema is the requested output shape
+	if opts.Structured != nil {
+		payload.ToolConfig = &ToolConfig{
+			Tools: []Tool{
+				{
+					ToolSpec: ToolSpec{
+						Name:        opts.Structured.Name,
+						Description: opts.Structured.Description,
+						InputSchema: InputSchema{JSON: opts.Structured.Schema},
 					},
 				},
 			},
+			ToolChoice: &ToolChoice{Tool: &SpecificTool{Name: opts.Structured.Name}},
+		}
+	}
+
+	return invoke(ctx, client, payload, opts)
+}
+
+// newPayload returns the request payload of a conversation, with the inference configuration
+// and the system prompt of opts
+func newPayload(turns []bedrock.Turn, opts bedrock.Options) Payload {
+	payload := Payload{
+		SchemaVersion: SchemaVersion,
+		InferenceConfig: InferenceConfig{
+			MaxNewTokens:  opts.MaxTokensOr(512),
+			Temperature:   opts.TemperatureOr(0.7),
+			TopP:          opts.TopPOr(0.9),
+			TopK:          opts.TopK,
+			StopSequences: opts.StopSequences,
 		},
+		Messages: messages(turns),
 	}
+	if opts.System != "" {
+		payload.System = []SystemContent{{Text: opts.System}}
+	}
+	return payload
+}
 
+// invoke sends a request payload to the Nova model
+func invoke(ctx context.Context, client bedrock.Invoker, payload Payload, opts bedrock.Options) (*Response, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
 	// Debug: Log the payload being sent to the model
-	log.Printf("Payload: %s", string(payloadBytes))
+	logging.Debugf("Payload: %s", string(payloadBytes))
 
 	// Create the input for the InvokeModel operation
 	input := &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(ModelID),
-		ContentType: aws.String("application/json"),
-		Body:        payloadBytes,
+		ModelId:                  aws.String(ModelID),
+		ContentType:              aws.String("application/json"),
+		Body:                     payloadBytes,
+		PerformanceConfigLatency: opts.PerformanceLatency("Nova", SupportsLatencyOptimized),
 	}
 
 	// Invoke the model
-	output, err := client.InvokeModel(ctx, input)
+	output, err := bedrock.Invoke(ctx, client, input, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error invoking Bedrock Nova model: %v", err)
+		return nil, fmt.Errorf("error invoking Bedrock Nova model: %w", err)
 	}
 
 	// Debug: Log the raw response
-	log.Printf("Raw response: %s", string(output.Body))
+	logging.Debugf("Raw response: %s", string(output.Body))
 
 	var response Response
 	if err := json.Unmarshal(output.Body, &response); err != nil {
@@ -124,34 +274,218 @@ func InvokeModel(ctx context.Context, prompt string, accessKeyId, secretAccessKe
 
 	// Debug: Log the parsed response structure
 	responseBytes, _ := json.MarshalIndent(response, "", "  ")
-	log.Printf("Parsed response: %s", string(responseBytes))
+	logging.Debugf("Parsed response: %s", string(responseBytes))
+
+	if bedrock.GuardrailIntervened(output.Body) {
+		response.StopReason = bedrock.StopGuardrailIntervened
+		response.Guardrail = bedrock.ParseGuardrailTrace(output.Body)
+	}
+	response.Latency = string(output.PerformanceConfigLatency)
+	opts.LogLatency(response.Latency)
 
 	return &response, nil
 }
 
+// messages converts conversation turns into Nova messages. Videos and images come before the
+// text, as Nova recommends.
+func messages(turns []bedrock.Turn) []Message {
+	messages := make([]Message, 0, len(turns))
+	for _, turn := range turns {
+		content := make([]Content, 0, len(turn.Videos)+len(turn.Images)+1)
+		for _, video := range turn.Videos {
+			source := VideoSource{Bytes: video.Data}
+			if video.S3URI != "" {
+				source = VideoSource{S3Location: &S3Location{URI: video.S3URI}}
+			}
+			content = append(content, Content{Video: &Video{Format: video.Format, Source: source}})
+		}
+		for _, image := range turn.Images {
+			content = append(content, Content{Image: &Image{Format: image.Format, Source: ImageSource{Bytes: image.Data}}})
+		}
+		messages = append(messages, Message{
+			Role:    turn.Role,
+			Content: append(content, Content{Text: turn.Text}),
+		})
+	}
+	return messages
+}
+
+// Text returns the generated text from the Nova response
+func (r *Response) Text() string {
+	for _, content := range r.Output.Message.Content {
+		if content.Text != "" {
+			return content.Text
+		}
+	}
+	return ""
+}
+
+// ToolInput returns the input of the first tool call in the Nova response, or nil if there is none
+func (r *Response) ToolInput() json.RawMessage {
+	for _, content := range r.Output.Message.Content {
+		if content.ToolUse != nil {
+			return content.ToolUse.Input
+		}
+	}
+	return nil
+}
+
+type model struct {
+	client bedrock.Runtime
+	opts   bedrock.Options
+}
+
+// New returns a bedrock.Model that invokes Nova through the given client
+func New(client bedrock.Runtime, opts bedrock.Options) bedrock.Model {
+	return &model{client: client, opts: opts}
+}
+
+func (m *model) Name() string {
+	return Name
+}
+
+func (m *model) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *model) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	// The native request body can't carry documents, the Converse API can
+	if bedrock.HasDocuments(turns) {
+		return m.Stream(ctx, turns, func(string) {})
+	}
+	response, err := InvokeConversation(ctx, m.client, turns, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	text := response.Text()
+	if input := response.ToolInput(); input != nil {
+		text = string(input)
+	}
+	result := &bedrock.Result{
+		Model:        Name,
+		Text:         text,
+		InputTokens:  response.Usage.InputTokens,
+		OutputTokens: response.Usage.OutputTokens,
+		Latency:      response.Latency,
+		Guardrail:    response.Guardrail,
+		StopReason:   response.StopReason,
+	}
+	// A filtered response is returned with the error, so its tokens are still accounted for
+	return result, bedrock.CheckStopReason(result)
+}
+
+// Stream streams the conversation through the Converse API
+func (m *model) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	return bedrock.ConverseStream(ctx, m.client, m.streamRequest(), turns, onText)
+}
+
+// streamRequest returns the Converse request of the model's options
+func (m *model) streamRequest() bedrock.StreamRequest {
+	return bedrock.StreamRequest{
+		Model:         Name,
+		ModelID:       ModelID,
+		Latency:       m.opts.PerformanceLatency(Name, SupportsLatencyOptimized),
+		Retry:         m.opts.Retry,
+		Timeout:       m.opts.Timeout,
+		MaxTokens:     m.opts.MaxTokens,
+		System:        m.opts.System,
+		StopSequences: m.opts.StopSequences,
+		Temperature:   m.opts.Temperature,
+		TopP:          m.opts.TopP,
+		TopK:          m.opts.TopK,
+		Guardrail:     m.opts.Guardrail,
+	}
+}
+
+// UseTools runs a tool-use conversation through Nova's native toolConfig, letting the model
+// choose which tools to call. Each tool call's result is sent back as a toolResult item of a
+// user message until the model answers in text.
+func (m *model) UseTools(ctx context.Context, turns []bedrock.Turn, tools []bedrock.Tool, onCall func(call bedrock.ToolCall)) (*bedrock.Result, error) {
+	// The native request body can't carry documents, the Converse API can
+	if bedrock.HasDocuments(turns) {
+		return bedrock.ConverseTools(ctx, m.client, m.streamRequest(), turns, tools, m.opts.MaxToolRounds, onCall)
+	}
+	if err := bedrock.CheckTools(tools); err != nil {
+		return nil, err
+	}
+	maxRounds := m.opts.MaxToolRounds
+	if maxRounds <= 0 {
+		maxRounds = bedrock.DefaultMaxToolRounds
+	}
+
+	config := &ToolConfig{ToolChoice: &ToolChoice{Auto: &struct{}{}}}
+	for _, tool := range tools {
+		config.Tools = append(config.Tools, Tool{ToolSpec: ToolSpec{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: InputSchema{JSON: tool.Schema},
+		}})
+	}
+	payload := newPayload(turns, m.opts)
+	payload.ToolConfig = config
+
+	result := &bedrock.Result{Model: Name}
+	for round := 0; ; round++ {
+		response, err := invoke(ctx, m.client, payload, m.opts)
+		if err != nil {
+			return nil, err
+		}
+		result.InputTokens += response.Usage.InputTokens
+		result.OutputTokens += response.Usage.OutputTokens
+		result.Latency = response.Latency
+		result.StopReason = response.StopReason
+		result.Guardrail = response.Guardrail
+		if response.StopReason != "tool_use" {
+			result.Text = response.Text()
+			return result, bedrock.CheckStopReason(result)
+		}
+		if round >= maxRounds {
+			return nil, fmt.Errorf("%w: %s still calling tools after %d rounds", bedrock.ErrToolRounds, Name, maxRounds)
+		}
+
+		var results []Content
+		for _, content := range response.Output.Message.Content {
+			if content.ToolUse == nil {
+				continue
+			}
+			call := bedrock.CallTool(ctx, tools, content.ToolUse.Name, content.ToolUse.Input)
+			if onCall != nil {
+				onCall(call)
+			}
+			toolResult := &ToolResult{ToolUseID: content.ToolUse.ToolUseID, Content: []ToolResultContent{{Text: call.Output}}}
+			if call.Err != nil {
+				toolResult.Content = []ToolResultContent{{Text: call.Err.Error()}}
+				toolResult.Status = "error"
+			}
+			results = append(results, Content{ToolResult: toolResult})
+		}
+		if len(results) == 0 {
+			return nil, fmt.Errorf("%s stopped to use tools without calling any", Name)
+		}
+		payload.Messages = append(payload.Messages,
+			Message{Role: bedrock.RoleAssistant, Content: response.Output.Message.Content},
+			Message{Role: bedrock.RoleUser, Content: results})
+	}
+}
+
 // PrintResponse formats and prints the Nova model response
 func PrintResponse(response *Response) {
 	var output string
-	if len(response.Output.Content) > 0 {
-		output = response.Output.Content[0].Text
+	if input := response.ToolInput(); input != nil {
+		// Structured output arrives as the tool input rather than text
+		output = string(input)
+	} else if len(response.Output.Message.Content) > 0 {
+		output = response.Text()
 	} else {
 		log.Println("No response content received from Nova model")
 		return
 	}
 
-	// Try to find the JSON array pattern and extract it
-	jsonPattern := regexp.MustCompile(`\[\s*{\s*"series"\s*:\s*"([^"]*)"\s*}\s*\]`)
-	if match := jsonPattern.FindStringSubmatch(output); len(match) > 1 {
-		fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
+	if series, err := extract.Series(output); err == nil {
+		fmt.Println(extract.FormatSeries(series))
 	} else {
-		// Try a fallback approach to extract just the series name
-		seriesPattern := regexp.MustCompile(`"series"\s*:\s*"([^"]*)"`)
-		if match := seriesPattern.FindStringSubmatch(output); len(match) > 1 {
-			fmt.Printf("[{\"series\": \"%s\"}]\n", match[1])
-		} else {
-			// Last resort: print the cleaned response
-			fmt.Println(strings.TrimSpace(output))
-		}
+		// Last resort: print the cleaned response
+		fmt.Println(strings.TrimSpace(output))
 	}
 
 	// Print token usage information as logs to not interfere with JSON output
@@ -0,0 +1,196 @@
+package nova
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/bedrock/bedrocktest"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// answer is a canned response body of a plain text answer
+const answer = `{"output": {"message": {"role": "assistant", "content": [{"text": "[{\"series\": \"Friends\"}]"}]}},
+	"stopReason": "end_turn", "usage": {"inputTokens": 130, "outputTokens": 9}}`
+
+// field is the expected value at a path of the marshalled payload; nil when it must be absent
+type field struct {
+	path []any
+	want any
+}
+
+func TestInvokeConversationPayload(t *testing.T) {
+	turns := []bedrock.Turn{{Role: bedrock.RoleUser, Text: "Friends Season 1 Episode 3"}}
+	tests := []struct {
+		name   string
+		turns  []bedrock.Turn
+		opts   bedrock.Options
+		fields []field
+	}{
+		{
+			name:  "defaults",
+			turns: turns,
+			fields: []field{
+				{[]any{"schemaVersion"}, SchemaVersion},
+				{[]any{"inferenceConfig", "max_new_tokens"}, 512.0},
+				{[]any{"inferenceConfig", "temperature"}, 0.7},
+				{[]any{"inferenceConfig", "top_p"}, 0.9},
+				{[]any{"inferenceConfig", "top_k"}, nil},
+				{[]any{"inferenceConfig", "stopSequences"}, nil},
+				{[]any{"system"}, nil},
+				{[]any{"messages", 0, "role"}, "user"},
+				{[]any{"messages", 0, "content", 0, "text"}, "Friends Season 1 Episode 3"},
+				{[]any{"toolConfig"}, nil},
+			},
+		},
+		{
+			name:  "full inference configuration",
+			turns: turns,
+			opts:  bedrock.Options{MaxTokens: 64, TopK: 40, StopSequences: []string{"]"}, System: "Answer in JSON."},
+			fields: []field{
+				{[]any{"inferenceConfig", "max_new_tokens"}, 64.0},
+				{[]any{"inferenceConfig", "top_k"}, 40.0},
+				{[]any{"inferenceConfig", "stopSequences", 0}, "]"},
+				{[]any{"system", 0, "text"}, "Answer in JSON."},
+			},
+		},
+		{
+			name:  "structured output forces the tool",
+			turns: turns,
+			opts: bedrock.Options{Structured: &bedrock.StructuredOutput{
+				Name:   "record_series",
+				Schema: json.RawMessage(`{"type": "object"}`),
+			}},
+			fields: []field{
+				{[]any{"toolConfig", "tools", 0, "toolSpec", "name"}, "record_series"},
+				{[]any{"toolConfig", "tools", 0, "toolSpec", "inputSchema", "json", "type"}, "object"},
+				{[]any{"toolConfig", "toolChoice", "tool", "name"}, "record_series"},
+			},
+		},
+		{
+			name: "video and image before the text",
+			turns: []bedrock.Turn{{
+				Role:   bedrock.RoleUser,
+				Text:   "Which series?",
+				Images: []bedrock.Image{{Format: "png", Data: []byte("png")}},
+				Videos: []bedrock.Video{{Format: "mp4", S3URI: "s3://clips/friends.mp4"}},
+			}},
+			fields: []field{
+				{[]any{"messages", 0, "content", 0, "video", "format"}, "mp4"},
+				{[]any{"messages", 0, "content", 0, "video", "source", "s3Location", "uri"}, "s3://clips/friends.mp4"},
+				{[]any{"messages", 0, "content", 1, "image", "format"}, "png"},
+				{[]any{"messages", 0, "content", 1, "image", "source", "bytes"}, "cG5n"},
+				{[]any{"messages", 0, "content", 2, "text"}, "Which series?"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: answer}
+			if _, err := InvokeConversation(context.Background(), client, tt.turns, tt.opts); err != nil {
+				t.Fatalf("InvokeConversation: %v", err)
+			}
+			if got := client.ModelID(t); got != ModelID {
+				t.Errorf("model ID = %q, want %q", got, ModelID)
+			}
+			payload := client.Payload(t)
+			for _, f := range tt.fields {
+				if got := bedrocktest.Field(payload, f.path...); !reflect.DeepEqual(got, f.want) {
+					t.Errorf("%v = %#v, want %#v", f.path, got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvokeConversationResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		text         string
+		toolInput    string
+		stopReason   string
+		inputTokens  int
+		outputTokens int
+	}{
+		{
+			name:         "text",
+			body:         answer,
+			text:         `[{"series": "Friends"}]`,
+			stopReason:   "end_turn",
+			inputTokens:  130,
+			outputTokens: 9,
+		},
+		{
+			name: "tool call",
+			body: `{"output": {"message": {"role": "assistant", "content": [
+				{"toolUse": {"toolUseId": "tool_1", "name": "record_series", "input": {"series": "Friends"}}}]}},
+				"stopReason": "tool_use", "usage": {"inputTokens": 320, "outputTokens": 25}}`,
+			toolInput:    `{"series": "Friends"}`,
+			stopReason:   "tool_use",
+			inputTokens:  320,
+			outputTokens: 25,
+		},
+		{
+			name: "cut off at the output cap",
+			body: `{"output": {"message": {"role": "assistant", "content": [{"text": "[{\"series\": \"Fri"}]}},
+				"stopReason": "max_tokens", "usage": {"inputTokens": 130, "outputTokens": 4}}`,
+			text:         `[{"series": "Fri`,
+			stopReason:   "max_tokens",
+			inputTokens:  130,
+			outputTokens: 4,
+		},
+		{
+			name: "guardrail intervention",
+			body: `{"output": {"message": {"role": "assistant", "content": [{"text": "Sorry."}]}},
+				"stopReason": "end_turn", "usage": {"inputTokens": 10, "outputTokens": 2},
+				"amazon-bedrock-guardrailAction": "INTERVENED"}`,
+			text:         "Sorry.",
+			stopReason:   bedrock.StopGuardrailIntervened,
+			inputTokens:  10,
+			outputTokens: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &bedrocktest.Invoker{Body: tt.body}
+			response, err := InvokeModel(context.Background(), client, "Friends Season 1 Episode 3", bedrock.Options{})
+			if err != nil {
+				t.Fatalf("InvokeModel: %v", err)
+			}
+			if got := response.Text(); got != tt.text {
+				t.Errorf("Text() = %q, want %q", got, tt.text)
+			}
+			if got := string(response.ToolInput()); got != tt.toolInput {
+				t.Errorf("ToolInput() = %s, want %s", got, tt.toolInput)
+			}
+			if response.StopReason != tt.stopReason {
+				t.Errorf("StopReason = %q, want %q", response.StopReason, tt.stopReason)
+			}
+			if response.Usage.InputTokens != tt.inputTokens || response.Usage.OutputTokens != tt.outputTokens {
+				t.Errorf("usage = %d/%d tokens, want %d/%d", response.Usage.InputTokens, response.Usage.OutputTokens, tt.inputTokens, tt.outputTokens)
+			}
+		})
+	}
+}
+
+func TestInvokeConversationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *bedrocktest.Invoker
+		want   string
+	}{
+		{"request fails", &bedrocktest.Invoker{Err: errors.New("connection refused")}, "error invoking Bedrock Nova model"},
+		{"invalid body", &bedrocktest.Invoker{Body: "not json"}, "failed to unmarshal response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := InvokeModel(context.Background(), tt.client, "Friends", bedrock.Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
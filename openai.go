@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chatCompletionRequest is the subset of the OpenAI chat completions request that is supported;
+// requests with other fields are rejected
+type chatCompletionRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream,omitempty"`
+	// StreamOptions asks for a last chunk with the token usage of a streamed response
+	StreamOptions *chatStreamOptions `json:"stream_options,omitempty"`
+	// MaxCompletionTokens, or the older MaxTokens, caps the response
+	MaxCompletionTokens int `json:"max_completion_tokens,omitempty"`
+	MaxTokens           int `json:"max_tokens,omitempty"`
+	// Temperature and TopP override the model's sampling
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	// Stop is a sequence that ends the response, or a list of them
+	Stop json.RawMessage `json:"stop,omitempty"`
+	// N is the number of choices to generate; only one is
+	N *int `json:"n,omitempty"`
+}
+
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// chatMessage is an OpenAI chat message. Content is either a string or a list of parts.
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// maxChatRequestSize is the largest chat completions request body, which may carry base64 images
+const maxChatRequestSize = 16 << 20
+
+// chatContentPart is one part of a multi-part message: text, or an image given as a data URL
+type chatContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// chatCompletion is the OpenAI chat completions response
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   chatUsage    `json:"usage"`
+}
+
+type chatChoice struct {
+	Index        int             `json:"index"`
+	Message      chatTextMessage `json:"message"`
+	FinishReason string          `json:"finish_reason"`
+}
+
+type chatTextMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatDelta is the part of the message a chunk adds: the role comes in the first chunk only
+type chatDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// chatCompletionChunk is one server-sent event of a streamed chat completion
+type chatCompletionChunk struct {
+	ID      string            `json:"id"`
+	Object  string            `json:"object"`
+	Created int64             `json:"created"`
+	Model   string            `json:"model"`
+	Choices []chatChunkChoice `json:"choices"`
+	// Usage is only set in the last chunk, with stream_options.include_usage
+	Usage *chatUsage `json:"usage,omitempty"`
+}
+
+type chatChunkChoice struct {
+	Index int       `json:"index"`
+	Delta chatDelta `json:"delta"`
+	// FinishReason is null until the last choice chunk
+	FinishReason *string `json:"finish_reason"`
+}
+
+// loadModelMap reads a JSON object mapping OpenAI model names to registered model names,
+// e.g. {"gpt-4o": "claude", "gpt-4o-mini": "nova"}
+func loadModelMap(path string) (map[string]string, error) {
+	aliases := map[string]string{}
+	if path == "" {
+		return aliases, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model map: %v", err)
+	}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("invalid model map %s: %v", path, err)
+	}
+	for alias, name := range aliases {
+		if _, ok := models.Lookup(name); !ok {
+			return nil, fmt.Errorf("model map %s: %q maps to unknown model %q", path, alias, name)
+		}
+	}
+	return aliases, nil
+}
+
+// resolveModel finds the registered model for an OpenAI model name or alias
+func (s *server) resolveModel(name string) (models.Info, bool) {
+	if name == "" {
+		return s.extractor.modelInfo, true
+	}
+	if target, ok := s.aliases[name]; ok {
+		name = target
+	}
+	return s.extractor.lookupModel(name)
+}
+
+// handleChatCompletions translates an OpenAI chat completions request into a Bedrock conversation
+func (s *server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxChatRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("unsupported field %s", field))
+			return
+		}
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	info, ok := s.resolveModel(req.Model)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("the model %q does not exist", req.Model))
+		return
+	}
+	turns, system, err := chatTurns(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if hasImages(turns) && !info.SupportsImages {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("images are not supported by the %s model", info.Name))
+		return
+	}
+
+	opts := s.extractor.chatOptions(info)
+	if system != "" {
+		opts.System = system
+	}
+	if err := req.applyOptions(&opts); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	model := req.Model
+	if model == "" {
+		model = info.Name
+	}
+	log.Printf("POST /v1/chat/completions model=%s (%s) turns=%d stream=%v", req.Model, info.Name, len(turns), req.Stream)
+	if req.Stream {
+		s.streamChatCompletion(w, r, model, s.extractor.newModel(info, opts), turns, req.StreamOptions != nil && req.StreamOptions.IncludeUsage)
+		return
+	}
+	result, err := s.extractor.newModel(info, opts).Chat(r.Context(), turns)
+	if result != nil {
+		charge(r.Context(), result.InputTokens, result.OutputTokens)
+	}
+	if errors.Is(err, errBudgetExceeded) {
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	now := time.Now()
+	writeJSON(w, http.StatusOK, chatCompletion{
+		ID:      fmt.Sprintf("chatcmpl-%x", now.UnixNano()),
+		Object:  "chat.completion",
+		Created: now.Unix(),
+		Model:   model,
+		Choices: []chatChoice{{
+			Message:      chatTextMessage{Role: bedrock.RoleAssistant, Content: result.Text},
+			FinishReason: finishReason(result),
+		}},
+		Usage: chatUsage{
+			PromptTokens:     result.InputTokens,
+			CompletionTokens: result.OutputTokens,
+			TotalTokens:      result.InputTokens + result.OutputTokens,
+		},
+	})
+}
+
+// applyOptions sets the response cap, the sampling and the stop sequences of the request on
+// opts, and rejects the values no Bedrock model takes
+func (req *chatCompletionRequest) applyOptions(opts *bedrock.Options) error {
+	if req.MaxCompletionTokens < 0 || req.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens and max_completion_tokens can't be negative")
+	}
+	if req.MaxCompletionTokens > 0 {
+		opts.MaxTokens = req.MaxCompletionTokens
+	} else if req.MaxTokens > 0 {
+		opts.MaxTokens = req.MaxTokens
+	}
+	if t := req.Temperature; t != nil {
+		if *t < 0 || *t > 1 {
+			return fmt.Errorf("temperature must be between 0 and 1")
+		}
+		opts.Temperature = t
+	}
+	if p := req.TopP; p != nil {
+		if *p < 0 || *p > 1 {
+			return fmt.Errorf("top_p must be between 0 and 1")
+		}
+		opts.TopP = p
+	}
+	if len(req.Stop) > 0 && string(req.Stop) != "null" {
+		var stop []string
+		var one string
+		if err := json.Unmarshal(req.Stop, &one); err == nil {
+			stop = []string{one}
+		} else if err := json.Unmarshal(req.Stop, &stop); err != nil {
+			return fmt.Errorf("stop must be a string or a list of strings")
+		}
+		for _, sequence := range stop {
+			if sequence == "" {
+				return fmt.Errorf("stop sequences can't be empty")
+			}
+		}
+		opts.StopSequences = stop
+	}
+	if req.N != nil && *req.N != 1 {
+		return fmt.Errorf("n must be 1: only one choice is generated")
+	}
+	if req.StreamOptions != nil && !req.Stream {
+		return fmt.Errorf("stream_options is only allowed with stream")
+	}
+	return nil
+}
+
+// streamChatCompletion streams the model's answer as server-sent chat.completion.chunk events,
+// ending with data: [DONE]. The response starts with the first chunk of text, so a request that
+// fails before it gets an error status; a failure after it is sent as an error event.
+func (s *server) streamChatCompletion(w http.ResponseWriter, r *http.Request, name string, model bedrock.Model, turns []bedrock.Turn, includeUsage bool) {
+	streamer, ok := model.(bedrock.Streamer)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("the %s model doesn't support streaming", model.Name()))
+		return
+	}
+	now := time.Now()
+	chunk := chatCompletionChunk{
+		ID:      fmt.Sprintf("chatcmpl-%x", now.UnixNano()),
+		Object:  "chat.completion.chunk",
+		Created: now.Unix(),
+		Model:   name,
+	}
+	controller := http.NewResponseController(w)
+	started := false
+	send := func(data any) {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		body, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", body)
+		controller.Flush()
+	}
+
+	result, err := streamer.Stream(r.Context(), turns, func(text string) {
+		delta := chatDelta{Content: text}
+		if !started {
+			delta.Role = bedrock.RoleAssistant
+		}
+		chunk.Choices = []chatChunkChoice{{Delta: delta}}
+		send(chunk)
+	})
+	if result != nil {
+		charge(r.Context(), result.InputTokens, result.OutputTokens)
+	}
+	if err != nil {
+		switch {
+		case started:
+			send(map[string]any{"error": map[string]string{"message": err.Error(), "type": "api_error"}})
+		case errors.Is(err, errBudgetExceeded):
+			writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		default:
+			writeOpenAIError(w, http.StatusBadGateway, err.Error())
+		}
+		return
+	}
+
+	reason := finishReason(result)
+	chunk.Choices = []chatChunkChoice{{FinishReason: &reason}}
+	if !started {
+		chunk.Choices[0].Delta.Role = bedrock.RoleAssistant
+	}
+	send(chunk)
+	if includeUsage {
+		chunk.Choices = []chatChunkChoice{}
+		chunk.Usage = &chatUsage{
+			PromptTokens:     result.InputTokens,
+			CompletionTokens: result.OutputTokens,
+			TotalTokens:      result.InputTokens + result.OutputTokens,
+		}
+		send(chunk)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	controller.Flush()
+}
+
+// finishReason returns the OpenAI finish_reason of a result
+func finishReason(result *bedrock.Result) string {
+	switch result.Status() {
+	case bedrock.StatusTruncated:
+		return "length"
+	case bedrock.StatusFiltered:
+		return "content_filter"
+	}
+	return "stop"
+}
+
+// handleModels lists the registered models and aliases in the OpenAI models format
+func (s *server) handleModels(w http.ResponseWriter, r *http.Request) {
+	names := models.Names()
+	for alias := range s.aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		OwnedBy string `json:"owned_by"`
+	}
+	list := struct {
+		Object string  `json:"object"`
+		Data   []model `json:"data"`
+	}{Object: "list"}
+	for _, name := range names {
+		list.Data = append(list.Data, model{ID: name, Object: "model", OwnedBy: "bedrock"})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// chatTurns converts OpenAI messages into conversation turns and a system prompt. The system
+// and developer messages make up the system prompt, wherever they are, and consecutive
+// messages from the same role are merged.
+func chatTurns(messages []chatMessage) ([]bedrock.Turn, string, error) {
+	var turns []bedrock.Turn
+	var system []string
+	for i, message := range messages {
+		text, images, err := messageContent(message.Content)
+		if err != nil {
+			return nil, "", fmt.Errorf("messages[%d]: %v", i, err)
+		}
+		role := message.Role
+		if len(images) > 0 && role != bedrock.RoleUser {
+			return nil, "", fmt.Errorf("messages[%d]: only user messages can contain images", i)
+		}
+		switch role {
+		case "system", "developer":
+			system = append(system, text)
+			continue
+		case bedrock.RoleUser, bedrock.RoleAssistant:
+		default:
+			return nil, "", fmt.Errorf("messages[%d]: unsupported role %q", i, role)
+		}
+		if n := len(turns); n > 0 && turns[n-1].Role == role {
+			turns[n-1].Text += "\n\n" + text
+			turns[n-1].Images = append(turns[n-1].Images, images...)
+			continue
+		}
+		turns = append(turns, bedrock.Turn{Role: role, Text: text, Images: images})
+	}
+	if len(turns) == 0 || turns[len(turns)-1].Role != bedrock.RoleUser {
+		return nil, "", fmt.Errorf("the last message must be from the user")
+	}
+	if turns[0].Role != bedrock.RoleUser {
+		return nil, "", fmt.Errorf("the first non-system message must be from the user")
+	}
+	return turns, strings.Join(system, "\n\n"), nil
+}
+
+// messageContent returns the text and images of a string or multi-part message content
+func messageContent(content json.RawMessage) (string, []bedrock.Image, error) {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil, nil
+	}
+	var parts []chatContentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", nil, fmt.Errorf("content must be a string or a list of parts")
+	}
+	texts := make([]string, 0, len(parts))
+	var images []bedrock.Image
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			image, err := dataURLImage(part.ImageURL.URL)
+			if err != nil {
+				return "", nil, err
+			}
+			images = append(images, image)
+		default:
+			return "", nil, fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+	}
+	return strings.Join(texts, "\n"), images, nil
+}
+
+// dataURLImage decodes an image given as a base64 data URL. Remote URLs aren't fetched.
+func dataURLImage(url string) (bedrock.Image, error) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return bedrock.Image{}, fmt.Errorf("image_url must be a base64 data URL, e.g. data:image/png;base64,...")
+	}
+	_, encoded, ok := strings.Cut(rest, ";base64,")
+	if !ok {
+		return bedrock.Image{}, fmt.Errorf("image_url must be base64-encoded")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return bedrock.Image{}, fmt.Errorf("invalid image_url: %v", err)
+	}
+	return bedrock.NewImage(data)
+}
+
+// hasImages reports whether any turn of a conversation carries images
+func hasImages(turns []bedrock.Turn) bool {
+	for _, turn := range turns {
+		if len(turn.Images) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOpenAIError writes an error in the OpenAI error format, which OpenAI clients display
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	errorType := "invalid_request_error"
+	if status >= 500 {
+		errorType = "api_error"
+	}
+	writeJSON(w, status, map[string]any{"error": map[string]string{"message": message, "type": errorType}})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChatCompletionOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want func(opts *bedrock.Options)
+		// err is part of the expected error; the options must apply when empty
+		err string
+	}{
+		{"nothing set", `{}`, func(opts *bedrock.Options) {}, ""},
+		{"caps", `{"max_tokens": 100, "max_completion_tokens": 200}`, func(opts *bedrock.Options) { opts.MaxTokens = 200 }, ""},
+		{"sampling", `{"temperature": 0, "top_p": 0.5}`, func(opts *bedrock.Options) {
+			temperature, topP := 0.0, 0.5
+			opts.Temperature, opts.TopP = &temperature, &topP
+		}, ""},
+		{"one stop sequence", `{"stop": "END"}`, func(opts *bedrock.Options) { opts.StopSequences = []string{"END"} }, ""},
+		{"stop sequences", `{"stop": ["END", "###"]}`, func(opts *bedrock.Options) { opts.StopSequences = []string{"END", "###"} }, ""},
+		{"null stop", `{"stop": null, "n": 1}`, func(opts *bedrock.Options) {}, ""},
+		{"temperature above 1", `{"temperature": 1.5}`, nil, "temperature must be between 0 and 1"},
+		{"negative top_p", `{"top_p": -0.1}`, nil, "top_p must be between 0 and 1"},
+		{"stop of another type", `{"stop": 3}`, nil, "stop must be a string or a list of strings"},
+		{"empty stop sequence", `{"stop": [""]}`, nil, "can't be empty"},
+		{"several choices", `{"n": 2}`, nil, "n must be 1"},
+		{"stream options without stream", `{"stream_options": {"include_usage": true}}`, nil, "only allowed with stream"},
+		{"negative cap", `{"max_tokens": -1}`, nil, "can't be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req chatCompletionRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("invalid test body: %v", err)
+			}
+			opts := bedrock.Options{MaxTokens: chatMaxTokens}
+			err := req.applyOptions(&opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("applyOptions(%s) error = %v, want one containing %q", tt.body, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyOptions(%s): %v", tt.body, err)
+			}
+			want := bedrock.Options{MaxTokens: chatMaxTokens}
+			tt.want(&want)
+			if !reflect.DeepEqual(opts, want) {
+				t.Errorf("applyOptions(%s) = %+v, want %+v", tt.body, opts, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"bedrock-llama/pricing"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awspricing "github.com/aws/aws-sdk-go-v2/service/pricing"
+)
+
+// pricesFileEnv overrides the default location of the synced price table
+const pricesFileEnv = "BEDROCK_LLAMA_PRICES"
+
+// pricingRegion is the region serving the AWS Price List API, which covers every region
+const pricingRegion = "us-east-1"
+
+// defaultPricesFile is the synced price table in the user's configuration directory, or empty
+// when the platform has none
+func defaultPricesFile() string {
+	if path := os.Getenv(pricesFileEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bedrock-llama", "prices.json")
+}
+
+// loadPrices makes cost estimates use the prices of the last 'pricing sync', when there was one
+func loadPrices() {
+	path := defaultPricesFile()
+	if path == "" {
+		return
+	}
+	if _, err := pricing.Load(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		warnf("using the built-in prices: %v", err)
+	}
+}
+
+// runPricing implements the pricing subcommands
+func runPricing(args []string) {
+	if len(args) == 0 || args[0] != "sync" {
+		fatalf("Usage: %s pricing sync [flags]", os.Args[0])
+	}
+
+	flagSet := flag.NewFlagSet("pricing sync", flag.ExitOnError)
+	f := &flags{endpoint: new(string), fips: new(bool)}
+	fileFlag := flagSet.String("prices", defaultPricesFile(), "Price table the estimates are read from; defaults to $"+pricesFileEnv)
+	regionFlag := flagSet.String("region", "", "Region to price, as in the model IDs' billing region; defaults to $AWS_REGION")
+	endpointFlag := flagSet.String("endpoint", "", "Override the AWS Price List API endpoint URL")
+	dryRunFlag := flagSet.Bool("dry-run", false, "Print the prices without saving them")
+	timeoutFlag := flagSet.Duration("timeout", 2*time.Minute, "Time limit for reading the prices")
+	registerSDKRetryFlags(flagSet, f)
+	registerLogFlags(flagSet, f)
+	flagSet.Parse(args[1:])
+	f.applyLogLevel()
+
+	if *fileFlag == "" && !*dryRunFlag {
+		fatalf("No price table: set -prices or $%s", pricesFileEnv)
+	}
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	// The previous prices are the ones in -prices, when it isn't the default table
+	saved := map[string]pricing.Price{}
+	if *fileFlag != "" {
+		previous, err := pricing.Load(*fileFlag)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			warnf("%v", err)
+		}
+		if previous != nil && previous.Prices != nil {
+			saved = previous.Prices
+		}
+	}
+	region := *regionFlag
+	if region == "" {
+		region = cfg.Region
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+	awsCfg, err := bedrock.LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	client := awspricing.NewFromConfig(awsCfg, func(o *awspricing.Options) {
+		o.Region = pricingRegion
+		// The Price List API has no FIPS endpoints
+		o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateDisabled
+		if *endpointFlag != "" {
+			o.BaseEndpoint = endpointFlag
+		}
+	})
+
+	log.Printf("Reading the Bedrock on-demand prices in %s from the AWS Price List API...", region)
+	prices, err := pricing.Fetch(ctx, client, region)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tINPUT_USD_PER_1K\tOUTPUT_USD_PER_1K\tPREVIOUS_INPUT\tPREVIOUS_OUTPUT")
+	var synced []string
+	for _, model := range models.Names() {
+		synced = append(synced, model)
+		for _, version := range models.Versions(model) {
+			if versioned := model + "@" + version; pricing.Synced(versioned) {
+				synced = append(synced, versioned)
+			}
+		}
+	}
+	for _, model := range synced {
+		previous, _ := pricing.Lookup(model)
+		price, ok := prices[model]
+		if !ok {
+			warnf("no on-demand price for %s in %s; keeping $%g/$%g per 1k tokens", model, region, previous.Input, previous.Output)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", model, formatPrice(price.Input), formatPrice(price.Output),
+			formatPrice(previous.Input), formatPrice(previous.Output))
+	}
+	w.Flush()
+	if len(prices) == 0 {
+		fatalf("Error: the Price List API returned no prices for the models in %s", region)
+	}
+	if *dryRunFlag {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*fileFlag), 0o700); err != nil {
+		fatalf("Error: %v", err)
+	}
+	// Models without a price this time keep the one synced before
+	for model, price := range prices {
+		saved[model] = price
+	}
+	file := &pricing.File{Region: region, UpdatedAt: time.Now().UTC(), Prices: saved}
+	if err := pricing.Save(*fileFlag, file); err != nil {
+		fatalf("Error saving prices: %v", err)
+	}
+	log.Printf("Saved %d prices to %s", len(saved), *fileFlag)
+}
+
+// formatPrice formats a price per 1k tokens without trailing zeros
+func formatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
@@ -0,0 +1,91 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Price is the on-demand price of a model in USD per 1,000 tokens
+type Price struct {
+	Input  float64 `json:"input_usd_per_1k"`
+	Output float64 `json:"output_usd_per_1k"`
+}
+
+// table lists the on-demand prices of the registered models in the US regions. The versions of
+// a model with a version table are priced as model@version, e.g. claude@opus-4; a version
+// without a price of its own costs what the model does.
+var table = map[string]Price{
+	"nova":              {Input: 0.0008, Output: 0.0032},
+	"llama":             {Input: 0.0001, Output: 0.0001},
+	"llama70b":          {Input: 0.00072, Output: 0.00072},
+	"claude":            {Input: 0.003, Output: 0.015},
+	"claude@3.7-sonnet": {Input: 0.003, Output: 0.015},
+	"claude@opus-4":     {Input: 0.015, Output: 0.075},
+	"claude@opus-4.1":   {Input: 0.015, Output: 0.075},
+	"claude@sonnet-4":   {Input: 0.003, Output: 0.015},
+	"claude@sonnet-4.5": {Input: 0.003, Output: 0.015},
+	"deepseek":          {Input: 0.00135, Output: 0.0054},
+}
+
+// File is a price table saved by a sync, which overrides the built-in prices
+type File struct {
+	// Region is the AWS region the prices apply to
+	Region    string           `json:"region"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Prices    map[string]Price `json:"prices"`
+}
+
+// Lookup returns the price of a model by its short name, or of a model version by
+// model@version
+func Lookup(model string) (Price, bool) {
+	if price, ok := table[model]; ok {
+		return price, true
+	}
+	family, _, versioned := strings.Cut(model, "@")
+	if !versioned {
+		return Price{}, false
+	}
+	price, ok := table[family]
+	return price, ok
+}
+
+// Load reads a price file and uses its prices instead of the built-in ones. It must be called
+// before any cost is estimated. Models missing from the file keep their built-in price.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid price file %s: %v", path, err)
+	}
+	for model, price := range file.Prices {
+		table[model] = price
+	}
+	return &file, nil
+}
+
+// Save writes a price file
+func Save(path string, file *File) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// Cost estimates the USD cost of a model call, rounded to a billionth of a dollar so that it
+// prints without floating point noise; unknown models cost nothing
+func Cost(model string, inputTokens, outputTokens int) float64 {
+	price, ok := Lookup(model)
+	if !ok {
+		return 0
+	}
+	cost := (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1000
+	return math.Round(cost*1e9) / 1e9
+}
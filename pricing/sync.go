@@ -0,0 +1,187 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awspricing "github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/aws/aws-sdk-go-v2/service/pricing/types"
+)
+
+// serviceCodes are the Price List services of Bedrock: Amazon's own and open-weight models,
+// and the models sold by third-party providers
+var serviceCodes = []string{"AmazonBedrock", "AmazonBedrockFoundationModels"}
+
+// catalogNames are the names the Price List API gives the registered models and the model
+// versions priced apart, best match first
+var catalogNames = map[string][]string{
+	"nova":              {"Nova Pro"},
+	"llama":             {"Llama 3.2 1B Instruct", "Llama 3.2 1B"},
+	"llama70b":          {"Llama 3.3 70B Instruct", "Llama 3.3 70B"},
+	"claude":            {"Claude 3.5 Sonnet v2", "Claude 3.5 Sonnet"},
+	"claude@3.7-sonnet": {"Claude 3.7 Sonnet"},
+	"claude@sonnet-4":   {"Claude Sonnet 4"},
+	"claude@opus-4":     {"Claude Opus 4"},
+	"claude@opus-4.1":   {"Claude Opus 4.1"},
+	"claude@sonnet-4.5": {"Claude Sonnet 4.5"},
+	"deepseek":          {"DeepSeek-R1", "R1"},
+}
+
+// Synced reports whether Fetch reads the price of a model, or of a model version as
+// model@version
+func Synced(model string) bool {
+	_, ok := catalogNames[model]
+	return ok
+}
+
+// excludedUsage marks the products that aren't standard on-demand token prices
+var excludedUsage = []string{"batch", "cache", "latency", "provisioned", "flex", "priority", "custom", "training", "storage", "long context"}
+
+// priceListItem is the part of a Price List product used to read its on-demand price
+type priceListItem struct {
+	Product struct {
+		Attributes map[string]string `json:"attributes"`
+	} `json:"product"`
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// match is a token price found for a model, ranked by how well the product name matched
+type match struct {
+	price float64
+	rank  int
+}
+
+// Fetch reads the current on-demand token prices of the registered models in a region from the
+// AWS Price List API. Models the catalog has no input and output price for are left out.
+func Fetch(ctx context.Context, client *awspricing.Client, region string) (map[string]Price, error) {
+	inputs, outputs := map[string]match{}, map[string]match{}
+	for _, serviceCode := range serviceCodes {
+		paginator := awspricing.NewGetProductsPaginator(client, &awspricing.GetProductsInput{
+			ServiceCode: aws.String(serviceCode),
+			Filters: []types.Filter{{
+				Type:  types.FilterTypeTermMatch,
+				Field: aws.String("regionCode"),
+				Value: aws.String(region),
+			}},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s prices: %v", serviceCode, err)
+			}
+			for _, raw := range page.PriceList {
+				var item priceListItem
+				if err := json.Unmarshal([]byte(raw), &item); err != nil {
+					return nil, fmt.Errorf("invalid %s price list item: %v", serviceCode, err)
+				}
+				collect(item, inputs, outputs)
+			}
+		}
+	}
+
+	prices := map[string]Price{}
+	for model := range catalogNames {
+		input, hasInput := inputs[model]
+		output, hasOutput := outputs[model]
+		if hasInput && hasOutput {
+			prices[model] = Price{Input: input.price, Output: output.price}
+		}
+	}
+	return prices, nil
+}
+
+// collect records the token price of a product when it belongs to a registered model
+func collect(item priceListItem, inputs, outputs map[string]match) {
+	attributes := item.Product.Attributes
+	model, rank, ok := catalogModel(attributes["model"])
+	if !ok {
+		if model, rank, ok = catalogModel(attributes["servicename"]); !ok {
+			return
+		}
+	}
+	usage := strings.ToLower(attributes["inferenceType"] + " " + attributes["usagetype"] + " " + attributes["feature"])
+	for _, excluded := range excludedUsage {
+		if strings.Contains(usage, excluded) {
+			return
+		}
+	}
+	prices := inputs
+	switch {
+	case strings.Contains(usage, "output"):
+		prices = outputs
+	case !strings.Contains(usage, "input"):
+		return
+	}
+	price, ok := per1kTokens(item)
+	if !ok {
+		return
+	}
+	if existing, ok := prices[model]; !ok || rank < existing.rank {
+		prices[model] = match{price, rank}
+	}
+}
+
+// catalogModel returns the registered model a Price List name belongs to, and the rank of the
+// name among the model's catalog names
+func catalogModel(name string) (string, int, bool) {
+	name = normalize(name)
+	if name == "" {
+		return "", 0, false
+	}
+	for model, names := range catalogNames {
+		for rank, candidate := range names {
+			if normalize(candidate) == name {
+				return model, rank, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// normalize lowercases a name and drops everything but letters and digits
+func normalize(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// per1kTokens returns the on-demand USD price of a product per 1,000 tokens, rounded so that
+// converted prices don't carry floating point noise
+func per1kTokens(item priceListItem) (float64, bool) {
+	for _, term := range item.Terms.OnDemand {
+		for _, dimension := range term.PriceDimensions {
+			usd, err := strconv.ParseFloat(dimension.PricePerUnit["USD"], 64)
+			if err != nil {
+				continue
+			}
+			unit := strings.ToLower(dimension.Unit)
+			switch {
+			case strings.Contains(unit, "1k") || strings.Contains(unit, "thousand"):
+			case strings.Contains(unit, "1m") || strings.Contains(unit, "million"):
+				usd /= 1000
+			case strings.Contains(unit, "token"):
+				usd *= 1000
+			default:
+				continue
+			}
+			return math.Round(usd*1e12) / 1e12, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/pricing"
+	"bedrock-llama/prompts"
+	"bedrock-llama/tasks"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// daysPerMonth turns the daily volume of the usage store into a monthly one
+const daysPerMonth = 30
+
+// promptSide is one of the prompt versions compared by prompts diff
+type promptSide struct {
+	Ref         string `json:"ref"`
+	Description string `json:"description,omitempty"`
+	// InputTokens estimates the prompt of the sample input
+	InputTokens int `json:"input_tokens"`
+}
+
+// promptImpact is the projected monthly impact of a prompt change on one model's volume
+type promptImpact struct {
+	Model string `json:"model"`
+	// Calls counts the model's calls for the task in the usage store over the window
+	Calls             int     `json:"calls"`
+	MonthlyCalls      float64 `json:"monthly_calls"`
+	MonthlyTokenDelta float64 `json:"monthly_input_token_delta"`
+	MonthlyCostDelta  float64 `json:"monthly_cost_delta_usd"`
+}
+
+// promptDiff is the report of prompts diff
+type promptDiff struct {
+	Task       string         `json:"task"`
+	Input      string         `json:"input"`
+	Old        promptSide     `json:"old"`
+	New        promptSide     `json:"new"`
+	TokenDelta int            `json:"input_token_delta"`
+	Since      string         `json:"since"`
+	Until      string         `json:"until"`
+	Models     []promptImpact `json:"models"`
+	// MonthlyCostDelta is the projected change of the monthly cost over every model
+	MonthlyCostDelta float64 `json:"monthly_cost_delta_usd"`
+}
+
+// runPrompts implements the prompts subcommands
+func runPrompts(args []string) {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "diff") {
+		fatalf("Usage: %s prompts list|diff [flags]", os.Args[0])
+	}
+	if args[0] == "list" {
+		fs := flag.NewFlagSet("prompts list", flag.ExitOnError)
+		dirFlag := fs.String("prompts-dir", "prompts", "Directory of the prompt registry")
+		fs.Parse(args[1:])
+		listPrompts(*dirFlag)
+		return
+	}
+	runPromptsDiff(args[1:])
+}
+
+// runPromptsDiff compares the estimated prompt tokens of two versions of a registry prompt and
+// projects the monthly cost of the change from the task's volume in the usage store. With
+// -max-monthly-increase it fails when the change costs more, to validate prompt changes before
+// they ship.
+func runPromptsDiff(args []string) {
+	fs := flag.NewFlagSet("prompts diff", flag.ExitOnError)
+	dirFlag := fs.String("prompts-dir", "prompts", "Directory of the prompt registry")
+	taskFlag := fs.String("task", "series", "Task of the prompts whose metadata doesn't name one")
+	inputFlag := fs.String("input", "", "Sample input the prompts are rendered with; defaults to the task's default input")
+	vars := varsFlag{}
+	fs.Var(vars, "var", "Template parameter as key=value, available as {{.Vars.key}} in the prompts (repeatable)")
+	usageFlag := fs.String("usage-file", defaultUsageFile(), "Usage store the volume is read from; defaults to $"+usageFileEnv)
+	daysFlag := fs.Int("days", 30, "Days of usage, up to today, the monthly volume is projected from")
+	var maxIncrease floatFlag
+	fs.Var(&maxIncrease, "max-monthly-increase", "Fail when the projected monthly cost grows by more USD than this")
+	formatFlag := fs.String("format", "table", "Report format: table or json")
+	fs.Parse(args)
+
+	format := strings.ToLower(*formatFlag)
+	if format != "table" && format != "json" {
+		fatalf("Invalid format %q. Use table or json", *formatFlag)
+	}
+	if *daysFlag < 1 {
+		fatalf("-days must be at least 1")
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fatalf("Usage: %s prompts diff [flags] <name@old> <name@new>, or <name@version> to compare with the version before it", os.Args[0])
+	}
+
+	registry, err := prompts.Open(*dirFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	var oldVersion, newVersion prompts.Version
+	if fs.NArg() == 2 {
+		if oldVersion, err = registry.Resolve(fs.Arg(0)); err != nil {
+			fatalf("Error: %v", err)
+		}
+		if newVersion, err = registry.Resolve(fs.Arg(1)); err != nil {
+			fatalf("Error: %v", err)
+		}
+	} else {
+		if newVersion, err = registry.Resolve(fs.Arg(0)); err != nil {
+			fatalf("Error: %v", err)
+		}
+		var ok bool
+		if oldVersion, ok = registry.Previous(newVersion); !ok {
+			fatalf("Prompt %s has no earlier version to compare with", newVersion.Ref())
+		}
+	}
+
+	taskName := func(version prompts.Version) string {
+		if version.Metadata.Task != "" {
+			return version.Metadata.Task
+		}
+		return strings.ToLower(*taskFlag)
+	}
+	if taskName(oldVersion) != taskName(newVersion) {
+		fatalf("%s is a %s prompt and %s a %s prompt: compare versions of the same task", oldVersion.Ref(), taskName(oldVersion), newVersion.Ref(), taskName(newVersion))
+	}
+	task, ok := tasks.Lookup(taskName(newVersion))
+	if !ok {
+		fatalf("Invalid task specified. Use one of: %s", strings.Join(tasks.Names(), ", "))
+	}
+	input := *inputFlag
+	if input == "" {
+		input = task.DefaultInput
+	}
+
+	now := time.Now()
+	report := &promptDiff{
+		Task:  task.Name,
+		Input: input,
+		Since: now.AddDate(0, 0, 1-*daysFlag).Format(dayLayout),
+		Until: now.Format(dayLayout),
+	}
+	for _, side := range []struct {
+		version prompts.Version
+		out     *promptSide
+	}{{oldVersion, &report.Old}, {newVersion, &report.New}} {
+		versionTask, err := task.WithTemplateFile(side.version.Path, vars)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		prompt, err := versionTask.Prompt(input)
+		if err != nil {
+			fatalf("Error rendering %s: %v", side.version.Ref(), err)
+		}
+		*side.out = promptSide{
+			Ref:         side.version.Ref(),
+			Description: side.version.Metadata.Description,
+			InputTokens: bedrock.EstimateTokens(bedrock.UserTurn(prompt)),
+		}
+	}
+	report.TokenDelta = report.New.InputTokens - report.Old.InputTokens
+
+	if *usageFlag != "" {
+		entries, err := readUsage(*usageFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		report.Models = projectPromptImpact(entries, task.Name, report.Since, report.Until, *daysFlag, report.TokenDelta)
+	}
+	for _, impact := range report.Models {
+		report.MonthlyCostDelta += impact.MonthlyCostDelta
+	}
+	report.MonthlyCostDelta = math.Round(report.MonthlyCostDelta*1e9) / 1e9
+
+	if err := writePromptDiff(os.Stdout, format, report); err != nil {
+		fatalf("Error writing report: %v", err)
+	}
+	if maxIncrease.value != nil && report.MonthlyCostDelta > *maxIncrease.value {
+		fatalf("%s raises the projected monthly cost by $%.2f, more than -max-monthly-increase $%.2f", newVersion.Ref(), report.MonthlyCostDelta, *maxIncrease.value)
+	}
+}
+
+// projectPromptImpact projects the monthly calls of each model for the task from the usage
+// between since and until, and what a change of the prompt's input tokens costs with them
+func projectPromptImpact(entries []usageEntry, task, since, until string, days, tokenDelta int) []promptImpact {
+	calls := map[string]int{}
+	for _, entry := range entries {
+		if entry.Task == task && entry.Day >= since && entry.Day <= until {
+			calls[entry.Model] += entry.Calls
+		}
+	}
+	impacts := make([]promptImpact, 0, len(calls))
+	for model, count := range calls {
+		monthlyCalls := float64(count) * daysPerMonth / float64(days)
+		monthlyTokens := math.Round(monthlyCalls * float64(tokenDelta))
+		impacts = append(impacts, promptImpact{
+			Model:             model,
+			Calls:             count,
+			MonthlyCalls:      monthlyCalls,
+			MonthlyTokenDelta: monthlyTokens,
+			MonthlyCostDelta:  pricing.Cost(model, int(monthlyTokens), 0),
+		})
+	}
+	sort.Slice(impacts, func(i, j int) bool { return impacts[i].Model < impacts[j].Model })
+	return impacts
+}
+
+// writePromptDiff writes the token change of the prompt and its monthly impact per model as an
+// aligned table with a total, or the report as JSON
+func writePromptDiff(out io.Writer, format string, report *promptDiff) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Fprintf(out, "%s: %d estimated input tokens\n", report.Old.Ref, report.Old.InputTokens)
+	fmt.Fprintf(out, "%s: %d estimated input tokens (%+d per call)\n", report.New.Ref, report.New.InputTokens, report.TokenDelta)
+	if len(report.Models) == 0 {
+		_, err := fmt.Fprintf(out, "No %s calls in the usage store between %s and %s to project a monthly cost from\n", report.Task, report.Since, report.Until)
+		return err
+	}
+	fmt.Fprintf(out, "Monthly impact, projected from the %s calls between %s and %s:\n", report.Task, report.Since, report.Until)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tCALLS\tMONTHLY_CALLS\tMONTHLY_INPUT_TOKENS\tMONTHLY_COST_USD")
+	var monthlyCalls, monthlyTokens float64
+	for _, impact := range report.Models {
+		monthlyCalls += impact.MonthlyCalls
+		monthlyTokens += impact.MonthlyTokenDelta
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%+.0f\t%+.4f\n", impact.Model, impact.Calls, impact.MonthlyCalls, impact.MonthlyTokenDelta, impact.MonthlyCostDelta)
+	}
+	fmt.Fprintf(w, "TOTAL\t\t%.0f\t%+.0f\t%+.4f\n", monthlyCalls, monthlyTokens, report.MonthlyCostDelta)
+	return w.Flush()
+}
@@ -0,0 +1,155 @@
+package prompts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metadata describes a prompt version. It is read from <version>.json next to the template.
+type Metadata struct {
+	// Task is the built-in task whose schema and validator apply to the prompt's output
+	Task string `json:"task"`
+	// Description summarizes what changed in this version
+	Description string `json:"description"`
+	// MaxTokens caps the model's output for the prompt instead of the task's default
+	MaxTokens int    `json:"max_tokens,omitempty"`
+	Author    string `json:"author,omitempty"`
+	Created   string `json:"created,omitempty"`
+}
+
+// Version is a single versioned prompt template in the registry
+type Version struct {
+	Name     string
+	Version  string
+	Path     string
+	Metadata Metadata
+}
+
+// Ref returns the name@version reference of the prompt
+func (v Version) Ref() string {
+	return v.Name + "@" + v.Version
+}
+
+// Registry is a directory of versioned prompt templates laid out as
+// <dir>/<name>/<version>.tmpl with optional <dir>/<name>/<version>.json metadata
+type Registry struct {
+	dir      string
+	versions map[string][]Version
+}
+
+// Open loads the prompt registry from a directory
+func Open(dir string) (*Registry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt registry: %v", err)
+	}
+
+	registry := &Registry{dir: dir, versions: map[string][]Version{}}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		templates, err := filepath.Glob(filepath.Join(dir, name, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range templates {
+			version := Version{
+				Name:    name,
+				Version: strings.TrimSuffix(filepath.Base(path), ".tmpl"),
+				Path:    path,
+			}
+			if err := loadMetadata(strings.TrimSuffix(path, ".tmpl")+".json", &version.Metadata); err != nil {
+				return nil, fmt.Errorf("prompt %s: %v", version.Ref(), err)
+			}
+			registry.versions[name] = append(registry.versions[name], version)
+		}
+		sort.Slice(registry.versions[name], func(i, j int) bool {
+			return compareVersions(registry.versions[name][i].Version, registry.versions[name][j].Version) < 0
+		})
+	}
+	return registry, nil
+}
+
+// loadMetadata reads a metadata file; a missing file leaves the metadata empty
+func loadMetadata(path string, metadata *Metadata) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return fmt.Errorf("invalid metadata: %v", err)
+	}
+	return nil
+}
+
+// Resolve finds a prompt by "name" (latest version) or "name@version"
+func (r *Registry) Resolve(ref string) (Version, error) {
+	name, version, pinned := strings.Cut(ref, "@")
+	versions := r.versions[name]
+	if len(versions) == 0 {
+		return Version{}, fmt.Errorf("prompt %q not found in %s", name, r.dir)
+	}
+	if !pinned {
+		return versions[len(versions)-1], nil
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("prompt %q has no version %q", name, version)
+}
+
+// Previous returns the version of the prompt before v, if there is one
+func (r *Registry) Previous(v Version) (Version, bool) {
+	versions := r.versions[v.Name]
+	for i := 1; i < len(versions); i++ {
+		if versions[i].Version == v.Version {
+			return versions[i-1], true
+		}
+	}
+	return Version{}, false
+}
+
+// List returns every prompt version, grouped by name and ordered by version
+func (r *Registry) List() []Version {
+	names := make([]string, 0, len(r.versions))
+	for name := range r.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var all []Version
+	for _, name := range names {
+		all = append(all, r.versions[name]...)
+	}
+	return all
+}
+
+// compareVersions orders versions like v1 < v2 < v10 and 1.2 < 1.10, comparing
+// dot-separated numeric parts numerically and anything else lexically
+func compareVersions(a, b string) int {
+	partsA := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	partsB := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		numberA, errA := strconv.Atoi(partsA[i])
+		numberB, errB := strconv.Atoi(partsB[i])
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			return numberA - numberB
+		case (errA != nil || errB != nil) && partsA[i] != partsB[i]:
+			return strings.Compare(partsA[i], partsB[i])
+		}
+	}
+	return len(partsA) - len(partsB)
+}
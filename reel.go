@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/reel"
+	"bedrock-llama/s3io"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// runReel implements the reel subcommand, which generates a video with Nova Reel
+func runReel(args []string) {
+	fs := flag.NewFlagSet("reel", flag.ExitOnError)
+	f := &flags{}
+	promptFlag := fs.String("prompt", "", "Description of the video to generate; defaults to the arguments")
+	outputS3Flag := fs.String("output-s3", "", "S3 prefix, as s3://bucket/prefix/, Nova Reel writes the video under")
+	secondsFlag := fs.Int("seconds", reel.ShotSeconds, fmt.Sprintf("Video length: %d, or a multiple of %d up to %d for a multi-shot video", reel.ShotSeconds, reel.ShotSeconds, reel.MaxSeconds))
+	seedFlag := fs.Int("seed", -1, "Seed to reproduce an earlier video with (-1 for a random seed)")
+	imageFlag := fs.String("image", "", fmt.Sprintf("PNG or JPEG starting frame of %s pixels, for %d second videos", reel.Dimension, reel.ShotSeconds))
+	outputFlag := fs.String("output", "video.mp4", "File to download the finished video to; empty to leave it in S3")
+	jobFlag := fs.String("job", "", "Invocation ARN of an earlier job to wait for and download, instead of starting one")
+	noWaitFlag := fs.Bool("no-wait", false, "Print the invocation ARN and exit without waiting for the video")
+	pollFlag := fs.Duration("poll-interval", 15*time.Second, "How often to check whether the video is done")
+	waitFlag := fs.Duration("wait-timeout", time.Hour, "How long to wait for the video")
+	regionFlag := fs.String("region", "", "Region to generate in, where Nova Reel is available (us-east-1); defaults to $AWS_REGION")
+	f.endpoint = fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL; defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	f.fips = fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	maxAttemptsFlag := fs.Int("max-attempts", 5, "Maximum attempts when Bedrock throttles a request (1 disables retries)")
+	registerSDKRetryFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	prompt := *promptFlag
+	if prompt == "" {
+		prompt = strings.Join(fs.Args(), " ")
+	}
+	if *jobFlag == "" {
+		if strings.TrimSpace(prompt) == "" {
+			fatalf("Usage: %s reel [flags] PROMPT", os.Args[0])
+		}
+		if _, isS3, err := s3io.Parse(*outputS3Flag); !isS3 || err != nil {
+			fatalf("-output-s3 must be an s3://bucket/prefix/ Nova Reel can write to")
+		}
+	}
+	if *pollFlag <= 0 {
+		fatalf("-poll-interval must be positive")
+	}
+	var image *bedrock.Image
+	if *imageFlag != "" {
+		loaded, err := bedrock.LoadImage(*imageFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		image = &loaded
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *regionFlag != "" {
+		cfg.Region = *regionFlag
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	opts := bedrock.Options{Retry: bedrock.RetryPolicy{MaxAttempts: *maxAttemptsFlag}, Timeout: time.Minute}
+
+	arn := *jobFlag
+	if arn == "" {
+		job, err := reel.Start(ctx, client, reel.Request{Prompt: prompt, Seconds: *secondsFlag, Seed: *seedFlag, Image: image}, *outputS3Flag, opts)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		arn = job.ARN
+		log.Printf("Started a %d second Nova Reel video in %s with seed %d: %s", *secondsFlag, cfg.Region, job.Seed, arn)
+	}
+	if *noWaitFlag {
+		fmt.Println(arn)
+		return
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, *waitFlag)
+	defer cancel()
+	start := time.Now()
+	invocation, err := bedrock.WaitAsyncInvoke(waitCtx, client, arn, *pollFlag, opts, func(invocation *bedrock.AsyncInvocation) {
+		log.Printf("%s after %s", invocation.Status, time.Since(start).Round(time.Second))
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			fatalf("Error: %v. The job keeps running; resume with -job=%s", err, arn)
+		}
+		fatalf("Error: %v", err)
+	}
+
+	videoURI := strings.TrimSuffix(invocation.OutputURI, "/") + "/" + reel.OutputFile
+	if *outputFlag == "" {
+		fmt.Println(videoURI)
+		return
+	}
+	if err := downloadS3(ctx, cfg, videoURI, *outputFlag); err != nil {
+		fatalf("Error: %v. The video is at %s", err, videoURI)
+	}
+	log.Printf("Downloaded %s", videoURI)
+	fmt.Println(*outputFlag)
+}
+
+// downloadS3 copies an S3 object to a local file
+func downloadS3(ctx context.Context, cfg bedrock.Config, uri, path string) error {
+	location, _, err := s3io.Parse(uri)
+	if err != nil {
+		return err
+	}
+	client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	body, err := s3io.Open(ctx, client, location)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to download %s: %v", uri, err)
+	}
+	return file.Close()
+}
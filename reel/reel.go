@@ -0,0 +1,145 @@
+package reel
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// ModelID is the Bedrock model ID of Amazon Nova Reel, which runs in us-east-1
+const ModelID = "amazon.nova-reel-v1:1"
+
+// Video sizes and lengths Nova Reel generates
+const (
+	// Dimension is the only resolution Nova Reel generates
+	Dimension = "1280x720"
+	// FPS is the only frame rate Nova Reel generates
+	FPS = 24
+	// ShotSeconds is the length of a single shot; longer videos are several shots
+	ShotSeconds = 6
+	// MaxSeconds is the longest video Nova Reel generates
+	MaxSeconds = 120
+	// maxSeed is the largest seed Nova Reel accepts
+	maxSeed = 2147483646
+)
+
+// OutputFile is the name of the video Nova Reel writes under the invocation's S3 prefix
+const OutputFile = "output.mp4"
+
+// Image represents the starting frame of a single-shot video
+type Image struct {
+	Format string      `json:"format"`
+	Source ImageSource `json:"source"`
+}
+
+// ImageSource holds the image data, base64-encoded in the JSON payload
+type ImageSource struct {
+	Bytes []byte `json:"bytes"`
+}
+
+// TextToVideoParams represents the prompt of a single-shot video
+type TextToVideoParams struct {
+	Text   string  `json:"text"`
+	Images []Image `json:"images,omitempty"`
+}
+
+// MultiShotAutomatedParams represents the prompt of a multi-shot video, which the model splits
+// into shots itself
+type MultiShotAutomatedParams struct {
+	Text string `json:"text"`
+}
+
+// VideoGenerationConfig represents the length, format and seed of the video
+type VideoGenerationConfig struct {
+	DurationSeconds int    `json:"durationSeconds"`
+	FPS             int    `json:"fps"`
+	Dimension       string `json:"dimension"`
+	Seed            int    `json:"seed"`
+}
+
+// Payload represents the model input of a Nova Reel video generation job
+type Payload struct {
+	TaskType                 string                    `json:"taskType"`
+	TextToVideoParams        *TextToVideoParams        `json:"textToVideoParams,omitempty"`
+	MultiShotAutomatedParams *MultiShotAutomatedParams `json:"multiShotAutomatedParams,omitempty"`
+	VideoGenerationConfig    VideoGenerationConfig     `json:"videoGenerationConfig"`
+}
+
+// Request describes the video to generate
+type Request struct {
+	Prompt string
+	// Seconds is the length: ShotSeconds, or a multiple of it up to MaxSeconds
+	Seconds int
+	// Seed makes the generation reproducible; a random seed is used when it is negative
+	Seed int
+	// Image is the starting frame, for single-shot videos; it must be 1280x720
+	Image *bedrock.Image
+}
+
+// Job is a started video generation
+type Job struct {
+	// ARN identifies the asynchronous invocation
+	ARN  string
+	Seed int
+}
+
+// NewPayload builds the model input for a request, choosing a seed when it has none
+func NewPayload(req Request) (Payload, error) {
+	seconds := req.Seconds
+	if seconds == 0 {
+		seconds = ShotSeconds
+	}
+	if seconds < ShotSeconds || seconds > MaxSeconds || seconds%ShotSeconds != 0 {
+		return Payload{}, fmt.Errorf("videos are %d seconds, or a multiple of %d up to %d", ShotSeconds, ShotSeconds, MaxSeconds)
+	}
+	seed := req.Seed
+	if seed < 0 {
+		seed = rand.IntN(maxSeed + 1)
+	} else if seed > maxSeed {
+		return Payload{}, fmt.Errorf("the seed must be at most %d", maxSeed)
+	}
+
+	payload := Payload{VideoGenerationConfig: VideoGenerationConfig{
+		DurationSeconds: seconds,
+		FPS:             FPS,
+		Dimension:       Dimension,
+		Seed:            seed,
+	}}
+	if seconds == ShotSeconds {
+		payload.TaskType = "TEXT_VIDEO"
+		payload.TextToVideoParams = &TextToVideoParams{Text: req.Prompt}
+		if req.Image != nil {
+			if req.Image.Format != "png" && req.Image.Format != "jpeg" {
+				return Payload{}, fmt.Errorf("the starting image must be PNG or JPEG")
+			}
+			payload.TextToVideoParams.Images = []Image{{Format: req.Image.Format, Source: ImageSource{Bytes: req.Image.Data}}}
+		}
+		return payload, nil
+	}
+	if req.Image != nil {
+		return Payload{}, fmt.Errorf("a starting image only works for %d second videos", ShotSeconds)
+	}
+	payload.TaskType = "MULTI_SHOT_AUTOMATED"
+	payload.MultiShotAutomatedParams = &MultiShotAutomatedParams{Text: req.Prompt}
+	return payload, nil
+}
+
+// Start submits a video generation job that writes the video under an s3:// prefix
+func Start(ctx context.Context, client *bedrockruntime.Client, req Request, outputURI string, opts bedrock.Options) (*Job, error) {
+	payload, err := NewPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	logging.Debugf("Payload: %s", string(payloadBytes))
+	arn, err := bedrock.StartAsyncInvoke(ctx, client, ModelID, payload, outputURI, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error starting Nova Reel video generation: %w", err)
+	}
+	return &Job{ARN: arn, Seed: payload.VideoGenerationConfig.Seed}, nil
+}
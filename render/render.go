@@ -0,0 +1,168 @@
+package render
+
+import (
+	"bedrock-llama/schema"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Output formats accepted by the -output flag
+const (
+	JSON     = "json"
+	Table    = "table"
+	Markdown = "markdown"
+	YAML     = "yaml"
+)
+
+// Formats returns the supported output formats
+func Formats() []string {
+	return []string{JSON, Table, Markdown, YAML}
+}
+
+// Records renders extraction records in the given format, with columns in schema order
+func Records(format string, s *schema.Schema, records []schema.Record) (string, error) {
+	switch format {
+	case JSON, "":
+		return s.Format(records), nil
+	case Table:
+		return table(s, records), nil
+	case Markdown:
+		return markdown(s, records), nil
+	case YAML:
+		return yaml(s, records), nil
+	default:
+		return "", fmt.Errorf("unsupported output format %q: use %s", format, strings.Join(Formats(), ", "))
+	}
+}
+
+// table renders records as an aligned plain-text table
+func table(s *schema.Schema, records []schema.Record) string {
+	header := make([]string, len(s.Fields))
+	widths := make([]int, len(s.Fields))
+	for i, field := range s.Fields {
+		header[i] = strings.ToUpper(field.Name)
+		widths[i] = utf8.RuneCountInString(header[i])
+	}
+
+	rows := cells(s, records)
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var out strings.Builder
+	writeRow := func(row []string) {
+		for i, cell := range row {
+			if i > 0 {
+				out.WriteString("  ")
+			}
+			out.WriteString(cell)
+			if i < len(row)-1 {
+				out.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+		}
+		out.WriteString("\n")
+	}
+	writeRow(header)
+	for _, row := range rows {
+		writeRow(row)
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// markdown renders records as a GitHub-flavored markdown table
+func markdown(s *schema.Schema, records []schema.Record) string {
+	var out strings.Builder
+	names := make([]string, len(s.Fields))
+	separators := make([]string, len(s.Fields))
+	for i, field := range s.Fields {
+		names[i] = field.Name
+		separators[i] = "---"
+	}
+	fmt.Fprintf(&out, "| %s |\n", strings.Join(names, " | "))
+	fmt.Fprintf(&out, "| %s |", strings.Join(separators, " | "))
+	for _, row := range cells(s, records) {
+		for i, cell := range row {
+			row[i] = strings.ReplaceAll(cell, "|", `\|`)
+		}
+		fmt.Fprintf(&out, "\n| %s |", strings.Join(row, " | "))
+	}
+	return out.String()
+}
+
+// yaml renders records as a YAML sequence of mappings
+func yaml(s *schema.Schema, records []schema.Record) string {
+	var lines []string
+	for _, record := range records {
+		for i, field := range s.Fields {
+			prefix := "  "
+			if i == 0 {
+				prefix = "- "
+			}
+			lines = append(lines, fmt.Sprintf("%s%s: %s", prefix, field.Name, yamlScalar(record[field.Name])))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// yamlScalar renders a value as a YAML scalar, quoting strings that would otherwise be misread
+func yamlScalar(value any) string {
+	text, ok := value.(string)
+	if !ok {
+		return cell(value)
+	}
+	if needsQuotes(text) {
+		quoted, _ := json.Marshal(text)
+		return string(quoted)
+	}
+	return text
+}
+
+// needsQuotes reports whether a plain YAML scalar would be parsed as something other than the string
+func needsQuotes(text string) bool {
+	if text == "" || strings.TrimSpace(text) != text {
+		return true
+	}
+	switch strings.ToLower(text) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return true
+	}
+	if _, err := strconv.ParseFloat(text, 64); err == nil {
+		return true
+	}
+	if strings.ContainsAny(text[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	return strings.Contains(text, ": ") || strings.Contains(text, " #") || strings.ContainsAny(text, "\n\t")
+}
+
+// cells converts records into rows of display strings in schema order
+func cells(s *schema.Schema, records []schema.Record) [][]string {
+	rows := make([][]string, len(records))
+	for i, record := range records {
+		row := make([]string, len(s.Fields))
+		for j, field := range s.Fields {
+			row[j] = cell(record[field.Name])
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// cell formats a single value for display
+func cell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
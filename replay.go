@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bedrock-llama/history"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// replayResult compares the candidate's answer to a recorded input with the recorded answer
+type replayResult struct {
+	// ID is the ID of the history entry
+	ID            int64  `json:"id"`
+	Input         string `json:"input"`
+	RecordedModel string `json:"recorded_model"`
+	// Recorded and Candidate are the -field values of the first records of the answers
+	Recorded          string  `json:"recorded"`
+	Candidate         string  `json:"candidate"`
+	Match             bool    `json:"match"`
+	Error             string  `json:"error,omitempty"`
+	RecordedCostUSD   float64 `json:"recorded_cost_usd"`
+	CostUSD           float64 `json:"estimated_cost_usd"`
+	RecordedLatencyMs int64   `json:"recorded_latency_ms"`
+	LatencyMs         int64   `json:"latency_ms"`
+}
+
+// replaySide is a row of the replay report: the recorded answers, or the candidate's
+type replaySide struct {
+	Source        string  `json:"source"`
+	Model         string  `json:"model"`
+	PromptVersion string  `json:"prompt_version"`
+	Items         int     `json:"items"`
+	Errors        int     `json:"errors"`
+	InputTokens   int     `json:"input_tokens"`
+	OutputTokens  int     `json:"output_tokens"`
+	P50LatencyMs  int64   `json:"p50_latency_ms"`
+	CostUSD       float64 `json:"estimated_cost_usd"`
+
+	latencies []int64
+}
+
+// replayReport compares a candidate model and prompt with the recorded production results
+type replayReport struct {
+	Task      string     `json:"task"`
+	Field     string     `json:"field"`
+	Match     string     `json:"match"`
+	Recorded  replaySide `json:"recorded"`
+	Candidate replaySide `json:"candidate"`
+	// Matches counts the items the candidate answered like the recorded result, by -match;
+	// Agreement is their share of the items
+	Matches   int            `json:"matches"`
+	Agreement float64        `json:"agreement"`
+	Results   []replayResult `json:"results"`
+}
+
+// runReplay re-runs a sample of the inputs recorded in the history with the candidate model and
+// prompt of the extraction flags, and compares its answers and costs with the recorded ones.
+// The candidate is always invoked, bypassing -cache, and its extractions aren't recorded.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	f := registerFlags(fs)
+	sinceFlag := fs.String("since", "", "First day of the recorded extractions to replay, as YYYY-MM-DD")
+	untilFlag := fs.String("until", "", "Last day of the recorded extractions to replay, as YYYY-MM-DD")
+	recordedModelFlag := fs.String("recorded-model", "", "Only replay the extractions answered by this model")
+	sampleFlag := fs.Int("sample", 100, "Number of recorded extractions replayed, picked at random (0 replays all of them)")
+	seedFlag := fs.Int64("seed", 1, "Seed of the random -sample, to replay the same extractions again")
+	fieldFlag := fs.String("field", "", "Record field compared with the recorded answer; defaults to the task's first field")
+	matchFlag := fs.String("match", "normalized", "Comparison that decides whether the answers agree: "+matcherUsage())
+	concurrencyFlag := fs.Int("concurrency", 1, "Number of inputs replayed in parallel")
+	formatFlag := fs.String("format", "table", "Report format: table or json")
+	fs.Parse(args)
+
+	format := strings.ToLower(*formatFlag)
+	if format != "table" && format != "json" {
+		fatalf("Invalid format %q. Use table or json", *formatFlag)
+	}
+	if *concurrencyFlag < 1 {
+		fatalf("-concurrency must be at least 1")
+	}
+	if *sampleFlag < 0 {
+		fatalf("-sample can't be negative")
+	}
+	match, err := parseMatcher(*matchFlag)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	historyFile := *f.history
+	if historyFile == "" {
+		fatalf("Replay needs the history of earlier extractions: set -history or $%s", historyFileEnv)
+	}
+	if _, err := os.Stat(historyFile); err != nil {
+		fatalf("Error: no history at %s: %v", historyFile, err)
+	}
+	filter := history.Filter{Model: strings.ToLower(*recordedModelFlag), Status: history.StatusOK}
+	for _, day := range []struct {
+		value string
+		bound *time.Time
+		next  int
+	}{{*sinceFlag, &filter.Since, 0}, {*untilFlag, &filter.Until, 1}} {
+		if day.value == "" {
+			continue
+		}
+		t, err := time.ParseInLocation(dayLayout, day.value, time.Local)
+		if err != nil {
+			fatalf("Invalid day %q: use YYYY-MM-DD", day.value)
+		}
+		*day.bound = t.AddDate(0, 0, day.next)
+	}
+
+	// The candidate's answers mustn't come from the cache, nor be recorded next to production's
+	*f.noCache = true
+	*f.history = ""
+	ctx := context.Background()
+	runWithExtractor(ctx, f, func(e *extractor) error {
+		filter.Task = e.task.Name
+
+		field := *fieldFlag
+		if field == "" {
+			field = e.task.Schema.Fields[0].Name
+		}
+		known := false
+		for _, schemaField := range e.task.Schema.Fields {
+			known = known || schemaField.Name == field
+		}
+		if !known {
+			return fmt.Errorf("the %s task has no field %q", e.task.Name, field)
+		}
+
+		store, err := history.Open(historyFile)
+		if err != nil {
+			return err
+		}
+		entries, err := store.List(ctx, filter)
+		store.Close()
+		if err != nil {
+			return err
+		}
+		// Entries without their input were compacted and can't be replayed
+		entries = slices.DeleteFunc(entries, func(entry history.Entry) bool { return entry.Input == "" })
+		if len(entries) == 0 {
+			return fmt.Errorf("no recorded %s extractions to replay in %s", e.task.Name, historyFile)
+		}
+		if *sampleFlag > 0 && len(entries) > *sampleFlag {
+			random := rand.New(rand.NewSource(*seedFlag))
+			random.Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+			entries = entries[:*sampleFlag]
+		}
+
+		report := &replayReport{
+			Task:      e.task.Name,
+			Field:     field,
+			Match:     match.spec,
+			Recorded:  replaySide{Source: "recorded"},
+			Candidate: replaySide{Source: "candidate", Model: e.modelInfo.Name, PromptVersion: e.task.Version()},
+		}
+		recorded := make(map[string]history.Entry, len(entries))
+		var recordedModels, recordedVersions []string
+		for _, entry := range entries {
+			recorded[strconv.FormatInt(entry.ID, 10)] = entry
+			if !slices.Contains(recordedModels, entry.Model) {
+				recordedModels = append(recordedModels, entry.Model)
+			}
+			if !slices.Contains(recordedVersions, entry.PromptVersion) {
+				recordedVersions = append(recordedVersions, entry.PromptVersion)
+			}
+			report.Recorded.add(entry.InputTokens, entry.OutputTokens, entry.CostUSD, entry.LatencyMs, false)
+		}
+		slices.Sort(recordedModels)
+		report.Recorded.Model = strings.Join(recordedModels, ",")
+		report.Recorded.PromptVersion = strings.Join(recordedVersions, ",")
+		log.Printf("Replaying %d recorded %s extractions with %s, matching with %s", len(entries), e.task.Name, e.modelInfo.Name, match.spec)
+
+		items := make(chan batchItem)
+		go func() {
+			for _, entry := range entries {
+				items <- batchItem{ID: strconv.FormatInt(entry.ID, 10), Input: entry.Input}
+			}
+			close(items)
+		}()
+		processItems(ctx, items, *concurrencyFlag, true, []runFunc{e.runItem}, func(out batchOutput) {
+			entry := recorded[out.ID]
+			result := replayResult{
+				ID:                entry.ID,
+				Input:             entry.Input,
+				RecordedModel:     entry.Model,
+				Error:             out.Error,
+				RecordedCostUSD:   entry.CostUSD,
+				CostUSD:           out.CostUSD,
+				RecordedLatencyMs: entry.LatencyMs,
+				LatencyMs:         out.LatencyMs,
+			}
+			if records, _, err := e.task.Check(entry.Output); err == nil {
+				data, _ := json.Marshal(records)
+				result.Recorded = firstRecordField(data, field)
+			}
+			if out.Error == "" {
+				result.Candidate = firstRecordField(out.Records, field)
+				result.Match = match.match(result.Candidate, result.Recorded)
+			}
+			if result.Match {
+				report.Matches++
+			} else if out.Error == "" {
+				log.Printf("%d: %s answered %q for %q, the recorded %s answer was %q", entry.ID, e.modelInfo.Name, result.Candidate, entry.Input, entry.Model, result.Recorded)
+			}
+			report.Candidate.add(out.InputTokens, out.OutputTokens, out.CostUSD, out.LatencyMs, out.Error != "")
+			report.Results = append(report.Results, result)
+		})
+		report.Recorded.finish()
+		report.Candidate.finish()
+		report.Agreement = float64(report.Matches) / float64(len(entries))
+
+		if err := writeReplayReport(os.Stdout, format, report); err != nil {
+			return fmt.Errorf("failed to write the report: %v", err)
+		}
+		return nil
+	})
+}
+
+// firstRecordField returns the field value of the first of the JSON records; empty without one
+func firstRecordField(data json.RawMessage, field string) string {
+	var records []map[string]any
+	json.Unmarshal(data, &records)
+	if len(records) > 0 {
+		if value, ok := records[0][field]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// add counts an item of a side of the report
+func (s *replaySide) add(inputTokens, outputTokens int, costUSD float64, latencyMs int64, failed bool) {
+	s.Items++
+	if failed {
+		s.Errors++
+	}
+	s.InputTokens += inputTokens
+	s.OutputTokens += outputTokens
+	s.CostUSD += costUSD
+	s.latencies = append(s.latencies, latencyMs)
+}
+
+// finish computes the median latency once every item is counted
+func (s *replaySide) finish() {
+	s.P50LatencyMs = percentile(s.latencies, 50)
+}
+
+// writeReplayReport writes the recorded and candidate rows as an aligned table with the
+// agreement and the cost change, or the whole report, with every item, as JSON
+func writeReplayReport(out io.Writer, format string, report *replayReport) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tMODEL\tPROMPT_VERSION\tITEMS\tERRORS\tMATCHES\tAGREEMENT\tINPUT_TOKENS\tOUTPUT_TOKENS\tP50_LATENCY\tESTIMATED_COST_USD")
+	r := report.Recorded
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t-\t-\t%d\t%d\t%dms\t%.4f\n", r.Source, r.Model, r.PromptVersion, r.Items, r.Errors, r.InputTokens, r.OutputTokens, r.P50LatencyMs, r.CostUSD)
+	c := report.Candidate
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%.4f\t%d\t%d\t%dms\t%.4f\n", c.Source, c.Model, c.PromptVersion, c.Items, c.Errors, report.Matches, report.Agreement, c.InputTokens, c.OutputTokens, c.P50LatencyMs, c.CostUSD)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if r.CostUSD > 0 {
+		_, err := fmt.Fprintf(out, "The candidate costs %+.1f%% compared with the recorded extractions\n", 100*(c.CostUSD-r.CostUSD)/r.CostUSD)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"bedrock-llama/vcr"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fixtureDir holds the exchanges of a smoke test, recorded with
+// go run . smoke -record-fixtures=testdata/fixtures
+const fixtureDir = "testdata/fixtures"
+
+// TestReplayFixtures runs the smoke prompt through every model from the recorded fixtures, so
+// the request each package builds and the parsing of its response are checked end to end
+func TestReplayFixtures(t *testing.T) {
+	recorder, err := vcr.New(vcr.ModeReplay, fixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := bedrock.NewClient(context.Background(), bedrock.Config{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		Region:          "us-east-2",
+		WrapHTTPClient:  recorder.Wrap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		model        string
+		text         string
+		thinking     string
+		inputTokens  int
+		outputTokens int
+	}{
+		{model: "nova", text: "OK", inputTokens: 9, outputTokens: 2},
+		{model: "llama", text: "OK", inputTokens: 21, outputTokens: 2},
+		{model: "llama70b", text: "OK", inputTokens: 21, outputTokens: 2},
+		{model: "claude", text: "OK", inputTokens: 14, outputTokens: 4},
+		{model: "deepseek", text: "OK", thinking: "The user asks for a single word, so I reply OK.", inputTokens: 12, outputTokens: 19},
+	}
+	if len(tests) != len(models.Names()) {
+		t.Errorf("the fixtures cover %d models, the registry has %d: %v", len(tests), len(models.Names()), models.Names())
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			info, ok := models.Lookup(tt.model)
+			if !ok {
+				t.Fatalf("unknown model %q", tt.model)
+			}
+			// The options of the smoke test, which recorded the fixtures
+			model := info.New(client, bedrock.Options{Latency: bedrock.LatencyStandard})
+			result, err := model.Invoke(context.Background(), smokePrompt)
+			if err != nil {
+				t.Fatalf("Invoke: %v", err)
+			}
+			if strings.TrimSpace(result.Text) != tt.text {
+				t.Errorf("text = %q, want %q", result.Text, tt.text)
+			}
+			if result.Thinking != tt.thinking {
+				t.Errorf("thinking = %q, want %q", result.Thinking, tt.thinking)
+			}
+			if result.InputTokens != tt.inputTokens || result.OutputTokens != tt.outputTokens {
+				t.Errorf("usage = %d/%d tokens, want %d/%d", result.InputTokens, result.OutputTokens, tt.inputTokens, tt.outputTokens)
+			}
+		})
+	}
+}
+
+// TestFixturesSanitized checks that the committed fixtures hold no credentials, signatures,
+// request IDs or account IDs
+func TestFixturesSanitized(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no fixtures in %s: %v", fixtureDir, err)
+	}
+	account := regexp.MustCompile(`\b\d{12}\b`)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fixture vcr.Fixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		for _, id := range account.FindAllString(fixture.Request.Path, -1) {
+			if id != "000000000000" {
+				t.Errorf("%s: account ID %s in the request path", file, id)
+			}
+		}
+		for name := range fixture.Response.Header {
+			if strings.Contains(strings.ToLower(name), "request-id") || strings.Contains(strings.ToLower(name), "requestid") {
+				t.Errorf("%s: header %s", file, name)
+			}
+		}
+		for _, secret := range []string{"Authorization", "X-Amz-Signature", "X-Amz-Security-Token", "AKIA"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s contains %s", file, secret)
+			}
+		}
+	}
+}
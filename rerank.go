@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/cohere"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// rerankOutput is one ranked candidate
+type rerankOutput struct {
+	Rank int `json:"rank"`
+	// ID is the candidate's input line number, or its position among the arguments
+	ID    string  `json:"id"`
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+// runRerank implements the rerank subcommand, which orders candidates by relevance to a query
+func runRerank(args []string) {
+	fs := flag.NewFlagSet("rerank", flag.ExitOnError)
+	f := &flags{}
+	queryFlag := fs.String("query", "", "Text to rank the candidates against, such as a file name")
+	inputFileFlag := fs.String("input-file", "", "File with one candidate per line, an s3://bucket/key object, or - for stdin; defaults to the arguments")
+	topFlag := fs.Int("top", 0, "Number of candidates to list, the most relevant first (0 for all)")
+	minScoreFlag := fs.Float64("min-score", 0, "Leave out candidates scoring below this relevance, from 0 to 1")
+	formatFlag := fs.String("format", "table", "List format: table or json")
+	regionFlag := fs.String("region", "", "Region to rank in, where Cohere Rerank is available; defaults to $AWS_REGION")
+	f.endpoint = fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL; defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	f.fips = fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	maxAttemptsFlag := fs.Int("max-attempts", 5, "Maximum attempts when Bedrock throttles the request (1 disables retries)")
+	timeoutFlag := fs.Duration("timeout", time.Minute, "Time limit for the request")
+	registerSDKRetryFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	if strings.TrimSpace(*queryFlag) == "" || (*inputFileFlag == "") == (fs.NArg() == 0) {
+		fatalf("Usage: %s rerank -query QUERY [flags] CANDIDATE..., or %s rerank -query QUERY -input-file FILE [flags]", os.Args[0], os.Args[0])
+	}
+	if *topFlag < 0 {
+		fatalf("-top can't be negative")
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "table" && format != "json" {
+		fatalf("Invalid format %q. Use table or json", *formatFlag)
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *regionFlag != "" {
+		cfg.Region = *regionFlag
+	}
+	ctx := context.Background()
+
+	var candidates []batchItem
+	if *inputFileFlag == "" {
+		for i, arg := range fs.Args() {
+			candidates = append(candidates, batchItem{ID: strconv.Itoa(i + 1), Input: arg})
+		}
+	} else {
+		input, _, err := openBatchInput(ctx, cfg, *inputFileFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		items := make(chan batchItem)
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- readItems(input, false, items)
+			close(items)
+		}()
+		for item := range items {
+			candidates = append(candidates, item)
+		}
+		input.Close()
+		if err := <-readErr; err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+	documents := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		documents[i] = candidate.Input
+	}
+
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	log.Printf("Ranking %d candidate(s) with Cohere Rerank in %s...", len(candidates), cfg.Region)
+	rankings, err := cohere.Rerank(ctx, client, *queryFlag, documents, *topFlag, bedrock.Options{
+		Retry:   bedrock.RetryPolicy{MaxAttempts: *maxAttemptsFlag},
+		Timeout: *timeoutFlag,
+	})
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	outputs := []rerankOutput{}
+	for _, ranking := range rankings {
+		if ranking.Score < *minScoreFlag {
+			continue
+		}
+		candidate := candidates[ranking.Index]
+		outputs = append(outputs, rerankOutput{Rank: len(outputs) + 1, ID: candidate.ID, Text: candidate.Input, Score: ranking.Score})
+	}
+	if format == "json" {
+		data, _ := json.MarshalIndent(outputs, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tSCORE\tID\tCANDIDATE")
+	for _, out := range outputs {
+		fmt.Fprintf(w, "%d\t%.4f\t%s\t%s\n", out.Rank, out.Score, out.ID, out.Text)
+	}
+	w.Flush()
+}
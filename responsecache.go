@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/cache"
+	"bedrock-llama/s3io"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// cacheFileEnv sets the cache when -cache isn't given
+const cacheFileEnv = "BEDROCK_LLAMA_CACHE"
+
+// redisKeyPrefix namespaces the cache keys in a Redis server shared with other applications
+const redisKeyPrefix = "bedrock-llama:"
+
+// responseCache stores the validated results of extractions, so an input seen before is answered
+// without invoking the model
+type responseCache struct {
+	store cache.Cache
+	// refresh skips lookups and overwrites the cached results, from -no-cache
+	refresh bool
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// openResponseCache opens the cache backend named by target: a redis:// or rediss:// URL, an
+// s3://bucket/prefix/, dynamodb://table, or the path of a local BoltDB file. The shared
+// backends let workers on several machines reuse each other's results.
+func (e *extractor) openResponseCache(ctx context.Context, target string, ttl time.Duration, refresh bool) (*responseCache, error) {
+	var store cache.Cache
+	switch {
+	case strings.HasPrefix(target, "redis://") || strings.HasPrefix(target, "rediss://"):
+		redis, err := cache.OpenRedis(ctx, target, redisKeyPrefix, ttl)
+		if err != nil {
+			return nil, err
+		}
+		store = redis
+	case strings.HasPrefix(target, "s3://"):
+		location, _, err := s3io.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if !location.IsPrefix() {
+			location.Key += "/"
+		}
+		client, err := e.s3Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		store = cache.NewS3(client, location.Bucket, location.Key, ttl)
+	case strings.HasPrefix(target, "dynamodb://"):
+		table := strings.TrimPrefix(target, "dynamodb://")
+		if table == "" {
+			return nil, errors.New("invalid -cache: dynamodb:// needs a table name")
+		}
+		awsCfg, err := bedrock.LoadAWSConfig(ctx, e.awsConfig)
+		if err != nil {
+			return nil, err
+		}
+		store = cache.NewDynamoDB(dynamodb.NewFromConfig(awsCfg), table, ttl)
+	default:
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			return nil, err
+		}
+		bolt, err := cache.OpenBolt(target, ttl)
+		if err != nil {
+			return nil, err
+		}
+		store = bolt
+	}
+	return &responseCache{store: store, refresh: refresh}, nil
+}
+
+// cacheKey hashes everything that determines a model's answer: the model and its version, the
+// full conversation including the task prompt and few-shot examples, every option that reaches
+// the request body, the guardrail ID and version screening it and the Llama Guard settings.
+// Edits to a guardrail's DRAFT don't change the key.
+func (e *extractor) cacheKey(turns []bedrock.Turn) string {
+	var thinking int
+	if e.opts.Thinking != nil {
+		thinking = e.opts.Thinking.BudgetTokens
+	}
+	fields := struct {
+		Model          string                    `json:"model"`
+		ModelID        string                    `json:"model_id"`
+		ModelVersion   string                    `json:"model_version,omitempty"`
+		System         string                    `json:"system,omitempty"`
+		Turns          []bedrock.Turn            `json:"turns"`
+		Structured     *bedrock.StructuredOutput `json:"structured,omitempty"`
+		MaxTokens      int                       `json:"max_tokens,omitempty"`
+		TopK           int                       `json:"top_k,omitempty"`
+		StopSequences  []string                  `json:"stop_sequences,omitempty"`
+		Temperature    *float64                  `json:"temperature,omitempty"`
+		TopP           *float64                  `json:"top_p,omitempty"`
+		ThinkingBudget int                       `json:"thinking_budget,omitempty"`
+		AnthropicBeta  []string                  `json:"anthropic_beta,omitempty"`
+		Guardrail      *bedrock.Guardrail        `json:"guardrail,omitempty"`
+		Moderation     *moderationSettings       `json:"moderation,omitempty"`
+	}{
+		e.modelInfo.Name, e.modelInfo.ModelID, e.opts.ModelVersion, e.opts.System, turns, e.opts.Structured,
+		e.opts.MaxTokens, e.opts.TopK, e.opts.StopSequences, e.opts.Temperature, e.opts.TopP, thinking,
+		e.opts.AnthropicBeta, e.opts.Guardrail, e.moderator.settings(),
+	}
+	data, _ := json.Marshal(fields)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// get returns the cached result under key. The result cost nothing, so it reports no tokens.
+// Errors are only logged and count as a miss; the model is invoked instead.
+func (c *responseCache) get(ctx context.Context, key string) (*bedrock.Result, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.refresh {
+		c.misses.Add(1)
+		return nil, false
+	}
+	data, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		log.Printf("Error reading the cache: %v", err)
+	}
+	var result bedrock.Result
+	if ok {
+		if err := json.Unmarshal(data, &result); err != nil {
+			log.Printf("Error decoding cached result: %v", err)
+			ok = false
+		}
+	}
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	result.Cached = true
+	result.InputTokens, result.OutputTokens, result.Repairs, result.Continuations = 0, 0, 0, 0
+	return &result, true
+}
+
+// put caches a validated result under key
+func (c *responseCache) put(ctx context.Context, key string, result *bedrock.Result) {
+	if c == nil {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Error encoding result for the cache: %v", err)
+		return
+	}
+	if err := c.store.Put(ctx, key, data); err != nil {
+		log.Printf("Error writing the cache: %v", err)
+	}
+}
+
+// close logs the hit rate and closes the cache
+func (c *responseCache) close() {
+	if c == nil {
+		return
+	}
+	if hits, misses := c.hits.Load(), c.misses.Load(); hits+misses > 0 {
+		log.Printf("Cache: %d hits, %d misses", hits, misses)
+	}
+	if err := c.store.Close(); err != nil {
+		log.Printf("Error closing the cache: %v", err)
+	}
+}
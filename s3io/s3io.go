@@ -0,0 +1,91 @@
+package s3io
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Location is an S3 object or prefix given as s3://bucket/key
+type Location struct {
+	Bucket string
+	Key    string
+}
+
+// String returns the location as an s3:// URL
+func (l Location) String() string {
+	return "s3://" + l.Bucket + "/" + l.Key
+}
+
+// IsPrefix reports whether the location names a prefix (a key ending in "/") rather than an object
+func (l Location) IsPrefix() bool {
+	return l.Key == "" || strings.HasSuffix(l.Key, "/")
+}
+
+// Parse parses an s3://bucket/key URL. ok is false when the text isn't an S3 URL.
+func Parse(text string) (location Location, ok bool, err error) {
+	rest, ok := strings.CutPrefix(text, "s3://")
+	if !ok {
+		return Location{}, false, nil
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return Location{}, true, fmt.Errorf("invalid S3 URL %q: missing bucket", text)
+	}
+	return Location{Bucket: bucket, Key: key}, true, nil
+}
+
+// Open streams an S3 object; the caller must close the returned reader
+func Open(ctx context.Context, client *s3.Client, location Location) (io.ReadCloser, error) {
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(location.Bucket),
+		Key:    aws.String(location.Key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", location, err)
+	}
+	return output.Body, nil
+}
+
+// Writer streams data to an S3 object with a multipart upload, so the object never has to be
+// held in memory. The upload completes when the writer is closed.
+type Writer struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+// Create starts an upload to an S3 object
+func Create(ctx context.Context, client *s3.Client, location Location, contentType string) *Writer {
+	reader, writer := io.Pipe()
+	w := &Writer{pipe: writer, done: make(chan error, 1)}
+	go func() {
+		_, err := manager.NewUploader(client).Upload(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(location.Bucket),
+			Key:         aws.String(location.Key),
+			Body:        reader,
+			ContentType: aws.String(contentType),
+		})
+		// Unblock pending writes if the upload failed early
+		reader.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+// Close finishes the upload and returns its error
+func (w *Writer) Close() error {
+	w.pipe.Close()
+	if err := <-w.done; err != nil {
+		return fmt.Errorf("failed to upload to S3: %v", err)
+	}
+	return nil
+}
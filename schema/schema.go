@@ -0,0 +1,306 @@
+package schema
+
+import (
+	"bedrock-llama/extract"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Field types, named after their JSON Schema equivalents
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// typeAliases maps the type names accepted in a schema spec to field types
+var typeAliases = map[string]string{
+	"string":  TypeString,
+	"str":     TypeString,
+	"int":     TypeInteger,
+	"integer": TypeInteger,
+	"float":   TypeNumber,
+	"number":  TypeNumber,
+	"bool":    TypeBoolean,
+	"boolean": TypeBoolean,
+}
+
+// Field is a named, typed field the model must extract
+type Field struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// Schema is the ordered list of fields in an extraction result
+type Schema struct {
+	Fields []Field
+}
+
+// Record is a validated extraction result keyed by field name
+type Record map[string]any
+
+// Series is the default schema: a single series name
+var Series = &Schema{
+	Fields: []Field{
+		{Name: "series", Type: TypeString, Description: "The series name, without season or episode information"},
+	},
+}
+
+// Parse parses a schema spec. The spec can be a field map ({"series":"string","year":"int"}),
+// a JSON Schema object with "properties", or a comma-separated field list (series,year:int)
+// where fields without a type are strings.
+func Parse(spec string) (*Schema, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, errors.New("empty schema")
+	}
+
+	var s *Schema
+	var err error
+	if strings.HasPrefix(spec, "{") {
+		s, err = parseJSON([]byte(spec))
+	} else {
+		s, err = parseList(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Fields) == 0 {
+		return nil, errors.New("schema has no fields")
+	}
+	return s, nil
+}
+
+// parseList parses a comma-separated field list such as "series,year:int"
+func parseList(spec string) (*Schema, error) {
+	s := &Schema{}
+	for _, item := range strings.Split(spec, ",") {
+		name, typeName, _ := strings.Cut(strings.TrimSpace(item), ":")
+		if typeName == "" {
+			typeName = TypeString
+		}
+		if err := s.add(name, typeName, ""); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseJSON parses a field map or a JSON Schema object, keeping the field order of the spec
+func parseJSON(data []byte) (*Schema, error) {
+	var probe struct {
+		Properties json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %v", err)
+	}
+
+	s := &Schema{}
+	if probe.Properties != nil {
+		// JSON Schema: {"type": "object", "properties": {"series": {"type": "string"}}}
+		err := orderedObject(probe.Properties, func(name string, value json.RawMessage) error {
+			var property struct {
+				Type        string `json:"type"`
+				Description string `json:"description"`
+			}
+			if err := json.Unmarshal(value, &property); err != nil {
+				return fmt.Errorf("invalid schema property %q: %v", name, err)
+			}
+			return s.add(name, property.Type, property.Description)
+		})
+		return s, err
+	}
+
+	// Field map: {"series": "string", "year": "int"}
+	err := orderedObject(data, func(name string, value json.RawMessage) error {
+		var typeName string
+		if err := json.Unmarshal(value, &typeName); err != nil {
+			return fmt.Errorf("field %q must map to a type name such as \"string\" or \"int\"", name)
+		}
+		return s.add(name, typeName, "")
+	})
+	return s, err
+}
+
+// orderedObject calls fn for each member of a JSON object in document order
+func orderedObject(data []byte, fn func(name string, value json.RawMessage) error) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return errors.New("schema must be a JSON object")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("invalid schema JSON: %v", err)
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("invalid schema JSON: %v", err)
+		}
+		if err := fn(token.(string), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) add(name, typeName, description string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("schema field name cannot be empty")
+	}
+	fieldType, ok := typeAliases[strings.ToLower(strings.TrimSpace(typeName))]
+	if !ok {
+		return fmt.Errorf("unsupported type %q for field %q: use string, int, number, or bool", typeName, name)
+	}
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return fmt.Errorf("duplicate schema field %q", name)
+		}
+	}
+	s.Fields = append(s.Fields, Field{Name: name, Type: fieldType, Description: description})
+	return nil
+}
+
+// Describe lists the fields for inclusion in a prompt, one per line
+func (s *Schema) Describe() string {
+	var lines []string
+	for _, field := range s.Fields {
+		line := fmt.Sprintf("- %s (%s)", field.Name, field.Type)
+		if field.Description != "" {
+			line += ": " + field.Description
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Example renders the expected output format with placeholder values, e.g. [{"series": "...", "year": 0}]
+func (s *Schema) Example() string {
+	record := Record{}
+	for _, field := range s.Fields {
+		switch field.Type {
+		case TypeString:
+			record[field.Name] = "..."
+		case TypeInteger, TypeNumber:
+			record[field.Name] = 0
+		case TypeBoolean:
+			record[field.Name] = false
+		}
+	}
+	return s.Format([]Record{record})
+}
+
+// JSONSchema returns the JSON Schema of a single record, for use with structured output
+func (s *Schema) JSONSchema() json.RawMessage {
+	type property struct {
+		Type        string `json:"type"`
+		Description string `json:"description,omitempty"`
+	}
+	properties := map[string]property{}
+	required := []string{}
+	for _, field := range s.Fields {
+		properties[field.Name] = property{Type: field.Type, Description: field.Description}
+		required = append(required, field.Name)
+	}
+	data, _ := json.Marshal(map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	})
+	return data
+}
+
+// Validate extracts the JSON from the model output and checks it against the schema.
+// The output may be an array of records or a single record object.
+func (s *Schema) Validate(text string) ([]Record, error) {
+	raw, err := extract.FirstJSON(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	if err := json.Unmarshal([]byte(raw), &records); err != nil {
+		var record Record
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			return nil, fmt.Errorf("expected a JSON array of objects: %v", err)
+		}
+		records = []Record{record}
+	}
+	if len(records) == 0 {
+		return nil, errors.New("JSON array is empty")
+	}
+
+	for i, record := range records {
+		if err := s.validateRecord(record); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+	}
+	return records, nil
+}
+
+func (s *Schema) validateRecord(record Record) error {
+	for _, field := range s.Fields {
+		value, ok := record[field.Name]
+		if !ok || value == nil {
+			return fmt.Errorf("missing field %q", field.Name)
+		}
+		switch field.Type {
+		case TypeString:
+			if text, ok := value.(string); !ok || strings.TrimSpace(text) == "" {
+				return fmt.Errorf("field %q must be a non-empty string", field.Name)
+			}
+		case TypeInteger:
+			number, ok := value.(float64)
+			if !ok || number != math.Trunc(number) {
+				return fmt.Errorf("field %q must be an integer", field.Name)
+			}
+			record[field.Name] = int64(number)
+		case TypeNumber:
+			if _, ok := value.(float64); !ok {
+				return fmt.Errorf("field %q must be a number", field.Name)
+			}
+		case TypeBoolean:
+			if _, ok := value.(bool); !ok {
+				return fmt.Errorf("field %q must be a boolean", field.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Format renders records as a JSON array with fields in schema order, e.g. [{"series": "Friends"}]
+func (s *Schema) Format(records []Record) string {
+	var out strings.Builder
+	out.WriteString("[")
+	for i, record := range records {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString("{")
+		for j, field := range s.Fields {
+			if j > 0 {
+				out.WriteString(", ")
+			}
+			fmt.Fprintf(&out, "%s: %s", encode(field.Name), encode(record[field.Name]))
+		}
+		out.WriteString("}")
+	}
+	out.WriteString("]")
+	return out.String()
+}
+
+// encode marshals a value to JSON without HTML escaping
+func encode(value any) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSpace(buf.String())
+}
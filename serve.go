@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxRequestSize is the largest request body the server accepts
+const maxRequestSize = 1 << 20
+
+// extractRequest is the body of POST /extract
+type extractRequest struct {
+	batchItem
+	// Model overrides the server's -model for this request
+	Model string `json:"model,omitempty"`
+	// AnthropicBeta overrides the server's -anthropic-beta flags for this request
+	AnthropicBeta []string `json:"anthropic_beta,omitempty"`
+}
+
+// server exposes the extractor over HTTP
+type server struct {
+	extractor *extractor
+	// aliases maps OpenAI model names to registered model names
+	aliases   map[string]string
+	mux       *http.ServeMux
+	readiness *readiness
+
+	// sessions counts the open chat WebSockets, which outlive http.Server.Shutdown
+	sessions sync.WaitGroup
+	// closing is closed once the HTTP requests have drained, telling chat sessions to end
+	closing chan struct{}
+	// chatLog tees the chat sessions to a file when -chat-log is set
+	chatLog *chatLog
+}
+
+// runServe starts the HTTP server
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	f := registerFlags(fs)
+	addrFlag := fs.String("addr", ":8080", "Address to listen on")
+	modelMapFlag := fs.String("model-map", "", `JSON file mapping OpenAI model names to models, e.g. {"gpt-4o": "claude"}`)
+	readyCheckFlag := fs.Bool("ready-check", false, "Make /readyz verify that Bedrock answers by invoking -model with a tiny prompt")
+	readyIntervalFlag := fs.Duration("ready-check-interval", time.Minute, "How long a -ready-check result is reused")
+	drainDelayFlag := fs.Duration("drain-delay", 0, "How long to keep serving after SIGTERM while /readyz fails, so load balancers stop routing first")
+	apiKeysFlag := fs.String("api-keys", "", `JSON file of API keys with optional limits, e.g. [{"name": "team-a", "key": "...", "rps": 2, "tpm": 20000}]`)
+	shutdownTimeoutFlag := fs.Duration("shutdown-timeout", 30*time.Second, "How long to wait for in-flight requests to finish on shutdown")
+	chatLogFlag := fs.String("chat-log", "", "JSONL file every /chat message, streamed delta and final response is appended to as it happens")
+	fs.Parse(args)
+
+	aliases, err := loadModelMap(*modelMapFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	auth, err := loadAPIKeys(*apiKeysFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runWithExtractor(context.Background(), f, func(e *extractor) error {
+		s := newServer(e, aliases)
+		s.readiness.interval = *readyIntervalFlag
+		if *readyCheckFlag {
+			s.readiness.check = e.bedrockCheck()
+		}
+		if *chatLogFlag != "" {
+			if s.chatLog, err = openChatLog(*chatLogFlag); err != nil {
+				return err
+			}
+			defer s.chatLog.close()
+			log.Printf("Logging chat sessions to %s", *chatLogFlag)
+		}
+
+		var handler http.Handler = s.mux
+		if auth != nil {
+			log.Printf("Requiring one of %d API keys", len(auth.keys))
+			handler = auth.middleware(s.mux)
+			defer auth.logUsage()
+		} else {
+			warnf("No -api-keys given: anyone who can reach %s can invoke Bedrock models", *addrFlag)
+		}
+
+		httpServer := &http.Server{Addr: *addrFlag, Handler: traceRequests(handler)}
+		serveErr := make(chan error, 1)
+		go func() {
+			log.Printf("Listening on %s", *addrFlag)
+			serveErr <- httpServer.ListenAndServe()
+		}()
+
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+		}
+		stop()
+
+		log.Println("Shutting down: failing readiness checks")
+		s.readiness.draining.Store(true)
+		time.Sleep(*drainDelayFlag)
+
+		log.Println("Waiting for in-flight requests to finish...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeoutFlag)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			warnf("Shutdown: %v", err)
+		}
+		if !s.closeSessions(shutdownCtx) {
+			warnf("Shutdown: chat sessions were still open after %s", *shutdownTimeoutFlag)
+		}
+		log.Println("Server stopped")
+		return nil
+	})
+}
+
+// newServer registers the HTTP routes
+func newServer(e *extractor, aliases map[string]string) *server {
+	s := &server{
+		extractor: e,
+		aliases:   aliases,
+		mux:       http.NewServeMux(),
+		readiness: &readiness{},
+		closing:   make(chan struct{}),
+	}
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+	s.mux.HandleFunc("GET /usage", s.handleUsage)
+	s.mux.Handle("GET /metrics", e.metrics.handler())
+	s.mux.HandleFunc("POST /extract", s.handleExtract)
+	s.mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	s.mux.HandleFunc("GET /v1/models", s.handleModels)
+	s.mux.HandleFunc("GET /chat", s.handleChat)
+	return s
+}
+
+// handleExtract runs the task for one input and returns the records with their usage
+func (s *server) handleExtract(w http.ResponseWriter, r *http.Request) {
+	var req extractRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if strings.TrimSpace(req.Input) == "" {
+		writeError(w, http.StatusBadRequest, `"input" is required`)
+		return
+	}
+
+	e, err := s.extractor.forModel(req.Model)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e = e.withAnthropicBeta(req.AnthropicBeta)
+
+	log.Printf("POST /extract model=%s input=%q", e.modelInfo.Name, req.Input)
+	out := e.runItem(r.Context(), req.batchItem)
+	charge(r.Context(), out.InputTokens, out.OutputTokens)
+	writeJSON(w, outputStatus(out), out)
+}
+
+// outputStatus is the HTTP status of an extraction result: 422 when the model's output never
+// parsed, 429 once the server's budget is spent and 502 when the model call failed
+func outputStatus(out batchOutput) int {
+	switch {
+	case out.invalid:
+		return http.StatusUnprocessableEntity
+	case errors.Is(out.err, errBudgetExceeded):
+		return http.StatusTooManyRequests
+	case out.Error != "":
+		return http.StatusBadGateway
+	}
+	return http.StatusOK
+}
+
+// closeSessions asks the chat sessions to end and waits for them until ctx is done, reporting
+// whether they all closed
+func (s *server) closeSessions(ctx context.Context) bool {
+	close(s.closing)
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/pricing"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Routing policies of simulate
+const (
+	// routingSplit sends each model its share of the items
+	routingSplit = "split"
+	// routingFallback sends every item to the first model, and the items a model fails to the next
+	routingFallback = "fallback"
+)
+
+// Throttle risks of a simulated model
+const (
+	riskLow    = "low"
+	riskMedium = "medium"
+	riskHigh   = "high"
+)
+
+// Thresholds of the throttle risk: the share of the quota a model uses, and the share of its
+// recorded calls that were throttled
+const (
+	riskMediumUtilization = 0.7
+	riskHighThrottleRate  = 0.05
+)
+
+// simulationProfile is what the earlier runs recorded of a model
+type simulationProfile struct {
+	latencies    []int64
+	failures     int
+	throttles    int
+	tokenCalls   int
+	inputTokens  int
+	outputTokens int
+}
+
+// simulatedModel is the estimate for one model of a simulated run
+type simulatedModel struct {
+	Model          string  `json:"model"`
+	Calls          float64 `json:"calls"`
+	Failed         float64 `json:"failed"`
+	InputTokens    float64 `json:"input_tokens"`
+	OutputTokens   float64 `json:"output_tokens"`
+	P50Ms          int64   `json:"p50_ms"`
+	P95Ms          int64   `json:"p95_ms"`
+	FailureRate    float64 `json:"failure_rate"`
+	ThrottleRate   float64 `json:"throttle_rate"`
+	RequestsPerMin float64 `json:"requests_per_minute"`
+	TokensPerMin   float64 `json:"tokens_per_minute"`
+	// Utilization is the share of -quota-rpm or -quota-tpm used, whichever is higher; 0 without quotas
+	Utilization  float64 `json:"quota_utilization,omitempty"`
+	ThrottleRisk string  `json:"throttle_risk"`
+	CostUSD      float64 `json:"estimated_cost_usd"`
+
+	meanMs float64
+}
+
+// simulation is the estimate of a simulated run
+type simulation struct {
+	Items   int              `json:"items"`
+	Routing string           `json:"routing"`
+	Models  []simulatedModel `json:"models"`
+	// Failed is the number of items no model is expected to answer
+	Failed      float64 `json:"failed"`
+	RuntimeSecs float64 `json:"runtime_seconds"`
+	// Bound is what the runtime is set by: latency, rps or tpm
+	Bound   string  `json:"bound_by"`
+	CostUSD float64 `json:"estimated_cost_usd"`
+}
+
+// runSimulate estimates the cost, runtime and throttle risk of a batch run from the latencies,
+// failures and token counts recorded by earlier runs and the current prices, without invoking
+// any model
+func runSimulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s simulate -items=N -models=... [flags] file ...\n\nReads the JSONL results or audit logs of earlier runs, like stats, or stdin without files.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	itemsFlag := fs.Int("items", 0, "Number of inputs of the simulated run")
+	modelsFlag := fs.String("models", "", "Model mix: shares with -routing=split, e.g. nova=0.8,claude=0.2 (equal shares when left out), or the fallback order with -routing=fallback, e.g. nova,claude")
+	routingFlag := fs.String("routing", routingSplit, "Routing policy: split (each model gets its share of the items) or fallback (the items a model fails go to the next)")
+	concurrencyFlag := fs.Int("concurrency", 1, "Number of inputs processed in parallel, as with batch")
+	rpsFlag := fs.Float64("rps", 0, "Client-side limit of requests per second, as with batch (0 for no limit)")
+	tpmFlag := fs.Int("tpm", 0, "Client-side limit of tokens per minute, as with batch (0 for no limit)")
+	quotaRPMFlag := fs.Float64("quota-rpm", 0, "Bedrock requests-per-minute quota of the account for each model, to rate the throttle risk (0 if unknown)")
+	quotaTPMFlag := fs.Float64("quota-tpm", 0, "Bedrock tokens-per-minute quota of the account for each model, to rate the throttle risk (0 if unknown)")
+	inputTokensFlag := fs.Int("input-tokens", 0, "Input tokens of each call, e.g. for a new prompt; the recorded average when 0")
+	outputTokensFlag := fs.Int("output-tokens", 0, "Output tokens of each call; the recorded average when 0")
+	formatFlag := fs.String("format", "table", "Report format: table or json")
+	fs.Parse(args)
+
+	if *itemsFlag <= 0 {
+		fatalf("Simulate needs the number of inputs. Provide it using the -items flag.")
+	}
+	routing := strings.ToLower(*routingFlag)
+	if routing != routingSplit && routing != routingFallback {
+		fatalf("Invalid routing %q. Use %s or %s", *routingFlag, routingSplit, routingFallback)
+	}
+	if *concurrencyFlag < 1 {
+		fatalf("-concurrency must be at least 1")
+	}
+	if *rpsFlag < 0 || *tpmFlag < 0 || *quotaRPMFlag < 0 || *quotaTPMFlag < 0 || *inputTokensFlag < 0 || *outputTokensFlag < 0 {
+		fatalf("-rps, -tpm, -quota-rpm, -quota-tpm, -input-tokens and -output-tokens can't be negative")
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "table" && format != "json" {
+		fatalf("Invalid format %q. Use table or json", *formatFlag)
+	}
+	names, shares, err := parseModelMix(*modelsFlag, routing)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	profiles := map[string]*simulationProfile{}
+	for _, path := range paths {
+		err := scanStats(path, func(record statsRecord, latency *int64) {
+			profile, ok := profiles[record.Model]
+			if !ok {
+				profile = &simulationProfile{}
+				profiles[record.Model] = profile
+			}
+			if record.Error != "" {
+				profile.failures++
+				if isThrottleError(record.Error) {
+					profile.throttles++
+				}
+				return
+			}
+			if latency != nil {
+				profile.latencies = append(profile.latencies, *latency)
+			}
+			if record.InputTokens > 0 || record.OutputTokens > 0 {
+				profile.tokenCalls++
+				profile.inputTokens += record.InputTokens
+				profile.outputTokens += record.OutputTokens
+			}
+		})
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+
+	sim := simulation{Items: *itemsFlag, Routing: routing}
+	remaining := float64(*itemsFlag)
+	for i, name := range names {
+		profile := profiles[name]
+		if profile == nil || len(profile.latencies) == 0 {
+			fatalf("No recorded latencies of %s: pass the batch outputs or audit logs of a run that used it", name)
+		}
+		m := simulatedModel{Model: name, P50Ms: percentile(profile.latencies, 50), P95Ms: percentile(profile.latencies, 95)}
+		var total int64
+		for _, latency := range profile.latencies {
+			total += latency
+		}
+		m.meanMs = float64(total) / float64(len(profile.latencies))
+		recorded := len(profile.latencies) + profile.failures
+		m.FailureRate = float64(profile.failures) / float64(recorded)
+		m.ThrottleRate = float64(profile.throttles) / float64(recorded)
+
+		inputTokens, outputTokens := float64(*inputTokensFlag), float64(*outputTokensFlag)
+		if profile.tokenCalls > 0 {
+			if inputTokens == 0 {
+				inputTokens = float64(profile.inputTokens) / float64(profile.tokenCalls)
+			}
+			if outputTokens == 0 {
+				outputTokens = float64(profile.outputTokens) / float64(profile.tokenCalls)
+			}
+		}
+		if inputTokens == 0 && outputTokens == 0 {
+			fatalf("No recorded token counts of %s: set -input-tokens and -output-tokens", name)
+		}
+
+		if routing == routingSplit {
+			m.Calls = float64(*itemsFlag) * shares[i]
+			m.Failed = m.Calls * m.FailureRate
+			sim.Failed += m.Failed
+		} else {
+			m.Calls = remaining
+			m.Failed = m.Calls * m.FailureRate
+			remaining = m.Failed
+		}
+		answered := m.Calls - m.Failed
+		m.InputTokens, m.OutputTokens = answered*inputTokens, answered*outputTokens
+		m.CostUSD = pricing.Cost(name, int(math.Round(m.InputTokens)), int(math.Round(m.OutputTokens)))
+		sim.CostUSD += m.CostUSD
+		sim.Models = append(sim.Models, m)
+	}
+	if routing == routingFallback {
+		sim.Failed = remaining
+	}
+
+	// The workers share the calls of every model; the client-side limits can slow them down
+	var workMs, calls, tokens float64
+	for _, m := range sim.Models {
+		workMs += m.Calls * m.meanMs
+		calls += m.Calls
+		tokens += m.InputTokens + m.OutputTokens
+	}
+	sim.RuntimeSecs, sim.Bound = workMs/1000/float64(*concurrencyFlag), "latency"
+	if *rpsFlag > 0 && calls / *rpsFlag > sim.RuntimeSecs {
+		sim.RuntimeSecs, sim.Bound = calls / *rpsFlag, "rps"
+	}
+	if *tpmFlag > 0 && tokens/float64(*tpmFlag)*60 > sim.RuntimeSecs {
+		sim.RuntimeSecs, sim.Bound = tokens/float64(*tpmFlag)*60, "tpm"
+	}
+	for i := range sim.Models {
+		m := &sim.Models[i]
+		if minutes := sim.RuntimeSecs / 60; minutes > 0 {
+			m.RequestsPerMin = m.Calls / minutes
+			m.TokensPerMin = (m.InputTokens + m.OutputTokens) / minutes
+		}
+		if *quotaRPMFlag > 0 {
+			m.Utilization = m.RequestsPerMin / *quotaRPMFlag
+		}
+		if *quotaTPMFlag > 0 {
+			m.Utilization = max(m.Utilization, m.TokensPerMin / *quotaTPMFlag)
+		}
+		m.ThrottleRisk = throttleRisk(m.Utilization, m.ThrottleRate)
+	}
+
+	if format == "json" {
+		data, _ := json.MarshalIndent(sim, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	printSimulation(sim, *concurrencyFlag)
+}
+
+// parseModelMix parses the -models of simulate into the models and their shares of the items,
+// which add up to 1 for the split policy
+func parseModelMix(mix, routing string) ([]string, []float64, error) {
+	var names []string
+	var shares []float64
+	var total float64
+	seen := map[string]bool{}
+	for _, part := range strings.Split(mix, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, hasShare := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			return nil, nil, fmt.Errorf("model %s is listed twice in -models", name)
+		}
+		seen[name] = true
+		share := 1.0
+		if hasShare {
+			if routing == routingFallback {
+				return nil, nil, fmt.Errorf("-routing=fallback takes the models in order, without shares")
+			}
+			var err error
+			if share, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || share <= 0 {
+				return nil, nil, fmt.Errorf("invalid share %q of %s: use a positive number", value, name)
+			}
+		}
+		names = append(names, name)
+		shares = append(shares, share)
+		total += share
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("simulate needs the model mix: provide it using the -models flag")
+	}
+	for i := range shares {
+		shares[i] /= total
+	}
+	return names, shares, nil
+}
+
+// isThrottleError reports whether a recorded error is Bedrock throttling the account
+func isThrottleError(message string) bool {
+	return strings.Contains(message, "ThrottlingException") || strings.Contains(message, "ServiceQuotaExceededException") ||
+		strings.Contains(message, bedrock.ErrThrottled.Error())
+}
+
+// throttleRisk rates how likely a model is to be throttled, from the share of its quota the run
+// would use and how often it was throttled before
+func throttleRisk(utilization, throttleRate float64) string {
+	switch {
+	case utilization >= 1 || throttleRate >= riskHighThrottleRate:
+		return riskHigh
+	case utilization >= riskMediumUtilization || throttleRate > 0:
+		return riskMedium
+	}
+	return riskLow
+}
+
+// printSimulation writes the estimate of each model with a total, then the runtime
+func printSimulation(sim simulation, concurrency int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tCALLS\tFAILED\tINPUT_TOKENS\tOUTPUT_TOKENS\tP50\tP95\tREQUESTS_PER_MIN\tTOKENS_PER_MIN\tTHROTTLE_RISK\tESTIMATED_COST_USD")
+	var calls, inputTokens, outputTokens float64
+	for _, m := range sim.Models {
+		fmt.Fprintf(w, "%s\t%.0f\t%.0f\t%.0f\t%.0f\t%dms\t%dms\t%.1f\t%.0f\t%s\t%.4f\n", m.Model, m.Calls, m.Failed, m.InputTokens,
+			m.OutputTokens, m.P50Ms, m.P95Ms, m.RequestsPerMin, m.TokensPerMin, m.ThrottleRisk, m.CostUSD)
+		calls += m.Calls
+		inputTokens += m.InputTokens
+		outputTokens += m.OutputTokens
+	}
+	fmt.Fprintf(w, "TOTAL\t%.0f\t%.0f\t%.0f\t%.0f\t\t\t\t\t\t%.4f\n", calls, sim.Failed, inputTokens, outputTokens, sim.CostUSD)
+	w.Flush()
+
+	runtime := time.Duration(sim.RuntimeSecs * float64(time.Second)).Round(time.Second)
+	fmt.Printf("Estimated runtime: %s for %d items with -concurrency %d, bound by %s\n", runtime, sim.Items, concurrency, sim.Bound)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// smokePrompt is the tiny prompt sent to every model during a smoke test
+const smokePrompt = "Reply with the single word OK."
+
+// smokeCheck is one step of the smoke test
+type smokeCheck struct {
+	name string
+	run  func(ctx context.Context) (detail string, err error)
+}
+
+// smokeResult is the outcome of a smoke check
+type smokeResult struct {
+	name     string
+	detail   string
+	err      error
+	duration time.Duration
+}
+
+// runSmoke exercises the deployment end to end and prints a pass/fail report
+func runSmoke(args []string) {
+	fs := flag.NewFlagSet("smoke", flag.ExitOnError)
+	f := &flags{
+		endpoint: fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL; defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME"),
+		fips:     fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true"),
+	}
+	modelsFlag := fs.String("models", strings.Join(models.Names(), ","), "Comma-separated models to invoke")
+	timeoutFlag := fs.Duration("timeout", 30*time.Second, "Time limit for each check")
+	registerSDKRetryFlags(fs, f)
+	registerFixtureFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	var selected []models.Info
+	for _, name := range strings.Split(*modelsFlag, ",") {
+		info, ok := models.Lookup(strings.ToLower(strings.TrimSpace(name)))
+		if !ok {
+			fatalf("Invalid model %q. Use %s", name, models.Usage())
+		}
+		selected = append(selected, info)
+	}
+
+	ctx := context.Background()
+
+	var model func(info models.Info) bedrock.Model
+	checks := []smokeCheck{{
+		name: "auth",
+		run: func(ctx context.Context) (string, error) {
+			cfg, err := clientConfig(f)
+			if err != nil {
+				return "", err
+			}
+			client, err := bedrock.NewClient(ctx, cfg)
+			if err != nil {
+				return "", err
+			}
+			model = func(info models.Info) bedrock.Model {
+				return info.New(client, bedrock.Options{Latency: bedrock.LatencyStandard})
+			}
+			return "region " + cfg.Region, nil
+		},
+	}}
+	for _, info := range selected {
+		checks = append(checks, smokeCheck{
+			name: "model " + info.Name,
+			run: func(ctx context.Context) (string, error) {
+				if model == nil {
+					return "", errors.New("skipped: no Bedrock client")
+				}
+				result, err := model(info).Invoke(ctx, smokePrompt)
+				if err != nil {
+					return "", err
+				}
+				if strings.TrimSpace(result.Text) == "" {
+					return "", errors.New("empty response")
+				}
+				return fmt.Sprintf("%d input / %d output tokens", result.InputTokens, result.OutputTokens), nil
+			},
+		})
+	}
+
+	results := make([]smokeResult, len(checks))
+	for i, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, *timeoutFlag)
+		start := time.Now()
+		detail, err := check.run(checkCtx)
+		cancel()
+		results[i] = smokeResult{name: check.name, detail: detail, err: err, duration: time.Since(start)}
+	}
+
+	if !printSmokeReport(results) {
+		os.Exit(1)
+	}
+}
+
+// printSmokeReport prints the smoke test results and reports whether every check passed
+func printSmokeReport(results []smokeResult) bool {
+	passed := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tTIME\tDETAIL")
+	for _, r := range results {
+		status, detail := "PASS", r.detail
+		if r.err != nil {
+			passed = false
+			status, detail = "FAIL", r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.name, status, r.duration.Round(time.Millisecond), detail)
+	}
+	w.Flush()
+
+	if passed {
+		fmt.Println("Smoke test passed")
+	} else {
+		fmt.Println(stdout.Red("Smoke test failed"))
+	}
+	return passed
+}
@@ -0,0 +1,491 @@
+package sonic
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// ModelID is the Bedrock model ID of Amazon Nova Sonic, which runs in us-east-1, eu-north-1
+// and ap-northeast-1
+const ModelID = "amazon.nova-sonic-v1:0"
+
+// Audio formats Nova Sonic exchanges: 16-bit little-endian mono PCM
+const (
+	// InputSampleRate is the sample rate of the audio sent to the model
+	InputSampleRate = 16000
+	// OutputSampleRate is the sample rate of the spoken reply
+	OutputSampleRate = 24000
+	// chunkBytes is the size of each audio input event, 32ms of input audio
+	chunkBytes = InputSampleRate / 1000 * 32 * 2
+	// tailSilence is the silence sent after the input so the model hears the speaker stop
+	tailSilence = time.Second
+	// quietPeriod is how long the model must stay silent after the input ends before the
+	// session is closed
+	quietPeriod = 2 * time.Second
+)
+
+// Roles of the text the model sends
+const (
+	// RoleUser marks the transcription of the input speech
+	RoleUser = "USER"
+	// RoleAssistant marks the text of the spoken reply
+	RoleAssistant = "ASSISTANT"
+)
+
+// Config describes a conversation. Zero MaxTokens uses the model's default.
+type Config struct {
+	// System is the system prompt
+	System string
+	// Voice names the voice of the reply, e.g. "matthew" or "tiffany"
+	Voice       string
+	MaxTokens   int
+	Temperature float64
+	TopP        float64
+	// Pace sends the input no faster than real time, for recordings rather than live audio
+	Pace bool
+}
+
+// Output is a piece of the conversation sent by the model: a text, or a chunk of the reply audio
+type Output struct {
+	// Role is RoleUser for the transcription of the input, RoleAssistant for the reply
+	Role string
+	Text string
+	// Audio is a chunk of the spoken reply, 16-bit mono PCM at OutputSampleRate
+	Audio []byte
+}
+
+// Usage is the token consumption of a conversation
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// MediaType represents the format of a text
+type MediaType struct {
+	MediaType string `json:"mediaType"`
+}
+
+// AudioConfiguration represents the format of input or output audio
+type AudioConfiguration struct {
+	MediaType       string `json:"mediaType"`
+	SampleRateHertz int    `json:"sampleRateHertz"`
+	SampleSizeBits  int    `json:"sampleSizeBits"`
+	ChannelCount    int    `json:"channelCount"`
+	VoiceID         string `json:"voiceId,omitempty"`
+	Encoding        string `json:"encoding"`
+	AudioType       string `json:"audioType"`
+}
+
+// InferenceConfiguration represents the sampling of the replies
+type InferenceConfiguration struct {
+	MaxTokens   int     `json:"maxTokens"`
+	TopP        float64 `json:"topP"`
+	Temperature float64 `json:"temperature"`
+}
+
+// SessionStart opens the session
+type SessionStart struct {
+	InferenceConfiguration InferenceConfiguration `json:"inferenceConfiguration"`
+}
+
+// PromptStart opens a prompt and sets the format of the replies
+type PromptStart struct {
+	PromptName               string             `json:"promptName"`
+	TextOutputConfiguration  MediaType          `json:"textOutputConfiguration"`
+	AudioOutputConfiguration AudioConfiguration `json:"audioOutputConfiguration"`
+}
+
+// ContentStart opens a text or audio content of a prompt
+type ContentStart struct {
+	PromptName              string              `json:"promptName"`
+	ContentName             string              `json:"contentName"`
+	Type                    string              `json:"type"`
+	Interactive             bool                `json:"interactive"`
+	Role                    string              `json:"role"`
+	TextInputConfiguration  *MediaType          `json:"textInputConfiguration,omitempty"`
+	AudioInputConfiguration *AudioConfiguration `json:"audioInputConfiguration,omitempty"`
+}
+
+// ContentInput carries a text, or a chunk of base64-encoded audio, of an open content
+type ContentInput struct {
+	PromptName  string `json:"promptName"`
+	ContentName string `json:"contentName"`
+	Content     string `json:"content"`
+}
+
+// ContentEnd closes a content
+type ContentEnd struct {
+	PromptName  string `json:"promptName"`
+	ContentName string `json:"contentName"`
+}
+
+// PromptEnd closes a prompt
+type PromptEnd struct {
+	PromptName string `json:"promptName"`
+}
+
+// InputEvent represents an event sent to Nova Sonic; exactly one field is set
+type InputEvent struct {
+	Event struct {
+		SessionStart *SessionStart `json:"sessionStart,omitempty"`
+		PromptStart  *PromptStart  `json:"promptStart,omitempty"`
+		ContentStart *ContentStart `json:"contentStart,omitempty"`
+		TextInput    *ContentInput `json:"textInput,omitempty"`
+		AudioInput   *ContentInput `json:"audioInput,omitempty"`
+		ContentEnd   *ContentEnd   `json:"contentEnd,omitempty"`
+		PromptEnd    *PromptEnd    `json:"promptEnd,omitempty"`
+		SessionEnd   *struct{}     `json:"sessionEnd,omitempty"`
+	} `json:"event"`
+}
+
+// OutputEvent represents an event sent by Nova Sonic
+type OutputEvent struct {
+	Event struct {
+		ContentStart *struct {
+			ContentID string `json:"contentId"`
+			Type      string `json:"type"`
+			Role      string `json:"role"`
+			// AdditionalModelFields is a JSON object holding the generation stage of texts
+			AdditionalModelFields string `json:"additionalModelFields"`
+		} `json:"contentStart"`
+		TextOutput *struct {
+			ContentID string `json:"contentId"`
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+		} `json:"textOutput"`
+		AudioOutput *struct {
+			ContentID string `json:"contentId"`
+			Content   string `json:"content"`
+		} `json:"audioOutput"`
+		ContentEnd *struct {
+			ContentID  string `json:"contentId"`
+			Type       string `json:"type"`
+			StopReason string `json:"stopReason"`
+		} `json:"contentEnd"`
+		UsageEvent *struct {
+			TotalInputTokens  int `json:"totalInputTokens"`
+			TotalOutputTokens int `json:"totalOutputTokens"`
+		} `json:"usageEvent"`
+	} `json:"event"`
+}
+
+// session is an open conversation with Nova Sonic
+type session struct {
+	stream *bedrock.BidirectionalStream
+	prompt string
+
+	mu sync.Mutex
+	// contents are the roles and generation stages of the open output contents by ID
+	contents map[string]content
+	// awaiting is set from the transcription of the input until the reply ends
+	awaiting  bool
+	speaking  bool
+	lastEvent time.Time
+	usage     Usage
+}
+
+// content is an output content of the model
+type content struct {
+	role        string
+	speculative bool
+}
+
+// Converse streams 16-bit mono PCM audio at InputSampleRate to Nova Sonic, calling onOutput
+// with the transcription of the input and the model's spoken reply as they arrive, from a
+// single goroutine. It returns once the input has ended and the model has finished replying.
+func Converse(ctx context.Context, client *bedrockruntime.Client, cfg Config, audio io.Reader, onOutput func(Output)) (*Usage, error) {
+	stream, err := bedrock.OpenBidirectionalStream(ctx, client, ModelID)
+	if err != nil {
+		return nil, fmt.Errorf("error starting Nova Sonic session: %w", err)
+	}
+	defer stream.Close()
+	s := &session{stream: stream, prompt: newName(), contents: map[string]content{}, lastEvent: time.Now()}
+
+	received := make(chan error, 1)
+	go func() {
+		received <- s.receive(onOutput)
+	}()
+
+	err = s.send(ctx, cfg, audio)
+	if err == nil {
+		err = s.waitQuiet(ctx, received)
+	}
+	if err == nil {
+		err = s.end(ctx)
+	}
+	if err == nil {
+		err = <-received
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s.usage, nil
+}
+
+// send opens the session and streams the system prompt and the audio
+func (s *session) send(ctx context.Context, cfg Config, audio io.Reader) error {
+	maxTokens := cfg.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = 1024
+	}
+	var event InputEvent
+	event.Event.SessionStart = &SessionStart{InferenceConfiguration: InferenceConfiguration{
+		MaxTokens:   maxTokens,
+		TopP:        cfg.TopP,
+		Temperature: cfg.Temperature,
+	}}
+	if err := s.sendEvent(ctx, event); err != nil {
+		return err
+	}
+	event = InputEvent{}
+	event.Event.PromptStart = &PromptStart{
+		PromptName:               s.prompt,
+		TextOutputConfiguration:  MediaType{MediaType: "text/plain"},
+		AudioOutputConfiguration: audioConfiguration(OutputSampleRate, cfg.Voice),
+	}
+	if err := s.sendEvent(ctx, event); err != nil {
+		return err
+	}
+
+	if cfg.System != "" {
+		name := newName()
+		event = InputEvent{}
+		event.Event.ContentStart = &ContentStart{
+			PromptName:             s.prompt,
+			ContentName:            name,
+			Type:                   "TEXT",
+			Interactive:            true,
+			Role:                   "SYSTEM",
+			TextInputConfiguration: &MediaType{MediaType: "text/plain"},
+		}
+		if err := s.sendEvent(ctx, event); err != nil {
+			return err
+		}
+		event = InputEvent{}
+		event.Event.TextInput = &ContentInput{PromptName: s.prompt, ContentName: name, Content: cfg.System}
+		if err := s.sendEvent(ctx, event); err != nil {
+			return err
+		}
+		if err := s.endContent(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	name := newName()
+	inputConfiguration := audioConfiguration(InputSampleRate, "")
+	event = InputEvent{}
+	event.Event.ContentStart = &ContentStart{
+		PromptName:              s.prompt,
+		ContentName:             name,
+		Type:                    "AUDIO",
+		Interactive:             true,
+		Role:                    RoleUser,
+		AudioInputConfiguration: &inputConfiguration,
+	}
+	if err := s.sendEvent(ctx, event); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	sent := 0
+	sendChunk := func(chunk []byte) error {
+		event := InputEvent{}
+		event.Event.AudioInput = &ContentInput{PromptName: s.prompt, ContentName: name, Content: base64.StdEncoding.EncodeToString(chunk)}
+		if err := s.sendEvent(ctx, event); err != nil {
+			return err
+		}
+		sent += len(chunk)
+		if cfg.Pace {
+			// Stay level with the clock so the model hears the recording as it was spoken
+			ahead := time.Until(start.Add(time.Duration(sent/2) * time.Second / InputSampleRate))
+			select {
+			case <-time.After(ahead):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	buf := make([]byte, chunkBytes)
+	carry := 0
+	for {
+		n, err := io.ReadFull(audio, buf[carry:])
+		n += carry
+		// Send whole samples, carrying an odd byte over to the next chunk
+		whole := n &^ 1
+		if whole > 0 {
+			if err := sendChunk(buf[:whole]); err != nil {
+				return err
+			}
+		}
+		carry = n - whole
+		if carry > 0 {
+			buf[0] = buf[whole]
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the audio input: %v", err)
+		}
+	}
+	silence := make([]byte, chunkBytes)
+	for range tailSilence / (32 * time.Millisecond) {
+		if err := sendChunk(silence); err != nil {
+			return err
+		}
+	}
+	return s.endContent(ctx, name)
+}
+
+// waitQuiet waits until the model has replied to the input and then stayed silent for a while
+func (s *session) waitQuiet(ctx context.Context, received <-chan error) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-received:
+			if err == nil {
+				err = errors.New("Nova Sonic closed the session before replying")
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		quiet := !s.awaiting && !s.speaking && time.Since(s.lastEvent) >= quietPeriod
+		s.mu.Unlock()
+		if quiet {
+			return nil
+		}
+	}
+}
+
+// end closes the prompt and the session, after which the model closes the stream
+func (s *session) end(ctx context.Context) error {
+	var event InputEvent
+	event.Event.PromptEnd = &PromptEnd{PromptName: s.prompt}
+	if err := s.sendEvent(ctx, event); err != nil {
+		return err
+	}
+	event = InputEvent{}
+	event.Event.SessionEnd = &struct{}{}
+	return s.sendEvent(ctx, event)
+}
+
+// receive handles the events of the model until it closes the stream
+func (s *session) receive(onOutput func(Output)) error {
+	for {
+		data, err := s.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Nova Sonic session failed: %w", err)
+		}
+		logging.Debugf("Response: %s", string(data))
+		var event OutputEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to unmarshal event: %v", err)
+		}
+		if output, ok := s.handle(event); ok {
+			onOutput(output)
+		}
+	}
+}
+
+// handle updates the state of the session with an event, returning the output it carries
+func (s *session) handle(event OutputEvent) (Output, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastEvent = time.Now()
+	switch e := event.Event; {
+	case e.ContentStart != nil:
+		var fields struct {
+			GenerationStage string `json:"generationStage"`
+		}
+		json.Unmarshal([]byte(e.ContentStart.AdditionalModelFields), &fields)
+		s.contents[e.ContentStart.ContentID] = content{role: e.ContentStart.Role, speculative: fields.GenerationStage == "SPECULATIVE"}
+		if e.ContentStart.Role == RoleAssistant && e.ContentStart.Type == "AUDIO" {
+			s.speaking = true
+		}
+	case e.TextOutput != nil:
+		c := s.contents[e.TextOutput.ContentID]
+		text := e.TextOutput.Content
+		// Barge-ins are reported as a JSON text instead of a transcription
+		if c.speculative || strings.HasPrefix(strings.TrimSpace(text), "{") {
+			return Output{}, false
+		}
+		if e.TextOutput.Role == RoleUser {
+			s.awaiting = true
+		}
+		return Output{Role: e.TextOutput.Role, Text: text}, true
+	case e.AudioOutput != nil:
+		audio, err := base64.StdEncoding.DecodeString(e.AudioOutput.Content)
+		if err != nil {
+			return Output{}, false
+		}
+		return Output{Role: RoleAssistant, Audio: audio}, true
+	case e.ContentEnd != nil:
+		c := s.contents[e.ContentEnd.ContentID]
+		delete(s.contents, e.ContentEnd.ContentID)
+		if c.role == RoleAssistant && e.ContentEnd.Type == "AUDIO" {
+			s.speaking = false
+			if e.ContentEnd.StopReason != "PARTIAL_TURN" {
+				s.awaiting = false
+			}
+		}
+	case e.UsageEvent != nil:
+		s.usage = Usage{InputTokens: e.UsageEvent.TotalInputTokens, OutputTokens: e.UsageEvent.TotalOutputTokens}
+	}
+	return Output{}, false
+}
+
+// sendEvent sends one event to the model
+func (s *session) sendEvent(ctx context.Context, event InputEvent) error {
+	if err := s.stream.Send(ctx, event); err != nil {
+		return fmt.Errorf("failed to send to Nova Sonic: %w", err)
+	}
+	return nil
+}
+
+// endContent closes a content of the prompt
+func (s *session) endContent(ctx context.Context, name string) error {
+	var event InputEvent
+	event.Event.ContentEnd = &ContentEnd{PromptName: s.prompt, ContentName: name}
+	return s.sendEvent(ctx, event)
+}
+
+// audioConfiguration describes 16-bit mono PCM speech, base64-encoded in the events
+func audioConfiguration(sampleRate int, voice string) AudioConfiguration {
+	return AudioConfiguration{
+		MediaType:       "audio/lpcm",
+		SampleRateHertz: sampleRate,
+		SampleSizeBits:  16,
+		ChannelCount:    1,
+		VoiceID:         voice,
+		Encoding:        "base64",
+		AudioType:       "SPEECH",
+	}
+}
+
+// newName returns a random name for a prompt or content
+func newName() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,138 @@
+package sonic
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ReadWAV decodes a 16-bit PCM WAV file into the mono audio at InputSampleRate Nova Sonic
+// takes, mixing the channels down and resampling as needed
+func ReadWAV(r io.Reader) ([]byte, error) {
+	var header struct {
+		RIFF [4]byte
+		Size uint32
+		WAVE [4]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil || string(header.RIFF[:]) != "RIFF" || string(header.WAVE[:]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+
+	var format struct {
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}
+	haveFormat := false
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+			return nil, errors.New("the WAV file has no audio data")
+		}
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			if chunk.Size < 16 {
+				return nil, errors.New("invalid WAV format chunk")
+			}
+			if err := binary.Read(r, binary.LittleEndian, &format); err != nil {
+				return nil, fmt.Errorf("invalid WAV format chunk: %v", err)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(chunk.Size-16+chunk.Size%2)); err != nil {
+				return nil, fmt.Errorf("invalid WAV format chunk: %v", err)
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, errors.New("the WAV file has no format before its audio data")
+			}
+			// 0xFFFE is WAVE_FORMAT_EXTENSIBLE, which also holds plain PCM
+			if (format.AudioFormat != 1 && format.AudioFormat != 0xFFFE) || format.BitsPerSample != 16 || format.Channels == 0 || format.SampleRate == 0 {
+				return nil, fmt.Errorf("the WAV file must be 16-bit PCM, not format %d with %d-bit samples", format.AudioFormat, format.BitsPerSample)
+			}
+			data, err := io.ReadAll(io.LimitReader(r, int64(chunk.Size)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read WAV audio: %v", err)
+			}
+			return convert(data, int(format.Channels), int(format.SampleRate)), nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(chunk.Size+chunk.Size%2)); err != nil {
+				return nil, errors.New("the WAV file has no audio data")
+			}
+		}
+	}
+}
+
+// convert mixes interleaved 16-bit PCM down to mono and linearly resamples it to InputSampleRate
+func convert(data []byte, channels, sampleRate int) []byte {
+	frames := len(data) / 2 / channels
+	mono := make([]float64, frames)
+	for i := range mono {
+		sum := 0
+		for c := range channels {
+			offset := (i*channels + c) * 2
+			sum += int(int16(binary.LittleEndian.Uint16(data[offset:])))
+		}
+		mono[i] = float64(sum) / float64(channels)
+	}
+
+	if sampleRate == InputSampleRate {
+		return encodePCM(mono)
+	}
+	resampled := make([]float64, int(int64(frames)*InputSampleRate/int64(sampleRate)))
+	for i := range resampled {
+		position := float64(i) * float64(sampleRate) / InputSampleRate
+		j := int(position)
+		if j+1 >= frames {
+			resampled[i] = mono[frames-1]
+			continue
+		}
+		fraction := position - float64(j)
+		resampled[i] = mono[j]*(1-fraction) + mono[j+1]*fraction
+	}
+	return encodePCM(resampled)
+}
+
+// encodePCM encodes samples as 16-bit little-endian PCM
+func encodePCM(samples []float64) []byte {
+	out := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(max(min(sample, 32767), -32768))))
+	}
+	return out
+}
+
+// WriteWAV writes 16-bit mono PCM as a WAV file
+func WriteWAV(w io.Writer, pcm []byte, sampleRate int) error {
+	header := struct {
+		RIFF          [4]byte
+		Size          uint32
+		WAVE          [4]byte
+		FormatID      [4]byte
+		FormatSize    uint32
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		DataID        [4]byte
+		DataSize      uint32
+	}{
+		RIFF: [4]byte{'R', 'I', 'F', 'F'}, Size: uint32(36 + len(pcm)), WAVE: [4]byte{'W', 'A', 'V', 'E'},
+		FormatID: [4]byte{'f', 'm', 't', ' '}, FormatSize: 16, AudioFormat: 1, Channels: 1,
+		SampleRate: uint32(sampleRate), ByteRate: uint32(sampleRate * 2), BlockAlign: 2, BitsPerSample: 16,
+		DataID: [4]byte{'d', 'a', 't', 'a'}, DataSize: uint32(len(pcm)),
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	_, err := w.Write(pcm)
+	return err
+}
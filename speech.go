@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/sonic"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// defaultSpeechSystem is the system prompt of speech conversations
+const defaultSpeechSystem = "You are a friendly assistant. The user and you will engage in a spoken dialog. Keep your responses short, generally two or three sentences."
+
+// runSpeech implements the speech subcommand, which talks to Nova Sonic with recorded or live audio
+func runSpeech(args []string) {
+	fs := flag.NewFlagSet("speech", flag.ExitOnError)
+	f := &flags{}
+	inputFlag := fs.String("input", "", fmt.Sprintf("16-bit PCM WAV file to send, or - for raw 16-bit mono PCM at %d Hz on stdin, e.g. from a microphone", sonic.InputSampleRate))
+	outputFlag := fs.String("output", "", "WAV file to write the spoken reply to")
+	transcribeFlag := fs.Bool("transcribe", false, "Only print the transcription of the input, not the reply")
+	systemFlag := fs.String("system", defaultSpeechSystem, "System prompt")
+	voiceFlag := fs.String("voice", "matthew", "Voice of the reply, e.g. matthew, tiffany or amy")
+	maxTokensFlag := fs.Int("max-tokens", 1024, "Maximum tokens of the replies")
+	temperatureFlag := fs.Float64("temperature", 0.7, "Sampling temperature of the replies")
+	topPFlag := fs.Float64("top-p", 0.9, "Nucleus sampling probability of the replies")
+	regionFlag := fs.String("region", "", "Region to talk in, where Nova Sonic is available; defaults to $AWS_REGION")
+	f.endpoint = fs.String("endpoint", "", "Override the Bedrock runtime endpoint URL (https only); defaults to $AWS_ENDPOINT_URL_BEDROCK_RUNTIME")
+	f.fips = fs.Bool("fips", false, "Use the FIPS Bedrock runtime endpoint for the region; also enabled by AWS_USE_FIPS_ENDPOINT=true")
+	timeoutFlag := fs.Duration("timeout", 8*time.Minute, "Time limit for the conversation; Nova Sonic sessions last at most 8 minutes")
+	registerSDKRetryFlags(fs, f)
+	registerLogFlags(fs, f)
+	fs.Parse(args)
+	f.applyLogLevel()
+
+	if *inputFlag == "" || fs.NArg() > 0 {
+		fatalf("Usage: %s speech -input FILE.wav [flags], or %s speech -input - [flags] < raw.pcm", os.Args[0], os.Args[0])
+	}
+	if *maxTokensFlag < 1 {
+		fatalf("-max-tokens must be at least 1")
+	}
+	var audio io.Reader = os.Stdin
+	if *inputFlag != "-" {
+		file, err := os.Open(*inputFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		pcm, err := sonic.ReadWAV(file)
+		file.Close()
+		if err != nil {
+			fatalf("Error reading %s: %v", *inputFlag, err)
+		}
+		audio = bytes.NewReader(pcm)
+		log.Printf("Sending %s of audio from %s", (time.Duration(len(pcm)/2) * time.Second / sonic.InputSampleRate).Round(100*time.Millisecond), *inputFlag)
+	}
+
+	cfg, err := clientConfig(f)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *regionFlag != "" {
+		cfg.Region = *regionFlag
+	}
+	if strings.HasPrefix(strings.ToLower(cfg.Endpoint), "http://") {
+		fatalf("Nova Sonic streams over HTTP/2, which needs an https:// endpoint")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeoutFlag)
+	defer cancel()
+	client, err := bedrock.NewClient(ctx, cfg)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	var reply bytes.Buffer
+	usage, err := sonic.Converse(ctx, client, sonic.Config{
+		System:      *systemFlag,
+		Voice:       *voiceFlag,
+		MaxTokens:   *maxTokensFlag,
+		Temperature: *temperatureFlag,
+		TopP:        *topPFlag,
+		// Files are sent as if spoken; stdin is already live
+		Pace: *inputFlag != "-",
+	}, audio, func(out sonic.Output) {
+		switch {
+		case out.Audio != nil:
+			reply.Write(out.Audio)
+		case *transcribeFlag:
+			if out.Role == sonic.RoleUser {
+				fmt.Println(out.Text)
+			}
+		case out.Role == sonic.RoleUser:
+			fmt.Printf("You: %s\n", out.Text)
+		default:
+			fmt.Printf("Nova: %s\n", out.Text)
+		}
+	})
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	log.Printf("Tokens: %d input, %d output", usage.InputTokens, usage.OutputTokens)
+
+	if *outputFlag != "" {
+		file, err := os.Create(*outputFlag)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		if err := sonic.WriteWAV(file, reply.Bytes(), sonic.OutputSampleRate); err != nil {
+			file.Close()
+			fatalf("Error writing %s: %v", *outputFlag, err)
+		}
+		if err := file.Close(); err != nil {
+			fatalf("Error writing %s: %v", *outputFlag, err)
+		}
+		log.Printf("Wrote %s of spoken reply to %s", (time.Duration(reply.Len()/2) * time.Second / sonic.OutputSampleRate).Round(100*time.Millisecond), *outputFlag)
+	}
+}
@@ -0,0 +1,232 @@
+package stability
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// Stable Diffusion 3.5 Large
+const (
+	// Name is the short model name used on the command line
+	Name = "sd3"
+	// ModelID is the Bedrock model ID; Stable Diffusion 3.5 Large runs in us-west-2
+	ModelID = "stability.sd3-5-large-v1:0"
+)
+
+// Stable Diffusion XL 1.0
+const (
+	// SDXLName is the short model name used on the command line
+	SDXLName = "sdxl"
+	// SDXLModelID is the Bedrock model ID; SDXL runs in us-east-1 and us-west-2
+	SDXLModelID = "stability.stable-diffusion-xl-v1"
+)
+
+// maxSeed is the largest seed the Stability models accept
+const maxSeed = 4294967294
+
+// aspectRatios are the aspect ratios Stable Diffusion 3 generates, as width and height
+var aspectRatios = [][2]int{{1, 1}, {16, 9}, {9, 16}, {21, 9}, {9, 21}, {3, 2}, {2, 3}, {5, 4}, {4, 5}}
+
+// TextPrompt represents a weighted prompt of an SDXL request; a negative weight steers away from it
+type TextPrompt struct {
+	Text   string  `json:"text"`
+	Weight float64 `json:"weight"`
+}
+
+// SDXLPayload represents the request payload for Stable Diffusion XL
+type SDXLPayload struct {
+	TextPrompts []TextPrompt `json:"text_prompts"`
+	CFGScale    float64      `json:"cfg_scale,omitempty"`
+	Steps       int          `json:"steps,omitempty"`
+	Seed        int          `json:"seed"`
+	Width       int          `json:"width,omitempty"`
+	Height      int          `json:"height,omitempty"`
+}
+
+// SDXLResponse represents the response from Stable Diffusion XL
+type SDXLResponse struct {
+	Result    string `json:"result"`
+	Artifacts []struct {
+		Seed         int    `json:"seed"`
+		Base64       string `json:"base64"`
+		FinishReason string `json:"finishReason"`
+	} `json:"artifacts"`
+}
+
+// Payload represents the request payload for Stable Diffusion 3
+type Payload struct {
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	Mode           string `json:"mode"`
+	AspectRatio    string `json:"aspect_ratio,omitempty"`
+	Seed           int    `json:"seed"`
+	OutputFormat   string `json:"output_format"`
+}
+
+// Response represents the response from Stable Diffusion 3
+type Response struct {
+	Seeds []int `json:"seeds"`
+	// FinishReasons are null for generated images, and say why the others were filtered
+	FinishReasons []*string `json:"finish_reasons"`
+	Images        []string  `json:"images"`
+}
+
+// invoke sends a payload to a Stability model and unmarshals its response
+func invoke(ctx context.Context, client bedrock.Invoker, modelID string, payload, response any, opts bedrock.Options) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %v", err)
+	}
+	logging.Debugf("Payload: %s", string(payloadBytes))
+
+	output, err := bedrock.Invoke(ctx, client, &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(modelID),
+		ContentType: aws.String("application/json"),
+		Accept:      aws.String("application/json"),
+		Body:        payloadBytes,
+	}, opts)
+	if err != nil {
+		return fmt.Errorf("error invoking Bedrock image model %s: %w", modelID, err)
+	}
+	if err := json.Unmarshal(output.Body, response); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return nil
+}
+
+// aspectRatio returns the supported aspect ratio closest to a size, or "" for the default
+func aspectRatio(width, height int) string {
+	if width == 0 || height == 0 {
+		return ""
+	}
+	best, bestDiff := aspectRatios[0], math.Inf(1)
+	for _, ratio := range aspectRatios {
+		diff := math.Abs(math.Log(float64(width)/float64(height)) - math.Log(float64(ratio[0])/float64(ratio[1])))
+		if diff < bestDiff {
+			best, bestDiff = ratio, diff
+		}
+	}
+	return fmt.Sprintf("%d:%d", best[0], best[1])
+}
+
+type model struct {
+	client bedrock.Invoker
+	opts   bedrock.Options
+	sdxl   bool
+}
+
+// New returns a bedrock.ImageModel that generates images with Stable Diffusion 3.5 Large
+func New(client bedrock.Invoker, opts bedrock.Options) bedrock.ImageModel {
+	return &model{client: client, opts: opts}
+}
+
+// NewSDXL returns a bedrock.ImageModel that generates images with Stable Diffusion XL
+func NewSDXL(client bedrock.Invoker, opts bedrock.Options) bedrock.ImageModel {
+	return &model{client: client, opts: opts, sdxl: true}
+}
+
+func (m *model) Name() string {
+	if m.sdxl {
+		return SDXLName
+	}
+	return Name
+}
+
+// Generate makes one request per image, as the Stability models return a single image each.
+// The images after the first use the following seeds.
+func (m *model) Generate(ctx context.Context, req bedrock.ImageRequest) (*bedrock.ImageResult, error) {
+	seed := req.Seed
+	if seed < 0 {
+		seed = rand.IntN(maxSeed + 1)
+	} else if seed > maxSeed {
+		return nil, fmt.Errorf("the %s seed must be at most %d", m.Name(), maxSeed)
+	}
+	switch {
+	case req.Quality != "":
+		return nil, fmt.Errorf("the %s model doesn't take a quality", m.Name())
+	case !m.sdxl && (req.Steps > 0 || req.CFGScale > 0):
+		return nil, fmt.Errorf("the %s model doesn't take steps or a CFG scale; use %s", Name, SDXLName)
+	}
+
+	generate := m.generate
+	if m.sdxl {
+		generate = m.generateSDXL
+	}
+	result := &bedrock.ImageResult{Model: m.Name(), Seed: seed}
+	for i := range max(req.Count, 1) {
+		image, err := generate(ctx, req, (seed+i)%(maxSeed+1))
+		if err != nil {
+			return nil, err
+		}
+		result.Images = append(result.Images, image)
+	}
+	return result, nil
+}
+
+// generate creates one image with Stable Diffusion 3
+func (m *model) generate(ctx context.Context, req bedrock.ImageRequest, seed int) ([]byte, error) {
+	var response Response
+	err := invoke(ctx, m.client, ModelID, Payload{
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		Mode:           "text-to-image",
+		AspectRatio:    aspectRatio(req.Width, req.Height),
+		Seed:           seed,
+		OutputFormat:   "png",
+	}, &response, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.FinishReasons) > 0 && response.FinishReasons[0] != nil {
+		return nil, fmt.Errorf("image generation failed: %s", *response.FinishReasons[0])
+	}
+	if len(response.Images) == 0 {
+		return nil, fmt.Errorf("the model returned no images")
+	}
+	return decode(response.Images[0])
+}
+
+// generateSDXL creates one image with Stable Diffusion XL
+func (m *model) generateSDXL(ctx context.Context, req bedrock.ImageRequest, seed int) ([]byte, error) {
+	prompts := []TextPrompt{{Text: req.Prompt, Weight: 1}}
+	if req.NegativePrompt != "" {
+		prompts = append(prompts, TextPrompt{Text: req.NegativePrompt, Weight: -1})
+	}
+	var response SDXLResponse
+	err := invoke(ctx, m.client, SDXLModelID, SDXLPayload{
+		TextPrompts: prompts,
+		CFGScale:    req.CFGScale,
+		Steps:       req.Steps,
+		Seed:        seed,
+		Width:       req.Width,
+		Height:      req.Height,
+	}, &response, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Artifacts) == 0 {
+		return nil, fmt.Errorf("the model returned no images")
+	}
+	if artifact := response.Artifacts[0]; artifact.FinishReason != "SUCCESS" {
+		return nil, fmt.Errorf("image generation failed: %s", artifact.FinishReason)
+	}
+	return decode(response.Artifacts[0].Base64)
+}
+
+// decode decodes a base64 image of a response
+func decode(encoded string) ([]byte, error) {
+	image, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image in the response: %v", err)
+	}
+	return image, nil
+}
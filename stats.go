@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// statsRecord holds the fields of a batch output or audit log line needed for latency statistics
+type statsRecord struct {
+	Model        string `json:"model"`
+	LatencyMs    *int64 `json:"latency_ms"`
+	DurationMs   *int64 `json:"duration_ms"`
+	Error        string `json:"error"`
+	DuplicateOf  string `json:"duplicate_of"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+}
+
+// runStats prints per-model latency statistics of earlier runs, read from JSONL batch outputs,
+// serve or worker results, or audit logs
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s stats [file ...]\n\nReads JSONL results or audit logs, or stdin without files.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	latencies := map[string][]int64{}
+	failures := map[string]int{}
+	for _, path := range paths {
+		if err := readStats(path, latencies, failures); err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+	if len(latencies) == 0 && len(failures) == 0 {
+		fatalf("No model invocations found")
+	}
+	printLatencies(os.Stdout, "", latencies, failures)
+}
+
+// readStats adds the latencies and failures recorded in a JSONL file, or stdin for "-"
+func readStats(path string, latencies map[string][]int64, failures map[string]int) error {
+	return scanStats(path, func(record statsRecord, latency *int64) {
+		switch {
+		case record.Error != "":
+			failures[record.Model]++
+		case latency != nil:
+			latencies[record.Model] = append(latencies[record.Model], *latency)
+		}
+	})
+}
+
+// scanStats calls fn with the model invocations recorded in a JSONL file, or stdin for "-",
+// and their latency; nil when the record has none
+func scanStats(path string, fn func(record statsRecord, latency *int64)) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", path, err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var record statsRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return fmt.Errorf("%s line %d: invalid JSON: %v", path, lineNumber, err)
+		}
+		// Duplicates reused another item's result without invoking the model
+		if record.Model == "" || record.DuplicateOf != "" {
+			continue
+		}
+		latency := record.LatencyMs
+		if latency == nil {
+			latency = record.DurationMs
+		}
+		fn(record, latency)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bedrock-llama/models"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+)
+
+// stubDefaultText is the answer of every model that the -responses file doesn't configure
+const stubDefaultText = `[{"series": "The Office"}]`
+
+// stubAnyModel is the -responses key of the answer of the models without their own
+const stubAnyModel = "*"
+
+// stubResponse is the canned answer of a model in the -responses file of stub-server
+type stubResponse struct {
+	// Text is the generated text
+	Text string `json:"text"`
+	// ToolInput is the tool call input returned when a request forces a tool call, as in
+	// -structured mode; the text is returned when it's empty
+	ToolInput json.RawMessage `json:"tool_input,omitempty"`
+	// InputTokens and OutputTokens are the reported usage, estimated from the request and the
+	// text when zero
+	InputTokens  int `json:"input_tokens,omitempty"`
+	OutputTokens int `json:"output_tokens,omitempty"`
+	// StopReason overrides the model's usual stop reason, e.g. max_tokens
+	StopReason string `json:"stop_reason,omitempty"`
+	// Delay is how long to wait before answering, e.g. "500ms"
+	Delay string `json:"delay,omitempty"`
+	// Error is an error code to fail with instead of answering, e.g. ThrottlingException
+	Error string `json:"error,omitempty"`
+
+	delay time.Duration
+}
+
+// stubErrorStatus gives the HTTP status of the Bedrock errors a stub can fail with
+var stubErrorStatus = map[string]int{
+	"AccessDeniedException":         http.StatusForbidden,
+	"ModelNotReadyException":        http.StatusTooManyRequests,
+	"ModelTimeoutException":         http.StatusRequestTimeout,
+	"ResourceNotFoundException":     http.StatusNotFound,
+	"ServiceQuotaExceededException": http.StatusBadRequest,
+	"ServiceUnavailableException":   http.StatusServiceUnavailable,
+	"ThrottlingException":           http.StatusTooManyRequests,
+	"ValidationException":           http.StatusBadRequest,
+}
+
+// stubServer answers InvokeModel and Converse requests with canned responses, in the wire
+// format of the model the request is for
+type stubServer struct {
+	responses map[string]stubResponse
+}
+
+// stubEvent is an event of a ConverseStream response
+type stubEvent struct {
+	event   string
+	payload any
+}
+
+// runStubServer serves canned Bedrock runtime responses for -endpoint, so prompts and parsing
+// can be worked on offline
+func runStubServer(args []string) {
+	fs := flag.NewFlagSet("stub-server", flag.ExitOnError)
+	addrFlag := fs.String("addr", "127.0.0.1:4010", "Address to listen on; point -endpoint at http://<addr>")
+	responsesFlag := fs.String("responses", "", `JSON file of canned responses by model, e.g. {"claude": {"text": "[]", "output_tokens": 3}, "*": {"error": "ThrottlingException"}}`)
+	textFlag := fs.String("text", stubDefaultText, "Text every model answers that -responses doesn't configure")
+	fs.Parse(args)
+
+	responses, err := loadStubResponses(*responsesFlag, *textFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	s := &stubServer{responses: responses}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /model/{modelID}/{operation}", s.handle)
+
+	log.Printf("Stub Bedrock runtime listening on %s; use -endpoint=http://%s", *addrFlag, *addrFlag)
+	if err := http.ListenAndServe(*addrFlag, mux); err != nil {
+		fatalf("Error: %v", err)
+	}
+}
+
+// loadStubResponses reads the canned responses of -responses, keyed by model name or
+// stubAnyModel; every other model answers text
+func loadStubResponses(path, text string) (map[string]stubResponse, error) {
+	responses := map[string]stubResponse{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stub responses: %v", err)
+		}
+		if err := json.Unmarshal(data, &responses); err != nil {
+			return nil, fmt.Errorf("invalid stub responses %s: %v", path, err)
+		}
+	}
+	if _, ok := responses[stubAnyModel]; !ok {
+		responses[stubAnyModel] = stubResponse{Text: text}
+	}
+	for name, response := range responses {
+		if _, ok := models.Lookup(name); !ok && name != stubAnyModel {
+			return nil, fmt.Errorf("stub responses %s: unknown model %q. Use %s", path, name, models.Usage())
+		}
+		if response.Error != "" {
+			if _, ok := stubErrorStatus[response.Error]; !ok {
+				return nil, fmt.Errorf("stub responses %s: unknown error %q for %s", path, response.Error, name)
+			}
+		}
+		if response.Delay != "" {
+			delay, err := time.ParseDuration(response.Delay)
+			if err != nil {
+				return nil, fmt.Errorf("stub responses %s: invalid delay for %s: %v", path, name, err)
+			}
+			response.delay = delay
+		}
+		responses[name] = response
+	}
+	return responses, nil
+}
+
+// stubModel returns the name of the model a model ID or inference profile ARN belongs to, which
+// decides the wire format of an InvokeModel response; empty for the models that aren't stubbed
+func stubModel(modelID string) string {
+	switch {
+	case strings.Contains(modelID, "anthropic.claude"):
+		return "claude"
+	case strings.Contains(modelID, "amazon.nova-"):
+		return "nova"
+	case strings.Contains(modelID, "meta.llama3-3-70b"):
+		return "llama70b"
+	case strings.Contains(modelID, "meta.llama"):
+		return "llama"
+	case strings.Contains(modelID, "deepseek.r1"):
+		return "deepseek"
+	}
+	return ""
+}
+
+func (s *stubServer) handle(w http.ResponseWriter, r *http.Request) {
+	modelID, operation := r.PathValue("modelID"), r.PathValue("operation")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		stubError(w, "ValidationException", fmt.Sprintf("failed to read request: %v", err))
+		return
+	}
+	name := stubModel(modelID)
+	if name == "" {
+		stubError(w, "ResourceNotFoundException", fmt.Sprintf("The stub doesn't serve model %s", modelID))
+		return
+	}
+	response, ok := s.responses[name]
+	if !ok {
+		response = s.responses[stubAnyModel]
+	}
+	log.Printf("%s %s (%s)", operation, name, modelID)
+
+	select {
+	case <-time.After(response.delay):
+	case <-r.Context().Done():
+		return
+	}
+	if response.Error != "" {
+		stubError(w, response.Error, "Stubbed "+response.Error)
+		return
+	}
+	if response.InputTokens == 0 {
+		response.InputTokens = max(len(body)/4, 1)
+	}
+	if response.OutputTokens == 0 {
+		response.OutputTokens = max(len(response.Text)/4, 1)
+	}
+
+	switch operation {
+	case "invoke":
+		s.invoke(w, name, body, response)
+	case "converse":
+		stubJSON(w, converseOutput(body, response))
+	case "converse-stream":
+		s.converseStream(w, response)
+	case "count-tokens":
+		stubJSON(w, map[string]int{"inputTokens": response.InputTokens})
+	default:
+		stubError(w, "ValidationException", fmt.Sprintf("The stub doesn't serve the %s operation", operation))
+	}
+}
+
+// invoke answers an InvokeModel request in the native response body of the model
+func (s *stubServer) invoke(w http.ResponseWriter, name string, body []byte, response stubResponse) {
+	stopReason := func(usual string) string {
+		if response.StopReason != "" {
+			return response.StopReason
+		}
+		return usual
+	}
+	// Bedrock reports the usage of every InvokeModel call in the response headers
+	w.Header().Set("X-Amzn-Bedrock-Input-Token-Count", strconv.Itoa(response.InputTokens))
+	w.Header().Set("X-Amzn-Bedrock-Output-Token-Count", strconv.Itoa(response.OutputTokens))
+	switch name {
+	case "claude":
+		var request struct {
+			ToolChoice *struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"tool_choice"`
+		}
+		json.Unmarshal(body, &request)
+		content := []map[string]any{{"type": "text", "text": response.Text}}
+		if request.ToolChoice != nil && request.ToolChoice.Type == "tool" && response.ToolInput != nil {
+			content = []map[string]any{{"type": "tool_use", "id": "toolu_stub", "name": request.ToolChoice.Name, "input": response.ToolInput}}
+		}
+		stubJSON(w, map[string]any{
+			"id":          "msg_stub",
+			"type":        "message",
+			"role":        "assistant",
+			"content":     content,
+			"stop_reason": stopReason("end_turn"),
+			"usage":       map[string]int{"input_tokens": response.InputTokens, "output_tokens": response.OutputTokens},
+		})
+	case "nova":
+		stubJSON(w, map[string]any{
+			"output":     map[string]any{"message": map[string]any{"role": "assistant", "content": novaContent(body, response)}},
+			"stopReason": stopReason("end_turn"),
+			"usage":      map[string]int{"inputTokens": response.InputTokens, "outputTokens": response.OutputTokens, "totalTokens": response.InputTokens + response.OutputTokens},
+		})
+	case "llama", "llama70b":
+		stubJSON(w, map[string]any{
+			"generation":             response.Text,
+			"prompt_token_count":     response.InputTokens,
+			"generation_token_count": response.OutputTokens,
+			"stop_reason":            stopReason("stop"),
+		})
+	case "deepseek":
+		stubJSON(w, map[string]any{
+			"choices": []map[string]string{{"text": response.Text, "stop_reason": stopReason("stop")}},
+		})
+	}
+}
+
+// novaContent returns the content of a Nova or Converse answer: a tool call when the request
+// forces one and the stub has a tool input for it, the text otherwise
+func novaContent(body []byte, response stubResponse) []map[string]any {
+	var request struct {
+		ToolConfig *struct {
+			ToolChoice *struct {
+				Tool *struct {
+					Name string `json:"name"`
+				} `json:"tool"`
+			} `json:"toolChoice"`
+		} `json:"toolConfig"`
+	}
+	json.Unmarshal(body, &request)
+	if config := request.ToolConfig; config != nil && config.ToolChoice != nil && config.ToolChoice.Tool != nil && response.ToolInput != nil {
+		return []map[string]any{{"toolUse": map[string]any{"toolUseId": "tooluse_stub", "name": config.ToolChoice.Tool.Name, "input": response.ToolInput}}}
+	}
+	return []map[string]any{{"text": response.Text}}
+}
+
+// converseOutput returns the body of a Converse response, which has the same shape for every model
+func converseOutput(body []byte, response stubResponse) map[string]any {
+	stopReason := response.StopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	return map[string]any{
+		"output":     map[string]any{"message": map[string]any{"role": "assistant", "content": novaContent(body, response)}},
+		"stopReason": stopReason,
+		"usage":      map[string]int{"inputTokens": response.InputTokens, "outputTokens": response.OutputTokens, "totalTokens": response.InputTokens + response.OutputTokens},
+		"metrics":    map[string]int64{"latencyMs": response.delay.Milliseconds()},
+	}
+}
+
+// converseStream answers a ConverseStream request with the text split into word deltas, sent
+// as they would arrive from the model
+func (s *stubServer) converseStream(w http.ResponseWriter, response stubResponse) {
+	stopReason := response.StopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	events := []stubEvent{{"messageStart", map[string]string{"role": "assistant"}}}
+	for _, word := range strings.SplitAfter(response.Text, " ") {
+		if word != "" {
+			events = append(events, stubEvent{"contentBlockDelta", map[string]any{"contentBlockIndex": 0, "delta": map[string]string{"text": word}}})
+		}
+	}
+	events = append(events, []stubEvent{
+		{"contentBlockStop", map[string]int{"contentBlockIndex": 0}},
+		{"messageStop", map[string]string{"stopReason": stopReason}},
+		{"metadata", map[string]any{
+			"usage":   map[string]int{"inputTokens": response.InputTokens, "outputTokens": response.OutputTokens, "totalTokens": response.InputTokens + response.OutputTokens},
+			"metrics": map[string]int64{"latencyMs": response.delay.Milliseconds()},
+		}},
+	}...)
+
+	w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+	encoder := eventstream.NewEncoder()
+	flusher, _ := w.(http.Flusher)
+	for _, e := range events {
+		payload, _ := json.Marshal(e.payload)
+		var headers eventstream.Headers
+		headers.Set(":message-type", eventstream.StringValue("event"))
+		headers.Set(":event-type", eventstream.StringValue(e.event))
+		headers.Set(":content-type", eventstream.StringValue("application/json"))
+		if err := encoder.Encode(w, eventstream.Message{Headers: headers, Payload: payload}); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// stubJSON writes a successful JSON response
+func stubJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// stubError fails a request the way Bedrock does, with the error code in x-amzn-ErrorType
+func stubError(w http.ResponseWriter, code, message string) {
+	status, ok := stubErrorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-ErrorType", code)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
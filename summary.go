@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// progressInterval is the minimum time between two batch progress lines
+const progressInterval = 2 * time.Second
+
+// batchSummary aggregates batch outputs for the progress lines and the end-of-run report
+type batchSummary struct {
+	total        int
+	done         int
+	failed       int
+	resumed      int
+	interrupted  int
+	inputTokens  int
+	outputTokens int
+	// costs and items are the estimated cost and the number of items of each model
+	costs        map[string]float64
+	items        map[string]int
+	latencies    map[string][]int64
+	start        time.Time
+	lastProgress time.Time
+	// accounts totals the items of each -accounts account
+	accounts map[string]*accountTotals
+}
+
+// accountTotals is the share of one -accounts account in a batch
+type accountTotals struct {
+	items        int
+	failed       int
+	inputTokens  int
+	outputTokens int
+	cost         float64
+}
+
+// newBatchSummary creates a summary for a batch of total items, or of an unknown number when total is 0
+func newBatchSummary(total int) *batchSummary {
+	return &batchSummary{total: total, latencies: map[string][]int64{}, costs: map[string]float64{}, items: map[string]int{}, accounts: map[string]*accountTotals{}, start: time.Now()}
+}
+
+// add records one finished item and logs progress at most every progressInterval
+func (s *batchSummary) add(out batchOutput) {
+	s.done++
+	if out.Error != "" {
+		s.failed++
+	}
+	if out.resumed {
+		s.resumed++
+	}
+	s.inputTokens += out.InputTokens
+	s.outputTokens += out.OutputTokens
+	if out.Error == "" {
+		s.costs[out.Model] += out.CostUSD
+		s.items[out.Model]++
+	}
+	if out.Account != "" {
+		totals := s.accounts[out.Account]
+		if totals == nil {
+			totals = &accountTotals{}
+			s.accounts[out.Account] = totals
+		}
+		totals.items++
+		if out.Error != "" {
+			totals.failed++
+		}
+		totals.inputTokens += out.InputTokens
+		totals.outputTokens += out.OutputTokens
+		totals.cost += out.CostUSD
+	}
+	// Resumed, deduplicated and cached items didn't invoke the model, so they have no latency
+	if !out.resumed && out.DuplicateOf == "" && !out.Cached && out.Error == "" {
+		s.latencies[out.Model] = append(s.latencies[out.Model], out.LatencyMs)
+	}
+
+	if (s.total > 0 && s.done == s.total) || time.Since(s.lastProgress) >= progressInterval {
+		s.lastProgress = time.Now()
+		s.logProgress()
+	}
+}
+
+// logProgress logs how far the batch has come and, when the total is known, the estimated time remaining
+func (s *batchSummary) logProgress() {
+	elapsed := time.Since(s.start)
+	if s.total == 0 {
+		log.Printf("Progress: %d done, %d failed, elapsed %s", s.done, s.failed, elapsed.Round(time.Second))
+		return
+	}
+	eta := "unknown"
+	if s.done > 0 {
+		remaining := time.Duration(float64(elapsed) / float64(s.done) * float64(s.total-s.done))
+		eta = remaining.Round(time.Second).String()
+	}
+	log.Printf("Progress: %d/%d (%.0f%%), %d failed, elapsed %s, ETA %s",
+		s.done, s.total, 100*float64(s.done)/float64(s.total), s.failed, elapsed.Round(time.Second), eta)
+}
+
+// print writes the end-of-run report to stderr
+func (s *batchSummary) print() {
+	fmt.Fprintf(os.Stderr, "\nBatch summary\n")
+	fmt.Fprintf(os.Stderr, "  Items:      %d processed, %d succeeded, %d failed", s.done, s.done-s.failed, s.failed)
+	if s.resumed > 0 {
+		fmt.Fprintf(os.Stderr, " (%d from checkpoint)", s.resumed)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+	if s.interrupted > 0 {
+		fmt.Fprintf(os.Stderr, "  Cancelled:  %d inputs, left for a resumed run\n", s.interrupted)
+	}
+	fmt.Fprintf(os.Stderr, "  Tokens:     %d input, %d output\n", s.inputTokens, s.outputTokens)
+	s.printCost()
+	fmt.Fprintf(os.Stderr, "  Duration:   %s\n", time.Since(s.start).Round(time.Millisecond))
+	if len(s.accounts) > 0 {
+		fmt.Fprintln(os.Stderr)
+		s.printAccounts()
+	}
+	if len(s.latencies) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr)
+	printLatencies(os.Stderr, "  ", s.latencies, nil)
+}
+
+// printAccounts writes a table of the items, tokens and estimated cost of each -accounts account
+func (s *batchSummary) printAccounts() {
+	names := make([]string, 0, len(s.accounts))
+	for name := range s.accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  ACCOUNT\tITEMS\tFAILED\tINPUT_TOKENS\tOUTPUT_TOKENS\tESTIMATED_COST_USD")
+	for _, name := range names {
+		t := s.accounts[name]
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%d\t%d\t%.4f\n", name, t.items, t.failed, t.inputTokens, t.outputTokens, t.cost)
+	}
+	w.Flush()
+}
+
+// printCost writes the estimated cost of the run and, per model, the cost per 1,000 successful
+// items, so models can be compared on the same inputs. Cached items and duplicates count at no cost.
+func (s *batchSummary) printCost() {
+	total := 0.0
+	names := make([]string, 0, len(s.costs))
+	for name, cost := range s.costs {
+		total += cost
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "  Cost:       $%.4f estimated", total)
+	for i, name := range names {
+		separator := ", "
+		if i == 0 {
+			separator = " ("
+		}
+		fmt.Fprintf(os.Stderr, "%s%s $%.4f per 1k items", separator, name, 1000*s.costs[name]/float64(s.items[name]))
+	}
+	if len(names) > 0 {
+		fmt.Fprint(os.Stderr, ")")
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// printLatencies writes a table of the min/p50/p95/max latency in milliseconds of each model,
+// with the failed calls of each model when failures is not nil
+func printLatencies(out io.Writer, indent string, latencies map[string][]int64, failures map[string]int) {
+	names := make([]string, 0, len(latencies))
+	for name := range latencies {
+		names = append(names, name)
+	}
+	for name := range failures {
+		if _, ok := latencies[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := indent + "MODEL\tCALLS\tMIN\tP50\tP95\tMAX"
+	if failures != nil {
+		header += "\tFAILED"
+	}
+	fmt.Fprintln(w, header)
+	for _, name := range names {
+		values := latencies[name]
+		row := fmt.Sprintf("%s%s\t%d\t-\t-\t-\t-", indent, name, len(values))
+		if len(values) > 0 {
+			p50, p95 := percentile(values, 50), percentile(values, 95)
+			row = fmt.Sprintf("%s%s\t%d\t%dms\t%dms\t%dms\t%dms", indent, name, len(values), values[0], p50, p95, values[len(values)-1])
+		}
+		if failures != nil {
+			row += fmt.Sprintf("\t%d", failures[name])
+		}
+		fmt.Fprintln(w, row)
+	}
+	w.Flush()
+}
+
+// percentile returns the nearest-rank percentile of the values, sorting them in place
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	return values[max(rank, 1)-1]
+}
@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"bedrock-llama/bedrock"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Example is a few-shot input/output pair shown to the model before the real input
+type Example struct {
+	Input string `json:"input"`
+	// Output is the expected answer, either a JSON value or plain text
+	Output json.RawMessage `json:"output"`
+}
+
+// LoadExamples reads few-shot examples from a JSONL file with one
+// {"input": "...", "output": ...} object per line
+func LoadExamples(path string) ([]Example, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open examples file: %v", err)
+	}
+	defer file.Close()
+
+	var examples []Example
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var example Example
+		if err := json.Unmarshal([]byte(text), &example); err != nil {
+			return nil, fmt.Errorf("examples file line %d: %v", line, err)
+		}
+		if example.Input == "" || len(example.Output) == 0 {
+			return nil, fmt.Errorf("examples file line %d: both input and output are required", line)
+		}
+		examples = append(examples, example)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read examples file: %v", err)
+	}
+	return examples, nil
+}
+
+// answer returns the example output as the text the model is expected to reply with
+func (e Example) answer() string {
+	var text string
+	if err := json.Unmarshal(e.Output, &text); err == nil {
+		return text
+	}
+	return string(e.Output)
+}
+
+// Conversation builds the turns sent to the model: each example as a prompt and its
+// expected answer, followed by the prompt for the real input
+func (t *Task) Conversation(input string, examples []Example) ([]bedrock.Turn, error) {
+	var turns []bedrock.Turn
+	for _, example := range examples {
+		prompt, err := t.Prompt(example.Input)
+		if err != nil {
+			return nil, err
+		}
+		turns = append(turns,
+			bedrock.Turn{Role: bedrock.RoleUser, Text: prompt},
+			bedrock.Turn{Role: bedrock.RoleAssistant, Text: example.answer()},
+		)
+	}
+
+	prompt, err := t.Prompt(input)
+	if err != nil {
+		return nil, err
+	}
+	return append(turns, bedrock.Turn{Role: bedrock.RoleUser, Text: prompt}), nil
+}
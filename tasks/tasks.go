@@ -0,0 +1,244 @@
+package tasks
+
+import (
+	"bedrock-llama/schema"
+	"bedrock-llama/validate"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Task is a named prompt template together with the schema and validators of its output
+type Task struct {
+	// Name is the task name used with the -task flag
+	Name string
+	// Description is a short human-readable summary of the task
+	Description string
+	// Template is the prompt template; %s is replaced by the input. For tasks loaded with
+	// WithTemplateFile it holds the text/template source instead.
+	Template string
+	// Schema describes the fields the model must return
+	Schema *schema.Schema
+	// DefaultInput is used when no input is provided
+	DefaultInput string
+	// Rules are task-specific checks run on records that already match the schema
+	Rules []validate.Rule
+	// Vars holds the parameters passed to a text/template prompt file
+	Vars map[string]string
+	// Ref identifies the registry prompt version the template came from (e.g. "series@v2")
+	Ref string
+	// MaxTokens caps the model's answer for the task, whatever the model; the model's own
+	// default when zero. A model that always reasons, like DeepSeek-R1, gets a reasoning budget
+	// on top, as Claude does with a thinking budget.
+	MaxTokens int
+
+	textTemplate *template.Template
+}
+
+// Prompt formats the task prompt for the given input
+func (t *Task) Prompt(input string) (string, error) {
+	if t.textTemplate != nil {
+		return t.render(input)
+	}
+	return fmt.Sprintf(t.Template, input), nil
+}
+
+// PromptRef returns the registry reference of the task's prompt, or the task name for built-in templates
+func (t *Task) PromptRef() string {
+	if t.Ref != "" {
+		return t.Ref
+	}
+	return t.Name
+}
+
+// Check validates the model output against the task schema and rules. A failing Retry rule
+// is returned as an error; failing Flag rules are returned as quality issues.
+func (t *Task) Check(text string) ([]schema.Record, []string, error) {
+	records, err := t.Schema.Validate(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues, err := validate.Run(t.Rules, records)
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, issues, nil
+}
+
+const (
+	// The template for the series name extraction prompt
+	seriesTemplate = `You are a series name extraction tool that ONLY outputs valid JSON.
+
+INPUT: "%s"
+
+INSTRUCTIONS:
+1. Extract ONLY the series name (text that appears before "Season" or "Episode")
+2. Return ONLY a valid JSON array with format: [{"series": "extracted name"}]
+3. DO NOT include any explanation, additional examples, or commentary
+4. The response must contain NOTHING except the JSON array
+
+For example, from "Friends Season 1", extract just "Friends" and output [{"series": "Friends"}]`
+
+	// The template for the movie title and year extraction prompt
+	movieTemplate = `You are a movie title extraction tool that ONLY outputs valid JSON.
+
+INPUT: "%s"
+
+INSTRUCTIONS:
+1. Extract the movie title and its release year from the filename
+2. Remove resolution, codec, release group, and other technical tags from the title
+3. Return ONLY a valid JSON array with format: [{"title": "movie title", "year": 1999}]
+4. DO NOT include any explanation, additional examples, or commentary
+5. The response must contain NOTHING except the JSON array
+
+For example, from "The.Matrix.1999.1080p.BluRay.x264", output [{"title": "The Matrix", "year": 1999}]`
+
+	// The template for the music track tagging prompt
+	musicTemplate = `You are a music tagging tool that ONLY outputs valid JSON.
+
+INPUT: "%s"
+
+INSTRUCTIONS:
+1. Extract the artist and the track title from the filename
+2. Remove track numbers, file extensions, and quality tags
+3. Return ONLY a valid JSON array with format: [{"artist": "artist name", "title": "track title"}]
+4. DO NOT include any explanation, additional examples, or commentary
+5. The response must contain NOTHING except the JSON array
+
+For example, from "03 - Queen - Bohemian Rhapsody (Remastered).mp3", output [{"artist": "Queen", "title": "Bohemian Rhapsody"}]`
+
+	// The template for the subtitle language detection prompt
+	subtitleTemplate = `You are a subtitle language detection tool that ONLY outputs valid JSON.
+
+INPUT: "%s"
+
+INSTRUCTIONS:
+1. Identify the language of the subtitle file from its filename
+2. Return the language as a lowercase ISO 639-1 two-letter code
+3. Return ONLY a valid JSON array with format: [{"language": "en"}]
+4. DO NOT include any explanation, additional examples, or commentary
+5. The response must contain NOTHING except the JSON array
+
+For example, from "Amelie.2001.French.srt", output [{"language": "fr"}]`
+
+	// The template used when a custom extraction schema is supplied with -schema
+	customTemplate = `You are a metadata extraction tool that ONLY outputs valid JSON.
+
+INPUT: "%%s"
+
+INSTRUCTIONS:
+1. Extract ONLY the following fields from the input:
+%s
+2. Return ONLY a valid JSON array with format: %s
+3. DO NOT include any explanation, additional examples, or commentary
+4. The response must contain NOTHING except the JSON array`
+)
+
+var (
+	// languageCode matches a lowercase ISO 639-1 language code
+	languageCode = regexp.MustCompile(`^[a-z]{2}$`)
+	// episodeMarker matches season and episode markers left in a series name
+	episodeMarker = regexp.MustCompile(`(?i)\b(season|episode|s\d{1,2}e\d{1,3}|\d{1,2}x\d{2})\b`)
+	// technicalTag matches resolution, codec and source tags left in a title
+	technicalTag = regexp.MustCompile(`(?i)\b(2160p|1080p|720p|480p|x264|x265|h\.?264|hevc|bluray|web-?dl|webrip|hdtv)\b`)
+	// audioExtension matches an audio file extension at the end of a title
+	audioExtension = regexp.MustCompile(`(?i)\.(mp3|flac|m4a|ogg|wav)$`)
+)
+
+// customMaxTokens caps the output of custom schemas, which can have any number of fields
+const customMaxTokens = 512
+
+// library lists the built-in tasks, default first
+var library = []*Task{
+	{
+		Name:         "series",
+		Description:  "Extract the series name from a TV episode filename",
+		Template:     seriesTemplate,
+		Schema:       schema.Series,
+		DefaultInput: "Friends Season 001 Episode 001",
+		MaxTokens:    64,
+		Rules: []validate.Rule{
+			{Validator: validate.NoMatch("series", episodeMarker, "season or episode text"), Action: validate.Retry},
+			{Validator: validate.MaxLength("series", 200), Action: validate.Flag},
+		},
+	},
+	{
+		Name:        "movie",
+		Description: "Extract the title and release year from a movie filename",
+		Template:    movieTemplate,
+		Schema: &schema.Schema{Fields: []schema.Field{
+			{Name: "title", Type: schema.TypeString, Description: "The movie title without technical tags"},
+			{Name: "year", Type: schema.TypeInteger, Description: "The release year"},
+		}},
+		DefaultInput: "The.Matrix.1999.1080p.BluRay.x264",
+		MaxTokens:    96,
+		Rules: []validate.Rule{
+			{Validator: validate.IntRange("year", 1870, 2100), Action: validate.Retry},
+			{Validator: validate.NoMatch("title", technicalTag, "technical tags"), Action: validate.Flag},
+		},
+	},
+	{
+		Name:        "music",
+		Description: "Tag the artist and track title of a music filename",
+		Template:    musicTemplate,
+		Schema: &schema.Schema{Fields: []schema.Field{
+			{Name: "artist", Type: schema.TypeString, Description: "The performing artist"},
+			{Name: "title", Type: schema.TypeString, Description: "The track title"},
+		}},
+		DefaultInput: "03 - Queen - Bohemian Rhapsody (Remastered).mp3",
+		MaxTokens:    96,
+		Rules: []validate.Rule{
+			{Validator: validate.NoMatch("title", audioExtension, "the file extension"), Action: validate.Retry},
+		},
+	},
+	{
+		Name:        "subtitle-language",
+		Description: "Detect the language of a subtitle file from its filename",
+		Template:    subtitleTemplate,
+		Schema: &schema.Schema{Fields: []schema.Field{
+			{Name: "language", Type: schema.TypeString, Description: "Lowercase ISO 639-1 language code"},
+		}},
+		DefaultInput: "Amelie.2001.French.srt",
+		MaxTokens:    32,
+		Rules: []validate.Rule{
+			{Validator: validate.Matches("language", languageCode, "a lowercase ISO 639-1 code"), Action: validate.Retry},
+		},
+	},
+}
+
+// Lookup returns the built-in task with the given name
+func Lookup(name string) (*Task, bool) {
+	for _, task := range library {
+		if task.Name == name {
+			return task, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the names of all built-in tasks
+func Names() []string {
+	names := make([]string, len(library))
+	for i, task := range library {
+		names[i] = task.Name
+	}
+	return names
+}
+
+// Custom returns a task that extracts the fields of a user-supplied schema
+func Custom(outputSchema *schema.Schema) *Task {
+	return &Task{
+		Name:         "custom",
+		Description:  "Extract user-defined fields",
+		Template:     fmt.Sprintf(customTemplate, escapeVerbs(outputSchema.Describe()), escapeVerbs(outputSchema.Example())),
+		Schema:       outputSchema,
+		DefaultInput: library[0].DefaultInput,
+		MaxTokens:    customMaxTokens,
+	}
+}
+
+// escapeVerbs escapes percent signs so text can be embedded in a template
+func escapeVerbs(text string) string {
+	return strings.ReplaceAll(text, "%", "%%")
+}
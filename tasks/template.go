@@ -0,0 +1,56 @@
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data available to prompt template files
+type TemplateData struct {
+	// Input is the text to extract from
+	Input string
+	// Vars holds the -var key=value parameters
+	Vars map[string]string
+	// Fields lists the schema fields, one per line
+	Fields string
+	// Example is the expected output format
+	Example string
+}
+
+// WithTemplateFile returns a copy of the task whose prompt is rendered from a Go text/template
+// file. The task keeps its schema and validator, so only the wording of the prompt changes.
+func (t *Task) WithTemplateFile(path string, vars map[string]string) (*Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt file: %v", err)
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt file: %v", err)
+	}
+
+	task := *t
+	task.Template = string(data)
+	task.textTemplate = tmpl
+	task.Vars = vars
+	return &task, nil
+}
+
+// render executes the task's text/template with the given input
+func (t *Task) render(input string) (string, error) {
+	var prompt strings.Builder
+	err := t.textTemplate.Execute(&prompt, TemplateData{
+		Input:   input,
+		Vars:    t.Vars,
+		Fields:  t.Schema.Describe(),
+		Example: t.Schema.Example(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render prompt template: %v", err)
+	}
+	return prompt.String(), nil
+}
@@ -0,0 +1,44 @@
+package tasks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"runtime/debug"
+)
+
+// Version returns a short content hash of the task's prompt template and schema, so every
+// output can be traced back to the exact prompt that produced it
+func (t *Task) Version() string {
+	hash := sha256.New()
+	hash.Write([]byte(t.Template))
+	hash.Write(t.Schema.JSONSchema())
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// BuildRevision returns the git commit the binary was built from, with a "-dirty" suffix
+// when the working tree had uncommitted changes. It is empty when the build didn't record
+// VCS information (e.g. "go run").
+func BuildRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,103 @@
+package titan
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// Amazon Titan Text Embeddings V2
+const (
+	// Name is the short model name used on the command line
+	Name = "titan-embed"
+	// ModelID is the Bedrock model ID; Titan Text Embeddings V2 runs in most Bedrock regions
+	ModelID = "amazon.titan-embed-text-v2:0"
+)
+
+// Dimensions are the vector lengths Titan Text Embeddings V2 produces, 1024 by default
+var Dimensions = []int{256, 512, 1024}
+
+// Payload represents the request payload for Titan Text Embeddings V2
+type Payload struct {
+	InputText  string `json:"inputText"`
+	Dimensions int    `json:"dimensions,omitempty"`
+	Normalize  bool   `json:"normalize"`
+}
+
+// Response represents the response from Titan Text Embeddings V2
+type Response struct {
+	Embedding           []float64 `json:"embedding"`
+	InputTextTokenCount int       `json:"inputTextTokenCount"`
+}
+
+// InvokeModel embeds one text with Titan Text Embeddings V2
+func InvokeModel(ctx context.Context, client bedrock.Invoker, payload Payload, opts bedrock.Options) (*Response, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %v", err)
+	}
+	logging.Debugf("Payload: %s", string(payloadBytes))
+
+	output, err := bedrock.Invoke(ctx, client, &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(ModelID),
+		ContentType: aws.String("application/json"),
+		Accept:      aws.String("application/json"),
+		Body:        payloadBytes,
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error invoking Bedrock embedding model %s: %w", ModelID, err)
+	}
+
+	var response Response
+	if err := json.Unmarshal(output.Body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	if len(response.Embedding) == 0 {
+		return nil, fmt.Errorf("the model returned no embedding")
+	}
+	return &response, nil
+}
+
+type model struct {
+	client bedrock.Invoker
+	opts   bedrock.Options
+}
+
+// New returns a bedrock.EmbeddingModel that embeds text with Titan Text Embeddings V2
+func New(client bedrock.Invoker, opts bedrock.Options) bedrock.EmbeddingModel {
+	return &model{client: client, opts: opts}
+}
+
+func (m *model) Name() string {
+	return Name
+}
+
+// Embed makes one request per text, as Titan Text Embeddings takes a single text per request
+func (m *model) Embed(ctx context.Context, req bedrock.EmbeddingRequest) (*bedrock.EmbeddingResult, error) {
+	if req.Dimensions != 0 && !slices.Contains(Dimensions, req.Dimensions) {
+		return nil, fmt.Errorf("the %s model makes vectors of %v dimensions", Name, Dimensions)
+	}
+	if req.InputType != "" {
+		return nil, fmt.Errorf("the %s model doesn't take an input type", Name)
+	}
+	result := &bedrock.EmbeddingResult{Model: Name}
+	for _, text := range req.Texts {
+		response, err := InvokeModel(ctx, m.client, Payload{
+			InputText:  text,
+			Dimensions: req.Dimensions,
+			Normalize:  req.Normalize,
+		}, m.opts)
+		if err != nil {
+			return nil, err
+		}
+		result.Embeddings = append(result.Embeddings, response.Embedding)
+		result.InputTokens += response.InputTextTokenCount
+	}
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// contextWarnRatio is the share of the context window above which a prompt is warned about
+const contextWarnRatio = 0.8
+
+// countTokens counts the input tokens of a conversation with Bedrock's CountTokens API when the
+// model supports it, and estimates them otherwise. exact reports which one it did.
+func (e *extractor) countTokens(ctx context.Context, turns []bedrock.Turn) (count int, exact bool) {
+	if e.modelInfo.SupportsCountTokens {
+		count, err := bedrock.CountTokens(ctx, e.client, e.modelInfo.ModelID, turns)
+		if err == nil {
+			return count, true
+		}
+		warnf("%v; estimating instead", err)
+	}
+	return bedrock.EstimateTokens(turns), false
+}
+
+// checkContextWindow warns when a prompt approaches the model's context window, leaving little
+// room for the response. The prompt is only counted exactly when the estimate comes close.
+func (e *extractor) checkContextWindow(ctx context.Context, turns []bedrock.Turn) {
+	window := e.modelInfo.ContextWindow
+	if window == 0 || float64(bedrock.EstimateTokens(turns)) < contextWarnRatio*float64(window) {
+		return
+	}
+	count, exact := e.countTokens(ctx, turns)
+	if float64(count) < contextWarnRatio*float64(window) {
+		return
+	}
+	kind := "an estimated"
+	if exact {
+		kind = "a counted"
+	}
+	warnf("the prompt is %s %d tokens, %.0f%% of the %s context window of %d tokens", kind, count, 100*float64(count)/float64(window), e.modelInfo.Name, window)
+}
+
+// runTokens implements the tokens subcommands
+func runTokens(args []string) {
+	if len(args) == 0 || args[0] != "count" {
+		fatalf("Usage: %s tokens count [flags]", os.Args[0])
+	}
+
+	fs := flag.NewFlagSet("tokens count", flag.ExitOnError)
+	f := registerFlags(fs)
+	inputFlag := fs.String("input", "", "The input to count the prompt of, e.g. a media filename")
+	inputFileFlag := fs.String("input-file", "", "File holding the input, or - for stdin, instead of -input")
+	rawFlag := fs.Bool("raw", false, "Count the input as the whole prompt, without the task's template and examples")
+	fs.Parse(args[1:])
+	f.applyLogLevel()
+
+	input := *inputFlag
+	if *inputFileFlag != "" {
+		var data []byte
+		var err error
+		if *inputFileFlag == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*inputFileFlag)
+		}
+		if err != nil {
+			fatalf("Error reading input: %v", err)
+		}
+		input = string(data)
+	}
+
+	ctx := context.Background()
+	runWithExtractor(ctx, f, func(e *extractor) error {
+		turns := bedrock.UserTurn(input)
+		turns[0].Images = e.images
+		turns[0].Documents = e.documents
+		turns[0].Videos = e.videos
+		if !*rawFlag {
+			if input == "" {
+				input = e.task.DefaultInput
+			}
+			var err error
+			if turns, err = e.conversation(input); err != nil {
+				return err
+			}
+		}
+
+		count, exact := e.countTokens(ctx, turns)
+		method := "estimated at 4 characters per token"
+		if exact {
+			method = "counted by Bedrock"
+		}
+		log.Printf("%s prompt: %d input tokens (%s), %.1f%% of the %d token context window",
+			e.modelInfo.Name, count, method, 100*float64(count)/float64(e.modelInfo.ContextWindow), e.modelInfo.ContextWindow)
+		fmt.Println(count)
+		return nil
+	})
+}
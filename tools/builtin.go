@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"bedrock-llama/bedrock"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Names of the built-in tools
+const (
+	CatalogSearchName = "catalog_search"
+	CurrentDateName   = "current_date"
+)
+
+// defaultCatalogMatches is the number of titles catalog_search returns when the model doesn't ask for a number
+const defaultCatalogMatches = 5
+
+// catalogQuery is the input of catalog_search
+type catalogQuery struct {
+	Query string `json:"query" description:"Words of the title to look up"`
+	Limit int    `json:"limit,omitempty" description:"Maximum number of titles to return, 5 by default"`
+}
+
+// ReadCatalog reads a catalog of titles, one per line, skipping blank lines
+func ReadCatalog(r io.Reader) ([]string, error) {
+	var titles []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if title := strings.TrimSpace(scanner.Text()); title != "" {
+			titles = append(titles, title)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %v", err)
+	}
+	return titles, nil
+}
+
+// CatalogSearch returns a tool that looks titles up in a catalog, such as a media library's
+// shows, so the model can answer with the title as the catalog spells it
+func CatalogSearch(titles []string) bedrock.Tool {
+	type match struct {
+		Title string  `json:"title"`
+		Score float64 `json:"score"`
+	}
+	return MustFunc(CatalogSearchName,
+		"Search the user's catalog of titles. Returns the closest matching titles, best first, each with a score from 0 to 1. Search with the words of a title, not with a whole file name.",
+		func(ctx context.Context, input catalogQuery) (string, error) {
+			if strings.TrimSpace(input.Query) == "" {
+				return "", fmt.Errorf("the query is empty")
+			}
+			limit := input.Limit
+			if limit <= 0 {
+				limit = defaultCatalogMatches
+			}
+			matches := []match{}
+			query := words(input.Query)
+			for _, title := range titles {
+				if score := overlap(query, words(title)); score > 0 {
+					matches = append(matches, match{title, score})
+				}
+			}
+			sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+			data, err := json.Marshal(matches[:min(len(matches), limit)])
+			return string(data), err
+		})
+}
+
+// CurrentDate returns a tool that tells the model today's date, e.g. to tell aired episodes from upcoming ones
+func CurrentDate() bedrock.Tool {
+	return MustFunc(CurrentDateName, "Get today's date in YYYY-MM-DD format.", func(ctx context.Context, input struct{}) (string, error) {
+		return time.Now().Format(time.DateOnly), nil
+	})
+}
+
+// words splits text into its lowercase words, treating dots, dashes and underscores as spaces
+func words(text string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		set[word] = true
+	}
+	return set
+}
+
+// overlap scores how well query words match a title's: the Dice coefficient of the word sets, to three decimals
+func overlap(query, title map[string]bool) float64 {
+	if len(query) == 0 || len(title) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range query {
+		if title[word] {
+			shared++
+		}
+	}
+	return math.Round(2000*float64(shared)/float64(len(query)+len(title))) / 1000
+}
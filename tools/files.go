@@ -0,0 +1,272 @@
+package tools
+
+import (
+	"bedrock-llama/bedrock"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the filesystem tools
+const (
+	ListDirectoryName = "list_directory"
+	ReadFilenameName  = "read_filename"
+	RenameFileName    = "rename_file"
+)
+
+// maxListedEntries is the number of entries list_directory returns at most, so that a large
+// library doesn't fill the model's context
+const maxListedEntries = 500
+
+// Rename is a rename the model asked rename_file for, with paths relative to the folder
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Applied is set when the file was renamed, rather than only proposed in a dry run
+	Applied bool `json:"applied"`
+}
+
+// Folder gives the filesystem tools access to the files under a directory. The paths the model
+// sends are relative to it, and can't lead out of it. In a dry run, rename_file only records
+// the renames it is asked for, for the user to review before applying them.
+type Folder struct {
+	root   string
+	dryRun bool
+
+	mu      sync.Mutex
+	renames []Rename
+	// taken and moved are the targets and the sources of the renames proposed in a dry run,
+	// which haven't changed the directory
+	taken map[string]bool
+	moved map[string]bool
+}
+
+// pathInput is the input of read_filename
+type pathInput struct {
+	Path string `json:"path" description:"Path of the file, relative to the folder"`
+}
+
+// listInput is the input of list_directory
+type listInput struct {
+	Path      string `json:"path,omitempty" description:"Directory to list, relative to the folder; the folder itself when left out"`
+	Recursive bool   `json:"recursive,omitempty" description:"Also list the contents of the subdirectories"`
+}
+
+// renameInput is the input of rename_file
+type renameInput struct {
+	From string `json:"from" description:"Path of the file to rename, relative to the folder"`
+	To   string `json:"to" description:"New path of the file, relative to the folder; its directory must exist"`
+}
+
+// NewFolder returns the filesystem tools' access to the directory root
+func NewFolder(root string, dryRun bool) (*Folder, error) {
+	abs, err := filepath.Abs(root)
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid folder %s: %v", root, err)
+	}
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &Folder{root: abs, dryRun: dryRun, taken: map[string]bool{}, moved: map[string]bool{}}, nil
+}
+
+// Tools returns list_directory, read_filename and rename_file
+func (f *Folder) Tools() []bedrock.Tool {
+	renameDescription := "Rename or move a file within the folder. Fails if the new path already exists."
+	if f.dryRun {
+		renameDescription += " This is a dry run: the rename is recorded as a proposal for the user to review, and the file keeps its name."
+	}
+	return []bedrock.Tool{
+		MustFunc(ListDirectoryName, "List the files and subdirectories of a directory in the user's folder, with their sizes in bytes. Hidden files are left out.", f.list),
+		MustFunc(ReadFilenameName, "Get the details of a file in the user's folder from its path: its name without and with the extension, its directory, size and modification time. The file's contents aren't read.", f.read),
+		MustFunc(RenameFileName, renameDescription, f.rename),
+	}
+}
+
+// Renames returns the renames the model asked for, in order
+func (f *Folder) Renames() []Rename {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Rename(nil), f.renames...)
+}
+
+func (f *Folder) list(ctx context.Context, input listInput) (string, error) {
+	type entry struct {
+		Path string `json:"path"`
+		Dir  bool   `json:"dir,omitempty"`
+		Size int64  `json:"size,omitempty"`
+	}
+	dir, err := f.resolve(input.Path)
+	if err != nil {
+		return "", err
+	}
+	listing := struct {
+		Entries   []entry `json:"entries"`
+		Truncated bool    `json:"truncated,omitempty"`
+	}{Entries: []entry{}}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(listing.Entries) == maxListedEntries {
+			listing.Truncated = true
+			return filepath.SkipAll
+		}
+		e := entry{Path: f.relative(path), Dir: d.IsDir()}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			e.Size = info.Size()
+		}
+		listing.Entries = append(listing.Entries, e)
+		if d.IsDir() && !input.Recursive {
+			return filepath.SkipDir
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s: %v", f.relative(dir), unwrapPath(err))
+	}
+	data, err := json.Marshal(listing)
+	return string(data), err
+}
+
+func (f *Folder) read(ctx context.Context, input pathInput) (string, error) {
+	path, err := f.resolve(input.Path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", input.Path, unwrapPath(err))
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory; list it with %s", input.Path, ListDirectoryName)
+	}
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	data, err := json.Marshal(struct {
+		Path      string `json:"path"`
+		Name      string `json:"name"`
+		Stem      string `json:"stem"`
+		Extension string `json:"extension"`
+		Directory string `json:"directory"`
+		Size      int64  `json:"size"`
+		Modified  string `json:"modified"`
+	}{f.relative(path), name, strings.TrimSuffix(name, ext), ext, f.relative(filepath.Dir(path)), info.Size(), info.ModTime().UTC().Format(time.RFC3339)})
+	return string(data), err
+}
+
+func (f *Folder) rename(ctx context.Context, input renameInput) (string, error) {
+	from, err := f.resolve(input.From)
+	if err != nil {
+		return "", err
+	}
+	to, err := f.resolve(input.To)
+	if err != nil {
+		return "", err
+	}
+	if from == f.root || to == f.root {
+		return "", errors.New("the folder itself can't be renamed")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if info, err := os.Stat(from); err != nil || f.moved[from] {
+		return "", fmt.Errorf("%s doesn't exist", input.From)
+	} else if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory; only files can be renamed", input.From)
+	}
+	if _, err := os.Lstat(to); err == nil || f.taken[to] {
+		return "", fmt.Errorf("%s already exists; pick another name", input.To)
+	}
+	if info, err := os.Stat(filepath.Dir(to)); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("the directory of %s doesn't exist", input.To)
+	}
+
+	rename := Rename{From: f.relative(from), To: f.relative(to)}
+	if f.dryRun {
+		f.taken[to], f.moved[from] = true, true
+		f.renames = append(f.renames, rename)
+		return fmt.Sprintf("Proposed renaming %s to %s. This is a dry run: the file keeps its name until the user applies the proposal.", rename.From, rename.To), nil
+	}
+	if err := os.Rename(from, to); err != nil {
+		return "", fmt.Errorf("failed to rename %s: %v", input.From, unwrapPath(err))
+	}
+	rename.Applied = true
+	f.renames = append(f.renames, rename)
+	return fmt.Sprintf("Renamed %s to %s", rename.From, rename.To), nil
+}
+
+// resolve returns the absolute path of a path the model sent, checking that it stays in the
+// folder once symbolic links are followed
+func (f *Folder) resolve(path string) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	sent := path
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(f.root, path); err == nil {
+			path = rel
+		}
+	}
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("%s is outside the folder; use a path relative to it", sent)
+	}
+	abs := filepath.Join(f.root, path)
+	// A path that doesn't exist yet, like a rename target, is checked through its directory
+	real, err := filepath.EvalSymlinks(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+			real = filepath.Join(dir, filepath.Base(abs))
+		} else {
+			return abs, nil
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("invalid path %s: %v", sent, unwrapPath(err))
+	}
+	if rel, err := filepath.Rel(f.root, real); err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+		return "", fmt.Errorf("%s links outside the folder", sent)
+	}
+	return real, nil
+}
+
+// relative returns a path in the folder relative to it, with forward slashes
+func (f *Folder) relative(path string) string {
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// unwrapPath drops the absolute path from a filesystem error, which the model has no use for
+func unwrapPath(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		return linkErr.Err
+	}
+	return err
+}
@@ -0,0 +1,200 @@
+package tools
+
+import (
+	"bedrock-llama/bedrock"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Types the schema of which isn't derived from their Go kind
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// SchemaFor derives the JSON schema of a tool input from the struct T. Properties are named
+// after the fields' json tags, and described by a description tag. Fields are required unless
+// they are pointers or tagged omitempty; fields tagged "-" and unexported fields are left out.
+//
+//	type lookup struct {
+//		Query string `json:"query" description:"Words of the title to look up"`
+//		Limit int    `json:"limit,omitempty" description:"Maximum number of titles to return"`
+//	}
+func SchemaFor[T any]() (json.RawMessage, error) {
+	t := reflect.TypeFor[T]()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("tool input must be a struct, not %s", t)
+	}
+	schema, err := typeSchema(t, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(schema)
+}
+
+// Func returns a tool that runs fn with the model's input decoded into T, the schema of which
+// is derived by SchemaFor. The input is decoded strictly, so a misspelled property is reported
+// back to the model rather than ignored.
+func Func[T any](name, description string, fn func(ctx context.Context, input T) (string, error)) (bedrock.Tool, error) {
+	schema, err := SchemaFor[T]()
+	if err != nil {
+		return bedrock.Tool{}, fmt.Errorf("invalid input of tool %q: %v", name, err)
+	}
+	return bedrock.Tool{
+		Name:        name,
+		Description: description,
+		Schema:      schema,
+		Run: func(ctx context.Context, raw json.RawMessage) (string, error) {
+			var input T
+			decoder := json.NewDecoder(strings.NewReader(string(raw)))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&input); err != nil {
+				return "", fmt.Errorf("invalid input: %v", err)
+			}
+			return fn(ctx, input)
+		},
+	}, nil
+}
+
+// MustFunc is like Func but panics when T has no schema, for tools defined at compile time
+func MustFunc[T any](name, description string, fn func(ctx context.Context, input T) (string, error)) bedrock.Tool {
+	tool, err := Func(name, description, fn)
+	if err != nil {
+		panic(err)
+	}
+	return tool
+}
+
+// typeSchema returns the JSON schema of a Go type. visiting holds the structs being described,
+// to reject recursive types, which would describe an endless schema.
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) (map[string]any, error) {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	case rawMessageType:
+		return map[string]any{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), visiting)
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Interface:
+		return map[string]any{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json sends byte slices as base64, and byte arrays as arrays of numbers
+			return map[string]any{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		items, err := typeSchema(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		schema := map[string]any{"type": "array", "items": items}
+		if t.Kind() == reflect.Array {
+			schema["minItems"], schema["maxItems"] = t.Len(), t.Len()
+		}
+		return schema, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys of %s must be strings", t)
+		}
+		values, err := typeSchema(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			return nil, fmt.Errorf("%s is recursive", t)
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		properties := map[string]any{}
+		required := []string{}
+		if err := structProperties(t, visiting, properties, &required); err != nil {
+			return nil, err
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema, nil
+	default:
+		return nil, fmt.Errorf("%s has no JSON schema", t)
+	}
+}
+
+// structProperties adds the properties of a struct's fields to properties, flattening
+// embedded structs as encoding/json does
+func structProperties(t reflect.Type, visiting map[reflect.Type]bool, properties map[string]any, required *[]string) error {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := structProperties(embedded, visiting, properties, required); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema, err := typeSchema(field.Type, visiting)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", field.Name, err)
+		}
+		if hasOption(options, "string") {
+			// The ,string option sends numbers and booleans quoted
+			schema = map[string]any{"type": "string"}
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+		properties[name] = schema
+		if field.Type.Kind() != reflect.Pointer && !hasOption(options, "omitempty") && !hasOption(options, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// hasOption reports whether the options of a json tag include the given one
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+package tools
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/bedrock/bedrocktest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// field is the expected value at a path of the generated schema; nil when it must be absent
+type field struct {
+	path []any
+	want any
+}
+
+type lookupInput struct {
+	Query string `json:"query" description:"Words of the title to look up"`
+	Limit int    `json:"limit,omitempty" description:"Maximum number of titles to return"`
+}
+
+type Paging struct {
+	Page int `json:"page"`
+}
+
+type scalarsInput struct {
+	Name    string          `json:"name"`
+	Flag    bool            `json:"flag"`
+	Small   int8            `json:"small"`
+	Count   uint            `json:"count"`
+	Score   float64         `json:"score"`
+	Year    int             `json:"year,string"`
+	Since   time.Time       `json:"since"`
+	Extra   json.RawMessage `json:"extra"`
+	Any     any             `json:"any"`
+	Data    []byte          `json:"data"`
+	Note    *string         `json:"note"`
+	Later   time.Time       `json:"later,omitzero"`
+	Skipped string          `json:"-"`
+	NoTag   string
+	hidden  string
+}
+
+type collectionsInput struct {
+	Paging
+	Tags   []string          `json:"tags"`
+	Pair   [2]int            `json:"pair"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Items  []lookupInput     `json:"items"`
+}
+
+type node struct {
+	Children []node `json:"children"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema func() (json.RawMessage, error)
+		fields []field
+	}{
+		{
+			name:   "descriptions and required",
+			schema: SchemaFor[lookupInput],
+			fields: []field{
+				{[]any{"type"}, "object"},
+				{[]any{"properties", "query", "type"}, "string"},
+				{[]any{"properties", "query", "description"}, "Words of the title to look up"},
+				{[]any{"properties", "limit", "type"}, "integer"},
+				{[]any{"required"}, []any{"query"}},
+			},
+		},
+		{
+			name:   "pointer to a struct",
+			schema: SchemaFor[*lookupInput],
+			fields: []field{
+				{[]any{"properties", "query", "type"}, "string"},
+			},
+		},
+		{
+			name:   "scalars",
+			schema: SchemaFor[scalarsInput],
+			fields: []field{
+				{[]any{"properties", "name", "type"}, "string"},
+				{[]any{"properties", "flag", "type"}, "boolean"},
+				{[]any{"properties", "small", "type"}, "integer"},
+				{[]any{"properties", "count", "type"}, "integer"},
+				{[]any{"properties", "count", "minimum"}, 0.0},
+				{[]any{"properties", "score", "type"}, "number"},
+				{[]any{"properties", "year", "type"}, "string"},
+				{[]any{"properties", "since", "format"}, "date-time"},
+				{[]any{"properties", "extra"}, map[string]any{}},
+				{[]any{"properties", "any"}, map[string]any{}},
+				{[]any{"properties", "data", "contentEncoding"}, "base64"},
+				{[]any{"properties", "note", "type"}, "string"},
+				{[]any{"properties", "NoTag", "type"}, "string"},
+				{[]any{"properties", "Skipped"}, nil},
+				{[]any{"properties", "hidden"}, nil},
+				{[]any{"required"}, []any{"name", "flag", "small", "count", "score", "year", "since", "extra", "any", "data", "NoTag"}},
+			},
+		},
+		{
+			name:   "collections and embedded structs",
+			schema: SchemaFor[collectionsInput],
+			fields: []field{
+				{[]any{"properties", "page", "type"}, "integer"},
+				{[]any{"properties", "Paging"}, nil},
+				{[]any{"properties", "tags", "items", "type"}, "string"},
+				{[]any{"properties", "pair", "minItems"}, 2.0},
+				{[]any{"properties", "pair", "maxItems"}, 2.0},
+				{[]any{"properties", "labels", "type"}, "object"},
+				{[]any{"properties", "labels", "additionalProperties", "type"}, "string"},
+				{[]any{"properties", "items", "items", "properties", "query", "type"}, "string"},
+				{[]any{"properties", "items", "items", "required"}, []any{"query"}},
+				{[]any{"required"}, []any{"page", "tags", "pair", "items"}},
+			},
+		},
+		{
+			name: "no required fields",
+			schema: SchemaFor[struct {
+				Limit int `json:"limit,omitempty"`
+			}],
+			fields: []field{
+				{[]any{"properties", "limit", "type"}, "integer"},
+				{[]any{"required"}, nil},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := tt.schema()
+			if err != nil {
+				t.Fatalf("SchemaFor: %v", err)
+			}
+			var schema map[string]any
+			if err := json.Unmarshal(raw, &schema); err != nil {
+				t.Fatalf("the schema isn't JSON: %v", err)
+			}
+			for _, f := range tt.fields {
+				if got := bedrocktest.Field(schema, f.path...); !reflect.DeepEqual(got, f.want) {
+					t.Errorf("%v = %#v, want %#v", f.path, got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaForErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema func() (json.RawMessage, error)
+		err    string
+	}{
+		{"not a struct", SchemaFor[string], "tool input must be a struct"},
+		{"recursive", SchemaFor[node], "is recursive"},
+		{"map with int keys", SchemaFor[struct {
+			Counts map[int]string `json:"counts"`
+		}], "field Counts: map keys of map[int]string must be strings"},
+		{"channel", SchemaFor[struct {
+			Done chan bool `json:"done"`
+		}], "field Done: chan bool has no JSON schema"},
+		{"function", SchemaFor[struct {
+			Callback func() `json:"callback"`
+		}], "has no JSON schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.schema()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("SchemaFor error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFuncRun(t *testing.T) {
+	tool := MustFunc("lookup", "Look up a title", func(ctx context.Context, input lookupInput) (string, error) {
+		return fmt.Sprintf("%s/%d", input.Query, input.Limit), nil
+	})
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		// err is part of the expected error; the tool must succeed when empty
+		err string
+	}{
+		{"valid", `{"query": "friends", "limit": 2}`, "friends/2", ""},
+		{"optional left out", `{"query": "friends"}`, "friends/0", ""},
+		{"misspelled property", `{"qeury": "friends"}`, "", `invalid input: json: unknown field "qeury"`},
+		{"wrong type", `{"query": 3}`, "", "invalid input"},
+		{"not JSON", `friends`, "", "invalid input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tool.Run(context.Background(), json.RawMessage(tt.input))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Run(%s) error = %v, want one containing %q", tt.input, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run(%s): %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("Run(%s) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuncInvalidInput(t *testing.T) {
+	if _, err := Func("echo", "Echo the input", func(ctx context.Context, input string) (string, error) { return input, nil }); err == nil {
+		t.Error("Func accepted a string input")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("MustFunc didn't panic on a recursive input")
+		}
+	}()
+	MustFunc("tree", "Walk a tree", func(ctx context.Context, input node) (string, error) { return "", nil })
+}
+
+func TestRegister(t *testing.T) {
+	run := func(ctx context.Context, input json.RawMessage) (string, error) { return "", nil }
+	object := json.RawMessage(`{"type": "object", "properties": {}}`)
+	tests := []struct {
+		name string
+		tool bedrock.Tool
+		// err is part of the expected error; the tool must be registered when empty
+		err string
+	}{
+		{"valid", bedrock.Tool{Name: "lookup_title-2", Schema: object, Run: run}, ""},
+		{"duplicate", bedrock.Tool{Name: "lookup_title-2", Schema: object, Run: run}, "already registered"},
+		{"name with spaces", bedrock.Tool{Name: "lookup title", Schema: object, Run: run}, "invalid tool name"},
+		{"empty name", bedrock.Tool{Schema: object, Run: run}, "invalid tool name"},
+		{"long name", bedrock.Tool{Name: strings.Repeat("a", 65), Schema: object, Run: run}, "invalid tool name"},
+		{"no function", bedrock.Tool{Name: "idle", Schema: object}, "no function to run"},
+		{"invalid schema", bedrock.Tool{Name: "broken", Schema: json.RawMessage(`{`), Run: run}, "invalid schema"},
+		{"array schema", bedrock.Tool{Name: "list", Schema: json.RawMessage(`{"type": "array"}`), Run: run}, "must describe an object"},
+	}
+	registry := NewRegistry()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := registry.Register(tt.tool)
+			if tt.err == "" && err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Register error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+	if got := registry.Names(); !reflect.DeepEqual(got, []string{"lookup_title-2"}) {
+		t.Errorf("Names() = %v, want only the valid tool", got)
+	}
+	if _, err := registry.Tools("missing"); err == nil {
+		t.Error("Tools returned an unknown tool")
+	}
+	if _, err := NewRegistry().Tools(); err == nil {
+		t.Error("an empty registry returned tools")
+	}
+}
@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"bedrock-llama/bedrock"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// namePattern matches the tool names Bedrock accepts
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Registry holds the tools a model may call, in the order they were registered
+type Registry struct {
+	byName map[string]bedrock.Tool
+	order  []string
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{byName: map[string]bedrock.Tool{}}
+}
+
+// Register adds a tool, checking its name and schema
+func (r *Registry) Register(tool bedrock.Tool) error {
+	if !namePattern.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q: use up to 64 letters, digits, underscores and hyphens", tool.Name)
+	}
+	if _, dup := r.byName[tool.Name]; dup {
+		return fmt.Errorf("tool %q is already registered", tool.Name)
+	}
+	if tool.Run == nil {
+		return fmt.Errorf("tool %q has no function to run", tool.Name)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(tool.Schema, &schema); err != nil {
+		return fmt.Errorf("invalid schema of tool %q: %v", tool.Name, err)
+	}
+	if schema["type"] != "object" {
+		return fmt.Errorf("invalid schema of tool %q: it must describe an object", tool.Name)
+	}
+	r.byName[tool.Name] = tool
+	r.order = append(r.order, tool.Name)
+	return nil
+}
+
+// Names returns the names of the registered tools
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.order...)
+}
+
+// Tools returns the named tools, or all registered tools when no names are given
+func (r *Registry) Tools(names ...string) ([]bedrock.Tool, error) {
+	if len(names) == 0 {
+		names = r.order
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no tools are registered")
+	}
+	tools := make([]bedrock.Tool, len(names))
+	for i, name := range names {
+		tool, ok := r.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		tools[i] = tool
+	}
+	return tools, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/models"
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by this program
+const tracerName = "bedrock-llama"
+
+// tracer creates the spans of model invocations and HTTP requests. It is a no-op until
+// setupTracing installs an exporting provider.
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing exports spans over OTLP/HTTP when an OTLP endpoint is configured through the
+// standard OTEL_EXPORTER_OTLP_* environment variables. It returns a function that flushes
+// and stops the exporter; without an endpoint tracing stays disabled and that function does nothing.
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	noop := func(context.Context) error { return nil }
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") ||
+		(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "") {
+		return noop, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, fmt.Errorf("failed to create the OTLP trace exporter: %v", err)
+	}
+	resource, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewSchemaless(
+		attribute.String("service.name", serviceName()),
+	))
+	if err != nil {
+		return noop, fmt.Errorf("failed to describe the trace resource: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(resource))
+	otel.SetTracerProvider(provider)
+	log.Println("Exporting traces over OTLP")
+	return provider.Shutdown, nil
+}
+
+// serviceName is the service.name of the exported spans, overridable with OTEL_SERVICE_NAME
+func serviceName() string {
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		return name
+	}
+	return tracerName
+}
+
+// flushTraces exports the spans buffered so far, e.g. before a Lambda invocation is frozen
+func flushTraces(ctx context.Context) {
+	if provider, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		if err := provider.ForceFlush(ctx); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}
+}
+
+// traceModel returns a model whose invocations are recorded as client spans
+func traceModel(model bedrock.Model, info models.Info, region string) bedrock.Model {
+	return &tracedModel{Model: model, attributes: []attribute.KeyValue{
+		attribute.String("gen_ai.system", "aws.bedrock"),
+		attribute.String("gen_ai.request.model", info.ModelID),
+		attribute.String("bedrock.model", info.Name),
+		attribute.String("cloud.region", region),
+	}}
+}
+
+// tracedModel is a Model whose invocations are recorded as spans
+type tracedModel struct {
+	bedrock.Model
+	attributes []attribute.KeyValue
+}
+
+func (m *tracedModel) Invoke(ctx context.Context, prompt string) (*bedrock.Result, error) {
+	return m.Chat(ctx, bedrock.UserTurn(prompt))
+}
+
+func (m *tracedModel) Chat(ctx context.Context, turns []bedrock.Turn) (*bedrock.Result, error) {
+	ctx, span := m.start(ctx, "chat", len(turns))
+	result, err := m.Model.Chat(ctx, turns)
+	m.end(span, result, err)
+	return result, err
+}
+
+func (m *tracedModel) Stream(ctx context.Context, turns []bedrock.Turn, onText func(text string)) (*bedrock.Result, error) {
+	streamer, ok := m.Model.(bedrock.Streamer)
+	if !ok {
+		return nil, fmt.Errorf("the %s model doesn't support streaming", m.Name())
+	}
+	ctx, span := m.start(ctx, "stream", len(turns))
+	result, err := streamer.Stream(ctx, turns, onText)
+	m.end(span, result, err)
+	return result, err
+}
+
+func (m *tracedModel) UseTools(ctx context.Context, turns []bedrock.Turn, tools []bedrock.Tool, onCall func(call bedrock.ToolCall)) (*bedrock.Result, error) {
+	toolUser, ok := m.Model.(bedrock.ToolUser)
+	if !ok {
+		return nil, fmt.Errorf("the %s model can't call tools", m.Name())
+	}
+	ctx, span := m.start(ctx, "tools", len(turns))
+	result, err := toolUser.UseTools(ctx, turns, tools, onCall)
+	m.end(span, result, err)
+	return result, err
+}
+
+// start opens the span of an invocation
+func (m *tracedModel) start(ctx context.Context, operation string, turns int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation+" "+m.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(m.attributes...),
+		trace.WithAttributes(attribute.String("gen_ai.operation.name", operation), attribute.Int("bedrock.turns", turns)),
+	)
+}
+
+// end records the usage and stop reason of an invocation, or its error, and closes the span
+func (m *tracedModel) end(span trace.Span, result *bedrock.Result, err error) {
+	if result != nil {
+		span.SetAttributes(
+			attribute.Int("gen_ai.usage.input_tokens", result.InputTokens),
+			attribute.Int("gen_ai.usage.output_tokens", result.OutputTokens),
+		)
+		if result.StopReason != "" {
+			span.SetAttributes(attribute.StringSlice("gen_ai.response.finish_reasons", []string{result.StopReason}))
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceRequests wraps the server's handler so each request gets a server span, continuing
+// the trace of the caller when it sends W3C trace context headers
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets WebSocket upgrades through the recorder
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", r.ResponseWriter)
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bedrock-llama/pricing"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// usageFileEnv overrides the default location of the usage store
+const usageFileEnv = "BEDROCK_LLAMA_USAGE_FILE"
+
+// usageFlushInterval is how often long-running commands append their usage to the store
+const usageFlushInterval = 10 * time.Minute
+
+// dayLayout formats the day of a usage entry
+const dayLayout = "2006-01-02"
+
+// defaultUsageFile is the usage store in the user's configuration directory, or empty when the
+// platform has none (e.g. on Lambda without a home directory)
+func defaultUsageFile() string {
+	if path := os.Getenv(usageFileEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bedrock-llama", "usage.jsonl")
+}
+
+// usageEntry is one line of the usage store: the invocations of a model for a task on one day,
+// within a single run
+type usageEntry struct {
+	Day          string    `json:"day"`
+	Run          string    `json:"run"`
+	Task         string    `json:"task"`
+	Model        string    `json:"model"`
+	Calls        int       `json:"calls"`
+	Failures     int       `json:"failures,omitempty"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	RecordedAt   time.Time `json:"recorded_at"`
+}
+
+// usageRecorder totals the token usage of a run and appends it to the usage store when the run
+// ends, and every usageFlushInterval for long-running commands
+type usageRecorder struct {
+	path string
+	run  string
+	task string
+
+	mu        sync.Mutex
+	pending   map[[2]string]*usageEntry
+	lastFlush time.Time
+}
+
+// newUsageRecorder records the usage of a run of a task to the store at path
+func newUsageRecorder(path, task string) *usageRecorder {
+	now := time.Now()
+	return &usageRecorder{
+		path:      path,
+		run:       newRunID(),
+		task:      task,
+		pending:   map[[2]string]*usageEntry{},
+		lastFlush: now,
+	}
+}
+
+// newRunID identifies a command invocation by its start time and process ID
+func newRunID() string {
+	return fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid())
+}
+
+// add records one model invocation
+func (u *usageRecorder) add(model string, inputTokens, outputTokens int, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	day := time.Now().Format(dayLayout)
+	key := [2]string{day, model}
+	entry, ok := u.pending[key]
+	if !ok {
+		entry = &usageEntry{Day: day, Run: u.run, Task: u.task, Model: model}
+		u.pending[key] = entry
+	}
+	entry.Calls++
+	if err != nil {
+		entry.Failures++
+	}
+	entry.InputTokens += inputTokens
+	entry.OutputTokens += outputTokens
+
+	if time.Since(u.lastFlush) >= usageFlushInterval {
+		u.flushLocked()
+	}
+}
+
+// flush appends the pending usage to the store
+func (u *usageRecorder) flush() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.flushLocked()
+}
+
+// flushLocked appends the pending usage to the store; the caller holds u.mu. Failures are only
+// logged, since losing the accounting must not fail the run.
+func (u *usageRecorder) flushLocked() {
+	u.lastFlush = time.Now()
+	if len(u.pending) == 0 {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(u.path), 0o700); err != nil {
+		log.Printf("Error recording usage: %v", err)
+		return
+	}
+	file, err := os.OpenFile(u.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		log.Printf("Error recording usage: %v", err)
+		return
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for key, entry := range u.pending {
+		entry.RecordedAt = u.lastFlush.UTC()
+		data, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Error recording usage: %v", err)
+			continue
+		}
+		w.Write(append(data, '\n'))
+		delete(u.pending, key)
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("Error recording usage: %v", err)
+	}
+}
+
+// usageDimensions are the fields a usage report can be grouped by
+var usageDimensions = []string{"day", "task", "model", "run"}
+
+// usageRow is one group of a usage report
+type usageRow struct {
+	Day          string  `json:"day,omitempty"`
+	Task         string  `json:"task,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	Run          string  `json:"run,omitempty"`
+	Calls        int     `json:"calls"`
+	Failures     int     `json:"failures"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CostUSD      float64 `json:"estimated_cost_usd"`
+}
+
+// field returns the value of a grouping dimension
+func (r *usageRow) field(dimension string) string {
+	switch dimension {
+	case "day":
+		return r.Day
+	case "task":
+		return r.Task
+	case "model":
+		return r.Model
+	default:
+		return r.Run
+	}
+}
+
+// runUsage implements the usage subcommands
+func runUsage(args []string) {
+	if len(args) == 0 || args[0] != "report" {
+		fatalf("Usage: %s usage report [flags]", os.Args[0])
+	}
+
+	fs := flag.NewFlagSet("usage report", flag.ExitOnError)
+	fileFlag := fs.String("usage-file", defaultUsageFile(), "Usage store written by earlier runs; defaults to $"+usageFileEnv)
+	byFlag := fs.String("by", "day,task,model", "Comma-separated dimensions to group by: "+strings.Join(usageDimensions, ", "))
+	sinceFlag := fs.String("since", "", "First day to include, as YYYY-MM-DD")
+	untilFlag := fs.String("until", "", "Last day to include, as YYYY-MM-DD")
+	formatFlag := fs.String("format", "table", "Report format: table, csv or json")
+	fs.Parse(args[1:])
+
+	var dimensions []string
+	for _, dimension := range strings.Split(*byFlag, ",") {
+		dimension = strings.ToLower(strings.TrimSpace(dimension))
+		if dimension == "" {
+			continue
+		}
+		valid := false
+		for _, known := range usageDimensions {
+			valid = valid || dimension == known
+		}
+		if !valid {
+			fatalf("Invalid dimension %q. Use %s", dimension, strings.Join(usageDimensions, ", "))
+		}
+		dimensions = append(dimensions, dimension)
+	}
+	for _, day := range []string{*sinceFlag, *untilFlag} {
+		if _, err := time.Parse(dayLayout, day); day != "" && err != nil {
+			fatalf("Invalid day %q: use YYYY-MM-DD", day)
+		}
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "table" && format != "csv" && format != "json" {
+		fatalf("Invalid format %q. Use table, csv or json", *formatFlag)
+	}
+	if *fileFlag == "" {
+		fatalf("No usage store: set -usage-file or $%s", usageFileEnv)
+	}
+
+	entries, err := readUsage(*fileFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	rows := aggregateUsage(entries, dimensions, *sinceFlag, *untilFlag)
+	if err := writeUsageReport(os.Stdout, format, dimensions, rows); err != nil {
+		fatalf("Error writing report: %v", err)
+	}
+}
+
+// readUsage reads the entries of the usage store; a missing store holds no usage
+func readUsage(path string) ([]usageEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the usage store: %v", err)
+	}
+	defer file.Close()
+
+	var entries []usageEntry
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry usageEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid JSON: %v", path, lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the usage store: %v", err)
+	}
+	return entries, nil
+}
+
+// aggregateUsage groups the entries within the day range by the dimensions, sorted by them
+func aggregateUsage(entries []usageEntry, dimensions []string, since, until string) []*usageRow {
+	groups := map[string]*usageRow{}
+	var rows []*usageRow
+	for _, entry := range entries {
+		if (since != "" && entry.Day < since) || (until != "" && entry.Day > until) {
+			continue
+		}
+		full := usageRow{Day: entry.Day, Task: entry.Task, Model: entry.Model, Run: entry.Run}
+		row := &usageRow{}
+		var key []string
+		for _, dimension := range dimensions {
+			value := full.field(dimension)
+			key = append(key, value)
+			switch dimension {
+			case "day":
+				row.Day = value
+			case "task":
+				row.Task = value
+			case "model":
+				row.Model = value
+			case "run":
+				row.Run = value
+			}
+		}
+		if existing, ok := groups[strings.Join(key, "\x00")]; ok {
+			row = existing
+		} else {
+			groups[strings.Join(key, "\x00")] = row
+			rows = append(rows, row)
+		}
+		row.Calls += entry.Calls
+		row.Failures += entry.Failures
+		row.InputTokens += entry.InputTokens
+		row.OutputTokens += entry.OutputTokens
+		row.CostUSD += pricing.Cost(entry.Model, entry.InputTokens, entry.OutputTokens)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, dimension := range dimensions {
+			if a, b := rows[i].field(dimension), rows[j].field(dimension); a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+	return rows
+}
+
+// writeUsageReport writes the report rows as an aligned table with a total, CSV, or a JSON array
+func writeUsageReport(out io.Writer, format string, dimensions []string, rows []*usageRow) error {
+	header := make([]string, 0, len(dimensions)+5)
+	for _, dimension := range dimensions {
+		header = append(header, dimension)
+	}
+	header = append(header, "calls", "failures", "input_tokens", "output_tokens", "estimated_cost_usd")
+	values := func(r *usageRow) []string {
+		row := make([]string, 0, len(header))
+		for _, dimension := range dimensions {
+			row = append(row, r.field(dimension))
+		}
+		return append(row, strconv.Itoa(r.Calls), strconv.Itoa(r.Failures), strconv.Itoa(r.InputTokens),
+			strconv.Itoa(r.OutputTokens), strconv.FormatFloat(r.CostUSD, 'f', 4, 64))
+	}
+
+	switch format {
+	case "json":
+		if rows == nil {
+			rows = []*usageRow{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write(header)
+		for _, row := range rows {
+			w.Write(values(row))
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+	total := &usageRow{}
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(values(row), "\t"))
+		total.Calls += row.Calls
+		total.Failures += row.Failures
+		total.InputTokens += row.InputTokens
+		total.OutputTokens += row.OutputTokens
+		total.CostUSD += row.CostUSD
+	}
+	totalRow := values(total)
+	if len(dimensions) > 0 {
+		totalRow[0] = "TOTAL"
+	}
+	fmt.Fprintln(w, strings.Join(totalRow, "\t"))
+	return w.Flush()
+}
@@ -0,0 +1,111 @@
+package validate
+
+import (
+	"bedrock-llama/schema"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator checks a record that already matches the task schema
+type Validator interface {
+	// Name identifies the validator in messages
+	Name() string
+	// Validate returns an error describing why the record is unacceptable
+	Validate(record schema.Record) error
+}
+
+// Action decides what happens when a validator rejects a record
+type Action int
+
+const (
+	// Retry rejects the output so the model is asked for a correction
+	Retry Action = iota
+	// Flag accepts the output but marks the result as low quality
+	Flag
+)
+
+// Rule attaches a validator to a task together with the action taken when it fails
+type Rule struct {
+	Validator Validator
+	Action    Action
+}
+
+// Run applies the rules to every record. Failures of Retry rules are returned as an error;
+// failures of Flag rules are returned as quality issues.
+func Run(rules []Rule, records []schema.Record) (issues []string, err error) {
+	for i, record := range records {
+		for _, rule := range rules {
+			verr := rule.Validator.Validate(record)
+			if verr == nil {
+				continue
+			}
+			if rule.Action == Retry {
+				return nil, fmt.Errorf("record %d: %v", i+1, verr)
+			}
+			issues = append(issues, fmt.Sprintf("record %d: %s: %v", i+1, rule.Validator.Name(), verr))
+		}
+	}
+	return issues, nil
+}
+
+// validatorFunc adapts a function to the Validator interface
+type validatorFunc struct {
+	name string
+	fn   func(record schema.Record) error
+}
+
+func (v validatorFunc) Name() string {
+	return v.name
+}
+
+func (v validatorFunc) Validate(record schema.Record) error {
+	return v.fn(record)
+}
+
+// New creates a validator from a function
+func New(name string, fn func(record schema.Record) error) Validator {
+	return validatorFunc{name: name, fn: fn}
+}
+
+// MaxLength rejects string fields longer than n characters
+func MaxLength(field string, n int) Validator {
+	return New(field+"-max-length", func(record schema.Record) error {
+		if text, _ := record[field].(string); utf8.RuneCountInString(text) > n {
+			return fmt.Errorf("%s is longer than %d characters", field, n)
+		}
+		return nil
+	})
+}
+
+// NoMatch rejects string fields that still contain text matching the pattern
+func NoMatch(field string, pattern *regexp.Regexp, description string) Validator {
+	return New(field+"-no-"+strings.ReplaceAll(description, " ", "-"), func(record schema.Record) error {
+		text, _ := record[field].(string)
+		if match := pattern.FindString(text); match != "" {
+			return fmt.Errorf("%s %q still contains %s (%q)", field, text, description, match)
+		}
+		return nil
+	})
+}
+
+// Matches rejects string fields that don't match the pattern
+func Matches(field string, pattern *regexp.Regexp, description string) Validator {
+	return New(field+"-format", func(record schema.Record) error {
+		if text, _ := record[field].(string); !pattern.MatchString(text) {
+			return fmt.Errorf("%s %q is not %s", field, text, description)
+		}
+		return nil
+	})
+}
+
+// IntRange rejects integer fields outside [min, max]
+func IntRange(field string, min, max int64) Validator {
+	return New(field+"-range", func(record schema.Record) error {
+		if value, _ := record[field].(int64); value < min || value > max {
+			return fmt.Errorf("%s %d is outside the range %d-%d", field, value, min, max)
+		}
+		return nil
+	})
+}
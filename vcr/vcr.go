@@ -0,0 +1,272 @@
+package vcr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// Modes of a Recorder
+const (
+	// ModeRecord sends the requests to Bedrock and saves every exchange as a fixture
+	ModeRecord = "record"
+	// ModeReplay answers the requests from the saved fixtures without calling Bedrock
+	ModeReplay = "replay"
+)
+
+// redactedAccount replaces AWS account IDs, e.g. those in inference profile ARNs, in fixtures
+const redactedAccount = "000000000000"
+
+// accountID matches the 12-digit AWS account IDs in request paths
+var accountID = regexp.MustCompile(`\b\d{12}\b`)
+
+// keptHeaders are the response headers saved in fixtures; the others, such as request IDs and
+// dates, differ between recordings and aren't needed to parse a response
+var keptHeaders = []string{
+	"Content-Type",
+	"Retry-After",
+	"X-Amzn-Bedrock-Input-Token-Count",
+	"X-Amzn-Bedrock-Output-Token-Count",
+	"X-Amzn-Bedrock-Invocation-Latency",
+	"X-Amzn-Bedrock-Performanceconfig-Latency",
+	"X-Amzn-Errortype",
+}
+
+// Fixture is a recorded HTTP exchange with Bedrock, stripped of credentials, signatures,
+// request IDs and account IDs so that it can be committed
+type Fixture struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the recorded side of an exchange that identifies it on replay
+type Request struct {
+	Method string `json:"method"`
+	// Path is the unescaped request path, e.g. /model/us.amazon.nova-lite-v1:0/invoke
+	Path string `json:"path"`
+	Body string `json:"body,omitempty"`
+}
+
+// Response is the recorded answer of Bedrock to a request
+type Response struct {
+	StatusCode int               `json:"status_code"`
+	Header     map[string]string `json:"header,omitempty"`
+	// Body holds a text response, BodyBase64 a binary one such as an event stream
+	Body       string `json:"body,omitempty"`
+	BodyBase64 []byte `json:"body_base64,omitempty"`
+}
+
+// Recorder is an HTTP client for the AWS SDK that records Bedrock exchanges to fixture files
+// in a directory, or replays them from it
+type Recorder struct {
+	mode string
+	dir  string
+	next aws.HTTPClient
+	mu   sync.Mutex
+}
+
+// New creates a recorder in the given mode over the fixture directory; the directory is created
+// when recording
+func New(mode, dir string) (*Recorder, error) {
+	switch mode {
+	case ModeRecord:
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create fixture directory: %v", err)
+		}
+	case ModeReplay:
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("failed to open fixture directory: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid recorder mode %q: use %s or %s", mode, ModeRecord, ModeReplay)
+	}
+	return &Recorder{mode: mode, dir: dir, next: awshttp.NewBuildableClient()}, nil
+}
+
+// Wrap makes the recorder send the requests it records through next, the SDK's HTTP client, and
+// returns it; for bedrock.Config.WrapHTTPClient
+func (r *Recorder) Wrap(next aws.HTTPClient) aws.HTTPClient {
+	if next != nil {
+		r.next = next
+	}
+	return r
+}
+
+// Do sends the request, or answers it from its fixture when replaying
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := Request{Method: req.Method, Path: sanitizePath(req.URL), Body: string(body)}
+	file := filepath.Join(r.dir, fixtureName(recorded))
+
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded, file)
+	}
+	resp, err := r.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &recordingBody{ReadCloser: resp.Body, save: func(body []byte) error {
+		return r.save(file, Fixture{Request: recorded, Response: newResponse(resp, body)})
+	}}
+	return resp, nil
+}
+
+// replay answers a request from its fixture. A request without one is rejected as a
+// ValidationException, which isn't retried, rather than failed as unsent.
+func (r *Recorder) replay(req *http.Request, recorded Request, file string) (*http.Response, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		message, _ := json.Marshal(map[string]string{"message": "no recorded fixture " + filepath.Base(file) + " for " + recorded.Method + " " + recorded.Path})
+		data, _ = json.Marshal(Fixture{Request: recorded, Response: Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     map[string]string{"Content-Type": "application/json", "X-Amzn-Errortype": "ValidationException"},
+			Body:       string(message),
+		}})
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %v", err)
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fixture %s: %v", file, err)
+	}
+	body := fixture.Response.BodyBase64
+	if body == nil {
+		body = []byte(fixture.Response.Body)
+	}
+	header := http.Header{}
+	for name, value := range fixture.Response.Header {
+		header.Set(name, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Response.StatusCode, http.StatusText(fixture.Response.StatusCode)),
+		StatusCode:    fixture.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// save writes a fixture, replacing an earlier recording of the same request
+func (r *Recorder) save(file string, fixture Fixture) error {
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal fixture: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.WriteFile(file, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write fixture: %v", err)
+	}
+	return nil
+}
+
+// readBody reads the request body and puts it back for sending
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// newResponse records a response with only the headers in keptHeaders
+func newResponse(resp *http.Response, body []byte) Response {
+	response := Response{StatusCode: resp.StatusCode, Header: map[string]string{}}
+	for _, name := range keptHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			response.Header[name] = value
+		}
+	}
+	if utf8.Valid(body) && !strings.Contains(resp.Header.Get("Content-Type"), "eventstream") {
+		response.Body = string(body)
+	} else {
+		response.BodyBase64 = body
+	}
+	return response
+}
+
+// sanitizePath returns the unescaped request path with account IDs redacted
+func sanitizePath(u *url.URL) string {
+	return accountID.ReplaceAllString(u.Path, redactedAccount)
+}
+
+// fixtureName names the fixture of a request after its operation and model, with a hash of the
+// request that tells apart the prompts sent to the same model, e.g.
+// invoke-us.amazon.nova-lite-v1_0-1a2b3c4d5e6f.json
+func fixtureName(req Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.Path + "\n" + req.Body))
+	operation := path.Base(req.Path)
+	model := path.Base(path.Dir(req.Path))
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, operation+"-"+model)
+	return name + "-" + hex.EncodeToString(sum[:6]) + ".json"
+}
+
+// recordingBody passes a response body through to the SDK and saves the fixture once the body
+// has been read to the end, so that event streams are still delivered as they arrive
+type recordingBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	save  func(body []byte) error
+	saved bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF && !b.saved {
+		b.saved = true
+		if saveErr := b.save(b.buf.Bytes()); saveErr != nil {
+			return n, saveErr
+		}
+	}
+	return n, err
+}
+
+// Close saves the fixture of a body the SDK didn't read to the end, such as a JSON document
+// decoded without reaching EOF
+func (b *recordingBody) Close() error {
+	if !b.saved {
+		b.saved = true
+		rest, _ := io.ReadAll(b.ReadCloser)
+		b.buf.Write(rest)
+		if err := b.save(b.buf.Bytes()); err != nil {
+			b.ReadCloser.Close()
+			return err
+		}
+	}
+	return b.ReadCloser.Close()
+}
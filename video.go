@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"bedrock-llama/s3io"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// loadVideo prepares the -video clip. An s3:// object is referenced as it is, a local clip is
+// sent in the request, and a local clip too large for that is uploaded under the -video-upload
+// prefix for Bedrock to read from S3.
+func (e *extractor) loadVideo(ctx context.Context, source, upload string) (bedrock.Video, error) {
+	if _, isS3, err := s3io.Parse(source); isS3 || err != nil {
+		if err != nil {
+			return bedrock.Video{}, err
+		}
+		return bedrock.S3Video(source)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return bedrock.Video{}, fmt.Errorf("failed to read video: %v", err)
+	}
+	if info.Size() <= bedrock.MaxVideoBytes {
+		return bedrock.LoadVideo(source)
+	}
+	if upload == "" {
+		return bedrock.Video{}, fmt.Errorf("the video is %d bytes, too large to send in a request: upload it with -video-upload=s3://bucket/prefix/", info.Size())
+	}
+
+	prefix, isS3, err := s3io.Parse(upload)
+	if err != nil {
+		return bedrock.Video{}, err
+	}
+	if !isS3 {
+		return bedrock.Video{}, fmt.Errorf("-video-upload must be an s3://bucket/prefix/, got %q", upload)
+	}
+	if _, err := bedrock.VideoFormat(source); err != nil {
+		return bedrock.Video{}, err
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		return bedrock.Video{}, fmt.Errorf("failed to read video: %v", err)
+	}
+	defer file.Close()
+	// Named by content, so clips with the same file name don't overwrite each other
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return bedrock.Video{}, fmt.Errorf("failed to read video: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return bedrock.Video{}, fmt.Errorf("failed to read video: %v", err)
+	}
+	object := prefix
+	object.Key = path.Join(prefix.Key, hex.EncodeToString(hash.Sum(nil))[:16]+"-"+filepath.Base(source))
+
+	client, err := e.s3Client(ctx)
+	if err != nil {
+		return bedrock.Video{}, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(source))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	log.Printf("Uploading %s (%d bytes) to %s", source, info.Size(), object)
+	w := s3io.Create(ctx, client, object, contentType)
+	if _, err := io.Copy(w, file); err != nil {
+		w.Close()
+		return bedrock.Video{}, fmt.Errorf("failed to upload video: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return bedrock.Video{}, err
+	}
+	return bedrock.S3Video(object.String())
+}
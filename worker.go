@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bedrock-llama/bedrock"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamotypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxWaitTime is the SQS long-polling limit
+const maxWaitTime = 20 * time.Second
+
+// worker consumes inputs from an SQS queue and writes the results to an output queue or a
+// DynamoDB table
+type worker struct {
+	extractor *extractor
+	sqs       *sqs.Client
+	dynamo    *dynamodb.Client
+
+	queueURL       string
+	outputQueueURL string
+	dlqURL         string
+	table          string
+	// visibility is how long a received message stays hidden; it is extended while the
+	// message is processed
+	visibility time.Duration
+	// retryDelay is how long a message that failed with a transient error waits to be redelivered
+	retryDelay time.Duration
+}
+
+// errPermanent marks a message that will never succeed, so it goes to the dead-letter queue
+// instead of being retried
+var errPermanent = errors.New("permanent failure")
+
+// runWorker long-polls an SQS queue until it receives SIGINT or SIGTERM
+func runWorker(args []string) {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	f := registerFlags(fs)
+	queueURLFlag := fs.String("queue-url", "", "URL of the SQS queue to read inputs from (required)")
+	outputQueueURLFlag := fs.String("output-queue-url", "", "URL of the SQS queue to send results to")
+	tableFlag := fs.String("table", "", "DynamoDB table to write results to, keyed by the string attribute 'id'")
+	dlqURLFlag := fs.String("dlq-url", "", "URL of the SQS queue for messages that can never succeed; without it they are left to the queue's redrive policy")
+	concurrencyFlag := fs.Int("concurrency", 4, "Number of messages processed in parallel")
+	visibilityFlag := fs.Duration("visibility-timeout", 0, "How long a received message stays hidden while it is processed; defaults to the queue's setting")
+	retryDelayFlag := fs.Duration("retry-delay", 30*time.Second, "How long a message that failed with a transient error waits before it is retried")
+	fs.Parse(args)
+
+	if *queueURLFlag == "" {
+		fatalf("Error: -queue-url is required")
+	}
+	if *outputQueueURLFlag == "" && *tableFlag == "" {
+		fatalf("Error: -output-queue-url or -table is required")
+	}
+	if *concurrencyFlag < 1 {
+		fatalf("Error: -concurrency must be at least 1")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runWithExtractor(context.Background(), f, func(e *extractor) error {
+		awsCfg, err := bedrock.LoadAWSConfig(ctx, e.awsConfig)
+		if err != nil {
+			return err
+		}
+		w := &worker{
+			extractor:      e,
+			sqs:            sqs.NewFromConfig(awsCfg),
+			queueURL:       *queueURLFlag,
+			outputQueueURL: *outputQueueURLFlag,
+			dlqURL:         *dlqURLFlag,
+			table:          *tableFlag,
+			visibility:     *visibilityFlag,
+			retryDelay:     *retryDelayFlag,
+		}
+		if w.table != "" {
+			w.dynamo = dynamodb.NewFromConfig(awsCfg)
+		}
+		if w.visibility == 0 {
+			if w.visibility, err = w.queueVisibility(ctx); err != nil {
+				return err
+			}
+		}
+		if w.visibility < 2*time.Second {
+			return errors.New("the visibility timeout must be at least 2s")
+		}
+
+		log.Printf("Polling %s with %d workers (visibility timeout %s)", w.queueURL, *concurrencyFlag, w.visibility)
+		var wg sync.WaitGroup
+		for range *concurrencyFlag {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.poll(ctx)
+			}()
+		}
+		<-ctx.Done()
+		log.Println("Shutting down, waiting for in-flight messages...")
+		wg.Wait()
+		return nil
+	})
+}
+
+// queueVisibility reads the queue's default visibility timeout
+func (w *worker) queueVisibility(ctx context.Context) (time.Duration, error) {
+	output, err := w.sqs.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(w.queueURL),
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameVisibilityTimeout},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the queue attributes: %v", err)
+	}
+	seconds, err := strconv.Atoi(output.Attributes[string(sqstypes.QueueAttributeNameVisibilityTimeout)])
+	if err != nil {
+		return 0, fmt.Errorf("invalid queue visibility timeout: %v", err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// poll receives and processes one message at a time until ctx is cancelled. Messages are only
+// received when there is a free worker, so none sit hidden waiting to be processed.
+func (w *worker) poll(ctx context.Context) {
+	for ctx.Err() == nil {
+		output, err := w.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(w.queueURL),
+			MaxNumberOfMessages:         1,
+			WaitTimeSeconds:             int32(maxWaitTime.Seconds()),
+			VisibilityTimeout:           int32(w.visibility.Seconds()),
+			MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{sqstypes.MessageSystemAttributeNameApproximateReceiveCount},
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				warnf("Failed to receive messages: %v", err)
+				sleep(ctx, 5*time.Second)
+			}
+			continue
+		}
+		for _, message := range output.Messages {
+			// In-flight messages are finished even when shutting down
+			w.handle(context.WithoutCancel(ctx), message)
+		}
+	}
+}
+
+// handle processes one message and settles it: deleted on success, moved to the dead-letter
+// queue on a permanent failure, and made visible again after the retry delay otherwise
+func (w *worker) handle(ctx context.Context, message sqstypes.Message) {
+	id := aws.ToString(message.MessageId)
+	if count := message.Attributes[string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount)]; count != "" && count != "1" {
+		log.Printf("%s: delivery attempt %s", id, count)
+	}
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	go w.heartbeat(heartbeatCtx, message)
+	err := w.process(ctx, message)
+	stopHeartbeat()
+
+	switch {
+	case err == nil:
+		w.delete(ctx, message)
+	case errors.Is(err, errPermanent):
+		warnf("%s: %v", id, err)
+		if w.dlqURL == "" {
+			// Leave the message to the queue's redrive policy
+			w.release(ctx, message, w.retryDelay)
+			return
+		}
+		if err := w.deadLetter(ctx, message, err); err != nil {
+			warnf("%s: failed to send to the dead-letter queue: %v", id, err)
+			w.release(ctx, message, w.retryDelay)
+			return
+		}
+		w.delete(ctx, message)
+	default:
+		warnf("%s: %v; retrying in %s", id, err, w.retryDelay)
+		w.release(ctx, message, w.retryDelay)
+	}
+}
+
+// unrecoverable reports whether a model failure will recur however often the message is
+// retried. Access errors are retried, since they end once the role's permissions are fixed.
+func unrecoverable(err error) bool {
+	return errors.Is(err, bedrock.ErrValidation) || errors.Is(err, bedrock.ErrModelNotFound) || errors.Is(err, bedrock.ErrContentFiltered)
+}
+
+// process runs the task for a message and writes the result
+func (w *worker) process(ctx context.Context, message sqstypes.Message) error {
+	item, err := parseMessage(message)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: extracting %q", item.ID, item.Input)
+	out := w.extractor.runItem(ctx, item)
+	if out.invalid || unrecoverable(out.err) {
+		return fmt.Errorf("%w: %s", errPermanent, out.Error)
+	}
+	if out.Error != "" {
+		return errors.New(out.Error)
+	}
+
+	if w.outputQueueURL != "" {
+		if err := w.sendResult(ctx, out); err != nil {
+			return err
+		}
+	}
+	if w.table != "" {
+		if err := w.putResult(ctx, out); err != nil {
+			return err
+		}
+	}
+	log.Printf("%s: done in %dms", item.ID, out.LatencyMs)
+	return nil
+}
+
+// parseMessage reads the input of a message: either a JSON object like a JSONL batch line or
+// the input itself, e.g. a filename. Items without an id use the message ID.
+func parseMessage(message sqstypes.Message) (batchItem, error) {
+	body := strings.TrimSpace(aws.ToString(message.Body))
+	item := batchItem{Input: body}
+	if strings.HasPrefix(body, "{") {
+		item = batchItem{}
+		if err := json.Unmarshal([]byte(body), &item); err != nil {
+			return item, fmt.Errorf("%w: invalid message body: %v", errPermanent, err)
+		}
+	}
+	if strings.TrimSpace(item.Input) == "" {
+		return item, fmt.Errorf("%w: message has no input", errPermanent)
+	}
+	if item.ID == "" {
+		item.ID = aws.ToString(message.MessageId)
+	}
+	return item, nil
+}
+
+// heartbeat keeps a message hidden while it is processed by extending its visibility timeout
+// halfway through each period
+func (w *worker) heartbeat(ctx context.Context, message sqstypes.Message) {
+	ticker := time.NewTicker(w.visibility / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.changeVisibility(ctx, message, w.visibility); err != nil && ctx.Err() == nil {
+				warnf("%s: failed to extend the visibility timeout: %v", aws.ToString(message.MessageId), err)
+			}
+		}
+	}
+}
+
+// release makes a message visible again after delay so it is retried
+func (w *worker) release(ctx context.Context, message sqstypes.Message, delay time.Duration) {
+	if err := w.changeVisibility(ctx, message, delay); err != nil {
+		warnf("%s: failed to release the message: %v", aws.ToString(message.MessageId), err)
+	}
+}
+
+func (w *worker) changeVisibility(ctx context.Context, message sqstypes.Message, timeout time.Duration) error {
+	_, err := w.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(w.queueURL),
+		ReceiptHandle:     message.ReceiptHandle,
+		VisibilityTimeout: int32(timeout.Seconds()),
+	})
+	return err
+}
+
+// delete removes a settled message from the queue
+func (w *worker) delete(ctx context.Context, message sqstypes.Message) {
+	_, err := w.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(w.queueURL),
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		warnf("%s: failed to delete the message: %v", aws.ToString(message.MessageId), err)
+	}
+}
+
+// deadLetter sends a message that can never succeed to the dead-letter queue with the reason
+func (w *worker) deadLetter(ctx context.Context, message sqstypes.Message, reason error) error {
+	_, err := w.sqs.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(w.dlqURL),
+		MessageBody: message.Body,
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"error": {DataType: aws.String("String"), StringValue: aws.String(reason.Error())},
+		},
+	})
+	return err
+}
+
+// sendResult sends a result to the output queue as JSON
+func (w *worker) sendResult(ctx context.Context, out batchOutput) error {
+	body, err := json.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %v", err)
+	}
+	_, err = w.sqs.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(w.outputQueueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send result: %v", err)
+	}
+	return nil
+}
+
+// putResult writes a result to the DynamoDB table, replacing any earlier result for the id
+func (w *worker) putResult(ctx context.Context, out batchOutput) error {
+	item := map[string]dynamotypes.AttributeValue{
+		"id":            &dynamotypes.AttributeValueMemberS{Value: out.ID},
+		"input":         &dynamotypes.AttributeValueMemberS{Value: out.Input},
+		"records":       &dynamotypes.AttributeValueMemberS{Value: string(out.Records)},
+		"model":         &dynamotypes.AttributeValueMemberS{Value: out.Model},
+		"input_tokens":  &dynamotypes.AttributeValueMemberN{Value: strconv.Itoa(out.InputTokens)},
+		"output_tokens": &dynamotypes.AttributeValueMemberN{Value: strconv.Itoa(out.OutputTokens)},
+		"latency_ms":    &dynamotypes.AttributeValueMemberN{Value: strconv.FormatInt(out.LatencyMs, 10)},
+		"repairs":       &dynamotypes.AttributeValueMemberN{Value: strconv.Itoa(out.Repairs)},
+		"processed_at":  &dynamotypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+	if len(out.Metadata) > 0 {
+		item["metadata"] = &dynamotypes.AttributeValueMemberS{Value: string(out.Metadata)}
+	}
+	if len(out.Issues) > 0 {
+		issues := make([]dynamotypes.AttributeValue, len(out.Issues))
+		for i, issue := range out.Issues {
+			issues[i] = &dynamotypes.AttributeValueMemberS{Value: issue}
+		}
+		item["issues"] = &dynamotypes.AttributeValueMemberL{Value: issues}
+	}
+
+	_, err := w.dynamo.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(w.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write result to %s: %v", w.table, err)
+	}
+	return nil
+}
+
+// sleep waits for d or until ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}